@@ -0,0 +1,102 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package changefeedccl
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/kvevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// RowSink allows jobs other than changefeeds to emit already encoded rows to
+// any of the sinks supported by changefeeds. Rows of each table are emitted to
+// the topic named after the table.
+type RowSink struct {
+	sink   EventSink
+	topics map[descpb.ID]TopicDescriptor
+}
+
+// MakeRowSink creates and dials a RowSink for the sink with the given URI. The
+// tables map the IDs of the tables whose rows will be emitted to their names.
+func MakeRowSink(
+	ctx context.Context,
+	serverCfg *execinfra.ServerConfig,
+	sinkURI string,
+	tables map[descpb.ID]string,
+	user username.SQLUsername,
+	jobID jobspb.JobID,
+) (*RowSink, error) {
+	feedCfg := jobspb.ChangefeedDetails{
+		SinkURI: sinkURI,
+		// Rows must be JSON encoded by the caller.
+		Opts: map[string]string{
+			changefeedbase.OptFormat: string(changefeedbase.OptFormatJSON),
+		},
+	}
+	topics := make(map[descpb.ID]TopicDescriptor, len(tables))
+	for id, name := range tables {
+		target := changefeedbase.Target{
+			Type:              jobspb.ChangefeedTargetSpecification_PRIMARY_FAMILY_ONLY,
+			TableID:           id,
+			StatementTimeName: changefeedbase.StatementTimeName(name),
+		}
+		feedCfg.TargetSpecifications = append(feedCfg.TargetSpecifications, jobspb.ChangefeedTargetSpecification{
+			Type:              target.Type,
+			TableID:           target.TableID,
+			StatementTimeName: string(target.StatementTimeName),
+		})
+		topics[id] = &tableDescriptorTopic{
+			Metadata: cdcevent.Metadata{TableID: id, TableName: name},
+			spec:     target,
+		}
+	}
+	sink, err := getEventSink(ctx, serverCfg, feedCfg, rowSinkTimestampOracle{},
+		user, jobID, (*sliMetrics)(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &RowSink{sink: sink, topics: topics}, nil
+}
+
+// EmitRow enqueues a row of the given table for asynchronous delivery.
+func (s *RowSink) EmitRow(
+	ctx context.Context, tableID descpb.ID, key, value []byte, updated hlc.Timestamp,
+) error {
+	topic, ok := s.topics[tableID]
+	if !ok {
+		return errors.AssertionFailedf("no topic for table %d", tableID)
+	}
+	return s.sink.EmitRow(ctx, topic, key, value, updated, updated, kvevent.Alloc{})
+}
+
+// Flush blocks until every row enqueued by EmitRow has been delivered.
+func (s *RowSink) Flush(ctx context.Context) error {
+	return s.sink.Flush(ctx)
+}
+
+// Close releases the resources held by the sink.
+func (s *RowSink) Close() error {
+	return s.sink.Close()
+}
+
+// rowSinkTimestampOracle is the timestampLowerBoundOracle of a RowSink. Rows
+// are not emitted in resolved timestamp order so there is no useful bound.
+type rowSinkTimestampOracle struct{}
+
+func (rowSinkTimestampOracle) inclusiveLowerBoundTS() hlc.Timestamp {
+	return hlc.Timestamp{}
+}
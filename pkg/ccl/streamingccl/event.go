@@ -49,6 +49,11 @@ type Event interface {
 	// GetKVs returns a KV event if the EventType is KVEvent.
 	GetKVs() []roachpb.KeyValue
 
+	// GetPrevValues returns the values that the KVs of a KV event replaced at
+	// the source, in the same order as GetKVs, or nil if the source didn't
+	// report them.
+	GetPrevValues() []roachpb.Value
+
 	// GetSSTable returns a AddSSTable event if the EventType is SSTableEvent.
 	GetSSTable() *kvpb.RangeFeedSSTable
 
@@ -69,6 +74,8 @@ type Event interface {
 // kvEvent is a key value pair that needs to be ingested.
 type kvEvent struct {
 	kv []roachpb.KeyValue
+	// prevValues are the values that each KV replaced at the source, if known.
+	prevValues []roachpb.Value
 }
 
 var _ Event = kvEvent{}
@@ -83,6 +90,11 @@ func (kve kvEvent) GetKVs() []roachpb.KeyValue {
 	return kve.kv
 }
 
+// GetPrevValues implements the Event interface.
+func (kve kvEvent) GetPrevValues() []roachpb.Value {
+	return kve.prevValues
+}
+
 // GetSSTable implements the Event interface.
 func (kve kvEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return nil
@@ -123,6 +135,11 @@ func (sste sstableEvent) GetKVs() []roachpb.KeyValue {
 	return nil
 }
 
+// GetPrevValues implements the Event interface.
+func (sste sstableEvent) GetPrevValues() []roachpb.Value {
+	return nil
+}
+
 // GetSSTable implements the Event interface.
 func (sste sstableEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return &sste.sst
@@ -165,6 +182,11 @@ func (dre delRangeEvent) GetKVs() []roachpb.KeyValue {
 	return nil
 }
 
+// GetPrevValues implements the Event interface.
+func (dre delRangeEvent) GetPrevValues() []roachpb.Value {
+	return nil
+}
+
 // GetSSTable implements the Event interface.
 func (dre delRangeEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return nil
@@ -210,6 +232,11 @@ func (ce checkpointEvent) GetKVs() []roachpb.KeyValue {
 	return nil
 }
 
+// GetPrevValues implements the Event interface.
+func (ce checkpointEvent) GetPrevValues() []roachpb.Value {
+	return nil
+}
+
 // GetSSTable implements the Event interface.
 func (ce checkpointEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return nil
@@ -251,6 +278,11 @@ func (spe spanConfigEvent) GetKVs() []roachpb.KeyValue {
 	return nil
 }
 
+// GetPrevValues implements the Event interface.
+func (spe spanConfigEvent) GetPrevValues() []roachpb.Value {
+	return nil
+}
+
 // GetSSTable implements the Event interface.
 func (spe spanConfigEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return nil
@@ -292,6 +324,11 @@ func (se splitEvent) GetKVs() []roachpb.KeyValue {
 	return nil
 }
 
+// GetPrevValues implements the Event interface.
+func (se splitEvent) GetPrevValues() []roachpb.Value {
+	return nil
+}
+
 // GetSSTable implements the Event interface.
 func (se splitEvent) GetSSTable() *kvpb.RangeFeedSSTable {
 	return nil
@@ -322,6 +359,12 @@ func MakeKVEvent(kv []roachpb.KeyValue) Event {
 	return kvEvent{kv: kv}
 }
 
+// MakeKVEventWithPrevValues creates an Event from KVs along with the values
+// they replaced at the source.
+func MakeKVEventWithPrevValues(kv []roachpb.KeyValue, prevValues []roachpb.Value) Event {
+	return kvEvent{kv: kv, prevValues: prevValues}
+}
+
 // MakeSSTableEvent creates an Event from a SSTable.
 func MakeSSTableEvent(sst kvpb.RangeFeedSSTable) Event {
 	return sstableEvent{sst: sst}
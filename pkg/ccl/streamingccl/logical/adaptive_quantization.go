@@ -0,0 +1,91 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+)
+
+var quantizeTargetSpans = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.timestamp_granularity_target_spans",
+	"if non-zero, the number of spans in the frontier of a writer processor that the granularity of "+
+		"its replicated times adapts to: the granularity is doubled, up to max_timestamp_granularity, "+
+		"while the frontier holds more spans, and halved, down to timestamp_granularity, while it "+
+		"holds fewer than half as many",
+	0,
+	settings.NonNegativeInt,
+)
+
+var maxQuantize = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_timestamp_granularity",
+	"the coarsest granularity at which replicated times are quantized when the granularity adapts "+
+		"to timestamp_granularity_target_spans",
+	time.Minute,
+	settings.NonNegativeDuration,
+)
+
+// Coarser quantization rounds the resolved timestamps of more spans down to
+// the same time, so that adjacent spans merge in the frontier, at the cost of
+// a replicated time that trails the source's checkpoints by up to the
+// granularity. A fixed granularity is either too fine for a fragmented
+// frontier or too coarse for a compact one, so if
+// timestamp_granularity_target_spans is set, each processor adapts its
+// granularity to keep its frontier near that many spans, between
+// timestamp_granularity and max_timestamp_granularity. Since a new granularity
+// only merges spans once their timestamps have advanced by about that much,
+// the granularity changes at most once per its current value.
+
+// adaptiveQuantization tracks the granularity at which a processor quantizes
+// resolved timestamps. It is only accessed by the goroutine that consumes the
+// partition's events.
+type adaptiveQuantization struct {
+	// current is the effective granularity, or 0 if it hasn't been adapted
+	// yet.
+	current time.Duration
+	// lastChange is when current last changed.
+	lastChange time.Time
+}
+
+// granularity returns the granularity at which resolved timestamps are
+// quantized, which is timestamp_granularity unless it adapts to the frontier.
+func (q *adaptiveQuantization) granularity(sv *settings.Values) time.Duration {
+	base := quantize.Get(sv)
+	if base <= 0 || quantizeTargetSpans.Get(sv) == 0 {
+		q.current = 0
+		return base
+	}
+	q.current = min(max(q.current, base), max(maxQuantize.Get(sv), base))
+	return q.current
+}
+
+// adapt coarsens the granularity if the frontier holds more spans than
+// timestamp_granularity_target_spans, or refines it if it holds fewer than
+// half as many, unless the granularity changed within its current value.
+func (q *adaptiveQuantization) adapt(sv *settings.Values, frontierSpans int, now time.Time) {
+	cur := q.granularity(sv)
+	target := int(quantizeTargetSpans.Get(sv))
+	if target == 0 || cur <= 0 || now.Sub(q.lastChange) < cur {
+		return
+	}
+	base := quantize.Get(sv)
+	next := cur
+	switch {
+	case frontierSpans > target:
+		next = min(cur*2, max(maxQuantize.Get(sv), base))
+	case frontierSpans < target/2:
+		next = max(cur/2, base)
+	}
+	if next != cur {
+		q.current, q.lastChange = next, now
+	}
+}
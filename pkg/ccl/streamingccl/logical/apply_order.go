@@ -0,0 +1,135 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// By default, the KVs of a flush are applied in key order, spread across the
+// flush's workers, so rows written at the same timestamp are applied in an
+// order that depends on their keys and on the workers' progress. If the
+// stream sets an apply_order_column, the KVs of a flush are instead applied by
+// a single worker in timestamp order and, at the same timestamp, in the order
+// of the value of that column, so that destinations sensitive to the order in
+// which rows are applied, e.g. because they have triggers, see a reproducible
+// order. The order only holds within the flushes of each processor.
+
+// orderedKV is a KV of a flush along with the value by which it is ordered
+// among the KVs written at the same timestamp.
+type orderedKV struct {
+	replicatedKV
+	value tree.Datum
+}
+
+// sortByApplyOrder sorts the KVs by timestamp, then by the given values of
+// their apply order column, with NULLs first, and then by row. All the KVs of a
+// row written at the same timestamp stay contiguous.
+func sortByApplyOrder(cmpCtx tree.CompareContext, kvs []replicatedKV, values []tree.Datum) {
+	ordered := make([]orderedKV, len(kvs))
+	for i := range kvs {
+		ordered[i] = orderedKV{replicatedKV: kvs[i], value: values[i]}
+	}
+	slices.SortStableFunc(ordered, func(a, b orderedKV) int {
+		if c := a.Value.Timestamp.Compare(b.Value.Timestamp); c != 0 {
+			return c
+		}
+		if c := compareApplyOrderValues(cmpCtx, a.value, b.value); c != 0 {
+			return c
+		}
+		if c := rowKey(a.replicatedKV).Compare(rowKey(b.replicatedKV)); c != 0 {
+			return c
+		}
+		return a.Key.Compare(b.Key)
+	})
+	for i := range ordered {
+		kvs[i] = ordered[i].replicatedKV
+	}
+}
+
+// compareApplyOrderValues compares values of the apply order column such that
+// the order is total even if the column has other types in other tables: NULLs
+// sort first, then values by the name of their type, and values of the same
+// type by value.
+func compareApplyOrderValues(cmpCtx tree.CompareContext, a, b tree.Datum) int {
+	if aNull, bNull := a == tree.DNull, b == tree.DNull; aNull || bNull {
+		switch {
+		case aNull && bNull:
+			return 0
+		case aNull:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if c := strings.Compare(a.ResolvedType().SQLString(), b.ResolvedType().SQLString()); c != 0 {
+		return c
+	}
+	c, err := a.CompareError(cmpCtx, b)
+	if err != nil {
+		// Values of the same type are comparable, but if they weren't, their
+		// string encodings still order them consistently.
+		return strings.Compare(a.String(), b.String())
+	}
+	return c
+}
+
+// applyOrderValue returns the value of the given column of the row written by
+// the KV, or NULL if the KV doesn't carry the column, e.g. because its table
+// doesn't have it or it is stored in another column family.
+func (lww *sqlLastWriteWinsRowProcessor) applyOrderValue(
+	ctx context.Context, kv replicatedKV, column string,
+) tree.Datum {
+	row, err := lww.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if err != nil {
+		return tree.DNull
+	}
+	it, err := row.DatumNamed(column)
+	if err != nil {
+		return tree.DNull
+	}
+	value := tree.Datum(tree.DNull)
+	_ = it.Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+		value = d
+		return nil
+	})
+	return value
+}
+
+// sortFlushByApplyOrder sorts the KVs of a flush by the stream's apply order
+// column. It returns false, leaving the KVs in key order, if the stream has no
+// apply order column or its rows aren't applied by a row processor that can
+// decode them.
+func (lrw *logicalReplicationWriterProcessor) sortFlushByApplyOrder(
+	ctx context.Context, kvs []replicatedKV,
+) bool {
+	column := lrw.spec.Options.ApplyOrderColumn
+	if column == "" || len(lrw.bh) == 0 {
+		return false
+	}
+	tb, ok := lrw.bh[0].(*txnBatch)
+	if !ok {
+		return false
+	}
+	lww, ok := tb.rp.(*sqlLastWriteWinsRowProcessor)
+	if !ok {
+		return false
+	}
+	values := make([]tree.Datum, len(kvs))
+	for i, kv := range kvs {
+		values[i] = lww.applyOrderValue(ctx, kv, column)
+	}
+	sortByApplyOrder(lrw.EvalCtx, kvs, values)
+	return true
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/backupccl/backupencryption"
+	"github.com/cockroachdb/cockroach/pkg/ccl/storageccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/utilccl"
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/privilege"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/volatility"
+	"github.com/cockroachdb/cockroach/pkg/sql/syntheticprivilege"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/errors"
+)
+
+// Besides the job's own records, a stream persists rows and checkpoints
+// through its checkpoint sink and dead letter queue, which may hold sensitive
+// values. If the stream sets encryption_kms, each writer processor generates
+// a random data key when it starts, encrypts it with the stream's KMS key, and
+// encrypts every artifact it persists with the data key, the way backups are
+// encrypted. Each encrypted artifact is prefixed by the encrypted data key, so
+// that it can be decrypted with access to the KMS key alone, and the KMS is
+// only called once per processor. Artifacts are decrypted with
+// crdb_internal.decrypt_logical_replication_artifact, given the stream's KMS
+// URI. The values of rows are kept out of the job's status and the logs of
+// every stream, as those aren't encrypted.
+
+// artifactEncryption encrypts the artifacts persisted by a writer processor.
+type artifactEncryption struct {
+	// dataKey is the key with which artifacts are encrypted.
+	dataKey []byte
+	// encryptedDataKey is dataKey encrypted with the stream's KMS key.
+	encryptedDataKey []byte
+}
+
+// makeArtifactEncryption returns the artifactEncryption of a processor of the
+// stream with the given KMS URI.
+func makeArtifactEncryption(
+	ctx context.Context, flowCtx *execinfra.FlowCtx, kmsURI string,
+) (*artifactEncryption, error) {
+	execCfg := flowCtx.Cfg.ExecutorConfig.(*sql.ExecutorConfig)
+	kmsEnv := backupencryption.MakeBackupKMSEnv(execCfg.Settings, &execCfg.ExternalIODirConfig,
+		execCfg.InternalDB, flowCtx.EvalCtx.SessionData().User())
+	kms, err := cloud.KMSFromURI(ctx, kmsURI, &kmsEnv)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening encryption KMS")
+	}
+	defer func() {
+		if err := kms.Close(); err != nil {
+			log.Warningf(ctx, "failed to close encryption KMS: %v", err)
+		}
+	}()
+	return newArtifactEncryption(ctx, kms)
+}
+
+// newArtifactEncryption generates a data key and encrypts it with the given
+// KMS.
+func newArtifactEncryption(ctx context.Context, kms cloud.KMS) (*artifactEncryption, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, errors.Wrap(err, "generating data key")
+	}
+	encryptedDataKey, err := kms.Encrypt(ctx, dataKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encrypting data key with KMS key %s", kms.MasterKeyID())
+	}
+	return &artifactEncryption{dataKey: dataKey, encryptedDataKey: encryptedDataKey}, nil
+}
+
+// encrypt returns the given artifact encrypted with the data key, prefixed by
+// the length of the encrypted data key and the encrypted data key itself.
+func (e *artifactEncryption) encrypt(plaintext []byte) ([]byte, error) {
+	ciphertext, err := storageccl.EncryptFile(plaintext, e.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	res := binary.AppendUvarint(nil, uint64(len(e.encryptedDataKey)))
+	res = append(res, e.encryptedDataKey...)
+	return append(res, ciphertext...), nil
+}
+
+// decryptArtifact decrypts an artifact encrypted by an artifactEncryption
+// whose data key was encrypted by the given KMS.
+func decryptArtifact(
+	ctx context.Context, kms cloud.KMS, data []byte, acc *mon.BoundAccount,
+) ([]byte, error) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || uint64(len(data)-l) < n {
+		return nil, errors.New("malformed encrypted artifact")
+	}
+	dataKey, err := kms.Decrypt(ctx, data[l:l+int(n)])
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting data key with KMS key %s", kms.MasterKeyID())
+	}
+	return storageccl.DecryptFile(ctx, data[l+int(n):], dataKey, acc)
+}
+
+func init() {
+	utilccl.RegisterCCLBuiltin("crdb_internal.decrypt_logical_replication_artifact",
+		`Decrypts an artifact persisted by a logical replication stream that sets encryption_kms, `+
+			`e.g. the key, value or base64-decoded reason of a dead letter queue row, with the `+
+			`stream's KMS URI.`,
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "kms_uri", Typ: types.String},
+				{Name: "artifact", Typ: types.Bytes},
+			},
+			ReturnType: tree.FixedReturnType(types.Bytes),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := evalCtx.SessionAccessor.CheckPrivilege(ctx,
+					syntheticprivilege.GlobalPrivilegeObject, privilege.REPLICATION); err != nil {
+					return nil, err
+				}
+				execCfg := evalCtx.Planner.ExecutorConfig().(*sql.ExecutorConfig)
+				kmsEnv := backupencryption.MakeBackupKMSEnv(execCfg.Settings, &execCfg.ExternalIODirConfig,
+					execCfg.InternalDB, evalCtx.SessionData().User())
+				kms, err := cloud.KMSFromURI(ctx, string(tree.MustBeDString(args[0])), &kmsEnv)
+				if err != nil {
+					return nil, errors.Wrap(err, "opening encryption KMS")
+				}
+				defer func() {
+					if err := kms.Close(); err != nil {
+						log.Warningf(ctx, "failed to close encryption KMS: %v", err)
+					}
+				}()
+				acc := evalCtx.Planner.Mon().MakeBoundAccount()
+				defer acc.Close(ctx)
+				plaintext, err := decryptArtifact(ctx, kms, []byte(tree.MustBeDBytes(args[1])), &acc)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDBytes(tree.DBytes(plaintext)), nil
+			},
+			Class:      tree.NormalClass,
+			Volatility: volatility.Volatile,
+		})
+}
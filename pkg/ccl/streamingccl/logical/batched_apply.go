@@ -0,0 +1,358 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser/statements"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+)
+
+var batchedApply = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.batched_apply.enabled",
+	"if enabled, the deletes of each batch are applied with one DELETE statement per destination "+
+		"table and its upserts with one INSERT statement per destination table and column family, "+
+		"rather than with one statement per row; streams that set apply_order_column, shadow_destination "+
+		"or fanout_sink always apply one statement per row",
+	false,
+)
+
+// Applying each row with its own statement costs a round trip to KV per row
+// for its conditional write, even though the rows of a batch are sorted and
+// most destination tables receive many of them. With batched_apply enabled,
+// the deletes of a batch are instead grouped into one DELETE statement per
+// table, and its upserts into one INSERT ... ON CONFLICT statement per table
+// and column family, each of which checks the last-write-wins timestamp of
+// every row it applies, so that KV receives the writes of a group in a single
+// batch.
+//
+// A key written more than once by the batch is applied row by row, in order,
+// since a statement can't apply two rows to the same key. So are the rows of
+// tables whose rows need statements of their own, e.g. because their
+// destination table lacks some of their columns, and the rows of processors
+// that check prior values or soft delete rows, whose writes also clear the
+// soft delete column. Splitting a batch into deletes and upserts reorders the
+// writes of different keys, which are independent of each other unless the
+// stream applies rows in a given order, i.e. sets an apply_order_column, whose
+// batches are therefore never applied by batched statements. Neither are those of streams that set a shadow_destination or a
+// fanout_sink, nor the rows of processors that verify that the timestamps
+// applied to each key are monotonic: a batched statement doesn't tell which of
+// its rows lost to a newer destination row, and those rows must not be applied
+// to the shadow, emitted to the sink or taken for applied timestamps.
+//
+// Batches whose prior rows are prefetched, with prefetch_prior_rows enabled,
+// are also applied by batched statements, whether or not batched_apply is
+// enabled. The rows that lose to a prefetched row are dropped from their
+// group, so that only the writes that win reach KV.
+
+// maxRowsPerBatchedStatement bounds the number of rows applied by a batched
+// statement, and so the number of its placeholders.
+const maxRowsPerBatchedStatement = 128
+
+// batchedRow is a row applied by a batched statement.
+type batchedRow struct {
+	kv replicatedKV
+	// columns are the names of the columns written by an upsert, which are
+	// the same for all the rows of a table's column family, and datums their
+	// values. They are empty for a delete.
+	columns []string
+	datums  []interface{}
+	// keyDatums are the values of the row's primary key columns.
+	keyDatums []interface{}
+	ts        *tree.DDecimal
+}
+
+// batchedGroupKey identifies the rows applied by the same batched statements:
+// the deletes of a table, or the upserts of one of its column families.
+type batchedGroupKey struct {
+	tableID  catid.DescID
+	familyID catid.FamilyID
+	delete   bool
+}
+
+// batchedGroup is a group of rows applied by the same batched statements.
+type batchedGroup struct {
+	batchedGroupKey
+	td   catalog.TableDescriptor
+	rows []batchedRow
+}
+
+// ApplyBatch implements the batchApplier interface.
+func (lww *sqlLastWriteWinsRowProcessor) ApplyBatch(
+	ctx context.Context, txn isql.Txn, batch []replicatedKV,
+) error {
+	if len(batch) < 2 {
+		for _, kv := range batch {
+			if err := lww.ProcessRow(ctx, txn, kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sp := tracing.SpanFromContext(ctx)
+	verbose := sp != nil && sp.IsVerbose()
+
+	// The rows are decoded up front to find the keys written more than once,
+	// and the parts of each row a batched statement needs are kept since the
+	// decoder may reuse the memory of a row for the next.
+	type decodedRow struct {
+		batchedRow
+		groupKey  batchedGroupKey
+		td        catalog.TableDescriptor
+		key       string
+		batchable bool
+	}
+	decoded := make([]decodedRow, len(batch))
+	writes := make(map[string]int, len(batch))
+	for i, kv := range batch {
+		row, err := lww.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+		if err != nil {
+			return err
+		}
+		keyDatums, err := keyColumnDatums(row)
+		if err != nil {
+			return err
+		}
+		d := decodedRow{
+			batchedRow: batchedRow{
+				kv:        kv,
+				keyDatums: keyDatums,
+				ts:        eval.TimestampToDecimalDatum(row.MvccTimestamp),
+			},
+			groupKey:  batchedGroupKey{tableID: row.TableID, delete: row.IsDeleted()},
+			td:        row.TableDescriptor(),
+			key:       prefetchKey(row.TableID, keyDatums),
+			batchable: lww.batchesRow(row),
+		}
+		if d.batchable && !row.IsDeleted() {
+			d.groupKey.familyID = row.FamilyID
+			if err := row.ForAllColumns().Datum(func(datum tree.Datum, col cdcevent.ResultColumn) error {
+				if col.Computed || col.Name == "crdb_internal_origin_timestamp" {
+					return nil
+				}
+				d.columns = append(d.columns, col.Name)
+				d.datums = append(d.datums, datum)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		writes[d.key]++
+		decoded[i] = d
+	}
+
+	// Group the rows by statement in the order of the batch, setting aside the
+	// rows that are applied on their own.
+	var groups []*batchedGroup
+	groupIdx := make(map[batchedGroupKey]int)
+	var rest []replicatedKV
+	for _, d := range decoded {
+		if !d.batchable || writes[d.key] > 1 {
+			rest = append(rest, d.kv)
+			continue
+		}
+		if existing := lww.prefetched[d.key]; existing != nil && newerThan(existing, d.ts, d.groupKey.delete) {
+			// The row loses to the prefetched destination row, so it isn't
+			// written at all.
+			lww.skippedWrites++
+			lww.recordLoss(d.kv)
+			continue
+		}
+		if verbose {
+			lww.traceKeyMapping(ctx, d.kv)
+		}
+		idx, ok := groupIdx[d.groupKey]
+		if !ok {
+			idx = len(groups)
+			groupIdx[d.groupKey] = idx
+			groups = append(groups, &batchedGroup{batchedGroupKey: d.groupKey, td: d.td})
+		}
+		groups[idx].rows = append(groups[idx].rows, d.batchedRow)
+	}
+
+	for _, g := range groups {
+		for rows := g.rows; len(rows) > 0; {
+			n := min(len(rows), maxRowsPerBatchedStatement)
+			if err := lww.applyBatchedRows(ctx, txn, g, rows[:n]); err != nil {
+				return err
+			}
+			rows = rows[n:]
+		}
+	}
+	for _, kv := range rest {
+		if err := lww.ProcessRow(ctx, txn, kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchesRow returns true if the row may be applied by a batched statement,
+// which is the case unless applying it takes more than writing its values,
+// e.g. checking its prior value or writing columns of the destination that it
+// doesn't have.
+func (lww *sqlLastWriteWinsRowProcessor) batchesRow(row cdcevent.Row) bool {
+	if lww.compareAndSwap || lww.softDelete || lww.applied != nil || lww.ignoresDelete(row) {
+		return false
+	}
+	tableID := row.TableID
+	if _, ok := lww.queryBuffer.regionRules[tableID]; ok {
+		return false
+	}
+	if _, ok := lww.queryBuffer.auditRules[tableID]; ok {
+		return false
+	}
+	return len(lww.droppedColumns[tableID]) == 0 && len(lww.notNullColumns[tableID]) == 0 &&
+		len(lww.destinationTypes[tableID]) == 0 && len(lww.resetColumns(tableID)) == 0 &&
+		len(lww.fanoutTables[tableID]) == 0
+}
+
+// applyBatchedRows applies the rows of the group with a single statement.
+func (lww *sqlLastWriteWinsRowProcessor) applyBatchedRows(
+	ctx context.Context, txn isql.Txn, g *batchedGroup, rows []batchedRow,
+) error {
+	stmt, err := lww.batchedQuery(g, rows[0].columns, len(rows))
+	if err != nil {
+		return err
+	}
+	opName := "replicated-batched-insert"
+	if g.delete {
+		opName = "replicated-batched-delete"
+	}
+	var args []interface{}
+	for _, r := range rows {
+		if g.delete {
+			args = append(args, r.keyDatums...)
+		} else {
+			args = append(args, r.datums...)
+		}
+		args = append(args, r.ts)
+	}
+	if _, err := txn.ExecParsed(ctx, opName, txn.KV(), stmt, args...); err != nil {
+		log.Warningf(ctx, "replicated batched write of %d rows to %s failed: %s",
+			len(rows), lww.queryBuffer.tableNames[g.tableID], err.Error())
+		return err
+	}
+	lww.metrics.BatchedApplyRows.Inc(int64(len(rows)))
+	return nil
+}
+
+// batchedQuery returns the statement applying n rows of the group that write
+// the given columns, generating it if necessary.
+func (lww *sqlLastWriteWinsRowProcessor) batchedQuery(
+	g *batchedGroup, columns []string, n int,
+) (statements.Statement[tree.Statement], error) {
+	qb := &lww.queryBuffer
+	cacheKey := fmt.Sprintf("%d/%d/%t/%d/%s", g.tableID, g.familyID, g.delete, n, strings.Join(columns, ","))
+	if q, ok := qb.batchedQueries[cacheKey]; ok {
+		return q, nil
+	}
+	name := qb.tableNames[g.tableID]
+	var sql string
+	if g.delete {
+		sql = makeBatchedDeleteQuery(name, g.td, n)
+	} else {
+		sql = makeBatchedInsertQuery(name, g.td, columns, n)
+	}
+	q, err := parser.ParseOne(sql)
+	if err != nil {
+		return statements.Statement[tree.Statement]{}, err
+	}
+	qb.batchedQueries[cacheKey] = q
+	return q, nil
+}
+
+// makeBatchedDeleteQuery returns a statement deleting n rows of the table,
+// each given by placeholders for the values of its primary key columns
+// followed by one for its origin timestamp. Like the statement of
+// makeDeleteQuery, it only deletes destination rows older than the delete.
+func makeBatchedDeleteQuery(fqTableName string, td catalog.TableDescriptor, n int) string {
+	names := td.TableDesc().PrimaryIndex.KeyColumnNames
+	var whereClause strings.Builder
+	argIdx := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			whereClause.WriteString("\n   OR ")
+		}
+		whereClause.WriteString("(")
+		for _, name := range names {
+			fmt.Fprintf(&whereClause, "%s = $%d AND ", tree.NameString(name), argIdx)
+			argIdx++
+		}
+		fmt.Fprintf(&whereClause, `((%[1]s.crdb_internal_mvcc_timestamp < $%[2]d
+         AND %[1]s.crdb_internal_origin_timestamp IS NULL)
+     OR (%[1]s.crdb_internal_origin_timestamp < $%[2]d
+         AND %[1]s.crdb_internal_origin_timestamp IS NOT NULL)))`, fqTableName, argIdx)
+		argIdx++
+	}
+	return fmt.Sprintf(`
+DELETE FROM %s
+WHERE %s`, fqTableName, whereClause.String())
+}
+
+// makeBatchedInsertQuery returns a statement upserting n rows of the table,
+// each given by placeholders for the values of the columns followed by one for
+// its origin timestamp. Like the statements of makeInsertQueries, it only
+// updates destination rows that aren't newer than the upsert.
+func makeBatchedInsertQuery(
+	fqTableName string, td catalog.TableDescriptor, columns []string, n int,
+) string {
+	var valueStrings strings.Builder
+	argIdx := 1
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			valueStrings.WriteString(",\n       ")
+		}
+		valueStrings.WriteString("(")
+		for j := 0; j <= len(columns); j++ {
+			if j > 0 {
+				valueStrings.WriteString(", ")
+			}
+			fmt.Fprintf(&valueStrings, "$%d", argIdx)
+			argIdx++
+		}
+		valueStrings.WriteString(")")
+	}
+	quoted := make([]string, len(columns))
+	var onConflictUpdateClause strings.Builder
+	for i, name := range columns {
+		quoted[i] = tree.NameString(name)
+		fmt.Fprintf(&onConflictUpdateClause, "%[1]s = excluded.%[1]s,\n", quoted[i])
+	}
+	baseQuery := `
+INSERT INTO %[1]s (%[2]s, crdb_internal_origin_timestamp)
+VALUES %[3]s
+ON CONFLICT ON CONSTRAINT %[4]s
+DO UPDATE SET
+%[5]scrdb_internal_origin_timestamp = excluded.crdb_internal_origin_timestamp
+WHERE (%[1]s.crdb_internal_mvcc_timestamp <= excluded.crdb_internal_origin_timestamp
+       AND %[1]s.crdb_internal_origin_timestamp IS NULL)
+   OR (%[1]s.crdb_internal_origin_timestamp <= excluded.crdb_internal_origin_timestamp
+       AND %[1]s.crdb_internal_origin_timestamp IS NOT NULL)`
+	return fmt.Sprintf(baseQuery,
+		fqTableName,
+		strings.Join(quoted, ", "),
+		valueStrings.String(),
+		tree.NameString(td.GetPrimaryIndex().GetName()),
+		onConflictUpdateClause.String(),
+	)
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+)
+
+var catchUpLagThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.catch_up_lag_threshold",
+	"the replication lag above which a stream whose frontier advances faster than real time "+
+		"is reported as catching up rather than in steady state; if 0, streams are never "+
+		"reported as catching up",
+	time.Minute,
+	settings.NonNegativeDuration,
+)
+
+// frontierAdvanceRateDecay is the weight of the previous frontier advance rate
+// when it is combined with a new sample.
+const frontierAdvanceRateDecay = 0.8
+
+// catchUpSampleInterval is the minimum interval between samples of the
+// frontier, so that frequent callers don't measure the rate over intervals
+// shorter than those at which the frontier advances.
+const catchUpSampleInterval = time.Second
+
+// catchUpTracker tracks how fast a frontier advances relative to real time to
+// tell a stream that is catching up on changes accumulated while it was paused
+// from one in steady state, and to estimate when it will be caught up.
+type catchUpTracker struct {
+	lastFrontier hlc.Timestamp
+	lastSampled  time.Time
+	// rate is a moving average of the number of seconds the frontier advances
+	// per second of real time. It is above 1 while the lag shrinks.
+	rate    float64
+	sampled bool
+}
+
+// catchUpStatus describes the progress of a stream relative to real time.
+type catchUpStatus struct {
+	// catchingUp is true if the frontier lags far behind now but advances
+	// faster than real time.
+	catchingUp bool
+	// advanceRate is the number of seconds the frontier advances per second.
+	advanceRate float64
+	// eta is the estimated time until the stream is caught up. It is only set
+	// while the stream is catching up.
+	eta time.Duration
+}
+
+// update samples the frontier at the given time and returns the stream's
+// resulting status.
+func (c *catchUpTracker) update(
+	now time.Time, frontier hlc.Timestamp, threshold time.Duration,
+) catchUpStatus {
+	if c.lastSampled.IsZero() || now.Sub(c.lastSampled) >= catchUpSampleInterval {
+		if !c.lastFrontier.IsEmpty() && !frontier.IsEmpty() {
+			sample := float64(frontier.GoTime().Sub(c.lastFrontier.GoTime())) / float64(now.Sub(c.lastSampled))
+			if c.sampled {
+				c.rate = frontierAdvanceRateDecay*c.rate + (1-frontierAdvanceRateDecay)*sample
+			} else {
+				c.rate, c.sampled = sample, true
+			}
+		}
+		c.lastFrontier, c.lastSampled = frontier, now
+	}
+
+	status := catchUpStatus{advanceRate: c.rate}
+	if threshold == 0 || frontier.IsEmpty() || c.rate <= 1 {
+		return status
+	}
+	lag := now.Sub(frontier.GoTime())
+	if lag <= threshold {
+		return status
+	}
+	status.catchingUp = true
+	// The lag shrinks by rate-1 seconds every second.
+	status.eta = time.Duration(float64(lag) / (c.rate - 1))
+	return status
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+type checkViolationPolicy int64
+
+const (
+	checkViolationPause checkViolationPolicy = iota
+	checkViolationSkip
+	checkViolationDLQ
+)
+
+// checkViolationPolicySetting decides what happens to replicated rows that
+// violate a CHECK constraint of their destination table, e.g. because the
+// destination table has a constraint the source table doesn't.
+var checkViolationPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.check_violation_policy",
+	"what to do with replicated rows that violate a CHECK constraint of their destination table: "+
+		"pause pauses the job until an operator intervenes, skip ignores them and dlq sends them to "+
+		"the dead letter queue",
+	"pause",
+	map[int64]string{
+		int64(checkViolationPause): "pause",
+		int64(checkViolationSkip):  "skip",
+		int64(checkViolationDLQ):   "dlq",
+	},
+)
+
+// isCheckViolation returns true if the error is the rejection of a write that
+// violates a CHECK constraint.
+func isCheckViolation(err error) bool {
+	return pgerror.GetPGCode(err) == pgcode.CheckViolation
+}
+
+// annotateCheckViolation wraps the error of a row's write that violates a
+// CHECK constraint with the name of the constraint and the names of the row's
+// columns, so that the violation can be acted upon without decoding the row.
+// The row's values aren't included since the error ends up in the job's
+// status and the logs, which aren't encrypted.
+func annotateCheckViolation(row cdcevent.Row, err error) error {
+	var cols []string
+	_ = row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if !col.Computed {
+			cols = append(cols, col.Name)
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "row of table %s with columns %s violates destination CHECK constraint %q",
+		row.TableName, strings.Join(cols, ", "), pgerror.GetConstraintName(err))
+}
+
+// handleCheckViolation handles a row that violates a CHECK constraint of its
+// destination table according to check_violation_policy: it is skipped, sent
+// to the dead letter queue or else returned as a permanent job error.
+func (lrw *logicalReplicationWriterProcessor) handleCheckViolation(
+	ctx context.Context, kv replicatedKV, err error,
+) error {
+	lrw.metrics.CheckViolations.Inc(1)
+	switch checkViolationPolicy(checkViolationPolicySetting.Get(&lrw.FlowCtx.Cfg.Settings.SV)) {
+	case checkViolationSkip:
+		return nil
+	case checkViolationDLQ:
+		return lrw.sendToDLQ(ctx, kv, err)
+	default:
+		return jobs.MarkAsPermanentJobError(errors.WithHint(err,
+			"set logical_replication.consumer.check_violation_policy to skip or dlq to resume the job "+
+				"without applying such rows"))
+	}
+}
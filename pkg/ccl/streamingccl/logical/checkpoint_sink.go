@@ -0,0 +1,93 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/cloud"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/errors"
+)
+
+// CheckpointSink receives every checkpoint emitted by a writer processor, in
+// addition to the job's own progress persistence, so that an external
+// controller can follow the progress of a stream, e.g. to coordinate cutover.
+type CheckpointSink interface {
+	// WriteCheckpoint records the spans resolved by the processor as of the
+	// most recent flush. Checkpoints of a processor are written in order.
+	WriteCheckpoint(ctx context.Context, checkpoint *jobspb.ResolvedSpans) error
+	// Close releases the resources held by the sink.
+	Close() error
+}
+
+// makeCheckpointSink opens the checkpoint sink of the given writer spec. If
+// enc is set, checkpoints are written encrypted.
+func makeCheckpointSink(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	spec execinfrapb.LogicalReplicationWriterSpec,
+	processorID int32,
+	enc *artifactEncryption,
+) (CheckpointSink, error) {
+	es, err := flowCtx.Cfg.ExternalStorageFromURI(ctx, spec.Options.CheckpointSinkURI,
+		flowCtx.EvalCtx.SessionData().User())
+	if err != nil {
+		return nil, errors.Wrap(err, "opening checkpoint sink")
+	}
+	return &externalStorageCheckpointSink{
+		es:       es,
+		basename: checkpointFileName(jobspb.JobID(spec.JobID), processorID),
+		enc:      enc,
+	}, nil
+}
+
+// checkpointFileName returns the name of the file holding the latest
+// checkpoint of the given processor of the given job.
+func checkpointFileName(jobID jobspb.JobID, processorID int32) string {
+	return fmt.Sprintf("%d/checkpoint-%d", jobID, processorID)
+}
+
+// externalStorageCheckpointSink is a CheckpointSink that writes each
+// checkpoint to external storage as a serialized jobspb.ResolvedSpans,
+// overwriting the processor's previous checkpoint.
+type externalStorageCheckpointSink struct {
+	es       cloud.ExternalStorage
+	basename string
+	// enc, if set, encrypts the checkpoints.
+	enc *artifactEncryption
+}
+
+var _ CheckpointSink = (*externalStorageCheckpointSink)(nil)
+
+// WriteCheckpoint implements the CheckpointSink interface.
+func (s *externalStorageCheckpointSink) WriteCheckpoint(
+	ctx context.Context, checkpoint *jobspb.ResolvedSpans,
+) error {
+	data, err := protoutil.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if s.enc != nil {
+		if data, err = s.enc.encrypt(data); err != nil {
+			return errors.Wrap(err, "encrypting checkpoint")
+		}
+	}
+	return cloud.WriteFile(ctx, s.es, s.basename, bytes.NewReader(data))
+}
+
+// Close implements the CheckpointSink interface.
+func (s *externalStorageCheckpointSink) Close() error {
+	return s.es.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+)
+
+var checkpointEmitInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.checkpoint_emit_interval",
+	"the minimum amount of time between the checkpoints a writer processor emits to the job after "+
+		"its flushes; the checkpoint of a flush completed sooner after the last emitted one is held "+
+		"until the interval elapses and superseded by the checkpoints of any later flushes, so that "+
+		"one checkpoint covers several flushes, which reduces the job's progress writes without "+
+		"slowing down the flushes themselves; if 0, a checkpoint is emitted after every flush",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// checkpointWindow decides which of the checkpoints of a processor's flushes
+// are emitted. Each checkpoint holds the processor's whole frontier as of its
+// flush, so a later checkpoint supersedes an earlier one and the held
+// checkpoint of a flush can simply be replaced by that of the next one. It is
+// only accessed by the flushLoop.
+type checkpointWindow struct {
+	// pending is the checkpoint of the last flush if it hasn't been emitted.
+	pending  *jobspb.ResolvedSpans
+	lastEmit time.Time
+}
+
+// add records the checkpoint of a flush completed at the given time and
+// returns the checkpoint to emit now, if any. The final checkpoint of a
+// stream is always emitted right away.
+func (w *checkpointWindow) add(
+	checkpoint *jobspb.ResolvedSpans, now time.Time, interval time.Duration,
+) *jobspb.ResolvedSpans {
+	w.pending = checkpoint
+	if checkpoint.Complete || w.wait(now, interval) == 0 {
+		return w.take(now)
+	}
+	return nil
+}
+
+// wait returns how long after the given time the pending checkpoint is due to
+// be emitted.
+func (w *checkpointWindow) wait(now time.Time, interval time.Duration) time.Duration {
+	return max(0, interval-now.Sub(w.lastEmit))
+}
+
+// take returns the pending checkpoint, if any, as emitted at the given time.
+func (w *checkpointWindow) take(now time.Time) *jobspb.ResolvedSpans {
+	checkpoint := w.pending
+	if checkpoint != nil {
+		w.pending = nil
+		w.lastEmit = now
+	}
+	return checkpoint
+}
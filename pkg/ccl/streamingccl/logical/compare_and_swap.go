@@ -0,0 +1,350 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser/statements"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/errors"
+)
+
+// By default, a replicated row is applied if the destination row is older,
+// i.e. last-write-wins. With compare_and_swap set, a row is instead only
+// applied if the destination row matches the row's prior value at the source,
+// i.e. the value the KV replaced, which gives optimistic concurrency semantics.
+// The comparison is folded into the write itself: an insert only applies if
+// there is no destination row, and an update or delete only if the
+// destination row matches the prior value, so the destination is only read if
+// the write doesn't apply.
+//
+// The source's rangefeeds are started with diffs so that the stream carries
+// the prior value of every KV. A KV of the initial scan has no prior value, so
+// it's applied as an insert. Rows whose destination row already matches their
+// new value are taken to have been applied already, e.g. by an earlier
+// attempt of the same flush, which makes retries idempotent.
+
+type casMismatchPolicy int64
+
+const (
+	casMismatchDLQ casMismatchPolicy = iota
+	casMismatchRetry
+)
+
+// casMismatchPolicySetting decides what happens to the rows of a
+// compare-and-swap stream whose destination row doesn't match their prior
+// value.
+var casMismatchPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.cas_mismatch_policy",
+	"what to do with replicated rows of compare_and_swap streams whose destination row doesn't match "+
+		"their prior value at the source: dlq sends them to the dead letter queue and retry retries "+
+		"their flush, pausing the job if the destination row still doesn't match once "+
+		"logical_replication.consumer.flush_grace_period elapses",
+	"dlq",
+	map[int64]string{
+		int64(casMismatchDLQ):   "dlq",
+		int64(casMismatchRetry): "retry",
+	},
+)
+
+// errPriorValueMismatch marks the error of a row whose destination row
+// doesn't match its prior value at the source.
+var errPriorValueMismatch = errors.New("destination row doesn't match the prior value of the replicated row")
+
+// casConditions appends the values of the columns of the row that the
+// destination writes to args and returns a predicate matching a destination
+// row with the same values.
+func (lww *sqlLastWriteWinsRowProcessor) casConditions(
+	row cdcevent.Row, args []interface{},
+) (string, []interface{}, error) {
+	var conds []string
+	err := row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if col.Computed || col.Name == "crdb_internal_origin_timestamp" {
+			return nil
+		}
+		if _, ok := lww.droppedColumns[row.TableID][col.Name]; ok {
+			return nil
+		}
+		args = append(args, d)
+		conds = append(conds, fmt.Sprintf("%s IS NOT DISTINCT FROM $%d", tree.NameString(col.Name), len(args)))
+		return nil
+	})
+	if err != nil || len(conds) == 0 {
+		return "true", args, err
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// casWriteRow applies a row if its destination row matches the row's prior value at the source. If the write doesn't apply,
+// the destination row is read to tell a row that was already applied from
+// one whose destination row matches neither value, whose error is marked with
+// errPriorValueMismatch.
+func (lww *sqlLastWriteWinsRowProcessor) casWriteRow(
+	ctx context.Context, txn isql.Txn, kv replicatedKV, row cdcevent.Row,
+) error {
+	name := lww.queryBuffer.tableNames[row.TableID]
+	prior, err := lww.decodePriorValue(ctx, kv, name)
+	if err != nil {
+		return err
+	}
+	if prior.IsDeleted() && row.IsDeleted() {
+		return nil
+	}
+	keyDatums, err := keyColumnDatums(row)
+	if err != nil {
+		return err
+	}
+	var newCols, priorCols []casColumn
+	if !row.IsDeleted() {
+		if newCols, err = lww.casColumns(ctx, row, true /* newValue */); err != nil {
+			return err
+		}
+	}
+	if !prior.IsDeleted() {
+		if priorCols, err = lww.casColumns(ctx, prior, false /* newValue */); err != nil {
+			return err
+		}
+	}
+	newValues := func(args []interface{}) []interface{} {
+		for _, col := range newCols {
+			args = append(args, col.datum)
+		}
+		return args
+	}
+	priorValues := func(args []interface{}) []interface{} {
+		for _, col := range priorCols {
+			if !col.key {
+				args = append(args, col.datum)
+			}
+		}
+		return args
+	}
+	originTS := eval.TimestampToDecimalDatum(row.MvccTimestamp)
+	var op casOp
+	var args []interface{}
+	switch {
+	case prior.IsDeleted():
+		op = casInsert
+		args = append(newValues(args), originTS)
+	case row.IsDeleted():
+		op = casDelete
+		args = priorValues(append(args, keyDatums...))
+	default:
+		op = casUpdate
+		args = priorValues(append(append(newValues(args), originTS), keyDatums...))
+	}
+	stmt, err := lww.casQuery(row, op, newCols, priorCols)
+	if err != nil {
+		return err
+	}
+	applied, err := txn.ExecParsed(ctx, "replicated-cas-write", txn.KV(), stmt, args...)
+	if err != nil || applied > 0 {
+		return err
+	}
+
+	// The write didn't apply, so the destination row doesn't match the prior
+	// value, but it may already match the new one.
+	stmt, err = lww.casQuery(row, casCheck, newCols, priorCols)
+	if err != nil {
+		return err
+	}
+	res, err := txn.QueryRowEx(ctx, "replicated-cas-check", txn.KV(),
+		sessiondata.NoSessionDataOverride, stmt.SQL, newValues(append([]interface{}(nil), keyDatums...))...)
+	if err != nil {
+		return err
+	}
+	if exists := res != nil; row.IsDeleted() && !exists || !row.IsDeleted() && exists && res[0] == tree.DBoolTrue {
+		return nil
+	}
+	return errors.Mark(errors.Newf("destination row of table %s with primary key %v "+
+		"doesn't match the prior value of the replicated row", name, keyDatums), errPriorValueMismatch)
+}
+
+// decodePriorValue decodes the prior value of the KV at the source, returning
+// a permanent job error if the source didn't report it.
+func (lww *sqlLastWriteWinsRowProcessor) decodePriorValue(
+	ctx context.Context, kv replicatedKV, name string,
+) (cdcevent.Row, error) {
+	if kv.prevValue == nil {
+		return cdcevent.Row{}, jobs.MarkAsPermanentJobError(errors.WithHint(errors.Newf(
+			"the source did not report the prior value of a row of table %s", name),
+			"compare_and_swap requires a source that reports the prior value of every replicated KV"))
+	}
+	return lww.decoder.DecodeKV(ctx, roachpb.KeyValue{Key: kv.Key, Value: *kv.prevValue},
+		cdcevent.PrevRow, kv.Value.Timestamp, false)
+}
+
+// casColumn is a column of a row compared or written by a compare-and-swap
+// statement.
+type casColumn struct {
+	name  string
+	datum tree.Datum
+	key   bool
+}
+
+// casColumns returns the columns of the row that the destination writes, with
+// their values re-encoded into the types of the destination columns. The
+// columns of a new value whose NULL is left to the column's DEFAULT are left
+// out.
+func (lww *sqlLastWriteWinsRowProcessor) casColumns(
+	ctx context.Context, row cdcevent.Row, newValue bool,
+) ([]casColumn, error) {
+	keyColumns := row.TableDescriptor().TableDesc().PrimaryIndex.KeyColumnNames
+	var cols []casColumn
+	err := row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if col.Computed || col.Name == "crdb_internal_origin_timestamp" {
+			return nil
+		}
+		if !newValue {
+			if _, ok := lww.droppedColumns[row.TableID][col.Name]; ok {
+				return nil
+			}
+		} else if dropped, err := lww.droppedColumn(row.TableID, col.Name, d); err != nil || dropped {
+			return err
+		} else if lww.defaultsNullValue(row.TableID, col.Name, d) {
+			lww.metrics.NotNullViolations.Inc(1)
+			return nil
+		}
+		d, err := lww.destinationValue(ctx, row.TableID, col.Name, d)
+		if err != nil {
+			return err
+		}
+		cols = append(cols, casColumn{name: col.Name, datum: d, key: slices.Contains(keyColumns, col.Name)})
+		return nil
+	})
+	return cols, err
+}
+
+type casOp int
+
+const (
+	casInsert casOp = iota
+	casUpdate
+	casDelete
+	casCheck
+)
+
+// casQuery returns the statement of the given operation that applies or
+// checks rows of the row's table and column family with the given new and
+// prior columns, generating it if necessary.
+//
+// The placeholders of an insert are the new values followed by the origin
+// timestamp, those of an update the new values, the origin timestamp, the
+// primary key and the prior values of the columns outside of it, those of a
+// delete the primary key and the prior values, and those of a check the
+// primary key followed by the new values.
+func (lww *sqlLastWriteWinsRowProcessor) casQuery(
+	row cdcevent.Row, op casOp, newCols, priorCols []casColumn,
+) (statements.Statement[tree.Statement], error) {
+	var newNames, priorNames []string
+	for _, col := range newCols {
+		newNames = append(newNames, col.name)
+	}
+	for _, col := range priorCols {
+		priorNames = append(priorNames, col.name)
+	}
+	reset := lww.resetColumns(row.TableID)
+	qb := &lww.queryBuffer
+	cacheKey := fmt.Sprintf("%d/%d/%d/%s/%s/%s", op, row.TableID, row.FamilyID,
+		strings.Join(newNames, ","), strings.Join(priorNames, ","), strings.Join(reset, ","))
+	if q, ok := qb.casQueries[cacheKey]; ok {
+		return q, nil
+	}
+
+	td := row.TableDescriptor()
+	keyCount := len(td.TableDesc().PrimaryIndex.KeyColumnNames)
+	table := qb.tableNames[row.TableID]
+	var sql strings.Builder
+	switch op {
+	case casInsert:
+		columns := make([]string, 0, len(newCols)+1)
+		values := make([]string, 0, len(newCols)+1)
+		for i, col := range newCols {
+			columns = append(columns, tree.NameString(col.name))
+			values = append(values, fmt.Sprintf("$%d", i+1))
+		}
+		columns = append(columns, "crdb_internal_origin_timestamp")
+		values = append(values, fmt.Sprintf("$%d", len(newCols)+1))
+		fmt.Fprintf(&sql, "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+			table, strings.Join(columns, ", "), strings.Join(values, ", "))
+	case casUpdate:
+		fmt.Fprintf(&sql, "UPDATE %s SET ", table)
+		for i, col := range newCols {
+			if !col.key {
+				fmt.Fprintf(&sql, "%s = $%d, ", tree.NameString(col.name), i+1)
+			}
+		}
+		for _, name := range reset {
+			fmt.Fprintf(&sql, "%s = DEFAULT, ", tree.NameString(name))
+		}
+		fmt.Fprintf(&sql, "crdb_internal_origin_timestamp = $%d WHERE %s", len(newCols)+1,
+			keyColumnPredicate(td, len(newCols)+2))
+		writePriorPredicate(&sql, priorCols, len(newCols)+keyCount+2)
+	case casDelete:
+		fmt.Fprintf(&sql, "DELETE FROM %s WHERE %s", table, keyColumnPredicate(td, 1))
+		writePriorPredicate(&sql, priorCols, keyCount+1)
+	case casCheck:
+		match := "true"
+		if len(newCols) > 0 {
+			conds := make([]string, 0, len(newCols))
+			for i, col := range newCols {
+				conds = append(conds, fmt.Sprintf("%s IS NOT DISTINCT FROM $%d",
+					tree.NameString(col.name), keyCount+i+1))
+			}
+			match = strings.Join(conds, " AND ")
+		}
+		fmt.Fprintf(&sql, "SELECT %s FROM %s WHERE %s", match, table, keyColumnPredicate(td, 1))
+	}
+	q, err := parser.ParseOne(sql.String())
+	if err != nil {
+		return statements.Statement[tree.Statement]{}, err
+	}
+	qb.casQueries[cacheKey] = q
+	return q, nil
+}
+
+// writePriorPredicate writes the conditions matching the columns that aren't
+// part of the primary key against the prior values, whose placeholders start
+// at startIdx.
+func writePriorPredicate(sql *strings.Builder, cols []casColumn, startIdx int) {
+	idx := startIdx
+	for _, col := range cols {
+		if col.key {
+			continue
+		}
+		fmt.Fprintf(sql, " AND %s IS NOT DISTINCT FROM $%d", tree.NameString(col.name), idx)
+		idx++
+	}
+}
+
+// handlePriorValueMismatch handles a row whose destination row doesn't match
+// its prior value according to cas_mismatch_policy: it is sent to the dead
+// letter queue or its error is returned for the flush to be retried.
+func (lrw *logicalReplicationWriterProcessor) handlePriorValueMismatch(
+	ctx context.Context, kv replicatedKV, err error,
+) error {
+	lrw.metrics.PriorValueMismatches.Inc(1)
+	if casMismatchPolicy(casMismatchPolicySetting.Get(&lrw.FlowCtx.Cfg.Settings.SV)) == casMismatchRetry {
+		return err
+	}
+	return lrw.sendToDLQ(ctx, kv, err)
+}
@@ -0,0 +1,341 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v4"
+)
+
+var cutoverVerificationSampleRate = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.cutover_verification.sample_rate",
+	"the fraction of rows, selected by the hashes of their primary keys, that are compared by the "+
+		"sampled verification of a stream's cutover",
+	0.01,
+	settings.Fraction,
+)
+
+var cutoverVerificationMaxDivergentRows = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.cutover_verification.max_divergent_rows",
+	"the number of compared rows of the destination tables that may differ from the source tables "+
+		"as of the cutover time before the verification of a stream's cutover fails",
+	0,
+	settings.NonNegativeInt,
+)
+
+// The verification of a cutover fingerprints each replicated table on both
+// sides, the source as of the cutover time and the destination as of the time
+// the verification starts, the same way SHOW EXPERIMENTAL_FINGERPRINTS does.
+// Tables are read by ID rather than by name since a destination table need not
+// have the name of its source table. Only the replicated columns are
+// fingerprinted, i.e. not the audit columns or crdb_internal_origin_timestamp,
+// which are written differently on each side. If the fingerprints of a table
+// differ, its rows are merged in primary key order to count and report those
+// that diverged. A sampled verification reads the rows whose primary keys hash
+// into the first sample_rate of cutoverVerificationBuckets, which selects the
+// same rows on both sides.
+//
+// The destination is read as of the time the verification starts rather than
+// the cutover time since the rows through the cutover time were applied after
+// it. Rows written to the destination directly are not replicated and may well
+// have been written before the cutover; any such writes show up as divergent
+// rows.
+
+// cutoverVerificationBuckets is the number of buckets into which the hashes of
+// the primary keys of rows are divided for sampling.
+const cutoverVerificationBuckets = 10000
+
+// maxReportedDivergentKeys is the number of divergent keys included in the
+// error of a failed verification.
+const maxReportedDivergentKeys = 10
+
+// verificationRows iterates over the rows read for the verification of a
+// table, in increasing order of their keys.
+type verificationRows interface {
+	Next(ctx context.Context) (bool, error)
+	// Cur returns the string encodings of the current row's primary key and
+	// columns.
+	Cur() (key, row string)
+}
+
+// verificationResult is the outcome of the verification of one or more
+// tables.
+type verificationResult struct {
+	compared, divergent int
+	// keys holds up to maxReportedDivergentKeys of the divergent keys.
+	keys []string
+}
+
+func (v *verificationResult) recordDivergent(table, key string) {
+	v.divergent++
+	if len(v.keys) < maxReportedDivergentKeys {
+		v.keys = append(v.keys, fmt.Sprintf("%s%s", table, key))
+	}
+}
+
+// compareRows merges the rows of the source and the destination and records
+// the rows that are missing from either side or differ between them.
+func compareRows(
+	ctx context.Context, table string, src, dst verificationRows, res *verificationResult,
+) error {
+	srcOK, err := src.Next(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading source")
+	}
+	dstOK, err := dst.Next(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading destination")
+	}
+	for srcOK || dstOK {
+		srcKey, srcRow := src.Cur()
+		dstKey, dstRow := dst.Cur()
+		res.compared++
+		advanceSrc, advanceDst := true, true
+		switch {
+		case !dstOK || (srcOK && srcKey < dstKey):
+			res.recordDivergent(table, srcKey)
+			advanceDst = false
+		case !srcOK || dstKey < srcKey:
+			res.recordDivergent(table, dstKey)
+			advanceSrc = false
+		case srcRow != dstRow:
+			res.recordDivergent(table, srcKey)
+		}
+		if advanceSrc && srcOK {
+			if srcOK, err = src.Next(ctx); err != nil {
+				return errors.Wrap(err, "reading source")
+			}
+		}
+		if advanceDst && dstOK {
+			if dstOK, err = dst.Next(ctx); err != nil {
+				return errors.Wrap(err, "reading destination")
+			}
+		}
+	}
+	return nil
+}
+
+// verificationColumns returns the replicated columns of the table with the
+// given source descriptor, skipping the columns written only on the
+// destination, i.e. its audit columns, and those whose values differ between
+// the sides.
+func verificationColumns(
+	desc *descpb.TableDescriptor, auditColumns map[string]string,
+) (keyCols []string, cols []catalog.Column) {
+	td := tabledesc.NewBuilder(desc).BuildImmutableTable()
+	skip := map[string]struct{}{"crdb_internal_origin_timestamp": {}}
+	for _, col := range auditColumns {
+		skip[col] = struct{}{}
+	}
+	for _, col := range td.GetPrimaryIndex().IndexDesc().KeyColumnNames {
+		keyCols = append(keyCols, lexbase.EscapeSQLIdent(col))
+	}
+	for _, col := range td.PublicColumns() {
+		if _, ok := skip[col.GetName()]; ok || col.IsVirtual() {
+			continue
+		}
+		cols = append(cols, col)
+	}
+	return keyCols, cols
+}
+
+// verificationSource returns the FROM clause reading the table with the given
+// ID as of the given time, restricted to the sampled rows.
+func verificationSource(
+	tableID descpb.ID, keyCols []string, asOf hlc.Timestamp, sampleRate float64,
+) string {
+	src := fmt.Sprintf("[%d AS t] AS OF SYSTEM TIME %s", tableID, asOf.AsOfSystemTime())
+	if sampleRate < 1 {
+		src += fmt.Sprintf(" WHERE crc32ieee(ROW(%s)::STRING) %% %d < %d", strings.Join(keyCols, ", "),
+			cutoverVerificationBuckets, int(sampleRate*cutoverVerificationBuckets))
+	}
+	return src
+}
+
+// fingerprintQuery returns the query counting and fingerprinting the given
+// rows of the table with the given ID.
+func fingerprintQuery(
+	tableID descpb.ID, keyCols []string, cols []catalog.Column, asOf hlc.Timestamp, sampleRate float64,
+) string {
+	return fmt.Sprintf("SELECT count(*), COALESCE(xor_agg(%s)::STRING, '') FROM %s",
+		sql.FingerprintRowHash(cols), verificationSource(tableID, keyCols, asOf, sampleRate))
+}
+
+// verificationQuery returns the query reading the given rows of the table with
+// the given ID in primary key order.
+func verificationQuery(
+	tableID descpb.ID, keyCols []string, cols []catalog.Column, asOf hlc.Timestamp, sampleRate float64,
+) string {
+	names := make([]string, 0, len(cols))
+	for _, col := range cols {
+		names = append(names, lexbase.EscapeSQLIdent(col.GetName()))
+	}
+	return fmt.Sprintf("SELECT ROW(%s)::STRING AS k, ROW(%s)::STRING FROM %s ORDER BY k",
+		strings.Join(keyCols, ", "), strings.Join(names, ", "),
+		verificationSource(tableID, keyCols, asOf, sampleRate))
+}
+
+type pgxVerificationRows struct {
+	rows     pgx.Rows
+	key, row string
+}
+
+func (r *pgxVerificationRows) Next(context.Context) (bool, error) {
+	if !r.rows.Next() {
+		return false, r.rows.Err()
+	}
+	return true, r.rows.Scan(&r.key, &r.row)
+}
+
+func (r *pgxVerificationRows) Cur() (string, string) {
+	return r.key, r.row
+}
+
+type isqlVerificationRows struct {
+	rows isql.Rows
+}
+
+func (r *isqlVerificationRows) Next(ctx context.Context) (bool, error) {
+	return r.rows.Next(ctx)
+}
+
+func (r *isqlVerificationRows) Cur() (string, string) {
+	row := r.rows.Cur()
+	return string(tree.MustBeDString(row[0])), string(tree.MustBeDString(row[1]))
+}
+
+// verifyCutover compares the destination tables with the source tables as of
+// the cutover time. It returns a permanent job error, which pauses the job
+// rather than completing it, if more rows differ than max_divergent_rows.
+func (r *logicalReplicationResumer) verifyCutover(
+	ctx context.Context,
+	db descs.DB,
+	sv *settings.Values,
+	payload jobspb.LogicalReplicationDetails,
+	tableDescs map[string]descpb.TableDescriptor,
+	metrics *Metrics,
+) error {
+	sampleRate := 1.0
+	if payload.Options.CutoverVerification == "sample" {
+		sampleRate = cutoverVerificationSampleRate.Get(sv)
+	}
+	destIDs, _, err := resolveDestinationTables(ctx, db, payload.TableNames)
+	if err != nil {
+		return err
+	}
+	destAsOf := db.KV().Clock().Now()
+	conn, err := pgx.Connect(ctx, payload.TargetClusterConnStr)
+	if err != nil {
+		return errors.Wrap(err, "dialing source to verify cutover")
+	}
+	defer func() { _ = conn.Close(ctx) }()
+
+	var res verificationResult
+	for i, name := range payload.TableNames {
+		desc, ok := tableDescs[name]
+		if !ok {
+			return errors.AssertionFailedf("no source descriptor for table %s", name)
+		}
+		keyCols, cols := verificationColumns(&desc, payload.Options.TableAuditColumns[name].Columns)
+		t := verifiedTable{
+			name:       name,
+			srcID:      desc.ID,
+			destID:     destIDs[i],
+			keyCols:    keyCols,
+			cols:       cols,
+			srcAsOf:    payload.Options.CutoverTime,
+			destAsOf:   destAsOf,
+			sampleRate: sampleRate,
+		}
+		if err := t.verify(ctx, conn, db, &res); err != nil {
+			return errors.Wrapf(err, "verifying table %s", name)
+		}
+	}
+	metrics.CutoverDivergentRows.Inc(int64(res.divergent))
+	log.Infof(ctx, "cutover verification compared %d rows, %d of which diverged", res.compared, res.divergent)
+	if maxDivergent := cutoverVerificationMaxDivergentRows.Get(sv); int64(res.divergent) > maxDivergent {
+		return jobs.MarkAsPermanentJobError(errors.Newf(
+			"cutover verification found %d of %d compared rows that differ between the source as of %s "+
+				"and the destination, more than the %d allowed by "+
+				"logical_replication.consumer.cutover_verification.max_divergent_rows; diverged keys include %s",
+			res.divergent, res.compared, payload.Options.CutoverTime, maxDivergent,
+			strings.Join(res.keys, ", ")))
+	}
+	return nil
+}
+
+// verifiedTable is a replicated table compared by the verification of a
+// cutover.
+type verifiedTable struct {
+	// name is the name of the destination table.
+	name              string
+	srcID, destID     descpb.ID
+	keyCols           []string
+	cols              []catalog.Column
+	srcAsOf, destAsOf hlc.Timestamp
+	sampleRate        float64
+}
+
+// verify compares the fingerprints of the table on both sides, and merges
+// their rows if they differ.
+func (t *verifiedTable) verify(
+	ctx context.Context, conn *pgx.Conn, db isql.DB, res *verificationResult,
+) error {
+	var srcCount int64
+	var srcFingerprint string
+	if err := conn.QueryRow(ctx, fingerprintQuery(t.srcID, t.keyCols, t.cols, t.srcAsOf,
+		t.sampleRate)).Scan(&srcCount, &srcFingerprint); err != nil {
+		return errors.Wrap(err, "fingerprinting source")
+	}
+	row, err := db.Executor().QueryRowEx(ctx, "logical-replication-fingerprint-cutover", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		fingerprintQuery(t.destID, t.keyCols, t.cols, t.destAsOf, t.sampleRate))
+	if err != nil {
+		return errors.Wrap(err, "fingerprinting destination")
+	}
+	if destFingerprint := string(tree.MustBeDString(row[1])); srcFingerprint == destFingerprint &&
+		int64(tree.MustBeDInt(row[0])) == srcCount {
+		res.compared += int(srcCount)
+		return nil
+	}
+
+	srcRows, err := conn.Query(ctx, verificationQuery(t.srcID, t.keyCols, t.cols, t.srcAsOf, t.sampleRate))
+	if err != nil {
+		return errors.Wrap(err, "reading source")
+	}
+	defer srcRows.Close()
+	dstRows, err := db.Executor().QueryIteratorEx(ctx, "logical-replication-verify-cutover", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride,
+		verificationQuery(t.destID, t.keyCols, t.cols, t.destAsOf, t.sampleRate))
+	if err != nil {
+		return errors.Wrap(err, "reading destination")
+	}
+	defer func() { _ = dstRows.Close() }()
+	return compareRows(ctx, t.name, &pgxVerificationRows{rows: srcRows}, &isqlVerificationRows{rows: dstRows}, res)
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catconstants"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/errors"
+)
+
+// DeadLetterQueueClient records replicated rows that could not be applied to
+// the destination so that replication can make progress past them.
+type DeadLetterQueueClient interface {
+	// Log records that the given row of the given job could not be applied
+	// because of the given error.
+	Log(ctx context.Context, jobID jobspb.JobID, kv replicatedKV, reason error) error
+}
+
+// Rows sent to the dead letter queue are persisted in the dead letter queue
+// table of the database of their destination table, which the job creates
+// when it starts, before its frontier can move past them. The table is shared
+// by the jobs replicating into the database, and keyed by job so that the rows
+// of a job can be inspected and replayed once the cause of their failure is
+// fixed. If the stream sets encryption_kms, the key, value and reason of each
+// row are stored encrypted.
+
+// dlqTableName is the name of the dead letter queue table of a database.
+const dlqTableName = "crdb_replication_dlq"
+
+const dlqTableSchema = `CREATE TABLE IF NOT EXISTS %s (
+	job_id INT8 NOT NULL,
+	dlq_timestamp TIMESTAMPTZ NOT NULL DEFAULT now(),
+	id UUID NOT NULL DEFAULT gen_random_uuid(),
+	table_id INT8 NOT NULL,
+	mvcc_timestamp DECIMAL NOT NULL,
+	key BYTES NOT NULL,
+	value BYTES,
+	reason STRING NOT NULL,
+	encrypted BOOL NOT NULL DEFAULT false,
+	PRIMARY KEY (job_id, dlq_timestamp, id)
+)`
+
+// dlqTableFor returns the fully qualified name of the dead letter queue table
+// of the database of the destination table with the given name.
+func dlqTableFor(destTable string) (string, error) {
+	tn, err := parser.ParseQualifiedTableName(destTable)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing destination table name %q", destTable)
+	}
+	dlq := tree.MakeTableNameWithSchema(tn.CatalogName, catconstants.PublicSchemaName, dlqTableName)
+	return dlq.FQString(), nil
+}
+
+// createDeadLetterQueueTables creates the dead letter queue tables of the
+// databases of the given destination tables, if they don't exist.
+func createDeadLetterQueueTables(ctx context.Context, db isql.DB, destTables []string) error {
+	created := make(map[string]struct{}, len(destTables))
+	for _, name := range destTables {
+		dlq, err := dlqTableFor(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := created[dlq]; ok {
+			continue
+		}
+		if err := db.Txn(ctx, func(ctx context.Context, txn isql.Txn) error {
+			_, err := txn.ExecEx(ctx, "create-dlq-table", txn.KV(),
+				sessiondata.NodeUserSessionDataOverride, fmt.Sprintf(dlqTableSchema, dlq))
+			return err
+		}); err != nil {
+			return errors.Wrapf(err, "creating dead letter queue table %s", dlq)
+		}
+		created[dlq] = struct{}{}
+	}
+	return nil
+}
+
+// tableDeadLetterQueueClient is a DeadLetterQueueClient that writes the rows
+// it is handed to the dead letter queue table of their destination table.
+type tableDeadLetterQueueClient struct {
+	db isql.DB
+	// tables maps the ID of each source table to the dead letter queue table of
+	// its destination table.
+	tables map[descpb.ID]string
+	// enc, if set, encrypts the key, value and reason of each row.
+	enc *artifactEncryption
+}
+
+var _ DeadLetterQueueClient = (*tableDeadLetterQueueClient)(nil)
+
+// Log implements the DeadLetterQueueClient interface.
+func (c *tableDeadLetterQueueClient) Log(
+	ctx context.Context, jobID jobspb.JobID, kv replicatedKV, reason error,
+) error {
+	tableID, ok := sourceTableID(kv)
+	if !ok {
+		return errors.AssertionFailedf("row with key %s has no table", kv.Key)
+	}
+	dlq, ok := c.tables[tableID]
+	if !ok {
+		return errors.AssertionFailedf("row with key %s belongs to unknown table %d", kv.Key, tableID)
+	}
+	key, value, reasonStr := []byte(kv.Key), kv.Value.RawBytes, reason.Error()
+	if c.enc != nil {
+		var err error
+		if key, err = c.enc.encrypt(key); err != nil {
+			return errors.Wrap(err, "encrypting dead letter queue entry")
+		}
+		if value, err = c.enc.encrypt(value); err != nil {
+			return errors.Wrap(err, "encrypting dead letter queue entry")
+		}
+		encryptedReason, err := c.enc.encrypt([]byte(reasonStr))
+		if err != nil {
+			return errors.Wrap(err, "encrypting dead letter queue entry")
+		}
+		reasonStr = base64.StdEncoding.EncodeToString(encryptedReason)
+	}
+	return c.db.Txn(ctx, func(ctx context.Context, txn isql.Txn) error {
+		_, err := txn.ExecEx(ctx, "insert-dlq-row", txn.KV(), sessiondata.NodeUserSessionDataOverride,
+			fmt.Sprintf(`INSERT INTO %s (job_id, table_id, mvcc_timestamp, key, value, reason, encrypted)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`, dlq),
+			jobID, tableID, eval.TimestampToDecimalDatum(kv.Value.Timestamp), key, value, reasonStr, c.enc != nil)
+		return errors.Wrapf(err, "writing row to dead letter queue table %s", dlq)
+	})
+}
+
+// InitDeadLetterQueueClient returns the DeadLetterQueueClient used by writer
+// processors replicating the given tables, keyed by destination table name.
+func InitDeadLetterQueueClient(
+	db isql.DB, tableDescs map[string]descpb.TableDescriptor, enc *artifactEncryption,
+) (DeadLetterQueueClient, error) {
+	tables := make(map[descpb.ID]string, len(tableDescs))
+	for name, desc := range tableDescs {
+		dlq, err := dlqTableFor(name)
+		if err != nil {
+			return nil, err
+		}
+		tables[desc.ID] = dlq
+	}
+	return &tableDeadLetterQueueClient{db: db, tables: tables, enc: enc}, nil
+}
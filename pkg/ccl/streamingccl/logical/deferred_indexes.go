@@ -0,0 +1,126 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// Applying the initial scan of tables with many secondary indexes is
+// dominated by the writes to those indexes. If the job's
+// defer_secondary_indexes option is set, the non-unique secondary indexes of
+// the destination tables are dropped before the initial scan and rebuilt by an
+// index backfill once the replicated time reaches the initial scan timestamp,
+// after which the indexes are maintained along with each applied row.
+//
+// While the indexes are absent, queries against the destination tables can't
+// use them and may be much slower. Unique indexes are never deferred since
+// they enforce constraints. The definitions of the deferred indexes are
+// recorded in the job's progress before they are dropped so that they are
+// rebuilt even if the job is restarted in the meantime. If the job fails or is
+// canceled before they are rebuilt, they are recreated as it reverts.
+
+// deferSecondaryIndexes records the definitions of the non-unique secondary
+// indexes of the given destination tables in the job's progress and drops
+// them. It is idempotent.
+func (r *logicalReplicationResumer) deferSecondaryIndexes(
+	ctx context.Context, db isql.DB, tableNames []string, tableIDs descpb.IDs,
+) error {
+	var deferred []jobspb.LogicalReplicationProgress_DeferredIndex
+	for i, name := range tableNames {
+		tn, err := parser.ParseQualifiedTableName(name)
+		if err != nil {
+			return err
+		}
+		database := tn.Catalog()
+		rows, err := db.Executor().QueryBufferedEx(ctx, "logical-replication-secondary-indexes", nil, /* txn */
+			sessiondata.NodeUserSessionDataOverride,
+			fmt.Sprintf(`SELECT index_name, create_statement FROM %s.crdb_internal.table_indexes
+WHERE descriptor_id = $1 AND index_type = 'secondary' AND NOT is_unique`, lexbase.EscapeSQLIdent(database)),
+			tableIDs[i])
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			deferred = append(deferred, jobspb.LogicalReplicationProgress_DeferredIndex{
+				Database:        database,
+				TableName:       name,
+				IndexName:       string(tree.MustBeDString(row[0])),
+				CreateStatement: string(tree.MustBeDString(row[1])),
+			})
+		}
+	}
+
+	if err := r.job.NoTxn().Update(ctx, func(txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		prog := md.Progress.GetLogicalReplication()
+		prog.SecondaryIndexesDeferred = true
+		prog.DeferredIndexes = deferred
+		ju.UpdateProgress(md.Progress)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, idx := range deferred {
+		log.Infof(ctx, "deferring secondary index %s of %s until the initial scan completes",
+			idx.IndexName, idx.TableName)
+		if _, err := db.Executor().ExecEx(ctx, "logical-replication-defer-index", nil, /* txn */
+			sessiondata.NodeUserSessionDataOverride,
+			fmt.Sprintf("DROP INDEX IF EXISTS %s@%s", idx.TableName, lexbase.EscapeSQLIdent(idx.IndexName)),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildDeferredIndexes recreates the deferred secondary indexes, each of
+// which is backfilled while rows continue to be applied, and removes them from
+// the job's progress once they have all been rebuilt. Indexes that already
+// exist, e.g. because they were rebuilt before the job was restarted, are left
+// as is.
+func (r *logicalReplicationResumer) rebuildDeferredIndexes(
+	ctx context.Context, db isql.DB, indexes []jobspb.LogicalReplicationProgress_DeferredIndex,
+) error {
+	for _, idx := range indexes {
+		log.Infof(ctx, "rebuilding deferred secondary index %s of %s", idx.IndexName, idx.TableName)
+		_, err := db.Executor().ExecEx(ctx, "logical-replication-rebuild-index", nil, /* txn */
+			sessiondata.InternalExecutorOverride{User: username.NodeUserName(), Database: idx.Database},
+			idx.CreateStatement,
+		)
+		if err != nil && pgerror.GetPGCode(err) != pgcode.DuplicateRelation {
+			return err
+		}
+	}
+	return r.job.NoTxn().Update(ctx, func(txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		md.Progress.GetLogicalReplication().DeferredIndexes = nil
+		ju.UpdateProgress(md.Progress)
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+var descriptorLeaseRetryPeriod = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.descriptor_lease_retry_period",
+	"the amount of time for which a batch that can't lease the descriptor of its destination table, "+
+		"e.g. because a schema change took the table offline, is retried with backoff before the job "+
+		"is paused; if 0, the job is paused on the first failure",
+	5*time.Minute,
+	settings.NonNegativeDuration,
+)
+
+// Rows are applied with SQL statements that lease the descriptors of their
+// destination tables, which fails while a schema change holds a table offline
+// or is adding it. Such a failure would otherwise fail the flush and drain
+// the stream, only for the job to retry from its last checkpoint. Instead,
+// the batch is retried with backoff on the same worker until the lease is
+// acquired, which rides out short schema changes, and the job is paused with
+// an error naming the failure once descriptor_lease_retry_period elapses, so
+// that an operator can finish or revert the schema change and resume the job.
+// A table that is being dropped will never be leased again, so a batch that
+// fails to lease it fails the flush right away.
+
+// isDescriptorLeaseError returns true if the error is the failure to lease
+// the descriptor of a table because it isn't public, unless it is being
+// dropped.
+func isDescriptorLeaseError(err error) bool {
+	if errors.Is(err, catalog.ErrDescriptorDropped) {
+		return false
+	}
+	return catalog.HasInactiveDescriptorError(err) || catalog.HasAddingDescriptorError(err)
+}
+
+// retryDescriptorLease retries the batch, which failed with the given
+// descriptor lease error, until it no longer fails to lease a descriptor. It
+// returns a permanent job error once descriptor_lease_retry_period elapses.
+func (lrw *logicalReplicationWriterProcessor) retryDescriptorLease(
+	ctx context.Context, bh BatchHandler, batch []replicatedKV, err error,
+) (batchStats, error) {
+	period := descriptorLeaseRetryPeriod.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+	start := timeutil.Now()
+	opts := retry.Options{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Closer:         lrw.stopCh,
+	}
+	var stats batchStats
+	for r := retry.StartWithCtx(ctx, opts); timeutil.Since(start) < period && r.Next(); {
+		lrw.metrics.LeaseRetries.Inc(1)
+		lrw.watchdog.recordWait(timeutil.Now())
+		log.VInfof(ctx, 2, "retrying batch of %d rows after descriptor lease failure: %v", len(batch), err)
+		stats, err = bh.HandleBatch(ctx, batch)
+		if !isDescriptorLeaseError(err) {
+			return stats, err
+		}
+	}
+	if timeutil.Since(start) < period {
+		// The processor is stopping.
+		return stats, err
+	}
+	return stats, jobs.MarkAsPermanentJobError(errors.WithHint(
+		errors.Wrapf(err, "cannot acquire descriptor lease on a destination table after retrying for %s", period),
+		"resume the job once the schema change of the destination table completes, or raise "+
+			"logical_replication.consumer.descriptor_lease_retry_period"))
+}
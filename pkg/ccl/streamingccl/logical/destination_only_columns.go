@@ -0,0 +1,131 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// Rows are decoded with the descriptors of their source tables and applied
+// with statements that only write the source's columns, so the columns that a
+// destination table has and its source table lacks, e.g. because they were
+// only added to the destination, are left to the destination: new rows get
+// their DEFAULT, and computed columns are computed from the applied values.
+// When a row updates an existing destination row, the destination-only
+// columns keep their value by default, which suits columns maintained at the
+// destination, or are reset to their DEFAULT if
+// destination_only_column_policy is default, which suits columns whose
+// DEFAULT is derived from the write, e.g. the time it was applied.
+
+type destinationOnlyColumnPolicy int64
+
+const (
+	destinationOnlyColumnKeep destinationOnlyColumnPolicy = iota
+	destinationOnlyColumnDefault
+)
+
+// destinationOnlyColumnPolicySetting decides what happens to the columns of
+// destination tables that their source tables lack when a replicated row
+// updates an existing destination row.
+var destinationOnlyColumnPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.destination_only_column_policy",
+	"what to do with the columns of destination tables that their source tables lack when a "+
+		"replicated row updates an existing destination row: keep keeps their value and default "+
+		"resets them to their DEFAULT; new destination rows always get the columns' DEFAULT, and "+
+		"computed columns are always recomputed",
+	"keep",
+	map[int64]string{
+		int64(destinationOnlyColumnKeep):    "keep",
+		int64(destinationOnlyColumnDefault): "default",
+	},
+)
+
+// destinationOnlyColumns returns the names of the columns of the destination
+// table that the source table lacks and that the stream doesn't otherwise
+// write, split into those that can be reset to their DEFAULT and those that
+// can't since they are NOT NULL without a DEFAULT. Computed columns are left
+// out since the destination computes them.
+func destinationOnlyColumns(
+	name string,
+	src, dest catalog.TableDescriptor,
+	options jobspb.LogicalReplicationDetails_Options,
+) (defaulted, unset []string) {
+	written := map[string]struct{}{
+		"crdb_internal_origin_timestamp": {},
+		options.SoftDeleteColumn:         {},
+	}
+	if col, ok := options.RegionColumns[name]; ok {
+		written[col.Name] = struct{}{}
+	}
+	for _, col := range options.TableAuditColumns[name].Columns {
+		written[col] = struct{}{}
+	}
+	for _, col := range dest.PublicColumns() {
+		if col.IsComputed() || catalog.FindColumnByName(src, col.GetName()) != nil {
+			continue
+		}
+		if _, ok := written[col.GetName()]; ok {
+			continue
+		}
+		if !col.IsNullable() && !col.HasDefault() {
+			unset = append(unset, col.GetName())
+		} else {
+			defaulted = append(defaulted, col.GetName())
+		}
+	}
+	return defaulted, unset
+}
+
+// resolveDestinationOnlyColumns returns, for each source table whose
+// destination table has columns it lacks that can be reset to their DEFAULT,
+// the names of those columns.
+func resolveDestinationOnlyColumns(
+	ctx context.Context,
+	db descs.DB,
+	tableDescs map[string]descpb.TableDescriptor,
+	options jobspb.LogicalReplicationDetails_Options,
+) (map[descpb.ID][]string, error) {
+	res := make(map[descpb.ID][]string)
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		defaulted, unset := destinationOnlyColumns(name, src, dest, options)
+		if len(defaulted) > 0 {
+			sort.Strings(defaulted)
+			log.Infof(ctx, "destination table %s has columns %v that its source table lacks", name, defaulted)
+			res[src.GetID()] = defaulted
+		}
+		if len(unset) > 0 {
+			sort.Strings(unset)
+			log.Warningf(ctx, "destination table %s has NOT NULL columns %v without a DEFAULT that its "+
+				"source table lacks, so replicated rows that don't update an existing row can't be applied",
+				name, unset)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// resetColumns returns the names of the destination-only columns of the table
+// that are reset to their DEFAULT when a row updates an existing destination
+// row, which are none unless destination_only_column_policy is default.
+func (lww *sqlLastWriteWinsRowProcessor) resetColumns(tableID catid.DescID) []string {
+	if destinationOnlyColumnPolicy(destinationOnlyColumnPolicySetting.Get(&lww.settings.SV)) != destinationOnlyColumnDefault {
+		return nil
+	}
+	return lww.destinationOnlyColumns[tableID]
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// Rows are decoded using the descriptor of their source table, whose columns
+// may have another width or precision than those of the destination table,
+// e.g. because the destination runs an older version that created the column
+// with the representation of the type at the time. Values of such columns are
+// re-encoded into the type of the destination column, with the assignment cast
+// an INSERT into it would apply, before they are written. A value that doesn't
+// fit the destination's type, e.g. an integer out of its range, is rejected
+// with an error marked with errDestinationTypeValue, and its row sent to the
+// dead letter queue.
+
+// errDestinationTypeValue marks the error of a row with a value that can't be
+// re-encoded into the type of its destination column.
+var errDestinationTypeValue = errors.New("row has a value that doesn't fit the type of its destination column")
+
+// destinationColumnTypes returns the types of the columns of the destination
+// table whose types aren't identical to those of the source table.
+func destinationColumnTypes(src, dest catalog.TableDescriptor) map[string]*types.T {
+	var res map[string]*types.T
+	for _, col := range src.PublicColumns() {
+		if col.IsComputed() || col.GetName() == "crdb_internal_origin_timestamp" {
+			continue
+		}
+		destCol := catalog.FindColumnByName(dest, col.GetName())
+		if destCol == nil || destCol.GetType().Identical(col.GetType()) {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]*types.T)
+		}
+		res[col.GetName()] = destCol.GetType()
+	}
+	return res
+}
+
+// resolveDestinationColumnTypes returns, for each source table with columns
+// of other types than those of its destination table, the types of those
+// destination columns.
+func resolveDestinationColumnTypes(
+	ctx context.Context, db descs.DB, tableDescs map[string]descpb.TableDescriptor,
+) (map[descpb.ID]map[string]*types.T, error) {
+	res := make(map[descpb.ID]map[string]*types.T)
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		if typs := destinationColumnTypes(src, dest); typs != nil {
+			names := make([]string, 0, len(typs))
+			for col, typ := range typs {
+				names = append(names, col+" "+typ.SQLString())
+			}
+			sort.Strings(names)
+			log.Infof(ctx, "destination table %s has columns of other types than its source table, "+
+				"whose values are re-encoded: %v", name, names)
+			res[src.GetID()] = typs
+		}
+		return nil
+	})
+	return res, err
+}
+
+// destinationValue returns the value of the column of the row re-encoded into
+// the type of its destination column, if that type differs from the source's.
+func (lww *sqlLastWriteWinsRowProcessor) destinationValue(
+	ctx context.Context, tableID catid.DescID, name string, d tree.Datum,
+) (tree.Datum, error) {
+	typ, ok := lww.destinationTypes[tableID][name]
+	if !ok || d == tree.DNull {
+		return d, nil
+	}
+	res, err := eval.PerformAssignmentCast(ctx, lww.evalCtx, d, typ)
+	if err != nil {
+		return nil, errors.Mark(errors.Wrapf(err, "re-encoding the value of column %s of table %s as %s",
+			name, lww.queryBuffer.tableNames[tableID], typ.SQLString()), errDestinationTypeValue)
+	}
+	return res, nil
+}
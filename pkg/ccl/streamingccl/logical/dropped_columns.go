@@ -0,0 +1,180 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+type droppedColumnPolicy int64
+
+const (
+	droppedColumnDrop droppedColumnPolicy = iota
+	droppedColumnDLQ
+)
+
+// droppedColumnPolicySetting decides what happens to the values of source
+// columns that the destination table doesn't have, e.g. because the column
+// was intentionally dropped from the destination but not from the source.
+var droppedColumnPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.dropped_column_policy",
+	"what to do with replicated rows that have a value for a source column their destination table "+
+		"doesn't have: drop applies them without the value and dlq sends them to the dead letter "+
+		"queue; rows whose value for such columns is NULL are always applied",
+	"drop",
+	map[int64]string{
+		int64(droppedColumnDrop): "drop",
+		int64(droppedColumnDLQ):  "dlq",
+	},
+)
+
+// errDroppedColumnValue marks the error of a row that has a value for a column
+// its destination table doesn't have and must be sent to the dead letter
+// queue.
+var errDroppedColumnValue = errors.New("row has a value for a column the destination table doesn't have")
+
+// destinationDroppedColumns returns the names of the columns written by the
+// source table that the destination table doesn't have.
+func destinationDroppedColumns(src, dest catalog.TableDescriptor) map[string]struct{} {
+	var dropped map[string]struct{}
+	for _, col := range src.PublicColumns() {
+		if col.IsComputed() || col.GetName() == "crdb_internal_origin_timestamp" {
+			continue
+		}
+		if catalog.FindColumnByName(dest, col.GetName()) != nil {
+			continue
+		}
+		if dropped == nil {
+			dropped = make(map[string]struct{})
+		}
+		dropped[col.GetName()] = struct{}{}
+	}
+	return dropped
+}
+
+// forEachDestinationTable calls fn with the descriptors of each source table
+// and of its destination table, stopping at the first error. fn may be called
+// again for the same tables if the transaction reading the descriptors is
+// retried.
+func forEachDestinationTable(
+	ctx context.Context,
+	db descs.DB,
+	tableDescs map[string]descpb.TableDescriptor,
+	fn func(name string, src, dest catalog.TableDescriptor) error,
+) error {
+	return db.DescsTxn(ctx, func(ctx context.Context, txn descs.Txn) error {
+		for name, srcDesc := range tableDescs {
+			row, err := txn.QueryRowEx(ctx, "resolve-destination-table", txn.KV(),
+				sessiondata.NodeUserSessionDataOverride, `SELECT $1::STRING::REGCLASS::OID`, name)
+			if err != nil {
+				return err
+			}
+			destID := descpb.ID(tree.MustBeDOid(row[0]).Oid)
+			dest, err := txn.Descriptors().ByID(txn.KV()).WithoutNonPublic().Get().Table(ctx, destID)
+			if err != nil {
+				return err
+			}
+			if err := fn(name, tabledesc.NewBuilder(&srcDesc).BuildImmutableTable(), dest); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// resolveDestinationDroppedColumns returns, for each source table with
+// columns its destination table doesn't have, the names of those columns.
+func resolveDestinationDroppedColumns(
+	ctx context.Context, db descs.DB, tableDescs map[string]descpb.TableDescriptor,
+) (map[descpb.ID]map[string]struct{}, error) {
+	res := make(map[descpb.ID]map[string]struct{})
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		if dropped := destinationDroppedColumns(src, dest); dropped != nil {
+			names := make([]string, 0, len(dropped))
+			for name := range dropped {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			log.Infof(ctx, "destination table %s lacks source columns %v, whose values are not applied",
+				name, names)
+			res[src.GetID()] = dropped
+		}
+		return nil
+	})
+	return res, err
+}
+
+// dropDestinationColumns regenerates the insert queries of the source tables
+// whose destination tables lack some of their columns so that they don't
+// write those columns.
+func (lww *sqlLastWriteWinsRowProcessor) dropDestinationColumns(
+	dropped map[descpb.ID]map[string]struct{},
+) error {
+	qb := &lww.queryBuffer
+	for id, cols := range dropped {
+		td, ok := qb.tableDescs[id]
+		if !ok {
+			continue
+		}
+		queries, err := makeInsertQueries(qb.tableNames[id], td, qb.regionRules[id], qb.auditRules[id], cols,
+			nil /* reset */, qb.softDeleteColumn)
+		if err != nil {
+			return err
+		}
+		qb.insertQueries[id] = queries
+	}
+	lww.droppedColumns = dropped
+	return nil
+}
+
+// droppedColumn returns true if the column of the row is one its destination
+// table doesn't have. A non-NULL value for it is counted by the current
+// attempt or, if dropped_column_policy is dlq, rejected with an error marked
+// with errDroppedColumnValue, which is counted once the row is sent to the dead
+// letter queue.
+func (lww *sqlLastWriteWinsRowProcessor) droppedColumn(
+	tableID catid.DescID, name string, d tree.Datum,
+) (bool, error) {
+	if _, ok := lww.droppedColumns[tableID][name]; !ok {
+		return false, nil
+	}
+	if d == tree.DNull {
+		return true, nil
+	}
+	if droppedColumnPolicy(droppedColumnPolicySetting.Get(&lww.settings.SV)) == droppedColumnDLQ {
+		return true, errors.Mark(errors.Newf("row of table %s has a value for column %s, which the "+
+			"destination table doesn't have", lww.queryBuffer.tableNames[tableID], name), errDroppedColumnValue)
+	}
+	lww.droppedValues++
+	return true, nil
+}
+
+// ResetAttemptMetrics implements the attemptMetrics interface.
+func (lww *sqlLastWriteWinsRowProcessor) ResetAttemptMetrics() {
+	lww.droppedValues = 0
+}
+
+// RecordAttemptMetrics implements the attemptMetrics interface.
+func (lww *sqlLastWriteWinsRowProcessor) RecordAttemptMetrics() {
+	lww.metrics.DroppedColumnValues.Inc(lww.droppedValues)
+	lww.droppedValues = 0
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+)
+
+var subscriptionQueueHighWater = settings.RegisterByteSizeSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.subscription_queue_high_water_mark",
+	"the size of the KVs received from the subscription but not yet buffered for flushing above "+
+		"which reading from the subscription pauses, which pushes back on the source; if 0, an "+
+		"event is only read once the previous one has been buffered",
+	16<<20,
+)
+
+// maxQueuedEvents bounds the number of events in an eventQueue regardless of
+// their size, e.g. while it holds checkpoints.
+const maxQueuedEvents = 1024
+
+// eventQueue sits between a subscription and the consumeEvents loop. It reads
+// ahead of the loop, e.g. while the loop waits for a flush, but pauses reading
+// once the size of the queued events reaches the high-water mark, so that
+// slow applies push back on the source through the stream rather than growing
+// the memory held by the processor. The size of the queued events is charged
+// to the processor's memory account and exposed in the SubscriptionQueueBytes
+// gauge.
+type eventQueue struct {
+	settings *settings.Values
+	metrics  *Metrics
+	acc      *mon.ConcurrentBoundAccount
+
+	ch chan queuedEvent
+	// bytes is the size of the queued events.
+	bytes atomic.Int64
+	// dequeued is signaled whenever an event is dequeued so that a paused
+	// reader checks the high-water mark again.
+	dequeued chan struct{}
+}
+
+type queuedEvent struct {
+	streamingccl.Event
+	size int64
+}
+
+func newEventQueue(
+	sv *settings.Values, metrics *Metrics, acc *mon.ConcurrentBoundAccount,
+) *eventQueue {
+	return &eventQueue{
+		settings: sv,
+		metrics:  metrics,
+		acc:      acc,
+		ch:       make(chan queuedEvent, maxQueuedEvents),
+		dequeued: make(chan struct{}, 1),
+	}
+}
+
+// run reads events into the queue until the events channel is closed or
+// stopCh is closed, after which it closes the queue. The deadline of the
+// subscription the events are read from, if any, is told about each of them.
+// An error is returned if the memory account can't be grown by the size of an
+// event.
+func (q *eventQueue) run(
+	ctx context.Context,
+	events <-chan streamingccl.Event,
+	deadline *handshakeDeadline,
+	stopCh <-chan struct{},
+) error {
+	defer close(q.ch)
+	for {
+		if !q.waitBelowHighWater(ctx, stopCh) {
+			return nil
+		}
+		var event streamingccl.Event
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			deadline.received()
+			event = e
+		case <-stopCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+		size := eventSize(event)
+		if err := q.acc.Grow(ctx, size); err != nil {
+			return err
+		}
+		q.bytes.Add(size)
+		q.metrics.SubscriptionQueueBytes.Inc(size)
+		select {
+		case q.ch <- queuedEvent{Event: event, size: size}:
+		case <-stopCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// waitBelowHighWater waits until the size of the queued events is below the
+// high-water mark, or the queue is empty if it is 0. It returns false if
+// stopCh is closed or the context is canceled first.
+func (q *eventQueue) waitBelowHighWater(ctx context.Context, stopCh <-chan struct{}) bool {
+	paused := false
+	for {
+		highWater := subscriptionQueueHighWater.Get(q.settings)
+		if queued := q.bytes.Load(); (highWater == 0 && len(q.ch) == 0) || (highWater > 0 && queued < highWater) {
+			return true
+		}
+		if !paused {
+			paused = true
+			q.metrics.SubscriptionQueuePauses.Inc(1)
+		}
+		select {
+		case <-q.dequeued:
+		case <-stopCh:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// events returns the channel the queued events are received from. Each
+// received event must be passed to dequeue.
+func (q *eventQueue) events() <-chan queuedEvent {
+	return q.ch
+}
+
+// dequeue releases the size of a received event from the queue.
+func (q *eventQueue) dequeue(ctx context.Context, e queuedEvent) {
+	q.acc.Shrink(ctx, e.size)
+	q.bytes.Add(-e.size)
+	q.metrics.SubscriptionQueueBytes.Dec(e.size)
+	select {
+	case q.dequeued <- struct{}{}:
+	default:
+	}
+}
+
+// close releases the size of the events left in the queue from the memory
+// account and the gauge.
+func (q *eventQueue) close(ctx context.Context) {
+	if n := q.bytes.Swap(0); n != 0 {
+		q.acc.Shrink(ctx, n)
+		q.metrics.SubscriptionQueueBytes.Dec(n)
+	}
+}
+
+// eventSize returns the size of the KVs of the event, if any.
+func eventSize(e streamingccl.Event) int64 {
+	switch e.Type() {
+	case streamingccl.KVEvent:
+		var size int64
+		for _, kv := range e.GetKVs() {
+			size += int64(kv.Size())
+		}
+		return size
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// A partition is subscribed from the address of the source node it was
+// planned on, but any source node can serve its subscription, so the spec
+// also carries the addresses of the other source nodes as fallbacks. If the
+// subscription fails, e.g. because its source node was decommissioned, the
+// processor subscribes from the next fallback address, resuming from its
+// frontier, rather than failing the flow. Addresses may embed credentials, so
+// they are only logged and reported redacted.
+//
+// A partially unhealthy source node may accept a subscription and then never
+// send anything, so the handshake of a subscription, i.e. everything up to the
+// first event it receives, is bounded by subscribe_handshake_timeout. A
+// subscription whose handshake times out fails like any other, so the next
+// fallback address is tried.
+
+var subscribeHandshakeTimeout = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.subscribe_handshake_timeout",
+	"the time a writer processor waits for the first event of a partition's subscription from "+
+		"the source before it fails the subscription and tries the partition's next source "+
+		"address, if any; if 0, the handshake doesn't time out",
+	time.Minute,
+	settings.NonNegativeDuration,
+)
+
+// errSubscribeHandshakeTimeout is the cause with which the context of a
+// subscription whose handshake timed out is canceled.
+var errSubscribeHandshakeTimeout = errors.New("subscription handshake timed out")
+
+// handshakeDeadline cancels the context of a subscription unless it receives
+// its first event within a timeout. A nil deadline never expires.
+type handshakeDeadline struct {
+	timer *time.Timer
+}
+
+// startHandshakeDeadline returns the deadline of a subscription whose context
+// is canceled by cancel, or nil if timeout is 0.
+func startHandshakeDeadline(timeout time.Duration, cancel context.CancelCauseFunc) *handshakeDeadline {
+	if timeout == 0 {
+		return nil
+	}
+	return &handshakeDeadline{timer: time.AfterFunc(timeout, func() {
+		cancel(errors.Wrapf(errSubscribeHandshakeTimeout, "no event received within %s", timeout))
+	})}
+}
+
+// received records that the subscription received an event.
+func (d *handshakeDeadline) received() {
+	if d != nil {
+		d.timer.Stop()
+	}
+}
+
+// runSubscription runs the subscription until it ends, failing it if it
+// doesn't receive an event within subscribe_handshake_timeout. It returns the
+// deadline, which must be told about the events the subscription receives,
+// and a function that runs the subscription and returns its error.
+func (lrw *logicalReplicationWriterProcessor) runSubscription(
+	ctx context.Context, sub streamclient.Subscription,
+) (*handshakeDeadline, func() error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	deadline := startHandshakeDeadline(subscribeHandshakeTimeout.Get(&lrw.FlowCtx.Cfg.Settings.SV), cancel)
+	return deadline, func() error {
+		defer cancel(nil)
+		err := sub.Subscribe(ctx)
+		if cause := context.Cause(ctx); errors.Is(cause, errSubscribeHandshakeTimeout) {
+			lrw.metrics.SubscribeHandshakeTimeouts.Inc(1)
+			return cause
+		}
+		return err
+	}
+}
+
+// subscribe creates a client for the given source address and subscribes to
+// the processor's partition from its frontier. The client replaces the
+// processor's previous client, if any.
+func (lrw *logicalReplicationWriterProcessor) subscribe(
+	ctx context.Context, addr string,
+) (streamclient.Subscription, error) {
+	token := streamclient.SubscriptionToken(lrw.spec.PartitionSpec.SubscriptionToken)
+	redactedAddr, redactedErr := streamclient.RedactSourceURI(addr)
+	if redactedErr != nil {
+		log.Warning(ctx, "could not redact stream address")
+	}
+	lrw.debug.RecordSource(redactedAddr, token.Fingerprint())
+	streamClient, err := streamclient.NewStreamClient(ctx, streamingccl.StreamAddress(addr), lrw.FlowCtx.Cfg.DB,
+		streamclient.WithStreamID(streampb.StreamID(lrw.spec.StreamID)),
+		streamclient.WithCompression(true),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating client for partition spec %q from %q", token, redactedAddr)
+	}
+
+	lrw.streamPartitionClientMu.Lock()
+	if lrw.clientClosed {
+		lrw.streamPartitionClientMu.Unlock()
+		_ = streamClient.Close(ctx)
+		return nil, errors.New("processor closed")
+	}
+	prev := lrw.streamPartitionClient
+	lrw.streamPartitionClient = streamClient
+	lrw.streamPartitionClientMu.Unlock()
+	if prev != nil {
+		_ = prev.Close(ctx)
+	}
+
+	if streamingKnobs, ok := lrw.FlowCtx.TestingKnobs().StreamingTestingKnobs.(*sql.StreamingTestingKnobs); ok {
+		if streamingKnobs != nil && streamingKnobs.BeforeClientSubscribe != nil {
+			streamingKnobs.BeforeClientSubscribe(addr, string(token), lrw.frontier)
+		}
+	}
+	sub, err := streamClient.Subscribe(ctx,
+		streampb.StreamID(lrw.spec.StreamID),
+		int32(lrw.flowCtx.NodeID.SQLInstanceID()), lrw.ProcessorID,
+		token,
+		lrw.spec.InitialScanTimestamp, lrw.frontier,
+		streamclient.WithFiltering(subscriptionFiltering.Get(&lrw.FlowCtx.Cfg.Settings.SV)),
+		streamclient.WithDiff(lrw.spec.Options.CompareAndSwap),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "subscribing to partition from %s", redactedAddr)
+	}
+	return sub, nil
+}
+
+// subscribeWithFallback subscribes from the remaining fallback addresses in
+// turn, after the subscription from the previous address failed with the
+// given error, until a subscription succeeds. It returns the error of the
+// last failed subscription if none does.
+func (lrw *logicalReplicationWriterProcessor) subscribeWithFallback(
+	ctx context.Context, cause error,
+) (streamclient.Subscription, error) {
+	fallbacks := lrw.spec.PartitionSpec.FallbackAddresses
+	for lrw.nextFallback < len(fallbacks) && ctx.Err() == nil {
+		addr := fallbacks[lrw.nextFallback]
+		lrw.nextFallback++
+		lrw.metrics.SubscriptionFailovers.Inc(1)
+		log.Warningf(ctx, "subscribing to partition %s from fallback address %d of %d after: %v",
+			lrw.spec.PartitionSpec.PartitionID, lrw.nextFallback, len(fallbacks), cause)
+		sub, err := lrw.subscribe(ctx, addr)
+		if err == nil {
+			return sub, nil
+		}
+		cause = err
+	}
+	return nil, cause
+}
+
+// startSubscription runs the subscription and reads its events into a new
+// eventQueue.
+func (lrw *logicalReplicationWriterProcessor) startSubscription(sub streamclient.Subscription) {
+	lrw.subscription = sub
+	deadline, run := lrw.runSubscription(lrw.subscriptionCtx, sub)
+	lrw.workerGroup.GoCtx(func(_ context.Context) error {
+		err := run()
+		if err != nil && !lrw.canFailOver() {
+			lrw.sendError(errors.Wrap(err, "subscription"))
+			err = nil
+		}
+		if lrw.subscriptionDone != nil {
+			lrw.subscriptionDone <- err
+		}
+		return nil
+	})
+	queue := newEventQueue(&lrw.FlowCtx.Cfg.Settings.SV, lrw.metrics, lrw.eventQueueAcc)
+	lrw.eventQueue = queue
+	lrw.workerGroup.GoCtx(func(ctx context.Context) error {
+		if err := queue.run(ctx, sub.Events(), deadline, lrw.stopCh); err != nil {
+			lrw.sendError(errors.Wrap(err, "event queue"))
+		}
+		return nil
+	})
+}
+
+// canFailOver returns true if a failed subscription can be replaced by one
+// from a fallback address. consumeEvents only advances nextFallback once it
+// has received the outcome of the subscription, so the subscription's
+// goroutine can read it.
+func (lrw *logicalReplicationWriterProcessor) canFailOver() bool {
+	return lrw.subscriptionDone != nil && lrw.subscriptionCtx.Err() == nil &&
+		lrw.nextFallback < len(lrw.spec.PartitionSpec.FallbackAddresses)
+}
+
+// maybeFailOver is called by consumeEvents once it has consumed every event of
+// the subscription. If the subscription failed and the partition has fallback
+// addresses left, it subscribes from the next one and returns true, in which
+// case consumeEvents carries on consuming the new subscription's events. The
+// subscriptions across which an initial scan is split don't fail over.
+func (lrw *logicalReplicationWriterProcessor) maybeFailOver(ctx context.Context) (bool, error) {
+	if lrw.subscriptionDone == nil || lrw.scanning() {
+		return false, nil
+	}
+	var cause error
+	select {
+	case cause = <-lrw.subscriptionDone:
+	case <-lrw.stopCh:
+		return false, nil
+	case <-ctx.Done():
+		return false, nil
+	}
+	if cause == nil {
+		return false, nil
+	}
+	sub, err := lrw.subscribeWithFallback(ctx, cause)
+	if err != nil {
+		return false, errors.Wrap(err, "subscription")
+	}
+	lrw.eventQueue.close(ctx)
+	lrw.startSubscription(sub)
+	return true, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondatapb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/json"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+var fanoutBufferSize = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.fanout_buffer_size",
+	"the maximum number of applied rows buffered for emission to a fanout sink; "+
+		"rows applied while the buffer is full are dropped rather than emitted",
+	1024,
+	settings.PositiveInt,
+)
+
+// rowFanout emits rows to a changefeed sink after they have been applied. Rows
+// are handed over through a bounded buffer so that a slow or unavailable sink
+// never holds up replication: rows that don't fit in the buffer are dropped and
+// counted in the FanoutDropped metric.
+type rowFanout struct {
+	sink    fanoutSink
+	decoder cdcevent.Decoder
+	metrics *Metrics
+
+	// rowCh buffers applied rows until they are emitted to the sink.
+	rowCh chan replicatedKV
+
+	dropWarning log.EveryN
+}
+
+// fanoutSink is the subset of changefeedccl.RowSink used by rowFanout.
+type fanoutSink interface {
+	EmitRow(ctx context.Context, tableID descpb.ID, key, value []byte, ts hlc.Timestamp) error
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+var _ fanoutSink = (*changefeedccl.RowSink)(nil)
+
+// makeRowFanout dials the fanout sink of the given writer spec. Rows of each
+// replicated table are emitted to the topic named after the destination table.
+func makeRowFanout(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	spec execinfrapb.LogicalReplicationWriterSpec,
+	metrics *Metrics,
+) (*rowFanout, error) {
+	decoder, tables, err := makeReplicatedRowDecoder(ctx, flowCtx, spec)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := changefeedccl.MakeRowSink(ctx, flowCtx.Cfg, spec.Options.FanoutSinkURI, tables,
+		flowCtx.EvalCtx.SessionData().User(), jobspb.JobID(spec.JobID))
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing fanout sink")
+	}
+	return newRowFanout(sink, decoder, int(fanoutBufferSize.Get(&flowCtx.Cfg.Settings.SV)), metrics), nil
+}
+
+// makeReplicatedRowDecoder returns a decoder of the rows of the source tables
+// of the given writer spec, along with the names of the destination tables
+// keyed by the IDs of their source tables.
+func makeReplicatedRowDecoder(
+	ctx context.Context, flowCtx *execinfra.FlowCtx, spec execinfrapb.LogicalReplicationWriterSpec,
+) (cdcevent.Decoder, map[descpb.ID]string, error) {
+	tables := make(map[descpb.ID]string, len(spec.TableDescriptors))
+	descs := make(map[catid.DescID]catalog.TableDescriptor, len(spec.TableDescriptors))
+	targets := changefeedbase.Targets{}
+	for name, desc := range spec.TableDescriptors {
+		td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
+		descs[desc.ID] = td
+		tables[desc.ID] = name
+		targets.Add(changefeedbase.Target{
+			Type:              jobspb.ChangefeedTargetSpecification_EACH_FAMILY,
+			TableID:           td.GetID(),
+			StatementTimeName: changefeedbase.StatementTimeName(td.GetName()),
+		})
+	}
+	rfCache, err := cdcevent.NewFixedRowFetcherCache(
+		ctx, flowCtx.Codec(), flowCtx.Cfg.Settings, targets, descs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cdcevent.NewEventDecoderWithCache(ctx, rfCache, false, false), tables, nil
+}
+
+func newRowFanout(
+	sink fanoutSink, decoder cdcevent.Decoder, bufferSize int, metrics *Metrics,
+) *rowFanout {
+	return &rowFanout{
+		sink:        sink,
+		decoder:     decoder,
+		metrics:     metrics,
+		rowCh:       make(chan replicatedKV, bufferSize),
+		dropWarning: log.Every(time.Minute),
+	}
+}
+
+// enqueue hands the given applied rows over to be emitted. It never blocks;
+// rows that don't fit in the buffer are dropped.
+func (f *rowFanout) enqueue(ctx context.Context, kvs []replicatedKV) {
+	for i, kv := range kvs {
+		select {
+		case f.rowCh <- kv:
+		default:
+			dropped := len(kvs) - i
+			f.metrics.FanoutDropped.Inc(int64(dropped))
+			if f.dropWarning.ShouldLog() {
+				log.Warningf(ctx, "fanout buffer full; dropped %d applied rows", dropped)
+			}
+			return
+		}
+	}
+}
+
+// run emits buffered rows until stopCh is closed. The sink is flushed whenever
+// the buffer is drained so that delivery failures are noticed promptly. Rows
+// that fail to be emitted are dropped: the fanout is best effort and must not
+// fail replication.
+func (f *rowFanout) run(ctx context.Context, stopCh <-chan struct{}) {
+	var pending int64
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case kv := <-f.rowCh:
+			if err := f.emit(ctx, kv); err != nil {
+				f.metrics.FanoutDropped.Inc(1)
+				if f.dropWarning.ShouldLog() {
+					log.Warningf(ctx, "failed to emit applied row to fanout sink: %v", err)
+				}
+				continue
+			}
+			pending++
+		}
+		if len(f.rowCh) > 0 || pending == 0 {
+			continue
+		}
+		if err := f.sink.Flush(ctx); err != nil {
+			f.metrics.FanoutDropped.Inc(pending)
+			if f.dropWarning.ShouldLog() {
+				log.Warningf(ctx, "failed to flush %d applied rows to fanout sink: %v", pending, err)
+			}
+		} else {
+			f.metrics.FanoutEmitted.Inc(pending)
+		}
+		pending = 0
+	}
+}
+
+// emit encodes the row as JSON and emits it to the sink. The key is the array
+// of primary key values and the value holds the row's columns under "after",
+// which is null for deletes.
+func (f *rowFanout) emit(ctx context.Context, kv replicatedKV) error {
+	row, err := f.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if err != nil {
+		return err
+	}
+	keyBuilder := json.NewArrayBuilder(len(row.TableDescriptor().TableDesc().PrimaryIndex.KeyColumnNames))
+	if err := row.ForEachKeyColumn().Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+		j, err := tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+		if err != nil {
+			return err
+		}
+		keyBuilder.Add(j)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var after json.JSON = json.NullJSONValue
+	if !row.IsDeleted() {
+		afterBuilder := json.NewObjectBuilder(len(row.EncDatums()))
+		if err := row.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+			if col.Name == "crdb_internal_origin_timestamp" {
+				return nil
+			}
+			j, err := tree.AsJSON(d, sessiondatapb.DataConversionConfig{}, time.UTC)
+			if err != nil {
+				return err
+			}
+			afterBuilder.Add(col.Name, j)
+			return nil
+		}); err != nil {
+			return err
+		}
+		after = afterBuilder.Build()
+	}
+	valueBuilder := json.NewObjectBuilder(2)
+	valueBuilder.Add("after", after)
+	valueBuilder.Add("updated", json.FromString(kv.Value.Timestamp.AsOfSystemTime()))
+
+	return f.sink.EmitRow(ctx, row.TableID, []byte(keyBuilder.Build().String()),
+		[]byte(valueBuilder.Build().String()), kv.Value.Timestamp)
+}
+
+// close releases the fanout sink. Rows still in the buffer are not emitted.
+func (f *rowFanout) close() error {
+	return f.sink.Close()
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser/statements"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/errors"
+)
+
+// If the stream sets fanout_tables, the rows of a replicated table are also
+// applied to each of its fan-out tables, in the same transaction as the row
+// itself, e.g. to maintain denormalized copies of the table. A fan-out table
+// must have the primary key columns of its source table, and its other
+// columns must be columns of the source table, but it may be keyed by any of
+// its columns: it holds a row for each source row, keyed by the values of its
+// own primary key columns. When a source row's values for those columns
+// change, the row keyed by the old values is deleted. Rows are applied to
+// fan-out tables with the same last-write-wins semantics as to the
+// destination table, and deletes delete the fan-out rows of the source row.
+// Source tables with fan-out tables must have a single column family, so that
+// each row carries all the columns of its fan-out rows. The rows of a batch
+// and of their fan-out tables are always written in one explicit transaction,
+// even if the batch falls in a single destination range.
+//
+// Values are written to fan-out tables as they are: a fan-out table can only
+// derive other values from them with computed columns, which are left to the
+// destination. Any other transform is rejected, since each column of a fan-out
+// table must be a column of its source table.
+
+// fanoutTable is a fan-out table of a replicated table.
+type fanoutTable struct {
+	name string
+	// columns are the names of the columns written to the table, all of which
+	// are columns of the source table.
+	columns []string
+	// keyColumns are the names of the primary key columns of the table.
+	keyColumns []string
+	// rekeyed is true if the table isn't keyed by the primary key of the
+	// source table.
+	rekeyed bool
+}
+
+// makeFanoutTable returns the fan-out table with the given descriptor of the
+// source table, or an error if the rows of the source table can't be applied
+// to it.
+func makeFanoutTable(name string, src, dest catalog.TableDescriptor) (fanoutTable, error) {
+	if src.NumFamilies() > 1 {
+		return fanoutTable{}, errors.Newf("source table %s of fan-out table %s has %d column families "+
+			"rather than one", src.GetName(), name, src.NumFamilies())
+	}
+	t := fanoutTable{name: name, keyColumns: dest.TableDesc().PrimaryIndex.KeyColumnNames}
+	for _, col := range dest.PublicColumns() {
+		if col.IsComputed() || col.GetName() == "crdb_internal_origin_timestamp" {
+			continue
+		}
+		if srcCol := catalog.FindColumnByName(src, col.GetName()); srcCol == nil || srcCol.IsComputed() {
+			return fanoutTable{}, errors.WithHint(errors.Newf(
+				"column %s of fan-out table %s is not a column of source table %s", col.GetName(), name, src.GetName()),
+				"fan-out tables can only transform replicated values with computed columns")
+		}
+		t.columns = append(t.columns, col.GetName())
+	}
+	srcKey := src.TableDesc().PrimaryIndex.KeyColumnNames
+	for _, colName := range srcKey {
+		if !slices.Contains(t.columns, colName) {
+			return fanoutTable{}, errors.Newf("fan-out table %s lacks primary key column %s of source table %s",
+				name, colName, src.GetName())
+		}
+	}
+	t.rekeyed = len(srcKey) != len(t.keyColumns)
+	for _, colName := range t.keyColumns {
+		if !slices.Contains(srcKey, colName) {
+			t.rekeyed = true
+		}
+	}
+	return t, nil
+}
+
+// resolveFanoutTables returns the fan-out tables of each source table that
+// has some, in the order they were given.
+func resolveFanoutTables(
+	ctx context.Context,
+	db descs.DB,
+	tableDescs map[string]descpb.TableDescriptor,
+	fanout map[string]jobspb.LogicalReplicationDetails_Options_FanoutTables,
+) (map[descpb.ID][]fanoutTable, error) {
+	res := make(map[descpb.ID][]fanoutTable, len(fanout))
+	for name, f := range fanout {
+		srcDesc, ok := tableDescs[name]
+		if !ok {
+			return nil, errors.Newf("table %q with fan-out tables is not replicated", name)
+		}
+		byName := make(map[string]descpb.TableDescriptor, len(f.Tables))
+		for _, fanoutName := range f.Tables {
+			byName[fanoutName] = srcDesc
+		}
+		tables := make(map[string]fanoutTable, len(f.Tables))
+		var invalid error
+		if err := forEachDestinationTable(ctx, db, byName, func(fanoutName string, src, dest catalog.TableDescriptor) error {
+			t, err := makeFanoutTable(fanoutName, src, dest)
+			if err != nil && invalid == nil {
+				invalid = err
+			}
+			tables[fanoutName] = t
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if invalid != nil {
+			return nil, jobs.MarkAsPermanentJobError(invalid)
+		}
+		for _, fanoutName := range f.Tables {
+			res[srcDesc.ID] = append(res[srcDesc.ID], tables[fanoutName])
+		}
+	}
+	return res, nil
+}
+
+// makeFanoutInsertQuery returns the statement used to apply rows to the
+// fan-out table. Its arguments are the values of the table's columns and the
+// origin timestamp of the row.
+func makeFanoutInsertQuery(t fanoutTable) string {
+	var values, setClause strings.Builder
+	columns := make([]string, len(t.columns))
+	for i, name := range t.columns {
+		if i > 0 {
+			values.WriteString(", ")
+			setClause.WriteString(",\n")
+		}
+		columns[i] = tree.NameString(name)
+		fmt.Fprintf(&values, "$%d", i+1)
+		fmt.Fprintf(&setClause, "%s = $%d", columns[i], i+1)
+	}
+	keyColumns := make([]string, len(t.keyColumns))
+	for i, name := range t.keyColumns {
+		keyColumns[i] = tree.NameString(name)
+	}
+	baseQuery := `
+INSERT INTO %[1]s (%[2]s, crdb_internal_origin_timestamp)
+VALUES (%[3]s, $%[4]d)
+ON CONFLICT (%[5]s)
+DO UPDATE SET
+%[6]s,
+crdb_internal_origin_timestamp = $%[4]d
+WHERE (%[1]s.crdb_internal_mvcc_timestamp <= $%[4]d
+       AND %[1]s.crdb_internal_origin_timestamp IS NULL)
+   OR (%[1]s.crdb_internal_origin_timestamp <= $%[4]d
+       AND %[1]s.crdb_internal_origin_timestamp IS NOT NULL)`
+	return fmt.Sprintf(baseQuery,
+		t.name,
+		strings.Join(columns, ", "),
+		values.String(),
+		len(t.columns)+1,
+		strings.Join(keyColumns, ", "),
+		setClause.String(),
+	)
+}
+
+// makeFanoutDeleteQuery returns the statement used to delete the rows of a
+// source row from the fan-out table. Its arguments are the primary key
+// columns of the source row and its origin timestamp. If rekey is set, the
+// rows keyed by the values of the table's primary key columns, which follow
+// the source row's key in the arguments, are kept.
+func makeFanoutDeleteQuery(t fanoutTable, src catalog.TableDescriptor, rekey bool) string {
+	keyCount := len(src.TableDesc().PrimaryIndex.KeyColumnNames)
+	originTSIdx := keyCount + 1
+	var keep string
+	if rekey {
+		var predicate strings.Builder
+		for i, name := range t.keyColumns {
+			if i > 0 {
+				predicate.WriteString(" AND ")
+			}
+			fmt.Fprintf(&predicate, "%s = $%d", tree.NameString(name), keyCount+1+i)
+		}
+		keep = fmt.Sprintf(" AND NOT (%s)", predicate.String())
+		originTSIdx += len(t.keyColumns)
+	}
+	baseQuery := `
+DELETE FROM %[1]s
+WHERE %[2]s%[3]s
+  AND ((%[1]s.crdb_internal_mvcc_timestamp < $%[4]d
+        AND %[1]s.crdb_internal_origin_timestamp IS NULL)
+    OR (%[1]s.crdb_internal_origin_timestamp < $%[4]d
+        AND %[1]s.crdb_internal_origin_timestamp IS NOT NULL))`
+	return fmt.Sprintf(baseQuery, t.name, keyColumnPredicate(src, 1 /* startIdx */), keep, originTSIdx)
+}
+
+// fanoutQueries are the statements used to apply the rows of a source table
+// to one of its fan-out tables.
+type fanoutQueries struct {
+	table       fanoutTable
+	insertQuery statements.Statement[tree.Statement]
+	deleteQuery statements.Statement[tree.Statement]
+	// rekeyQuery deletes the row of a source row keyed by other values than
+	// those of the applied row. It is only set for rekeyed tables.
+	rekeyQuery statements.Statement[tree.Statement]
+}
+
+// setFanoutTables generates the statements used to apply the rows of the
+// source tables to their fan-out tables.
+func (lww *sqlLastWriteWinsRowProcessor) setFanoutTables(tables map[descpb.ID][]fanoutTable) error {
+	fanout := make(map[descpb.ID][]fanoutQueries, len(tables))
+	for id, ts := range tables {
+		td, ok := lww.queryBuffer.tableDescs[id]
+		if !ok {
+			continue
+		}
+		for _, t := range ts {
+			q := fanoutQueries{table: t}
+			var err error
+			if q.insertQuery, err = parser.ParseOne(makeFanoutInsertQuery(t)); err != nil {
+				return err
+			}
+			if q.deleteQuery, err = parser.ParseOne(makeFanoutDeleteQuery(t, td, false /* rekey */)); err != nil {
+				return err
+			}
+			if t.rekeyed {
+				if q.rekeyQuery, err = parser.ParseOne(makeFanoutDeleteQuery(t, td, true /* rekey */)); err != nil {
+					return err
+				}
+			}
+			fanout[id] = append(fanout[id], q)
+		}
+	}
+	lww.fanoutTables = fanout
+	return nil
+}
+
+// applyToFanoutTables applies the row to the fan-out tables of its table.
+func (lww *sqlLastWriteWinsRowProcessor) applyToFanoutTables(
+	ctx context.Context, txn isql.Txn, row cdcevent.Row,
+) error {
+	keyDatums, err := keyColumnDatums(row)
+	if err != nil {
+		return err
+	}
+	originTS := eval.TimestampToDecimalDatum(row.MvccTimestamp)
+	for _, q := range lww.fanoutTables[row.TableID] {
+		if row.IsDeleted() {
+			args := append(slices.Clip(keyDatums), originTS)
+			if _, err := txn.ExecParsed(ctx, "replicated-fanout-delete", txn.KV(), q.deleteQuery, args...); err != nil {
+				return errors.Wrapf(err, "applying delete to fan-out table %s", q.table.name)
+			}
+			continue
+		}
+		if q.table.rekeyed {
+			keyValues, err := namedDatums(row, q.table.keyColumns)
+			if err != nil {
+				return err
+			}
+			args := append(append(slices.Clip(keyDatums), keyValues...), originTS)
+			if _, err := txn.ExecParsed(ctx, "replicated-fanout-rekey", txn.KV(), q.rekeyQuery, args...); err != nil {
+				return errors.Wrapf(err, "applying row to fan-out table %s", q.table.name)
+			}
+		}
+		args, err := namedDatums(row, q.table.columns)
+		if err != nil {
+			return err
+		}
+		args = append(args, originTS)
+		if _, err := txn.ExecParsed(ctx, "replicated-fanout-insert", txn.KV(), q.insertQuery, args...); err != nil {
+			return errors.Wrapf(err, "applying row to fan-out table %s", q.table.name)
+		}
+	}
+	return nil
+}
+
+// namedDatums returns the values of the row for the columns with the given
+// names.
+func namedDatums(row cdcevent.Row, names []string) ([]interface{}, error) {
+	datums := make([]interface{}, 0, len(names)+1)
+	for _, name := range names {
+		it, err := row.DatumNamed(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := it.Datum(func(d tree.Datum, _ cdcevent.ResultColumn) error {
+			datums = append(datums, d)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return datums, nil
+}
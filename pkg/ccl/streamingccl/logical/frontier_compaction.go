@@ -0,0 +1,102 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/errors"
+)
+
+var frontierMaxSpans = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.frontier_max_spans",
+	"the number of spans in the frontier of a writer processor above which adjacent spans are "+
+		"merged at their lowest timestamp until it holds half as many, which keeps forwarding the "+
+		"frontier and checkpointing it cheap at the cost of replaying some changes after a restart; "+
+		"if 0, the frontier is never compacted",
+	10000,
+	settings.NonNegativeInt,
+)
+
+// The frontier of a processor holds a span for each distinct resolved span it
+// received, which, as ranges split and the checkpoints of the source's ranges
+// advance independently, can grow to many small spans. Merging adjacent spans
+// at the lowest of their timestamps regresses the timestamps of some of them,
+// so that changes below their previous timestamp may be received and applied
+// again after a restart, which is harmless since applying a change is
+// idempotent. It never regresses the frontier's overall timestamp.
+
+// maybeCompactFrontier compacts the processor's frontier if it holds more than
+// frontier_max_spans spans and updates the FrontierSpans gauge.
+func (lrw *logicalReplicationWriterProcessor) maybeCompactFrontier() error {
+	if maxSpans := int(frontierMaxSpans.Get(&lrw.FlowCtx.Cfg.Settings.SV)); maxSpans > 0 && lrw.frontier.Len() > maxSpans {
+		compacted, err := compactFrontier(lrw.frontier, max(maxSpans/2, 1))
+		if err != nil {
+			return errors.Wrap(err, "compacting frontier")
+		}
+		lrw.frontier.Release()
+		lrw.frontier = compacted
+		lrw.metrics.FrontierCompactions.Inc(1)
+	}
+	n := int64(lrw.frontier.Len())
+	lrw.metrics.FrontierSpans.Inc(n - lrw.frontierSpans)
+	lrw.frontierSpans = n
+	return nil
+}
+
+// compactFrontier returns a copy of the frontier in which runs of adjacent
+// spans are merged into single spans at the lowest timestamp of each run, so
+// that it holds about target spans. Spans that haven't been resolved yet, e.g.
+// because the initial scan is in progress, are not merged, so as not to
+// discard the progress of the spans next to them.
+func compactFrontier(f span.Frontier, target int) (span.Frontier, error) {
+	runLen := 1
+	if n := f.Len(); target > 0 && n > target {
+		runLen = (n + target - 1) / target
+	}
+
+	type run struct {
+		sp  roachpb.Span
+		ts  hlc.Timestamp
+		len int
+	}
+	var runs []run
+	f.Entries(func(sp roachpb.Span, ts hlc.Timestamp) span.OpResult {
+		if n := len(runs); n > 0 {
+			cur := &runs[n-1]
+			if cur.len < runLen && cur.sp.EndKey.Equal(sp.Key) && !cur.ts.IsEmpty() && !ts.IsEmpty() {
+				cur.sp.EndKey = sp.EndKey
+				cur.ts.Backward(ts)
+				cur.len++
+				return span.ContinueMatch
+			}
+		}
+		runs = append(runs, run{sp: sp, ts: ts, len: 1})
+		return span.ContinueMatch
+	})
+
+	spans := make(roachpb.Spans, len(runs))
+	for i, r := range runs {
+		spans[i] = r.sp
+	}
+	compacted, err := span.MakeFrontier(spans...)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range runs {
+		if _, err := compacted.Forward(r.sp, r.ts); err != nil {
+			compacted.Release()
+			return nil, err
+		}
+	}
+	return compacted, nil
+}
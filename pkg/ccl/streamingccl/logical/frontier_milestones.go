@@ -0,0 +1,68 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"slices"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/log/eventpb"
+)
+
+// frontierMilestones holds the frontier milestones of a stream that the
+// frontier of a processor hasn't crossed yet, in ascending order.
+type frontierMilestones []hlc.Timestamp
+
+// makeFrontierMilestones returns the given milestones that the given frontier
+// hasn't crossed. Milestones the frontier had crossed before the processor
+// started, e.g. before the job was resumed, are not crossed again.
+func makeFrontierMilestones(
+	milestones []hlc.Timestamp, frontier hlc.Timestamp,
+) frontierMilestones {
+	var pending frontierMilestones
+	for _, m := range milestones {
+		if frontier.Less(m) {
+			pending = append(pending, m)
+		}
+	}
+	slices.SortFunc(pending, hlc.Timestamp.Compare)
+	return slices.Compact(pending)
+}
+
+// cross removes the milestones crossed by the given frontier and returns them.
+func (m *frontierMilestones) cross(frontier hlc.Timestamp) []hlc.Timestamp {
+	n := 0
+	for n < len(*m) && (*m)[n].LessEq(frontier) {
+		n++
+	}
+	crossed := (*m)[:n:n]
+	*m = (*m)[n:]
+	return crossed
+}
+
+// maybeLogMilestones logs a structured event for each frontier milestone that
+// the persisted replicated time of the job crossed for the first time. The
+// replicated time only covers the changes flushed by every processor, so once
+// it crosses a milestone all changes through the milestone have been applied.
+func (rh *rowHandler) maybeLogMilestones(ctx context.Context, replicatedTime hlc.Timestamp) {
+	for _, milestone := range rh.milestones.cross(replicatedTime) {
+		rh.metrics.FrontierMilestonesCrossed.Inc(1)
+		log.StructuredEvent(ctx, &eventpb.LogicalReplicationMilestone{
+			CommonJobEventDetails: eventpb.CommonJobEventDetails{
+				JobID:   int64(rh.job.ID()),
+				JobType: jobspb.TypeLogicalReplication.String(),
+			},
+			Milestone:      milestone.AsOfSystemTime(),
+			ReplicatedTime: replicatedTime.AsOfSystemTime(),
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+var globalFrontierRefreshInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.global_frontier_refresh_interval",
+	"how often each processor reads the replicated time of its job, i.e. the minimum of the "+
+		"frontiers of all processors, and whether its partition holds it back, to report them in "+
+		"its debug status alongside its own frontier; if 0, processors only report their own frontier",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// Each processor only tracks the frontier of its own partition, while the
+// job's replicated time is the minimum of the frontiers of all partitions,
+// which the coordinator computes from the checkpoints of the processors. Since
+// the coordinator knows the spans of each partition, it also records in the
+// job's progress which partitions are laggards, i.e. own a span whose
+// frontier is the replicated time and so hold it back. There is no channel
+// from the coordinator back to the processors, so a processor that reports
+// the global frontier reads both from the job's progress every
+// global_frontier_refresh_interval, off the goroutine consuming events.
+
+// globalFrontierPollInterval is how often a processor checks whether
+// global_frontier_refresh_interval was set while it is 0.
+var globalFrontierPollInterval = 10 * time.Second
+
+// laggardPartitions returns the sorted IDs of the partitions that own a span
+// whose frontier is the frontier's minimum, given the spans of each
+// partition. It returns nil if the frontier is empty, i.e. the initial scan
+// isn't done.
+func laggardPartitions(frontier span.Frontier, partitions map[string][]roachpb.Span) []string {
+	replicatedTime := frontier.Frontier()
+	if replicatedTime.IsEmpty() {
+		return nil
+	}
+	var laggards []string
+	frontier.Entries(func(sp roachpb.Span, ts hlc.Timestamp) span.OpResult {
+		if ts != replicatedTime {
+			return span.ContinueMatch
+		}
+		for id, spans := range partitions {
+			if slices.Contains(laggards, id) {
+				continue
+			}
+			for _, partitionSpan := range spans {
+				if partitionSpan.Overlaps(sp) {
+					laggards = append(laggards, id)
+					break
+				}
+			}
+		}
+		return span.ContinueMatch
+	})
+	sort.Strings(laggards)
+	return laggards
+}
+
+// loadGlobalFrontier reads the replicated time of the job and whether the
+// processor's partition holds it back, as of the last progress persisted by
+// the coordinator.
+func (lrw *logicalReplicationWriterProcessor) loadGlobalFrontier(
+	ctx context.Context,
+) (hlc.Timestamp, bool, error) {
+	job, err := lrw.FlowCtx.Cfg.JobRegistry.LoadJob(ctx, jobspb.JobID(lrw.spec.JobID))
+	if err != nil {
+		return hlc.Timestamp{}, false, err
+	}
+	progress := job.Progress()
+	prog := progress.GetLogicalReplication()
+	return prog.ReplicatedTime, slices.Contains(prog.LaggardPartitions, lrw.spec.PartitionSpec.PartitionID), nil
+}
+
+// runGlobalFrontierRefresh records the job's global frontier, and whether the
+// processor's partition is a laggard, in its debug status every
+// global_frontier_refresh_interval until the processor stops consuming
+// events. A failure to read them is logged and the last read ones are kept,
+// since they only serve diagnostics.
+func (lrw *logicalReplicationWriterProcessor) runGlobalFrontierRefresh(ctx context.Context) error {
+	var timer timeutil.Timer
+	defer timer.Stop()
+	for {
+		interval := globalFrontierRefreshInterval.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+		if interval == 0 {
+			lrw.debug.RecordGlobalFrontier(hlc.Timestamp{}, false)
+			interval = globalFrontierPollInterval
+		} else if global, laggard, err := lrw.loadGlobalFrontier(ctx); err != nil {
+			log.VInfof(ctx, 2, "failed to read the replicated time of the job: %v", err)
+		} else {
+			lrw.debug.RecordGlobalFrontier(global, laggard)
+		}
+		timer.Reset(interval)
+		select {
+		case <-timer.C:
+			timer.Read = true
+		case <-lrw.watchdog.consumerDone:
+			return nil
+		case <-lrw.stopCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
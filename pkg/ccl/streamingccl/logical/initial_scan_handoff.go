@@ -0,0 +1,63 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import "github.com/cockroachdb/cockroach/pkg/util/hlc"
+
+// initialScanHandoff keeps the initial scan from resurrecting rows deleted by
+// live changes. While the initial scan is running, its rows, which are at or
+// below the initial scan timestamp, are interleaved with live changes, which
+// are above it. A destination row's last-write-wins timestamp keeps a scanned
+// row from overwriting a newer live update, but a live delete leaves nothing
+// behind to compare against, so a scanned row arriving after the delete of the
+// same row would otherwise be applied. To prevent that, the rows deleted by
+// live changes are remembered until the scan completes and scanned KVs of
+// those rows are dropped.
+type initialScanHandoff struct {
+	scanTimestamp hlc.Timestamp
+	// deleted holds the keys of the rows deleted by live changes since the
+	// handoff started. It is nil once the scan has completed.
+	deleted map[string]struct{}
+}
+
+// makeInitialScanHandoff returns the handoff of a processor whose frontier
+// starts at the given timestamp. There is no handoff if the initial scan has
+// already completed or there is none.
+func makeInitialScanHandoff(scanTimestamp, frontier hlc.Timestamp) initialScanHandoff {
+	h := initialScanHandoff{scanTimestamp: scanTimestamp}
+	if !scanTimestamp.IsEmpty() && frontier.Less(scanTimestamp) {
+		h.deleted = make(map[string]struct{})
+	}
+	return h
+}
+
+// skip returns true if the KV is a scanned KV of a row deleted by a live
+// change and must not be applied. Live deletes are recorded as they are seen.
+func (h *initialScanHandoff) skip(kv replicatedKV) bool {
+	if h.deleted == nil {
+		return false
+	}
+	key := string(rowKey(kv))
+	if h.scanTimestamp.Less(kv.Value.Timestamp) {
+		if !kv.Value.IsPresent() {
+			h.deleted[key] = struct{}{}
+		}
+		return false
+	}
+	_, deleted := h.deleted[key]
+	return deleted
+}
+
+// advance ends the handoff once the frontier has reached the initial scan
+// timestamp, after which no more scanned KVs can arrive.
+func (h *initialScanHandoff) advance(frontier hlc.Timestamp) {
+	if h.deleted != nil && h.scanTimestamp.LessEq(frontier) {
+		h.deleted = nil
+	}
+}
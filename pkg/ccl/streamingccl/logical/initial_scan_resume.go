@@ -0,0 +1,70 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+)
+
+var initialScanResumeEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.initial_scan_resume.enabled",
+	"if enabled, the spans whose initial scan has been applied are persisted while the initial "+
+		"scan is in progress, so that a flow restarted before the initial scan completes only "+
+		"rescans the remaining spans; if disabled, such a flow rescans every span",
+	true,
+)
+
+// The frontier of a job doesn't advance until every span has been scanned by
+// the initial scan, but the producer checkpoints each span at the scan
+// timestamp once it has been scanned, and a subscription whose frontier has
+// spans at the scan timestamp only scans the others. If initial_scan_resume is
+// enabled, the job persists its frontier whenever more of it has been scanned,
+// so that a flow restarted during the initial scan, e.g. after a transient
+// error on the source, resumes the scan rather than starting it over.
+
+// newlyScanned returns true if forwarding the span to the given timestamp
+// marks part of it as scanned by the initial scan at scanTimestamp.
+func newlyScanned(frontier span.Frontier, sp roachpb.Span, ts, scanTimestamp hlc.Timestamp) bool {
+	if ts.Less(scanTimestamp) {
+		return false
+	}
+	newly := false
+	frontier.SpanEntries(sp, func(_ roachpb.Span, cur hlc.Timestamp) span.OpResult {
+		if cur.Less(scanTimestamp) {
+			newly = true
+			return span.StopMatch
+		}
+		return span.ContinueMatch
+	})
+	return newly
+}
+
+// scannedSpans returns the spans of the checkpoint that have been scanned by
+// the initial scan at scanTimestamp.
+func scannedSpans(checkpoint jobspb.StreamIngestionCheckpoint, scanTimestamp hlc.Timestamp) roachpb.Spans {
+	var spans roachpb.Spans
+	for _, sp := range checkpoint.ResolvedSpans {
+		if scanTimestamp.LessEq(sp.Timestamp) {
+			spans = append(spans, sp.Span)
+		}
+	}
+	return spans
+}
+
+// scannedMore returns true if the spans scanned by the initial scan cover some
+// span not covered by the previously scanned spans.
+func scannedMore(scanned, prevScanned roachpb.Spans) bool {
+	// SubtractSpans mutates its first argument.
+	return len(roachpb.SubtractSpans(append(roachpb.Spans(nil), scanned...), prevScanned)) > 0
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+)
+
+type intentResolutionMode int64
+
+const (
+	intentResolutionAsync intentResolutionMode = iota
+	intentResolutionSync
+)
+
+var intentResolution = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.intent_resolution",
+	"if sync, the intents left on the destination rows of a batch applied in an explicit "+
+		"transaction are resolved before the batch is considered applied, which raises the "+
+		"latency of each batch but spares later batches updating the same rows from "+
+		"encountering them; if async, they are left to be resolved in the background",
+	"async",
+	map[int64]string{
+		int64(intentResolutionAsync): "async",
+		int64(intentResolutionSync):  "sync",
+	},
+)
+
+// Committing a transaction resolves the intents it wrote in the range holding
+// its transaction record, while the rest are resolved asynchronously after the
+// commit is acknowledged. Until then, a later transaction writing one of those
+// keys, which is common since apply often updates the same rows repeatedly,
+// has to push the committed transaction and resolve the intent itself before
+// its write can proceed, which is slower than if the intent had already been
+// resolved.
+//
+// If intent_resolution is sync, the destination rows of each batch applied in
+// an explicit transaction are read back once the transaction commits. The
+// read encounters the remaining intents of the committed transaction and
+// resolves them before returning, moving that cost from the batches that next
+// update the rows to the batch that wrote them. Only the rows' primary index
+// keys are read, so the intents on secondary indexes are still resolved
+// asynchronously. Batches applied using autocommitting statements commit in
+// one phase and don't leave intents behind.
+
+// resolveIntents reads the destination rows of the batch, which resolves any
+// intents a committed transaction left on them.
+func (t *txnBatch) resolveIntents(ctx context.Context, batch []replicatedKV) error {
+	spans := t.destinationRowSpans(batch)
+	if len(spans) == 0 {
+		return nil
+	}
+	b := &kv.Batch{}
+	for _, sp := range spans {
+		b.Scan(sp.Key, sp.EndKey)
+	}
+	return t.db.KV().Run(ctx, b)
+}
+
+// destinationRowSpans returns the spans of the destination rows written by the
+// batch, which is sorted by row. KVs whose destination can't be determined are
+// skipped.
+func (t *txnBatch) destinationRowSpans(batch []replicatedKV) []roachpb.Span {
+	var spans []roachpb.Span
+	for _, kv := range batch {
+		key, ok := t.destinationKey(kv)
+		if !ok {
+			continue
+		}
+		row, err := keys.EnsureSafeSplitKey(key.AsRawKey())
+		if err != nil {
+			continue
+		}
+		if n := len(spans); n > 0 && spans[n-1].Key.Equal(row) {
+			continue
+		}
+		spans = append(spans, roachpb.Span{Key: row, EndKey: row.PrefixEnd()})
+	}
+	return spans
+}
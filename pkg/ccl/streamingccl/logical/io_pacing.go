@@ -0,0 +1,144 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/rangecache"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+)
+
+var maxIOOverloadScore = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_io_overload_score",
+	"the IO overload score of the stores holding the destination ranges of a flush, derived from "+
+		"the number of files and sublevels in their L0 and equal to 1 where admission control starts "+
+		"throttling writes, above which flushes are slowed down to keep the destination's LSMs from "+
+		"approaching a write stall; if 0, flushes are not paced based on storage pressure",
+	0.8,
+	settings.NonNegativeFloat,
+)
+
+// When disk is the destination's bottleneck, a processor that applies as fast
+// as it can keeps adding files to L0 faster than they are compacted, until
+// admission control throttles all writes to the store or the store stalls.
+// The ioPacer slows down flushes before that happens, based on the IO
+// overload score that admission control computes from the L0 files and
+// sublevels of each store and that stores gossip. The stores observed are
+// those holding replicas of the cached destination ranges of the rows about
+// to be flushed, which, unlike the stores of the processor's node, are the
+// ones its writes land on.
+
+// maxIOPacingFactor bounds how much the ioPacer may slow down flushes.
+const maxIOPacingFactor = 16
+
+// ioPacer paces flushes based on the IO overload score of the stores the
+// flushes write to. Like the flushPacer, it forms a closed control loop: the
+// pacing factor doubles every flush that finds the score above the maximum
+// and halves every flush that doesn't, and each flush is preceded by a pause
+// of factor-1 times the duration of the previous flush.
+type ioPacer struct {
+	// overloadScore returns the gossiped IO overload score of the given
+	// store, or false if it isn't known. It is nil if stores can't be
+	// observed, e.g. on a virtual cluster, in which case flushes are never
+	// paced.
+	overloadScore func(roachpb.StoreID) (float64, bool)
+	// score is the highest score observed before the last flush. It is only
+	// accessed by the flushLoop.
+	score float64
+	// factor is the current pacing factor. It is only accessed by the
+	// flushLoop.
+	factor float64
+}
+
+func makeIOPacer(flowCtx *execinfra.FlowCtx) ioPacer {
+	p := ioPacer{factor: 1}
+	execCfg, ok := flowCtx.Cfg.ExecutorConfig.(*sql.ExecutorConfig)
+	if !ok {
+		return p
+	}
+	g, ok := execCfg.Gossip.Optional(0 /* issue */)
+	if !ok {
+		return p
+	}
+	p.overloadScore = func(storeID roachpb.StoreID) (float64, bool) {
+		desc, err := g.GetStoreDescriptor(storeID)
+		if err != nil {
+			return 0, false
+		}
+		score, _ := desc.Capacity.IOThreshold.Score()
+		return score, true
+	}
+	return p
+}
+
+// pace updates the observed score of the given stores and the pacing factor,
+// and returns how long to wait before the next flush.
+func (p *ioPacer) pace(maxScore float64, stores []roachpb.StoreID, lastFlush time.Duration) time.Duration {
+	if p.overloadScore == nil || maxScore <= 0 {
+		p.score, p.factor = 0, 1
+		return 0
+	}
+	p.score = 0
+	for _, storeID := range stores {
+		if score, ok := p.overloadScore(storeID); ok {
+			p.score = max(p.score, score)
+		}
+	}
+	if p.score > maxScore {
+		p.factor = min(p.factor*2, maxIOPacingFactor)
+	} else {
+		p.factor = max(p.factor/2, 1)
+	}
+	return time.Duration((p.factor - 1) * float64(lastFlush))
+}
+
+// destinationStores returns the stores holding replicas of the cached
+// destination ranges of the given rows. Rows whose destination range isn't
+// cached are skipped.
+func destinationStores(
+	ctx context.Context,
+	rangeCache *rangecache.RangeCache,
+	destIndexPrefixes map[descpb.ID]roachpb.Key,
+	kvs []replicatedKV,
+) []roachpb.StoreID {
+	if rangeCache == nil {
+		return nil
+	}
+	var ranges []roachpb.RangeDescriptor
+	var stores []roachpb.StoreID
+	for _, kv := range kvs {
+		key, ok := mapDestinationKey(destIndexPrefixes, kv)
+		if !ok {
+			continue
+		}
+		if len(ranges) > 0 && ranges[len(ranges)-1].ContainsKey(key) {
+			continue
+		}
+		cached := rangeCache.GetCachedOverlapping(ctx, roachpb.RSpan{Key: key, EndKey: key.Next()})
+		if len(cached) != 1 {
+			continue
+		}
+		desc := cached[0].Desc
+		ranges = append(ranges, desc)
+		for _, r := range desc.Replicas().Descriptors() {
+			if !slices.Contains(stores, r.StoreID) {
+				stores = append(stores, r.StoreID)
+			}
+		}
+	}
+	return stores
+}
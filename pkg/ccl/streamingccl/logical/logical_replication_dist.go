@@ -10,6 +10,7 @@ package logical
 
 import (
 	"context"
+	"slices"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
@@ -18,11 +19,15 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/sql"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/redact"
 )
 
 func constructLogicalReplicationWriterSpecs(
@@ -34,8 +39,10 @@ func constructLogicalReplicationWriterSpecs(
 	previousReplicatedTimestamp hlc.Timestamp,
 	checkpoint jobspb.StreamIngestionCheckpoint,
 	tableDescs map[string]descpb.TableDescriptor,
+	options jobspb.LogicalReplicationDetails_Options,
 	jobID jobspb.JobID,
 	streamID streampb.StreamID,
+	sourceClusterID uuid.UUID,
 ) (map[base.SQLInstanceID][]execinfrapb.LogicalReplicationWriterSpec, error) {
 	spanGroup := roachpb.SpanGroup{}
 	baseSpec := execinfrapb.LogicalReplicationWriterSpec{
@@ -46,6 +53,8 @@ func constructLogicalReplicationWriterSpecs(
 		Checkpoint:                  checkpoint, // TODO: Only forward relevant checkpoint info
 		StreamAddress:               string(streamAddress),
 		TableDescriptors:            tableDescs,
+		Options:                     options,
+		SourceClusterID:             sourceClusterID,
 	}
 
 	writerSpecs := make(map[base.SQLInstanceID][]execinfrapb.LogicalReplicationWriterSpec, len(destSQLInstances))
@@ -71,6 +80,7 @@ func constructLogicalReplicationWriterSpecs(
 			Spans:             partition.Spans,
 			SrcInstanceID:     base.SQLInstanceID(partition.SrcInstanceID),
 			DestInstanceID:    destID,
+			FallbackAddresses: fallbackAddresses(topology, partition.SrcAddr),
 		}
 		writerSpecs[destID] = append(writerSpecs[destID], spec)
 		spanGroup.Add(partition.Spans...)
@@ -80,3 +90,84 @@ func constructLogicalReplicationWriterSpecs(
 
 	return writerSpecs, nil
 }
+
+// fallbackAddresses returns the distinct addresses of the source nodes in the
+// topology other than the given one, from which a partition planned on the
+// node with that address may also be subscribed.
+func fallbackAddresses(
+	topology streamclient.Topology, addr streamingccl.PartitionAddress,
+) []string {
+	var fallbacks []string
+	seen := map[string]struct{}{string(addr): {}}
+	for _, a := range topology.StreamAddresses() {
+		if _, ok := seen[a]; ok {
+			continue
+		}
+		seen[a] = struct{}{}
+		fallbacks = append(fallbacks, a)
+	}
+	return fallbacks
+}
+
+// writerProcessorGoroutines is the number of long-lived goroutines run by each
+// writer processor: the subscription, the event queue, the event consumer and
+// the flush loop.
+const writerProcessorGoroutines = 4
+
+// resourceEstimate is the projected peak resource usage of the writer
+// processors planned on a single node.
+type resourceEstimate struct {
+	processors  int
+	memoryBytes int64
+	goroutines  int
+}
+
+// estimateResourceUsage projects the peak memory and goroutine footprint of the
+// given writer specs on each node under the current settings. Each processor
+// may hold a full KV buffer while flushing the previous one on all of its
+// writer workers.
+func estimateResourceUsage(
+	sv *settings.Values, specs map[base.SQLInstanceID][]execinfrapb.LogicalReplicationWriterSpec,
+) map[base.SQLInstanceID]resourceEstimate {
+	bufferSize := maxKVBufferSize.Get(sv)
+	estimates := make(map[base.SQLInstanceID]resourceEstimate, len(specs))
+	for instanceID, nodeSpecs := range specs {
+		var est resourceEstimate
+		for _, spec := range nodeSpecs {
+			est.processors++
+			est.memoryBytes += 2*bufferSize + subscriptionQueueHighWater.Get(sv)
+			est.goroutines += writerProcessorGoroutines + maxWriterWorkers
+			if spec.Options.FanoutSinkURI != "" {
+				est.goroutines++
+			}
+			if spec.Options.ShadowDestinationURI != "" {
+				est.goroutines++
+			}
+		}
+		estimates[instanceID] = est
+	}
+	return estimates
+}
+
+// formatResourceEstimates renders the given per-node estimates in instance ID
+// order.
+func formatResourceEstimates(
+	estimates map[base.SQLInstanceID]resourceEstimate,
+) redact.RedactableString {
+	instanceIDs := make([]base.SQLInstanceID, 0, len(estimates))
+	for instanceID := range estimates {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	slices.Sort(instanceIDs)
+
+	var buf redact.StringBuilder
+	for i, instanceID := range instanceIDs {
+		if i > 0 {
+			buf.SafeString("; ")
+		}
+		est := estimates[instanceID]
+		buf.Printf("node %d: %d processors, %s memory, %d goroutines",
+			instanceID, est.processors, humanizeutil.IBytes(est.memoryBytes), est.goroutines)
+	}
+	return buf.RedactableString()
+}
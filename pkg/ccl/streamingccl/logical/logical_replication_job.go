@@ -12,6 +12,8 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
@@ -21,15 +23,18 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobsprofiler"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/protectedts"
 	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/isql"
 	"github.com/cockroachdb/cockroach/pkg/sql/physicalplan"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
@@ -57,7 +62,10 @@ var (
 		settings.ApplicationLevel,
 		"logical_replication.consumer.heartbeat_frequency",
 		"controls frequency the stream replication destination cluster sends heartbeat "+
-			"to the source cluster to keep the stream alive",
+			"to the source cluster to keep the stream alive, reporting the stream's replicated "+
+			"time so that the source may release the history the stream no longer needs; "+
+			"if 0 when the job starts, no heartbeats are sent, while setting it to 0 once the job "+
+			"runs reverts to the default frequency until the job restarts",
 		30*time.Second,
 		settings.NonNegativeDuration,
 	)
@@ -100,13 +108,24 @@ func (r *logicalReplicationResumer) Resume(ctx context.Context, execCtx interfac
 			return err
 		}
 		log.Infof(ctx, "replication producer spec: %#+v", spec)
+		sourceSpans := spec.TableSpans
+		if payload.Options.RepairSpan.Valid() {
+			if sourceSpans, err = repairSpans(sourceSpans, payload.Options.RepairSpan); err != nil {
+				return jobs.MarkAsPermanentJobError(err)
+			}
+		}
 		if err := r.job.NoTxn().Update(ctx, func(txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
 			prog := md.Progress.GetLogicalReplication()
 			prog.StreamID = uint64(spec.StreamID)
 			prog.SourceClusterID = spec.SourceClusterID
-			prog.SourceSpans = spec.TableSpans
+			prog.SourceSpans = sourceSpans
 			prog.ReplicationStartTime = spec.ReplicationStartTime
 			prog.TableDescriptors = spec.TableDescriptors
+			if payload.Options.RepairSpan.Valid() {
+				// A targeted repair subscribes to the changes made in its window
+				// rather than running an initial scan.
+				prog.ReplicatedTime = repairReplicatedTimeAtStart(payload.Options)
+			}
 			ju.UpdateProgress(md.Progress)
 			return nil
 		}); err != nil {
@@ -117,13 +136,34 @@ func (r *logicalReplicationResumer) Resume(ctx context.Context, execCtx interfac
 		}
 
 	}
-	return r.handleResumeError(ctx, jobExecCtx, r.ingestWithRetries(ctx, jobExecCtx))
+	err := r.ingestWithRetries(ctx, jobExecCtx)
+	if err == nil {
+		// Only dry runs and streams that reached their cutover time complete
+		// without error.
+		return nil
+	}
+	if jobs.IsRetryJobError(err) {
+		// The job is being handed off to another node; there's no reason to
+		// pause it.
+		return err
+	}
+	return r.handleResumeError(ctx, jobExecCtx, err)
 }
 
 // The ingestion job should never fail, only pause, as progress should never be lost.
+//
+// A job paused after a permanent error waits for the destination or the job to
+// be fixed, possibly for good, so it releases the protected timestamp record of
+// its destination tables rather than holding up their GC in the meantime. The
+// tables are protected again if the job resumes.
 func (r *logicalReplicationResumer) handleResumeError(
 	ctx context.Context, execCtx sql.JobExecContext, err error,
 ) error {
+	if jobs.IsPermanentJobError(err) {
+		if releaseErr := r.releaseDestinationProtectedTimestamp(ctx, execCtx.ExecCfg()); releaseErr != nil {
+			log.Warningf(ctx, "failed to release protected timestamp of the destination tables: %v", releaseErr)
+		}
+	}
 	r.updateRunningStatus(ctx, redact.Sprintf("pausing after error: %s", err.Error()))
 	return jobs.MarkPauseRequestError(err)
 }
@@ -160,15 +200,6 @@ func (r *logicalReplicationResumer) ingest(
 		streamID              = progress.StreamID
 	)
 
-	frontier, err := span.MakeFrontierAt(replicatedTimeAtStart, sourceSpans...)
-	if err != nil {
-		return err
-	}
-	for _, resolvedSpan := range progress.Checkpoint.ResolvedSpans {
-		if _, err := frontier.Forward(resolvedSpan.Span, resolvedSpan.Timestamp); err != nil {
-			return err
-		}
-	}
 	planCtx, nodes, err := distSQLPlanner.SetupAllNodesPlanning(ctx, evalCtx, execCfg)
 	if err != nil {
 		return err
@@ -189,6 +220,39 @@ func (r *logicalReplicationResumer) ingest(
 	}
 	defer func() { _ = client.Close(ctx) }()
 
+	if payload.Options.ReplicateSchemaChanges {
+		if err := r.replicateSchemaChanges(ctx, execCfg.InternalDB, &execCfg.Settings.SV, client, payload.Options); err != nil {
+			return err
+		}
+		progress = r.job.Progress().Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
+		sourceSpans = progress.SourceSpans
+	}
+
+	metrics := execCfg.JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
+	checkpoint := progress.Checkpoint
+	resumeInitialScan := initialScanResumeEnabled.Get(&execCfg.Settings.SV)
+	if replicatedTimeAtStart.IsEmpty() && len(checkpoint.ResolvedSpans) > 0 {
+		// The flow is restarting before the initial scan completed.
+		if resumeInitialScan {
+			if scanned := scannedSpans(checkpoint, progress.ReplicationStartTime); len(scanned) > 0 {
+				log.Infof(ctx, "resuming initial scan with %d spans already scanned", len(scanned))
+				metrics.InitialScanResumedSpans.Inc(int64(len(scanned)))
+			}
+		} else {
+			checkpoint = jobspb.StreamIngestionCheckpoint{}
+		}
+	}
+
+	frontier, err := span.MakeFrontierAt(replicatedTimeAtStart, sourceSpans...)
+	if err != nil {
+		return err
+	}
+	for _, resolvedSpan := range checkpoint.ResolvedSpans {
+		if _, err := frontier.Forward(resolvedSpan.Span, resolvedSpan.Timestamp); err != nil {
+			return err
+		}
+	}
+
 	topology, err := client.PlanLogicalReplication(ctx, sourceSpans)
 	if err != nil {
 		return err
@@ -200,13 +264,46 @@ func (r *logicalReplicationResumer) ingest(
 		destNodeLocalities,
 		progress.ReplicationStartTime,
 		progress.ReplicatedTime,
-		progress.Checkpoint,
+		checkpoint,
 		progress.TableDescriptors,
+		payload.Options,
 		jobID,
-		streampb.StreamID(streamID))
+		streampb.StreamID(streamID),
+		progress.SourceClusterID)
+	if err != nil {
+		return err
+	}
+
+	estimates := formatResourceEstimates(estimateResourceUsage(&execCfg.Settings.SV, specs))
+	if payload.Options.DryRun {
+		r.updateRunningStatus(ctx, redact.Sprintf("dry run: projected peak resource usage: %s", estimates))
+		return client.Complete(ctx, streampb.StreamID(streamID), false /* successfulIngestion */)
+	}
+	log.Infof(ctx, "projected peak resource usage: %s", estimates)
+
+	tableIDs, gcTTL, err := resolveDestinationTables(ctx, execCfg.InternalDB, payload.TableNames)
 	if err != nil {
 		return err
 	}
+	protectAt := progress.ReplicatedTime
+	if protectAt.IsEmpty() {
+		protectAt = progress.ReplicationStartTime
+	}
+	if err := r.protectDestinationTables(ctx, execCfg, tableIDs, protectAt); err != nil {
+		return err
+	}
+	if err := createDeadLetterQueueTables(ctx, execCfg.InternalDB, payload.TableNames); err != nil {
+		return err
+	}
+	if payload.Options.DeferSecondaryIndexes && !progress.SecondaryIndexesDeferred {
+		if err := r.deferSecondaryIndexes(ctx, execCfg.InternalDB, payload.TableNames, tableIDs); err != nil {
+			return err
+		}
+		progress = r.job.Progress().Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
+	}
+	if err := execCfg.JobRegistry.CheckPausepoint("logical_replication.after_deferring_indexes"); err != nil {
+		return jobs.MarkAsPermanentJobError(err)
+	}
 
 	// Setup a one-stage plan with one proc per input spec.
 	//
@@ -247,7 +344,6 @@ func (r *logicalReplicationResumer) ingest(
 		execCfg.InternalDB,
 		jobID)
 
-	metrics := execCfg.JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
 	metaFn := func(_ context.Context, meta *execinfrapb.ProducerMetadata) error {
 		log.Warningf(ctx, "received unexpected producer meta: %v", meta)
 		return nil
@@ -255,16 +351,72 @@ func (r *logicalReplicationResumer) ingest(
 	// TODO(ssd): Replan
 	heartbeatSender := streamclient.NewHeartbeatSender(ctx, client, streampb.StreamID(streamID),
 		func() time.Duration {
-			return heartbeatFrequency.Get(&execCfg.Settings.SV)
+			// Heartbeats are only disabled when the job starts, so a frequency
+			// set to 0 while it runs falls back to the default.
+			if freq := heartbeatFrequency.Get(&execCfg.Settings.SV); freq > 0 {
+				return freq
+			}
+			return heartbeatFrequency.Default()
 		})
 	defer func() { _ = heartbeatSender.Stop() }()
+	var heartbeat *streamclient.HeartbeatSender
+	if heartbeatFrequency.Get(&execCfg.Settings.SV) > 0 {
+		heartbeatSender.Start(ctx, timeutil.DefaultTimeSource{})
+		heartbeat = heartbeatSender
+	}
 	rh := rowHandler{
 		replicatedTimeAtStart: replicatedTimeAtStart,
 		frontier:              frontier,
 		metrics:               metrics,
 		settings:              &execCfg.Settings.SV,
 		job:                   r.job,
-		frontierUpdates:       heartbeatSender.FrontierUpdates,
+		heartbeat:             heartbeat,
+		ptp:                   execCfg.ProtectedTimestampProvider,
+		gcTTL:                 gcTTL,
+		gcWarning:             log.Every(time.Minute),
+		sourceTableNames:      make(map[descpb.ID]string, len(progress.TableDescriptors)),
+		partitions:            make(map[string][]roachpb.Span, len(topology.Partitions)),
+		quarantined:           make(map[descpb.ID]struct{}),
+		scanTimestamp:         progress.ReplicationStartTime,
+		resumeInitialScan:     resumeInitialScan,
+		lag:                   &replicationLag{},
+		milestones:            makeFrontierMilestones(payload.Options.FrontierMilestones, replicatedTimeAtStart),
+	}
+	if payload.Options.RepairSpan.Valid() {
+		rh.repair = &payload.Options
+	}
+	rh.lag.update(frontier.Frontier())
+	defer metrics.trackReplicationLag(jobID, rh.lag)()
+	for name, desc := range progress.TableDescriptors {
+		rh.sourceTableNames[desc.ID] = name
+	}
+	for _, partition := range topology.Partitions {
+		rh.partitions[partition.ID] = partition.Spans
+	}
+	if payload.Options.ReplicateSchemaChanges {
+		tableDescs := progress.TableDescriptors
+		rh.checkSourceSchema = func(ctx context.Context, asOf hlc.Timestamp) error {
+			return checkSourceSchema(ctx, client, tableDescs, asOf)
+		}
+		rh.schemaCheckedAt = replicatedTimeAtStart
+	}
+	// Deferred indexes are rebuilt concurrently with the flow once the initial
+	// scan has been applied.
+	rebuildCtx, cancelRebuild := context.WithCancel(ctx)
+	rebuildGroup := ctxgroup.WithContext(rebuildCtx)
+	defer func() {
+		cancelRebuild()
+		if err := rebuildGroup.Wait(); err != nil && ctx.Err() == nil {
+			log.Warningf(ctx, "failed to rebuild deferred secondary indexes: %v", err)
+		}
+	}()
+	if deferred := progress.DeferredIndexes; len(deferred) > 0 {
+		rh.deferredIndexes = len(deferred)
+		rh.rebuildIndexes = func() {
+			rebuildGroup.GoCtx(func(ctx context.Context) error {
+				return r.rebuildDeferredIndexes(ctx, execCfg.InternalDB, deferred)
+			})
+		}
 	}
 	rowResultWriter := sql.NewCallbackResultWriter(rh.handleRow)
 	distSQLReceiver := sql.MakeDistSQLReceiver(
@@ -289,7 +441,49 @@ func (r *logicalReplicationResumer) ingest(
 		nil, /* finishedSetupFn */
 	)
 
-	return rowResultWriter.Err()
+	err = rowResultWriter.Err()
+	if err == nil && !payload.Options.CutoverTime.IsEmpty() {
+		if rh.completed < len(processorCorePlacements) {
+			return errors.Newf("only %d of %d processors reached the cutover time",
+				rh.completed, len(processorCorePlacements))
+		}
+		// Every processor applied all changes through the cutover time.
+		if err := rh.persistProgress(ctx); err != nil {
+			return err
+		}
+		if err := rebuildGroup.Wait(); err != nil {
+			return errors.Wrap(err, "rebuilding deferred secondary indexes")
+		}
+		if payload.Options.CutoverVerification != "" {
+			r.updateRunningStatus(ctx, redact.Sprintf("verifying destination tables against source as of cutover time %s",
+				payload.Options.CutoverTime.GoTime()))
+			if err := r.verifyCutover(ctx, execCfg.InternalDB, &execCfg.Settings.SV, payload,
+				progress.TableDescriptors, metrics); err != nil {
+				return err
+			}
+		}
+		if payload.Options.RepairSpan.Valid() {
+			r.updateRunningStatus(ctx, redact.Sprintf("targeted repair of span %s complete: changes from %s through %s applied",
+				payload.Options.RepairSpan, payload.Options.RepairStartTime.GoTime(), payload.Options.CutoverTime.GoTime()))
+		} else {
+			r.updateRunningStatus(ctx, redact.Sprintf("logical replication complete through cutover time %s",
+				payload.Options.CutoverTime.GoTime()))
+		}
+		if err := client.Complete(ctx, streampb.StreamID(streamID), true /* successfulIngestion */); err != nil {
+			return err
+		}
+		return r.releaseDestinationProtectedTimestamp(ctx, execCfg)
+	}
+	if errors.Is(err, errNodeDraining) {
+		// A processor shut down because its node is draining after emitting an
+		// up-to-date checkpoint. Persist it regardless of the checkpoint
+		// frequency so that the replanned flow redoes as little work as
+		// possible.
+		if persistErr := rh.persistProgress(ctx); persistErr != nil {
+			log.Warningf(ctx, "failed to persist progress after node drain: %v", persistErr)
+		}
+	}
+	return err
 }
 
 // rowHandler is responsible for handling checkpoints sent by logical
@@ -300,9 +494,64 @@ type rowHandler struct {
 	metrics               *Metrics
 	settings              *settings.Values
 	job                   *jobs.Job
-	frontierUpdates       chan hlc.Timestamp
+	// heartbeat, if set, reports the persisted replicated time to the source.
+	heartbeat *streamclient.HeartbeatSender
+	// unreportedReplicatedTime is the latest persisted replicated time that
+	// the heartbeat sender hasn't taken yet.
+	unreportedReplicatedTime hlc.Timestamp
+	// ptp advances the protected timestamp record of the destination tables
+	// along with the replicated time.
+	ptp protectedts.Manager
+	// gcTTL is the shortest GC TTL of the destination tables. It is used to
+	// warn when the replicated time lags close to the GC threshold.
+	gcTTL     time.Duration
+	gcWarning log.EveryN
+	// catchUp tracks whether the replicated time is catching up after the job
+	// was paused or fell behind.
+	catchUp catchUpTracker
+	// sourceTableNames maps the IDs of the source tables to the names of their
+	// destination tables.
+	sourceTableNames map[descpb.ID]string
+	// partitions maps the ID of each partition to its spans, to record which
+	// partitions hold back the replicated time.
+	partitions map[string][]roachpb.Span
+	// quarantined holds the IDs of the source tables quarantined by any
+	// processor.
+	quarantined map[descpb.ID]struct{}
+	// scanTimestamp is the timestamp of the initial scan, which has been
+	// applied once the frontier reaches it.
+	scanTimestamp hlc.Timestamp
+	// resumeInitialScan, if set, persists the frontier whenever more of it has
+	// been scanned by the initial scan, even though the frontier itself only
+	// advances once the whole initial scan has been applied.
+	resumeInitialScan bool
+	// rebuildIndexes, if set, starts rebuilding the secondary indexes that
+	// were deferred until the frontier reaches scanTimestamp. It is cleared
+	// once called.
+	rebuildIndexes  func()
+	deferredIndexes int
+	// checkSourceSchema, if set, returns an error if the schema of any source
+	// table changed before the given time, in which case the frontier isn't
+	// persisted.
+	checkSourceSchema func(ctx context.Context, asOf hlc.Timestamp) error
+	// schemaCheckedAt is the latest time as of which the source schema was
+	// checked, up to which progress is persisted, and lastSchemaCheck when it
+	// was checked.
+	schemaCheckedAt hlc.Timestamp
+	lastSchemaCheck time.Time
+	// lag tracks the frontier for the stream's replication lag metric.
+	lag *replicationLag
+	// milestones holds the stream's frontier milestones that the persisted
+	// replicated time hasn't crossed yet.
+	milestones frontierMilestones
+	// repair, if set, holds the options of a targeted repair, whose coverage of
+	// its window is reported in the running status.
+	repair *jobspb.LogicalReplicationDetails_Options
 
 	lastPartitionUpdate time.Time
+	// completed is the number of processors that emitted their final
+	// checkpoint after reaching the stream's cutover time.
+	completed int
 }
 
 func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
@@ -316,14 +565,29 @@ func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
 			`unmarshalling resolved timestamp: %x`, raw)
 	}
 
+	if resolvedSpans.Complete {
+		rh.completed++
+	}
+	for _, id := range resolvedSpans.QuarantinedTableIDs {
+		rh.quarantined[id] = struct{}{}
+	}
+
 	advanced := false
 	for _, sp := range resolvedSpans.ResolvedSpans {
+		if rh.resumeInitialScan && newlyScanned(rh.frontier, sp.Span, sp.Timestamp, rh.scanTimestamp) {
+			advanced = true
+		}
 		adv, err := rh.frontier.Forward(sp.Span, sp.Timestamp)
 		if err != nil {
 			return err
 		}
 		advanced = advanced || adv
 	}
+	rh.lag.update(rh.frontier.Frontier())
+	if rh.rebuildIndexes != nil && rh.scanTimestamp.LessEq(rh.frontier.Frontier()) {
+		rh.rebuildIndexes()
+		rh.rebuildIndexes = nil
+	}
 
 	if !advanced {
 		return nil
@@ -333,16 +597,42 @@ func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
 	if updateFreq == 0 || timeutil.Since(rh.lastPartitionUpdate) < updateFreq {
 		return nil
 	}
+	return rh.persistProgress(ctx)
+}
 
+// persistProgress writes the current frontier to the job's progress.
+func (rh *rowHandler) persistProgress(ctx context.Context) error {
 	frontierResolvedSpans := make([]jobspb.ResolvedSpan, 0)
 	rh.frontier.Entries(func(sp roachpb.Span, ts hlc.Timestamp) (done span.OpResult) {
 		frontierResolvedSpans = append(frontierResolvedSpans, jobspb.ResolvedSpan{Span: sp, Timestamp: ts})
 		return span.ContinueMatch
 	})
 	replicatedTime := rh.frontier.Frontier()
+	laggards := laggardPartitions(rh.frontier, rh.partitions)
+	if rh.checkSourceSchema != nil && !replicatedTime.IsEmpty() {
+		checkedAt, err := rh.sourceSchemaCheckedAt(ctx, replicatedTime)
+		if err != nil {
+			return err
+		}
+		if checkedAt.Less(replicatedTime) {
+			replicatedTime = checkedAt
+		}
+		for i := range frontierResolvedSpans {
+			if checkedAt.Less(frontierResolvedSpans[i].Timestamp) {
+				frontierResolvedSpans[i].Timestamp = checkedAt
+			}
+		}
+	}
 
 	rh.lastPartitionUpdate = timeutil.Now()
 	log.VInfof(ctx, 2, "persisting replicated time of %s", replicatedTime.GoTime())
+	nearGCThreshold := approachingGCThreshold(
+		hlc.Timestamp{WallTime: rh.lastPartitionUpdate.UnixNano()}, replicatedTime, rh.gcTTL)
+	catchUp := rh.catchUp.update(rh.lastPartitionUpdate, replicatedTime, catchUpLagThreshold.Get(rh.settings))
+	if nearGCThreshold && rh.gcWarning.ShouldLog() {
+		log.Warningf(ctx, "replicated time %s is approaching the GC threshold of the destination tables (GC TTL %s)",
+			replicatedTime.GoTime(), rh.gcTTL)
+	}
 	if err := rh.job.NoTxn().Update(ctx,
 		func(txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
 			if err := md.CheckRunningOrReverting(); err != nil {
@@ -351,6 +641,7 @@ func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
 			progress := md.Progress
 			prog := progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
 			prog.Checkpoint.ResolvedSpans = frontierResolvedSpans
+			prog.LaggardPartitions = laggards
 			if rh.replicatedTimeAtStart.Less(replicatedTime) {
 				prog.ReplicatedTime = replicatedTime
 				// The HighWater is for informational purposes
@@ -360,6 +651,36 @@ func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
 				}
 			}
 			progress.RunningStatus = fmt.Sprintf("logical replication running: %s", replicatedTime.GoTime())
+			if catchUp.catchingUp {
+				progress.RunningStatus = fmt.Sprintf(
+					"logical replication catching up: %s, advancing %.1fx faster than real time, caught up in about %s",
+					replicatedTime.GoTime(), catchUp.advanceRate, catchUp.eta.Round(time.Second))
+			}
+			if replicatedTime.IsEmpty() {
+				progress.RunningStatus = "logical replication initial scan in progress"
+			}
+			if rh.repair != nil {
+				progress.RunningStatus = repairStatus(*rh.repair, replicatedTime)
+			}
+			if ptsID := md.Payload.GetLogicalReplication().ProtectedTimestampRecordID; ptsID != nil {
+				protected, err := advanceProtectedTimestamp(ctx, rh.ptp.WithTxn(txn), *ptsID, replicatedTime)
+				if err != nil {
+					return err
+				}
+				progress.RunningStatus += fmt.Sprintf("; destination protected from GC at %s", protected.GoTime())
+			}
+			if nearGCThreshold {
+				progress.RunningStatus += "; approaching the GC threshold of the destination tables"
+			}
+			if rh.rebuildIndexes != nil {
+				progress.RunningStatus += fmt.Sprintf(
+					"; %d secondary indexes deferred until the initial scan completes", rh.deferredIndexes)
+			}
+			if quarantined := rh.quarantinedTables(); len(quarantined) > 0 {
+				progress.RunningStatus += fmt.Sprintf(
+					"; sending the rows of quarantined tables to the dead letter queue until the job is resumed: %s",
+					strings.Join(quarantined, ", "))
+			}
 			ju.UpdateProgress(progress)
 			if md.RunStats != nil && md.RunStats.NumRuns > 1 {
 				ju.UpdateRunStats(1, md.RunStats.LastRun)
@@ -370,16 +691,93 @@ func (rh *rowHandler) handleRow(ctx context.Context, row tree.Datums) error {
 	}
 
 	rh.metrics.ReplicatedTimeSeconds.Update(replicatedTime.GoTime().Unix())
+	rh.reportReplicatedTime(ctx, replicatedTime)
+	rh.maybeLogMilestones(ctx, replicatedTime)
 	return nil
 }
 
+// sourceSchemaCheckedAt returns the latest time as of which the schema of the
+// source tables is known not to have changed since the processors were
+// planned. It checks the schema as of the given time unless it was checked
+// within source_schema_check_interval.
+func (rh *rowHandler) sourceSchemaCheckedAt(
+	ctx context.Context, asOf hlc.Timestamp,
+) (hlc.Timestamp, error) {
+	if rh.schemaCheckedAt.Less(asOf) &&
+		timeutil.Since(rh.lastSchemaCheck) >= sourceSchemaCheckInterval.Get(rh.settings) {
+		if err := rh.checkSourceSchema(ctx, asOf); err != nil {
+			return hlc.Timestamp{}, err
+		}
+		rh.schemaCheckedAt, rh.lastSchemaCheck = asOf, timeutil.Now()
+	}
+	return rh.schemaCheckedAt, nil
+}
+
+// reportReplicatedTime hands the replicated time, once persisted, to the
+// heartbeat sender, which reports it to the source with its next heartbeat so
+// that the source may advance its protected timestamp and release the history
+// the stream no longer needs. Processors only report their flushes to the
+// job, since the frontier of a single processor only covers its partition,
+// and the replicated time is only reported once persisted, since the job
+// resumes from its persisted progress. If the sender stopped, e.g. because the
+// source's stream is no longer active, reporting stops and the stream's
+// subscriptions fail on their own.
+//
+// The sender doesn't take updates while it heartbeats, so rather than wait for
+// it, the replicated time is kept and handed to it with the next report, by
+// which time it has usually been superseded.
+func (rh *rowHandler) reportReplicatedTime(ctx context.Context, replicatedTime hlc.Timestamp) {
+	if rh.heartbeat == nil {
+		return
+	}
+	rh.unreportedReplicatedTime.Forward(replicatedTime)
+	if rh.unreportedReplicatedTime.IsEmpty() {
+		return
+	}
+	select {
+	case rh.heartbeat.FrontierUpdates <- rh.unreportedReplicatedTime:
+		rh.unreportedReplicatedTime = hlc.Timestamp{}
+	case <-rh.heartbeat.StoppedChan:
+		log.Warningf(ctx, "no longer reporting the replicated time to the source: %v", rh.heartbeat.Wait())
+		rh.heartbeat = nil
+	default:
+	}
+}
+
+// quarantinedTables returns the sorted names of the tables quarantined by any
+// processor.
+func (rh *rowHandler) quarantinedTables() []string {
+	names := make([]string, 0, len(rh.quarantined))
+	for id := range rh.quarantined {
+		name, ok := rh.sourceTableNames[id]
+		if !ok {
+			name = fmt.Sprintf("[%d]", id)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (r *logicalReplicationResumer) ingestWithRetries(
 	ctx context.Context, execCtx sql.JobExecContext,
 ) error {
 	ingestionJob := r.job
 	ro := getRetryPolicy(execCtx.ExecCfg().StreamingTestingKnobs)
+
+	// If processors checkpoint on drain, hold up the drain of this node until
+	// we've had a chance to persist their final checkpoint.
+	var drainCh <-chan struct{}
+	if drainCheckpointEnabled.Get(&execCtx.ExecCfg().Settings.SV) {
+		var drainDone func()
+		drainCh, drainDone = execCtx.ExecCfg().JobRegistry.OnDrain()
+		defer drainDone()
+	}
+
+	metrics := execCtx.ExecCfg().JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
 	var err error
 	var lastReplicatedTime hlc.Timestamp
+	var lastScanned roachpb.Spans
 	for retrier := retry.Start(ro); retrier.Next(); {
 		err = r.ingest(ctx, execCtx)
 		if err == nil {
@@ -392,13 +790,41 @@ func (r *logicalReplicationResumer) ingestWithRetries(
 		if jobs.IsPermanentJobError(err) || ctx.Err() != nil {
 			break
 		}
+		if errors.Is(err, errNodeDraining) {
+			select {
+			case <-drainCh:
+				// This node is draining, so let the registry resume the job
+				// elsewhere rather than replanning from here.
+				return jobs.MarkAsRetryJobError(err)
+			default:
+				// Some other node is draining; replanning will avoid it.
+			}
+		}
+
+		if errors.Is(err, errSourceSchemaChanged) {
+			// The job replans with the current source schema right away.
+			log.Infof(ctx, "replanning: %s", err)
+			retrier.Reset()
+			continue
+		}
 
 		log.Infof(ctx, "hit retryable error %s", err)
-		newReplicatedTime := loadOnlineReplicatedTime(ctx, execCtx.ExecCfg().InternalDB, ingestionJob)
+		newProgress := loadOnlineProgress(ctx, execCtx.ExecCfg().InternalDB, ingestionJob)
+		newReplicatedTime := newProgress.ReplicatedTime
 		if lastReplicatedTime.Less(newReplicatedTime) {
 			retrier.Reset()
 			lastReplicatedTime = newReplicatedTime
 		}
+		if newReplicatedTime.IsEmpty() && !newProgress.ReplicationStartTime.IsEmpty() {
+			// The flow failed before the initial scan completed. As long as each
+			// attempt scans more of the source, keep retrying.
+			metrics.InitialScanRestarts.Inc(1)
+			scanned := scannedSpans(newProgress.Checkpoint, newProgress.ReplicationStartTime)
+			if scannedMore(scanned, lastScanned) {
+				retrier.Reset()
+				lastScanned = scanned
+			}
+		}
 		if knobs := execCtx.ExecCfg().StreamingTestingKnobs; knobs != nil && knobs.AfterRetryIteration != nil {
 			knobs.AfterRetryIteration(err)
 		}
@@ -406,19 +832,21 @@ func (r *logicalReplicationResumer) ingestWithRetries(
 	return err
 }
 
-func loadOnlineReplicatedTime(
+// loadOnlineProgress loads the job's progress. If it can't be loaded, it
+// returns an empty progress.
+func loadOnlineProgress(
 	ctx context.Context, db isql.DB, ingestionJob *jobs.Job,
-) hlc.Timestamp {
+) *jobspb.LogicalReplicationProgress {
 	progress, err := jobs.LoadJobProgress(ctx, db, ingestionJob.ID())
 	if err != nil {
 		log.Warningf(ctx, "error loading job progress: %s", err)
-		return hlc.Timestamp{}
+		return &jobspb.LogicalReplicationProgress{}
 	}
 	if progress == nil {
 		log.Warningf(ctx, "no job progress yet: %s", err)
-		return hlc.Timestamp{}
+		return &jobspb.LogicalReplicationProgress{}
 	}
-	return progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication.ReplicatedTime
+	return progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
 }
 
 // OnFailOrCancel implements jobs.Resumer interface
@@ -428,6 +856,18 @@ func (h *logicalReplicationResumer) OnFailOrCancel(
 	execCfg := execCtx.(sql.JobExecContext).ExecCfg()
 	metrics := execCfg.JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
 	metrics.ReplicatedTimeSeconds.Update(0)
+	// The protected timestamp record is released first so that the
+	// destination tables aren't protected from GC while the rest of the
+	// cleanup is retried.
+	if err := h.releaseDestinationProtectedTimestamp(ctx, execCfg); err != nil {
+		return err
+	}
+	// Secondary indexes dropped until the initial scan completes are recreated
+	// so that the destination tables aren't left without them.
+	progress := h.job.Progress().Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
+	if deferred := progress.DeferredIndexes; len(deferred) > 0 {
+		return h.rebuildDeferredIndexes(ctx, execCfg.InternalDB, deferred)
+	}
 	return nil
 }
 
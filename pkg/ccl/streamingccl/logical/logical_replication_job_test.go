@@ -10,14 +10,28 @@ package logical
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdctest"
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
 	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/security/username"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/jobutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
@@ -25,7 +39,11 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -42,16 +60,14 @@ var (
 		"SET CLUSTER SETTING logical_replication.consumer.job_checkpoint_frequency = '100ms'",
 		"SET CLUSTER SETTING logical_replication.consumer.minimum_flush_interval = '10ms'",
 		"SET CLUSTER SETTING logical_replication.consumer.timestamp_granularity = '100ms'",
+		"SET CLUSTER SETTING logical_replication.consumer.source_schema_check_interval = '100ms'",
 	}
 	lwwColumnAdd = "ALTER TABLE tab ADD COLUMN crdb_internal_origin_timestamp DECIMAL NOT VISIBLE DEFAULT NULL ON UPDATE NULL"
 )
 
-func TestLogicalStreamIngestionJob(t *testing.T) {
-	defer leaktest.AfterTest(t)()
-	defer log.Scope(t).Close(t)
-
-	ctx := context.Background()
-	clusterArgs := base.TestClusterArgs{
+// testClusterArgs returns the args with which tests start their clusters.
+func testClusterArgs() base.TestClusterArgs {
+	return base.TestClusterArgs{
 		ServerArgs: base.TestServerArgs{
 			DefaultTestTenant: base.TestControlsTenantsExplicitly,
 			Knobs: base.TestingKnobs{
@@ -59,32 +75,57 @@ func TestLogicalStreamIngestionJob(t *testing.T) {
 			},
 		},
 	}
+}
 
-	serverA := testcluster.StartTestCluster(t, 1, clusterArgs)
-	defer serverA.Stopper().Stop(ctx)
-
-	serverB := testcluster.StartTestCluster(t, 1, clusterArgs)
-	defer serverB.Stopper().Stop(ctx)
-
-	serverASQL := sqlutils.MakeSQLRunner(serverA.Server(0).ApplicationLayer().SQLConn(t))
-	serverBSQL := sqlutils.MakeSQLRunner(serverB.Server(0).ApplicationLayer().SQLConn(t))
+// setupTwoClusterTest starts a source cluster, A, and a destination cluster,
+// B, with a single node each and applies testClusterSettings to both. If
+// createStmt is set, it creates tab on both clusters with it, along with the
+// origin timestamp column. It returns SQL runners for both clusters and the
+// URL of A, along with a function that stops the clusters.
+func setupTwoClusterTest(
+	t testing.TB, clusterArgs base.TestClusterArgs, createStmt string,
+) (
+	serverA, serverB *testcluster.TestCluster,
+	serverASQL, serverBSQL *sqlutils.SQLRunner,
+	serverAURL url.URL,
+	cleanup func(),
+) {
+	ctx := context.Background()
+	serverA = testcluster.StartTestCluster(t, 1, clusterArgs)
+	serverB = testcluster.StartTestCluster(t, 1, clusterArgs)
 
+	serverASQL = sqlutils.MakeSQLRunner(serverA.Server(0).ApplicationLayer().SQLConn(t))
+	serverBSQL = sqlutils.MakeSQLRunner(serverB.Server(0).ApplicationLayer().SQLConn(t))
 	for _, s := range testClusterSettings {
 		serverASQL.Exec(t, s)
 		serverBSQL.Exec(t, s)
 	}
+	if createStmt != "" {
+		serverASQL.Exec(t, createStmt)
+		serverBSQL.Exec(t, createStmt)
+		serverASQL.Exec(t, lwwColumnAdd)
+		serverBSQL.Exec(t, lwwColumnAdd)
+	}
+
+	serverAURL, cleanupURL := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	return serverA, serverB, serverASQL, serverBSQL, serverAURL, func() {
+		cleanupURL()
+		serverB.Stopper().Stop(ctx)
+		serverA.Stopper().Stop(ctx)
+	}
+}
+
+func TestLogicalStreamIngestionJob(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
 
 	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
-	serverASQL.Exec(t, createStmt)
-	serverBSQL.Exec(t, createStmt)
-	serverASQL.Exec(t, lwwColumnAdd)
-	serverBSQL.Exec(t, lwwColumnAdd)
+	serverA, serverB, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
 
 	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
 	serverBSQL.Exec(t, "INSERT INTO tab VALUES (1, 'goodbye')")
 
-	serverAURL, cleanup := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
-	defer cleanup()
 	serverBURL, cleanupB := sqlutils.PGUrl(t, serverB.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
 	defer cleanupB()
 
@@ -117,23 +158,141 @@ func TestLogicalStreamIngestionJob(t *testing.T) {
 	}
 	serverBSQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
 	serverASQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
+
+	testutils.SucceedsSoon(t, func() error {
+		var processors int
+		serverBSQL.QueryRow(t, `SELECT count(*) FROM crdb_internal.logical_replication_node_processors
+WHERE admit_latency_p50 IS NOT NULL AND admit_latency_p99 >= admit_latency_p50`).Scan(&processors)
+		if processors == 0 {
+			return errors.New("waiting for admission latency percentiles")
+		}
+		return nil
+	})
+
+	// Each processor reports the redacted address of its source and the
+	// fingerprint of its partition's subscription token.
+	var processors, redacted, fingerprinted int
+	serverBSQL.QueryRow(t, `SELECT count(*), count(*) FILTER (WHERE source_address LIKE '%?redacted'),
+count(token_fingerprint) FROM crdb_internal.logical_replication_node_processors`).Scan(&processors, &redacted, &fingerprinted)
+	require.NotZero(t, processors)
+	require.Equal(t, processors, redacted)
+	require.Equal(t, processors, fingerprinted)
+
+	// The node's health endpoint covers every processor, each of which has
+	// flushed by now.
+	httpClient, err := serverB.Server(0).ApplicationLayer().GetAdminHTTPClient()
+	require.NoError(t, err)
+	defer httpClient.CloseIdleConnections()
+	resp, err := httpClient.Get(serverB.Server(0).ApplicationLayer().AdminURL().
+		WithPath("/debug/logical_replication/health").String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var health streampb.DebugLogicalConsumersHealth
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	require.True(t, health.Healthy)
+	require.Len(t, health.Consumers, processors)
+	for _, c := range health.Consumers {
+		require.NotZero(t, c.LastFlushUnixMicros)
+	}
 }
 
 func TestLogicalStreamIngestionJobWithColumnFamilies(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
 
+	createStmt := `CREATE TABLE tab (
+pk int primary key,
+payload string,
+v1 int as (pk + 9000) virtual,
+v2 int as (pk + 42) stored,
+other_payload string,
+family f1(pk, payload),
+family f2(other_payload, v2))
+`
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab(pk, payload, other_payload) VALUES (1, 'hello', 'ruroh1')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverASQL.Exec(t, "INSERT INTO tab(pk, payload, other_payload) VALUES (2, 'potato', 'ruroh2')")
+	serverASQL.Exec(t, "UPSERT INTO tab(pk, payload, other_payload) VALUES (1, 'hello, again', 'ruroh3')")
+
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	expectedRows := [][]string{
+		{"1", "hello, again", "9001", "43", "ruroh3"},
+		{"2", "potato", "9002", "44", "ruroh2"},
+	}
+	serverBSQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
+	serverASQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
+}
+
+func TestLogicalStreamIngestionJobPausesOnMaxLag(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	_, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	// Any lag at all is more than we are willing to tolerate.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.max_lag = '1ns'")
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.max_lag_window = '0s'")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "exceeded max_lag")
+}
+
+func TestLogicalStreamIngestionJobCheckpointsOnDrain(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
 	ctx := context.Background()
+
+	// Only the first flow sees the drain; the replanned flow keeps running.
+	drainCh := make(chan struct{})
+	var drainWatchers atomic.Int32
+	retryErrCh := make(chan error)
+	resumeCh := make(chan struct{})
+	streamingKnobs := &sql.StreamingTestingKnobs{
+		OnDrain: func() <-chan struct{} {
+			if drainWatchers.Add(1) == 1 {
+				return drainCh
+			}
+			return nil
+		},
+		AfterRetryIteration: func(err error) {
+			if errors.Is(err, errNodeDraining) {
+				retryErrCh <- err
+				<-resumeCh
+			}
+		},
+	}
 	clusterArgs := base.TestClusterArgs{
 		ServerArgs: base.TestServerArgs{
 			DefaultTestTenant: base.TestControlsTenantsExplicitly,
 			Knobs: base.TestingKnobs{
 				JobsTestingKnobs: jobs.NewTestingKnobsWithShortIntervals(),
+				DistSQL: &execinfra.TestingKnobs{
+					StreamingTestingKnobs: streamingKnobs,
+				},
+				Streaming: streamingKnobs,
 			},
 		},
 	}
 
-	serverA := testcluster.StartTestCluster(t, 1, clusterArgs)
+	serverA := testcluster.StartTestCluster(t, 1, base.TestClusterArgs{
+		ServerArgs: base.TestServerArgs{DefaultTestTenant: base.TestControlsTenantsExplicitly},
+	})
 	defer serverA.Stopper().Stop(ctx)
 
 	serverB := testcluster.StartTestCluster(t, 1, clusterArgs)
@@ -147,56 +306,1487 @@ func TestLogicalStreamIngestionJobWithColumnFamilies(t *testing.T) {
 		serverBSQL.Exec(t, s)
 	}
 
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverASQL.Exec(t, createStmt)
+	serverBSQL.Exec(t, createStmt)
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	serverAURL, cleanup := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	defer cleanup()
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	// Stop persisting progress in the normal course of things so that only the
+	// checkpoint emitted on drain can advance the persisted replicated time.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.job_checkpoint_frequency = '1h'")
+	replicatedTime := func() hlc.Timestamp {
+		progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+		return progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication.ReplicatedTime
+	}
+	beforeDrain := replicatedTime()
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+	serverBSQL.CheckQueryResultsRetry(t, "SELECT * from tab", [][]string{{"1", "hello"}})
+
+	close(drainCh)
+	require.ErrorIs(t, <-retryErrCh, errNodeDraining)
+	require.True(t, beforeDrain.Less(replicatedTime()))
+	close(resumeCh)
+
+	// The replanned flow picks up where the drained one left off.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'goodbye')")
+	serverBSQL.CheckQueryResultsRetry(t, "SELECT * from tab", [][]string{{"1", "hello"}, {"2", "goodbye"}})
+}
+
+func TestLogicalStreamIngestionJobFansOutAppliedRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	cert, certEncoded, err := cdctest.NewCACertBase64Encoded()
+	require.NoError(t, err)
+	sinkDest, err := cdctest.StartMockWebhookSink(cert)
+	require.NoError(t, err)
+	defer sinkDest.Close()
+	sinkURL, err := url.Parse(sinkDest.URL())
+	require.NoError(t, err)
+	params := sinkURL.Query()
+	params.Set(changefeedbase.SinkParamCACert, certEncoded)
+	sinkURL.RawQuery = params.Encode()
+
+	serverBSQL.ExpectErr(t, "unknown option",
+		fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"bogus\": \"\"}')",
+			serverAURL.String(), `ARRAY['tab']`))
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, json_build_object('fanout_sink', '%s'))",
+		serverAURL.String(), `ARRAY['tab']`, "webhook-"+sinkURL.String())).Scan(&jobBID)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+	serverBSQL.CheckQueryResultsRetry(t, "SELECT * from tab", [][]string{{"1", "hello"}})
+	testutils.SucceedsSoon(t, func() error {
+		if msg := sinkDest.Pop(); !strings.Contains(msg, "hello") {
+			return errors.Newf("unexpected fanout message %q", msg)
+		}
+		return nil
+	})
+
+	// A row that loses to a newer destination row isn't emitted.
+	serverBSQL.Exec(t, "PAUSE JOB $1", jobBID)
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'lost')")
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (2, 'newer')")
+	serverBSQL.Exec(t, "RESUME JOB $1", jobBID)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (3, 'after')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT * from tab",
+		[][]string{{"1", "hello"}, {"2", "newer"}, {"3", "after"}})
+	testutils.SucceedsSoon(t, func() error {
+		msg := sinkDest.Pop()
+		require.NotContains(t, msg, "lost")
+		if !strings.Contains(msg, "after") {
+			return errors.Newf("waiting for the fanout message of row 3, got %q", msg)
+		}
+		return nil
+	})
+}
+
+func TestLogicalStreamIngestionJobWithSecondaryIndexes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
 	createStmt := `CREATE TABLE tab (
 pk int primary key,
 payload string,
-v1 int as (pk + 9000) virtual,
-v2 int as (pk + 42) stored,
 other_payload string,
 family f1(pk, payload),
-family f2(other_payload, v2))
+family f2(other_payload),
+index idx_payload(payload),
+index idx_other_payload(other_payload))
 `
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	// Apply a single KV per batch so that rows with several column families
+	// would be split across transactions if they weren't kept together.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.batch_size = 1")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab SELECT i, 'a' || i::STRING, 'b' || i::STRING FROM generate_series(1, 20) AS g(i)")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'updated', other_payload = 'also updated' WHERE pk % 3 = 0")
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk % 5 = 0")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	// Reading through either secondary index must find the same rows as
+	// reading the primary index.
+	expectedRows := serverASQL.QueryStr(t, "SELECT pk, payload, other_payload FROM tab ORDER BY pk")
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, other_payload FROM tab@tab_pkey ORDER BY pk", expectedRows)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab@idx_payload ORDER BY pk",
+		serverASQL.QueryStr(t, "SELECT pk, payload FROM tab ORDER BY pk"))
+	serverBSQL.CheckQueryResults(t, "SELECT pk, other_payload FROM tab@idx_other_payload ORDER BY pk",
+		serverASQL.QueryStr(t, "SELECT pk, other_payload FROM tab ORDER BY pk"))
+}
+
+func TestLogicalStreamIngestionJobWritesCheckpointsToSink(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	dir, dirCleanup := testutils.TempDir(t)
+	defer dirCleanup()
+	clusterArgs := testClusterArgs()
+	clusterArgs.ServerArgs.ExternalIODir = dir
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, clusterArgs, createStmt)
+	defer cleanup()
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, json_build_object('checkpoint_sink', '%s'))",
+		serverAURL.String(), `ARRAY['tab']`, "nodelocal://1/checkpoints")).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The checkpoint written by the writer processor eventually covers the
+	// time the job has replicated up to.
+	testutils.SucceedsSoon(t, func() error {
+		files, err := filepath.Glob(filepath.Join(dir, "checkpoints", jobBID.String(), "checkpoint-*"))
+		if err != nil {
+			return err
+		}
+		if len(files) != 1 {
+			return errors.Newf("expected 1 checkpoint file, found %v", files)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			return err
+		}
+		var checkpoint jobspb.ResolvedSpans
+		if err := protoutil.Unmarshal(data, &checkpoint); err != nil {
+			return err
+		}
+		if len(checkpoint.ResolvedSpans) == 0 {
+			return errors.New("checkpoint has no resolved spans")
+		}
+		for _, sp := range checkpoint.ResolvedSpans {
+			if sp.Timestamp.Less(now) {
+				return errors.Newf("span %s resolved at %s, before %s", sp.Span, sp.Timestamp, now)
+			}
+		}
+		return nil
+	})
+}
+
+func TestLogicalStreamIngestionJobDryRun(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	_, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"dry_run\": \"true\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	jobutils.WaitForJobToSucceed(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "projected peak resource usage")
+	require.Contains(t, progress.RunningStatus, "1 processors")
+
+	// Nothing was replicated.
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM tab", [][]string{{"0"}})
+}
+
+func TestLogicalStreamIngestionJobCompletesAtCutover(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'before')")
+	cutover := serverA.Server(0).Clock().Now()
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'after')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, json_build_object('cutover_time', '%s'))",
+		serverAURL.String(), `ARRAY['tab']`, cutover.AsOfSystemTime())).Scan(&jobBID)
+
+	jobutils.WaitForJobToSucceed(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "complete through cutover time")
+
+	// Only the row written before the cutover time was replicated.
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "before"}})
+}
+
+func TestLogicalStreamIngestionJobVerifiesCutover(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// The destination tables have other IDs than the source tables.
+	serverBSQL.Exec(t, "CREATE TABLE unrelated (pk int primary key)")
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
 	serverASQL.Exec(t, createStmt)
 	serverBSQL.Exec(t, createStmt)
 	serverASQL.Exec(t, lwwColumnAdd)
 	serverBSQL.Exec(t, lwwColumnAdd)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, 'world')")
+	cutover := serverA.Server(0).Clock().Now()
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (3, 'after')")
 
-	serverASQL.Exec(t, "INSERT INTO tab(pk, payload, other_payload) VALUES (1, 'hello', 'ruroh1')")
+	startJob := func() jobspb.JobID {
+		var jobID jobspb.JobID
+		serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, "+
+			"json_build_object('cutover_time', '%s', 'cutover_verification', 'full'))",
+			serverAURL.String(), `ARRAY['tab']`, cutover.AsOfSystemTime())).Scan(&jobID)
+		return jobID
+	}
 
-	serverAURL, cleanup := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	// The destination matches the source as of the cutover time, so the job
+	// completes.
+	jobBID := startJob()
+	jobutils.WaitForJobToSucceed(t, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "hello"}, {"2", "world"}})
+
+	// A row written to the destination directly diverges from the source, so
+	// the job pauses rather than completing.
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (4, 'local')")
+	jobBID = startJob()
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "cutover verification found 1 of 3 compared rows")
+	require.Contains(t, progress.RunningStatus, "tab(4)")
+}
+
+func TestLogicalStreamIngestionJobRepairsTargetedWindow(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
 	defer cleanup()
 
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'before'), (2, 'before')")
+	start := serverA.Server(0).Clock().Now()
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'repaired' WHERE pk = 1")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (3, 'outside span')")
+	end := serverA.Server(0).Clock().Now()
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'after' WHERE pk = 2")
+
+	// The repair span covers the rows with primary keys 1 and 2.
+	var startKey, endKey string
+	serverASQL.QueryRow(t, `SELECT
+  encode(crdb_internal.encode_key('tab'::regclass::oid::int, 1, (1,)), 'hex'),
+  encode(crdb_internal.encode_key('tab'::regclass::oid::int, 1, (3,)), 'hex')`).Scan(&startKey, &endKey)
+
 	var jobBID jobspb.JobID
-	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, "+
+		"json_build_object('repair_span', '%s,%s', 'repair_start_time', '%s', 'cutover_time', '%s'))",
+		serverAURL.String(), `ARRAY['tab']`, startKey, endKey, start.AsOfSystemTime(), end.AsOfSystemTime())).Scan(&jobBID)
+
+	jobutils.WaitForJobToSucceed(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "targeted repair of span")
+	require.Contains(t, progress.RunningStatus, "complete")
+
+	// Only the change made to the repair span within the window was applied.
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "repaired"}})
+}
+
+func TestLogicalStreamIngestionJobAppliesDeletesWithFiltering(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab SELECT i, 'row' FROM generate_series(1, 6) AS g(i)")
 
+	// Deletes made by a session that opted out of replication are filtered.
+	optedOut, err := serverA.Server(0).ApplicationLayer().SQLConn(t).Conn(ctx)
+	require.NoError(t, err)
+	defer func() { _ = optedOut.Close() }()
+	_, err = optedOut.ExecContext(ctx, "SET disable_changefeed_replication = true")
+	require.NoError(t, err)
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
 	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
-	serverASQL.Exec(t, "INSERT INTO tab(pk, payload, other_payload) VALUES (2, 'potato', 'ruroh2')")
-	serverASQL.Exec(t, "UPSERT INTO tab(pk, payload, other_payload) VALUES (1, 'hello, again', 'ruroh3')")
 
+	// With filtering, point and ranged deletes are still delivered and applied.
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk = 1")
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk >= 5")
+	_, err = optedOut.ExecContext(ctx, "DELETE FROM tab WHERE pk = 2")
+	require.NoError(t, err)
 	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk FROM tab ORDER BY pk", [][]string{{"2"}, {"3"}, {"4"}})
 
-	expectedRows := [][]string{
-		{"1", "hello, again", "9001", "43", "ruroh3"},
-		{"2", "potato", "9002", "44", "ruroh2"},
-	}
-	serverBSQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
-	serverASQL.CheckQueryResults(t, "SELECT * from tab", expectedRows)
+	// Without filtering, deletes of sessions that opted out are applied too
+	// once the partitions are resubscribed.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.subscription_filtering.enabled = false")
+	serverBSQL.Exec(t, "PAUSE JOB $1", jobBID)
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	serverBSQL.Exec(t, "RESUME JOB $1", jobBID)
+	jobutils.WaitForJobToRun(t, serverBSQL, jobBID)
+	_, err = optedOut.ExecContext(ctx, "DELETE FROM tab WHERE pk = 3")
+	require.NoError(t, err)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk FROM tab ORDER BY pk", [][]string{{"2"}, {"4"}})
 }
 
-func WaitUntilReplicatedTime(
-	t *testing.T, targetTime hlc.Timestamp, db *sqlutils.SQLRunner, ingestionJobID jobspb.JobID,
-) {
-	testutils.SucceedsSoon(t, func() error {
-		progress := jobutils.GetJobProgress(t, db, ingestionJobID)
-		replicatedTime := progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication.ReplicatedTime
-		if replicatedTime.IsEmpty() {
-			return errors.Newf("stream ingestion has not recorded any progress yet, waiting to advance pos %s",
-				targetTime)
-		}
-		if replicatedTime.Less(targetTime) {
-			return errors.Newf("waiting for stream ingestion job progress %s to advance beyond %s",
-				replicatedTime, targetTime)
+func TestLogicalStreamIngestionJobVisibleToRangefeeds(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	clusterArgs := testClusterArgs()
+
+	// Rows are replicated from A to B and from B to C, so C only receives the
+	// rows applied to B if they are visible to B's rangefeeds.
+	serverA := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer serverA.Stopper().Stop(ctx)
+	serverB := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer serverB.Stopper().Stop(ctx)
+	serverC := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer serverC.Stopper().Stop(ctx)
+
+	serverASQL := sqlutils.MakeSQLRunner(serverA.Server(0).ApplicationLayer().SQLConn(t))
+	serverBSQL := sqlutils.MakeSQLRunner(serverB.Server(0).ApplicationLayer().SQLConn(t))
+	serverCSQL := sqlutils.MakeSQLRunner(serverC.Server(0).ApplicationLayer().SQLConn(t))
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	for _, r := range []*sqlutils.SQLRunner{serverASQL, serverBSQL, serverCSQL} {
+		for _, s := range testClusterSettings {
+			r.Exec(t, s)
+		}
+		r.Exec(t, createStmt)
+		r.Exec(t, lwwColumnAdd)
+	}
+
+	serverAURL, cleanupA := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	defer cleanupA()
+	serverBURL, cleanupB := sqlutils.PGUrl(t, serverB.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	defer cleanupB()
+
+	var jobBID, jobCID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	serverCSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverBURL.String(), `ARRAY['tab']`)).Scan(&jobCID)
+
+	// By default, the rows applied to B are omitted from its rangefeeds.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'omitted')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	WaitUntilReplicatedTime(t, serverB.Server(0).Clock().Now(), serverCSQL, jobCID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "omitted"}})
+	serverCSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{})
+
+	// With visible_to_rangefeeds, they reach C.
+	serverBSQL.Exec(t, "CANCEL JOB $1", jobBID)
+	jobutils.WaitForJobToCancel(t, serverBSQL, jobBID)
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, "+
+		"json_build_object('visible_to_rangefeeds', true))", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'visible')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	WaitUntilReplicatedTime(t, serverB.Server(0).Clock().Now(), serverCSQL, jobCID)
+	serverCSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab WHERE pk = 2", [][]string{{"2", "visible"}})
+}
+
+func TestLogicalStreamIngestionJobPrefetchesPriorRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := `CREATE TABLE tab ("Primary Key" int primary key, payload string)`
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.prefetch_prior_rows.enabled = true")
+
+	// The primary key column's name needs quoting.
+
+	// Row 1 is written on B after A, so A's write loses to it.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'older'), (2, 'hello'), (3, 'world')")
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (1, 'newer')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	serverBSQL.CheckQueryResults(t, `SELECT "Primary Key", payload FROM tab`,
+		[][]string{{"1", "newer"}, {"2", "hello"}, {"3", "world"}})
+	// The rows that won were written by batched statements.
+	var reads, skipped, batched int
+	serverBSQL.QueryRow(t, `SELECT
+  sum(value) FILTER (WHERE name = 'logical_replication.prefetch_reads'),
+  sum(value) FILTER (WHERE name = 'logical_replication.prefetch_skipped_writes'),
+  sum(value) FILTER (WHERE name = 'logical_replication.batched_apply_rows')
+FROM crdb_internal.node_metrics`).Scan(&reads, &skipped, &batched)
+	require.NotZero(t, reads)
+	require.NotZero(t, skipped)
+	require.NotZero(t, batched)
+}
+
+func TestLogicalStreamIngestionJobBatchesDeletesAndUpserts(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.batched_apply.enabled = true")
+
+	// Row 1 is written on B after A, so A's write loses to it.
+	serverASQL.Exec(t, "INSERT INTO tab SELECT i, 'hello' FROM generate_series(1, 10) AS g(i)")
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (1, 'newer')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM tab WHERE payload = 'hello'", [][]string{{"9"}})
+	serverBSQL.CheckQueryResults(t, "SELECT payload FROM tab WHERE pk = 1", [][]string{{"newer"}})
+
+	// Deletes are batched alongside upserts, including of keys written more
+	// than once since the last flush.
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk > 5")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'world' WHERE pk > 1")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'again' WHERE pk = 2")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk", [][]string{
+		{"1", "newer"}, {"2", "again"}, {"3", "world"}, {"4", "world"}, {"5", "world"},
+	})
+
+	var batched int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.batched_apply_rows'`).Scan(&batched)
+	require.NotZero(t, batched)
+}
+
+// BenchmarkLogicalStreamIngestionJobApply measures how long it takes to
+// replicate a large DELETE or UPDATE of the source table, with and without
+// batched_apply, and with and without applying the batches, which all fall in
+// the table's single range, using autocommitting statements.
+func BenchmarkLogicalStreamIngestionJobApply(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+	defer log.Scope(b).Close(b)
+
+	for _, workload := range []string{"upserts", "deletes"} {
+		for _, batched := range []bool{false, true} {
+			for _, singleRange := range []bool{false, true} {
+				name := fmt.Sprintf("workload=%s/batched=%t/single-range=%t", workload, batched, singleRange)
+				b.Run(name, func(b *testing.B) {
+					createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+					serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(b, testClusterArgs(), createStmt)
+					defer cleanup()
+
+					serverBSQL.Exec(b, fmt.Sprintf(
+						"SET CLUSTER SETTING logical_replication.consumer.batched_apply.enabled = %t", batched))
+					serverBSQL.Exec(b, fmt.Sprintf(
+						"SET CLUSTER SETTING logical_replication.consumer.single_range_batches.enabled = %t", singleRange))
+
+					serverASQL.Exec(b, "INSERT INTO tab SELECT i, 'hello' FROM generate_series(1, $1) AS g(i)", b.N)
+
+					var jobBID jobspb.JobID
+					serverBSQL.QueryRow(b, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+						serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+					WaitUntilReplicatedTime(b, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+					b.ResetTimer()
+					if workload == "deletes" {
+						serverASQL.Exec(b, "DELETE FROM tab WHERE true")
+					} else {
+						serverASQL.Exec(b, "UPDATE tab SET payload = 'world' WHERE true")
+					}
+					WaitUntilReplicatedTime(b, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+					b.StopTimer()
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkLogicalStreamIngestionJobRangeLimitedBatches measures how long it
+// takes to replicate a large UPDATE of the source table to a destination table
+// split into many ranges, whose secondary index is split as well, with and
+// without max_ranges_per_batch.
+func BenchmarkLogicalStreamIngestionJobRangeLimitedBatches(b *testing.B) {
+	defer leaktest.AfterTest(b)()
+	defer log.Scope(b).Close(b)
+
+	for _, maxRanges := range []int{0, 2, 8} {
+		b.Run(fmt.Sprintf("max-ranges-per-batch=%d", maxRanges), func(b *testing.B) {
+			createStmt := "CREATE TABLE tab (pk int primary key, payload string, INDEX payload_idx (payload))"
+			serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(b, testClusterArgs(), createStmt)
+			defer cleanup()
+
+			serverBSQL.Exec(b, fmt.Sprintf(
+				"SET CLUSTER SETTING logical_replication.consumer.max_ranges_per_batch = %d", maxRanges))
+
+			// Split the destination's primary and secondary indexes into 64
+			// ranges each.
+			serverBSQL.Exec(b, "ALTER TABLE tab SPLIT AT SELECT i * $1 // 64 FROM generate_series(1, 63) AS g(i)", b.N)
+			serverBSQL.Exec(b, "ALTER INDEX tab@payload_idx SPLIT AT SELECT lpad(i::string, 8, '0') FROM generate_series(1, 63) AS g(i)")
+			serverASQL.Exec(b, "INSERT INTO tab SELECT i, 'hello' FROM generate_series(1, $1) AS g(i)", b.N)
+
+			var jobBID jobspb.JobID
+			serverBSQL.QueryRow(b, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+				serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+			WaitUntilReplicatedTime(b, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+			b.ResetTimer()
+			serverASQL.Exec(b, "UPDATE tab SET payload = lpad((pk % 64)::string, 8, '0') WHERE true")
+			WaitUntilReplicatedTime(b, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+			b.StopTimer()
+		})
+	}
+}
+
+func TestLogicalStreamIngestionJobRetriesOnLockTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, serverB, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.apply_lock_timeout = '50ms'")
+
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+
+	// Hold a lock on the row replicated from A so that applying it times out.
+	tx, err := serverB.Server(0).ApplicationLayer().SQLConn(t).BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.Exec("SELECT * FROM tab WHERE pk = 1 FOR UPDATE")
+	require.NoError(t, err)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'world')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	testutils.SucceedsSoon(t, func() error {
+		var retries int
+		serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.lock_timeout_retries'`).Scan(&retries)
+		if retries == 0 {
+			return errors.New("no batch retried on lock timeout yet")
+		}
+		return nil
+	})
+	require.NoError(t, tx.Rollback())
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "world"}})
+}
+
+func TestLogicalStreamIngestionJobIgnoresDeletes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverBSQL.ExpectErr(t, "is not replicated",
+		fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"ignore_deletes\": \"other\"}')",
+			serverAURL.String(), `ARRAY['tab']`))
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"ignore_deletes\": \"tab\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, 'world')")
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk = 1")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'updated' WHERE pk = 2")
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The deleted row is retained while other changes are applied.
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "hello"}, {"2", "updated"}})
+	var ignored int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.ignored_deletes'`).Scan(&ignored)
+	require.NotZero(t, ignored)
+}
+
+func TestLogicalStreamIngestionJobComparesAndSwaps(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := `CREATE TABLE tab (pk int primary key, "Payload" string)`
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	// The column names need quoting.
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"compare_and_swap\": \"true\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	// Inserts, updates and deletes whose destination rows match their prior
+	// values are applied.
+	serverASQL.Exec(t, `INSERT INTO tab VALUES (1, 'hello'), (2, 'world'), (3, 'gone')`)
+	serverASQL.Exec(t, `UPDATE tab SET "Payload" = 'hi' WHERE pk = 1`)
+	serverASQL.Exec(t, `DELETE FROM tab WHERE pk = 3`)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, `SELECT pk, "Payload" FROM tab ORDER BY pk`,
+		[][]string{{"1", "hi"}, {"2", "world"}})
+
+	// An update whose destination row was changed locally doesn't match its
+	// prior value, so it's sent to the dead letter queue rather than applied.
+	serverBSQL.Exec(t, `UPDATE tab SET "Payload" = 'local' WHERE pk = 2`)
+	serverASQL.Exec(t, `UPDATE tab SET "Payload" = 'remote' WHERE pk = 2`)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, `SELECT pk, "Payload" FROM tab ORDER BY pk`,
+		[][]string{{"1", "hi"}, {"2", "local"}})
+	var mismatches int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.prior_value_mismatches'`).Scan(&mismatches)
+	require.Equal(t, 1, mismatches)
+	serverBSQL.CheckQueryResults(t, fmt.Sprintf(
+		`SELECT count(*) FROM defaultdb.public.crdb_replication_dlq WHERE job_id = %d`, jobBID), [][]string{{"1"}})
+}
+
+func TestLogicalStreamIngestionJobSoftDeletes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// Only the destination table has the soft delete column.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string, deleted_at TIMESTAMPTZ)")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	// The destination tables must have the soft delete column.
+	serverBSQL.ExpectErr(t, `has no soft delete column "missing"`,
+		fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"soft_delete_column\": \"missing\"}')",
+			serverAURL.String(), `ARRAY['tab']`))
+	serverBSQL.ExpectErr(t, "must be TIMESTAMPTZ",
+		fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"soft_delete_column\": \"payload\"}')",
+			serverAURL.String(), `ARRAY['tab']`))
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"soft_delete_column\": \"deleted_at\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, 'world')")
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk = 1")
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The deleted row is retained with its soft delete column set.
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, deleted_at IS NOT NULL FROM tab ORDER BY pk",
+		[][]string{{"1", "hello", "true"}, {"2", "world", "false"}})
+	var softDeletes int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.soft_deletes'`).Scan(&softDeletes)
+	require.NotZero(t, softDeletes)
+
+	// A row reinserted after its delete is live again.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'again')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, deleted_at IS NOT NULL FROM tab ORDER BY pk",
+		[][]string{{"1", "again", "false"}, {"2", "world", "false"}})
+}
+
+func TestLogicalStreamIngestionJobAppliesToFanoutTables(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+	// One fan-out table copies the source table, the other is keyed by its
+	// payload and transforms it with a computed column.
+	serverBSQL.Exec(t, "CREATE TABLE tab_copy (pk int primary key, payload string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab_by_payload (payload string primary key, pk int, "+
+		"upper_payload string AS (upper(payload)) STORED)")
+	for _, name := range []string{"tab_copy", "tab_by_payload"} {
+		serverBSQL.Exec(t, fmt.Sprintf("ALTER TABLE %s ADD COLUMN crdb_internal_origin_timestamp "+
+			"DECIMAL NOT VISIBLE DEFAULT NULL ON UPDATE NULL", name))
+	}
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '%s')",
+		serverAURL.String(), `ARRAY['tab']`, `{"fanout_tables": "tab=tab_copy,tab_by_payload"}`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'a'), (2, 'b')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk", [][]string{{"1", "a"}, {"2", "b"}})
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab_copy ORDER BY pk", [][]string{{"1", "a"}, {"2", "b"}})
+	serverBSQL.CheckQueryResults(t, "SELECT payload, pk, upper_payload FROM tab_by_payload ORDER BY payload",
+		[][]string{{"a", "1", "A"}, {"b", "2", "B"}})
+
+	// Updating the payload rekeys the row of tab_by_payload, and deletes are
+	// applied to every fan-out table.
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'c' WHERE pk = 1")
+	serverASQL.Exec(t, "DELETE FROM tab WHERE pk = 2")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk", [][]string{{"1", "c"}})
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab_copy ORDER BY pk", [][]string{{"1", "c"}})
+	serverBSQL.CheckQueryResults(t, "SELECT payload, pk, upper_payload FROM tab_by_payload ORDER BY payload",
+		[][]string{{"c", "1", "C"}})
+}
+
+func TestLogicalStreamIngestionJobSplitsInitialScan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.initial_scan_parallelism = 2")
+
+	for _, table := range []string{"a", "b"} {
+		createStmt := fmt.Sprintf("CREATE TABLE %s (pk int primary key, payload string)", table)
+		serverASQL.Exec(t, createStmt)
+		serverBSQL.Exec(t, createStmt)
+		addColumn := strings.Replace(lwwColumnAdd, "TABLE tab", "TABLE "+table, 1)
+		serverASQL.Exec(t, addColumn)
+		serverBSQL.Exec(t, addColumn)
+		serverASQL.Exec(t, fmt.Sprintf("INSERT INTO %s SELECT i, 'scanned' FROM generate_series(1, 100) AS g(i)", table))
+	}
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['a', 'b']`)).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM a", [][]string{{"100"}})
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM b", [][]string{{"100"}})
+
+	// Once the scan completes, changes are received through a single
+	// subscription.
+	serverASQL.Exec(t, "UPDATE a SET payload = 'updated' WHERE pk = 1")
+	serverASQL.Exec(t, "DELETE FROM b WHERE pk = 1")
+	now = serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT payload FROM a WHERE pk = 1", [][]string{{"updated"}})
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM b", [][]string{{"99"}})
+}
+
+func TestLogicalStreamIngestionJobProtectsDestinationTables(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The record protecting the destination table advances with the
+	// replicated time.
+	ptsID := jobutils.GetJobPayload(t, serverBSQL, jobBID).GetLogicalReplication().ProtectedTimestampRecordID
+	require.NotNil(t, ptsID)
+	testutils.SucceedsSoon(t, func() error {
+		var ts string
+		serverBSQL.QueryRow(t, `SELECT ts FROM system.protected_ts_records WHERE id = $1`, ptsID).Scan(&ts)
+		protected, err := hlc.ParseHLC(ts)
+		if err != nil {
+			return err
+		}
+		if protected.Less(now) {
+			return errors.Newf("protected timestamp %s is behind replicated time %s", protected, now)
+		}
+		return nil
+	})
+	require.Contains(t, jobutils.GetJobProgress(t, serverBSQL, jobBID).RunningStatus, "protected from GC")
+
+	// Canceling the job releases the record.
+	serverBSQL.Exec(t, "CANCEL JOB $1", jobBID)
+	jobutils.WaitForJobToCancel(t, serverBSQL, jobBID)
+	var count int
+	serverBSQL.QueryRow(t, `SELECT count(*) FROM system.protected_ts_records WHERE id = $1`, ptsID).Scan(&count)
+	require.Zero(t, count)
+}
+
+func TestLogicalStreamIngestionJobReportsReplicatedTimeToSource(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.heartbeat_frequency = '100ms'")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The record protecting the source tables advances with the replicated
+	// time reported by the destination.
+	streamID := jobutils.GetJobProgress(t, serverBSQL, jobBID).GetLogicalReplication().StreamID
+	ptsID := jobutils.GetJobPayload(t, serverASQL, jobspb.JobID(streamID)).GetStreamReplication().ProtectedTimestampRecordID
+	testutils.SucceedsSoon(t, func() error {
+		var ts string
+		serverASQL.QueryRow(t, `SELECT ts FROM system.protected_ts_records WHERE id = $1`, ptsID.String()).Scan(&ts)
+		protected, err := hlc.ParseHLC(ts)
+		if err != nil {
+			return err
+		}
+		if protected.Less(now) {
+			return errors.Newf("source protected timestamp %s is behind replicated time %s", protected, now)
+		}
+		return nil
+	})
+}
+
+func TestLogicalStreamIngestionJobPausesOnIncompatibleRecreatedTable(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	ptsID := jobutils.GetJobPayload(t, serverBSQL, jobBID).GetLogicalReplication().ProtectedTimestampRecordID
+	require.NotNil(t, ptsID)
+
+	// Recreate the destination table without the column used for
+	// last-write-wins.
+	serverBSQL.Exec(t, "DROP TABLE tab")
+	serverBSQL.Exec(t, createStmt)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello')")
+
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, "destination table defaultdb.public.tab was recreated with descriptor ID")
+	require.Contains(t, progress.RunningStatus, `column "crdb_internal_origin_timestamp" is missing`)
+
+	// The job paused after a permanent error, so it no longer protects the
+	// dropped table from GC.
+	require.Nil(t, jobutils.GetJobPayload(t, serverBSQL, jobBID).GetLogicalReplication().ProtectedTimestampRecordID)
+	var records int
+	serverBSQL.QueryRow(t, `SELECT count(*) FROM system.protected_ts_records WHERE id = $1`, ptsID).Scan(&records)
+	require.Zero(t, records)
+}
+
+func TestLogicalStreamIngestionJobHandlesDestinationCheckViolations(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// Only the destination table limits the length of the payload.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string, "+
+		"CONSTRAINT short_payload CHECK (length(payload) < 10))")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.check_violation_policy = 'dlq'")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, 'much too long'), (3, 'world')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+
+	// The violating row is sent to the dead letter queue while the others are
+	// applied.
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"1", "hello"}, {"3", "world"}})
+	var violations int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.check_violations'`).Scan(&violations)
+	require.Equal(t, 1, violations)
+	// It's persisted in the dead letter queue table of the destination
+	// database rather than lost.
+	serverBSQL.CheckQueryResults(t, fmt.Sprintf(`SELECT count(*) FROM defaultdb.public.crdb_replication_dlq
+WHERE job_id = %d AND reason LIKE '%%short_payload%%'`, jobBID), [][]string{{"1"}})
+
+	// By default, a violation pauses the job.
+	serverBSQL.Exec(t, "RESET CLUSTER SETTING logical_replication.consumer.check_violation_policy")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (4, 'also much too long')")
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, `violates destination CHECK constraint "short_payload"`)
+	require.Contains(t, progress.RunningStatus, `with columns pk, payload`)
+	// The row's values are kept out of the status.
+	require.NotContains(t, progress.RunningStatus, `also much too long`)
+}
+
+func TestLogicalStreamIngestionJobHandlesDestinationNotNullViolations(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// Only the destination table requires a payload.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string NOT NULL DEFAULT 'unknown')")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.not_null_violation_policy = 'dlq'")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	// The violating row is sent to the dead letter queue while the others are
+	// applied.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, NULL), (3, 'world')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk", [][]string{{"1", "hello"}, {"3", "world"}})
+	var violations int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.not_null_violations'`).Scan(&violations)
+	require.Equal(t, 1, violations)
+
+	// With the default policy, new rows get the column's DEFAULT and existing
+	// rows keep their value.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.not_null_violation_policy = 'default'")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (4, NULL)")
+	serverASQL.Exec(t, "UPDATE tab SET payload = NULL WHERE pk = 1")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk",
+		[][]string{{"1", "hello"}, {"3", "world"}, {"4", "unknown"}})
+
+	// By default, a violation pauses the job.
+	serverBSQL.Exec(t, "RESET CLUSTER SETTING logical_replication.consumer.not_null_violation_policy")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (5, NULL)")
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	progress := jobutils.GetJobProgress(t, serverBSQL, jobBID)
+	require.Contains(t, progress.RunningStatus, `null value in column "payload" violates not-null constraint`)
+	require.Contains(t, progress.RunningStatus, `with NULL columns payload`)
+}
+
+func TestLogicalStreamIngestionJobHandlesSourceOnlyColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// The extra column was dropped from the destination table only.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string, extra string)")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	// By default, rows are applied without the values of the extra column.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello', NULL), (2, 'world', 'dropped')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk", [][]string{{"1", "hello"}, {"2", "world"}})
+	var dropped int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.dropped_column_values'`).Scan(&dropped)
+	require.Equal(t, 1, dropped)
+
+	// With the dlq policy, rows with a value for the extra column are sent to
+	// the dead letter queue while the others are applied.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.dropped_column_policy = 'dlq'")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (3, 'set aside', 'dropped'), (4, 'applied', NULL)")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab ORDER BY pk",
+		[][]string{{"1", "hello"}, {"2", "world"}, {"4", "applied"}})
+	// The row sent to the dead letter queue is counted once, however many
+	// times the batches holding it were retried.
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.dropped_column_values'`).Scan(&dropped)
+	require.Equal(t, 2, dropped)
+
+	// A destination table recreated without the extra column is still
+	// compatible with the source table.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.dropped_column_policy = 'drop'")
+	serverBSQL.Exec(t, "DROP TABLE tab")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, lwwColumnAdd)
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (5, 'recreated', 'dropped')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"5", "recreated"}})
+}
+
+func TestLogicalStreamIngestionJobAppliesRowsInApplyOrder(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// The destination numbers the rows in the order they are applied.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, ord int)")
+	serverBSQL.Exec(t, "CREATE SEQUENCE applied")
+	serverBSQL.Exec(t, "CREATE TABLE tab (pk int primary key, ord int, seq int DEFAULT nextval('applied'))")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	// The column must exist in every destination table.
+	serverBSQL.ExpectErr(t, `destination table defaultdb.public.tab has no apply order column "missing"`,
+		fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, "+
+			"json_build_object('apply_order_column', 'missing'))", serverAURL.String(), `ARRAY['tab']`))
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, "+
+		"json_build_object('apply_order_column', 'ord'))", serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	// The rows are written at the same timestamp, so they are applied in the
+	// order of ord rather than of their keys.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 30), (2, 10), (3, NULL), (4, 20)")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk FROM tab ORDER BY seq", [][]string{{"3"}, {"2"}, {"4"}, {"1"}})
+}
+
+func TestLogicalStreamIngestionJobHandlesDestinationOnlyColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), "")
+	defer cleanup()
+
+	// The note and shout columns were only added to the destination table.
+	serverASQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string)")
+	serverBSQL.Exec(t, `CREATE TABLE tab (pk int primary key, payload string,
+note string NOT NULL DEFAULT 'unset', shout string AS (upper(payload)) STORED)`)
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	// New rows get the DEFAULT of the destination-only column and the value
+	// computed from the applied row.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello'), (2, 'world')")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, note, shout FROM tab ORDER BY pk",
+		[][]string{{"1", "hello", "unset", "HELLO"}, {"2", "world", "unset", "WORLD"}})
+
+	// By default, updates keep the value of the destination-only column.
+	serverBSQL.Exec(t, "UPDATE tab SET note = 'kept' WHERE pk IN (1, 2)")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'hi' WHERE pk = 1")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, note, shout FROM tab ORDER BY pk",
+		[][]string{{"1", "hi", "kept", "HI"}, {"2", "world", "kept", "WORLD"}})
+
+	// With the default policy, updates reset it to its DEFAULT.
+	serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.destination_only_column_policy = 'default'")
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'earth' WHERE pk = 2")
+	WaitUntilReplicatedTime(t, serverA.Server(0).Clock().Now(), serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, note, shout FROM tab ORDER BY pk",
+		[][]string{{"1", "hi", "kept", "HI"}, {"2", "earth", "unset", "EARTH"}})
+}
+
+func WaitUntilReplicatedTime(
+	t testing.TB, targetTime hlc.Timestamp, db *sqlutils.SQLRunner, ingestionJobID jobspb.JobID,
+) {
+	testutils.SucceedsSoon(t, func() error {
+		progress := jobutils.GetJobProgress(t, db, ingestionJobID)
+		replicatedTime := progress.Details.(*jobspb.Progress_LogicalReplication).LogicalReplication.ReplicatedTime
+		if replicatedTime.IsEmpty() {
+			return errors.Newf("stream ingestion has not recorded any progress yet, waiting to advance pos %s",
+				targetTime)
+		}
+		if replicatedTime.Less(targetTime) {
+			return errors.Newf("waiting for stream ingestion job progress %s to advance beyond %s",
+				replicatedTime, targetTime)
+		}
+		return nil
+	})
+}
+
+func TestLogicalStreamIngestionJobDefersSecondaryIndexes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := `CREATE TABLE tab (
+  pk int primary key,
+  payload string,
+  code string,
+  INDEX tab_payload_idx (payload) STORING (code),
+  UNIQUE INDEX tab_code_key (code)
+)`
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab SELECT i, 'payload-' || i::STRING, 'code-' || i::STRING FROM generate_series(1, 100) AS g(i)")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"defer_secondary_indexes\": \"true\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The non-unique index is rebuilt once the initial scan has been applied,
+	// while the unique index is never dropped.
+	testutils.SucceedsSoon(t, func() error {
+		var found int
+		serverBSQL.QueryRow(t, `
+SELECT count(*) FROM crdb_internal.table_indexes
+WHERE descriptor_name = 'tab' AND index_name IN ('tab_payload_idx', 'tab_code_key')`).Scan(&found)
+		if found != 2 {
+			return errors.Newf("expected both secondary indexes, found %d", found)
+		}
+		return nil
+	})
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM tab@tab_payload_idx", [][]string{{"100"}})
+
+	// Rows applied after the rebuild maintain the index.
+	serverASQL.Exec(t, "UPDATE tab SET payload = 'updated' WHERE pk = 1")
+	now = serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT pk FROM tab@tab_payload_idx WHERE payload = 'updated'", [][]string{{"1"}})
+
+	// Indexes deferred by a job that is canceled before its initial scan
+	// completes are recreated as it reverts.
+	serverBSQL.Exec(t, "CANCEL JOB $1", jobBID)
+	jobutils.WaitForJobToCancel(t, serverBSQL, jobBID)
+	serverBSQL.Exec(t, "SET CLUSTER SETTING jobs.debug.pausepoints = 'logical_replication.after_deferring_indexes'")
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"defer_secondary_indexes\": \"true\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, `
+SELECT count(*) FROM crdb_internal.table_indexes
+WHERE descriptor_name = 'tab' AND index_name = 'tab_payload_idx'`, [][]string{{"0"}})
+	serverBSQL.Exec(t, "SET CLUSTER SETTING jobs.debug.pausepoints = ''")
+	serverBSQL.Exec(t, "CANCEL JOB $1", jobBID)
+	jobutils.WaitForJobToCancel(t, serverBSQL, jobBID)
+	serverBSQL.CheckQueryResults(t, "SELECT count(*) FROM tab@tab_payload_idx", [][]string{{"100"}})
+}
+
+func TestLogicalStreamIngestionJobReplicatesSchemaChanges(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string, obsolete string)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'hello', 'old')")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"replicate_schema_changes\": \"true\"}')",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	serverASQL.Exec(t, "ALTER TABLE tab ADD COLUMN extra INT DEFAULT 7")
+	serverASQL.Exec(t, "ALTER TABLE tab DROP COLUMN obsolete")
+	serverASQL.Exec(t, "CREATE INDEX tab_extra_idx ON tab (extra) STORING (payload)")
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'world', 42)")
+
+	now = serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// The schema changes are applied to the destination before the rows
+	// written with them.
+	serverBSQL.CheckQueryResults(t,
+		"SELECT column_name FROM [SHOW COLUMNS FROM tab] WHERE NOT is_hidden ORDER BY column_name",
+		[][]string{{"extra"}, {"payload"}, {"pk"}})
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload, extra FROM tab ORDER BY pk",
+		[][]string{{"1", "hello", "7"}, {"2", "world", "42"}})
+	serverBSQL.CheckQueryResults(t, "SELECT pk FROM tab@tab_extra_idx WHERE extra = 42", [][]string{{"2"}})
+
+	// Changes that can't be replicated pause the job.
+	serverASQL.Exec(t, "ALTER TABLE tab RENAME COLUMN payload TO body")
+	jobutils.WaitForJobToPause(t, serverBSQL, jobBID)
+	var status string
+	serverBSQL.QueryRow(t, "SELECT running_status FROM [SHOW JOB $1]", jobBID).Scan(&status)
+	require.Contains(t, status, "column payload renamed to body")
+}
+
+func TestLogicalStreamIngestionJobResolvesIntentsSynchronously(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	createStmt := "CREATE TABLE tab (pk int primary key, payload int)"
+	serverA, _, serverASQL, serverBSQL, serverAURL, cleanup := setupTwoClusterTest(t, testClusterArgs(), createStmt)
+	defer cleanup()
+
+	serverASQL.Exec(t, "INSERT INTO tab SELECT i, 0 FROM generate_series(1, 10) AS g(i)")
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s)",
+		serverAURL.String(), `ARRAY['tab']`)).Scan(&jobBID)
+
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	// Repeatedly update the same rows in each mode, logging how long it takes
+	// to apply the updates.
+	const updates = 50
+	for _, mode := range []string{"async", "sync"} {
+		serverBSQL.Exec(t, "SET CLUSTER SETTING logical_replication.consumer.intent_resolution = $1", mode)
+		start := timeutil.Now()
+		for i := 1; i <= updates; i++ {
+			serverASQL.Exec(t, "UPDATE tab SET payload = $1", i)
+		}
+		now := serverA.Server(0).Clock().Now()
+		WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+		log.Infof(ctx, "applied %d updates of the same rows with %s intent resolution in %s",
+			updates, mode, timeutil.Since(start))
+		serverBSQL.CheckQueryResults(t, "SELECT DISTINCT payload FROM tab", [][]string{{fmt.Sprint(updates)}})
+		serverASQL.Exec(t, "UPDATE tab SET payload = 0")
+	}
+
+	var resolutions int
+	serverBSQL.QueryRow(t, `SELECT value FROM crdb_internal.node_metrics
+WHERE name = 'logical_replication.intent_resolution_latency-count'`).Scan(&resolutions)
+	require.NotZero(t, resolutions)
+}
+
+func TestLogicalStreamIngestionJobAppliesToShadowDestination(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	clusterArgs := testClusterArgs()
+
+	serverA := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer serverA.Stopper().Stop(ctx)
+
+	serverB := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer serverB.Stopper().Stop(ctx)
+
+	shadow := testcluster.StartTestCluster(t, 1, clusterArgs)
+	defer shadow.Stopper().Stop(ctx)
+
+	serverASQL := sqlutils.MakeSQLRunner(serverA.Server(0).ApplicationLayer().SQLConn(t))
+	serverBSQL := sqlutils.MakeSQLRunner(serverB.Server(0).ApplicationLayer().SQLConn(t))
+	shadowSQL := sqlutils.MakeSQLRunner(shadow.Server(0).ApplicationLayer().SQLConn(t))
+
+	for _, s := range testClusterSettings {
+		serverASQL.Exec(t, s)
+		serverBSQL.Exec(t, s)
+	}
+
+	createStmt := "CREATE TABLE tab (pk int primary key, payload string, amount decimal)"
+	serverASQL.Exec(t, createStmt)
+	serverBSQL.Exec(t, createStmt)
+	// The shadow's schema differs, so amounts with trailing zeros diverge.
+	shadowSQL.Exec(t, "CREATE TABLE tab (pk int primary key, payload string, amount float)")
+	serverASQL.Exec(t, lwwColumnAdd)
+	serverBSQL.Exec(t, lwwColumnAdd)
+
+	// The row is written on B after A, so A's write loses to it on B, and isn't
+	// applied to the shadow, which doesn't apply rows using last-write-wins.
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (1, 'older', 1)")
+	serverBSQL.Exec(t, "INSERT INTO tab VALUES (1, 'newer', 1)")
+
+	serverAURL, cleanup := sqlutils.PGUrl(t, serverA.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	defer cleanup()
+	shadowURL, cleanupShadow := sqlutils.PGUrl(t, shadow.Server(0).ApplicationLayer().SQLAddr(), t.Name(), url.User(username.RootUser))
+	defer cleanupShadow()
+
+	var jobBID jobspb.JobID
+	serverBSQL.QueryRow(t, fmt.Sprintf("SELECT crdb_internal.start_logical_replication_job('%s', %s, '{\"shadow_destination\": \"%s\"}')",
+		serverAURL.String(), `ARRAY['tab']`, shadowURL.String())).Scan(&jobBID)
+
+	serverASQL.Exec(t, "INSERT INTO tab VALUES (2, 'hello', 1.10), (3, 'world', 2)")
+	now := serverA.Server(0).Clock().Now()
+	WaitUntilReplicatedTime(t, now, serverBSQL, jobBID)
+
+	serverBSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{
+		{"1", "newer"}, {"2", "hello"}, {"3", "world"},
+	})
+	shadowMetrics := func() (applied, divergences int) {
+		serverBSQL.QueryRow(t, `SELECT
+  sum(value) FILTER (WHERE name = 'logical_replication.shadow_applied_rows'),
+  sum(value) FILTER (WHERE name = 'logical_replication.shadow_divergences')
+FROM crdb_internal.node_metrics`).Scan(&applied, &divergences)
+		return applied, divergences
+	}
+	testutils.SucceedsSoon(t, func() error {
+		if applied, _ := shadowMetrics(); applied < 2 {
+			return errors.New("rows not applied to the shadow yet")
+		}
+		return nil
+	})
+	// Only the rows applied to the primary are applied to the shadow, and only
+	// the one that differs there diverges.
+	shadowSQL.CheckQueryResults(t, "SELECT pk, payload FROM tab", [][]string{{"2", "hello"}, {"3", "world"}})
+	applied, divergences := shadowMetrics()
+	require.Equal(t, 2, applied)
+	require.Equal(t, 1, divergences)
+}
+
+func TestInitialScanResumeTracksScannedSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	scanTS := hlc.Timestamp{WallTime: 10}
+
+	frontier, err := span.MakeFrontier(sp("a", "z"))
+	require.NoError(t, err)
+	defer frontier.Release()
+
+	// Scanning a span scans new keys, but the frontier doesn't advance until
+	// the whole span has been scanned.
+	require.True(t, newlyScanned(frontier, sp("a", "m"), scanTS, scanTS))
+	advanced, err := frontier.Forward(sp("a", "m"), scanTS)
+	require.NoError(t, err)
+	require.False(t, advanced)
+	require.False(t, newlyScanned(frontier, sp("a", "m"), scanTS, scanTS))
+	require.False(t, newlyScanned(frontier, sp("b", "c"), scanTS.Next(), scanTS))
+	require.True(t, newlyScanned(frontier, sp("l", "n"), scanTS, scanTS))
+	// A checkpoint below the scan timestamp doesn't scan anything.
+	require.False(t, newlyScanned(frontier, sp("m", "z"), scanTS.Prev(), scanTS))
+
+	checkpoint := jobspb.StreamIngestionCheckpoint{ResolvedSpans: []jobspb.ResolvedSpan{
+		{Span: sp("a", "m"), Timestamp: scanTS},
+		{Span: sp("m", "p"), Timestamp: scanTS.Prev()},
+		{Span: sp("p", "r"), Timestamp: scanTS.Next()},
+	}}
+	scanned := scannedSpans(checkpoint, scanTS)
+	require.Equal(t, roachpb.Spans{sp("a", "m"), sp("p", "r")}, scanned)
+
+	require.True(t, scannedMore(scanned, nil))
+	require.False(t, scannedMore(scanned, scanned))
+	require.False(t, scannedMore(roachpb.Spans{sp("b", "c")}, scanned))
+	require.True(t, scannedMore(roachpb.Spans{sp("a", "n")}, scanned))
+	// scannedMore doesn't mutate its arguments.
+	require.Equal(t, roachpb.Spans{sp("a", "m"), sp("p", "r")}, scanned)
+}
+
+type sliceVerificationRows struct {
+	rows [][2]string
+	i    int
+}
+
+func (r *sliceVerificationRows) Next(context.Context) (bool, error) {
+	r.i++
+	return r.i <= len(r.rows), nil
+}
+
+func (r *sliceVerificationRows) Cur() (string, string) {
+	if r.i == 0 || r.i > len(r.rows) {
+		return "", ""
+	}
+	return r.rows[r.i-1][0], r.rows[r.i-1][1]
+}
+
+func TestCompareRowsReportsDivergentRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	src := &sliceVerificationRows{rows: [][2]string{
+		{"(1)", "(1,a)"}, {"(2)", "(2,b)"}, {"(3)", "(3,c)"}, {"(5)", "(5,e)"},
+	}}
+	dst := &sliceVerificationRows{rows: [][2]string{
+		{"(1)", "(1,a)"}, {"(3)", "(3,x)"}, {"(4)", "(4,d)"}, {"(5)", "(5,e)"},
+	}}
+	var res verificationResult
+	require.NoError(t, compareRows(ctx, "tab", src, dst, &res))
+	// Row 2 is missing from the destination, row 3 differs and row 4 is only
+	// on the destination.
+	require.Equal(t, 5, res.compared)
+	require.Equal(t, 3, res.divergent)
+	require.Equal(t, []string{"tab(2)", "tab(3)", "tab(4)"}, res.keys)
+
+	var empty verificationResult
+	require.NoError(t, compareRows(ctx, "tab", &sliceVerificationRows{}, &sliceVerificationRows{}, &empty))
+	require.Zero(t, empty.compared)
+}
+
+func TestReplicationLagIsReportedPerStream(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	now := timeutil.Now()
+	var scanning, behind replicationLag
+	require.Zero(t, scanning.seconds(now))
+	behind.update(hlc.Timestamp{WallTime: now.Add(-time.Hour).UnixNano()})
+	require.Equal(t, int64(3600), behind.seconds(now))
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+	untrackScanning := m.trackReplicationLag(1, &scanning)
+	untrackBehind := m.trackReplicationLag(2, &behind)
+	// The gauge reports the lag of the most lagging stream.
+	require.GreaterOrEqual(t, m.ReplicationLagSeconds.Value(), int64(3600))
+	untrackBehind()
+	require.Zero(t, m.ReplicationLagSeconds.Value())
+	untrackScanning()
+}
+
+func TestReportReplicatedTimeDoesNotWaitForHeartbeats(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	// The sender isn't started, as if it were busy heartbeating, so it takes
+	// no updates.
+	sender := streamclient.NewHeartbeatSender(ctx, nil /* client */, 1 /* streamID */, func() time.Duration {
+		return time.Second
+	})
+	rh := &rowHandler{heartbeat: sender}
+	rh.reportReplicatedTime(ctx, hlc.Timestamp{WallTime: 10})
+	rh.reportReplicatedTime(ctx, hlc.Timestamp{WallTime: 20})
+	require.Equal(t, hlc.Timestamp{WallTime: 20}, rh.unreportedReplicatedTime)
+
+	// Once the sender takes updates, it is handed the latest replicated time.
+	received := make(chan hlc.Timestamp, 1)
+	go func() { received <- <-rh.heartbeat.FrontierUpdates }()
+	testutils.SucceedsSoon(t, func() error {
+		rh.reportReplicatedTime(ctx, hlc.Timestamp{})
+		if !rh.unreportedReplicatedTime.IsEmpty() {
+			return errors.New("replicated time not reported yet")
 		}
 		return nil
 	})
+	require.Equal(t, hlc.Timestamp{WallTime: 20}, <-received)
 }
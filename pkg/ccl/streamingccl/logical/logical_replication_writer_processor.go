@@ -9,37 +9,62 @@
 package logical
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"runtime"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
 	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
 	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/rangecache"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvpb"
 	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/server/status"
 	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
 	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
 	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
 	"github.com/cockroachdb/cockroach/pkg/sql/rowexec"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/admission"
 	"github.com/cockroachdb/cockroach/pkg/util/ctxgroup"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/retry"
 	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/logtags"
+	"github.com/cockroachdb/redact"
 )
 
 var logicalReplicationWriterResultType = []*types.T{
@@ -67,6 +92,103 @@ var maxKVBufferSize = settings.RegisterByteSizeSetting(
 	128<<20, // 128 MiB
 )
 
+var nodeBufferBudget = settings.RegisterByteSizeSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.node_buffer_budget",
+	"the maximum total size of the KV buffers of all writer processors on a node; a processor "+
+		"that buffers KVs while it is exceeded flushes its buffer, which holds up reading from "+
+		"its stream until the flush starts; if 0, there is no node-wide limit",
+	0,
+)
+
+// subscriptionFiltering decides whether partitions are subscribed with
+// filtering, which has the source's rangefeeds elide the writes, deletes
+// included, of transactions that set OmitInRangefeeds. Those are the writes of
+// sessions with disable_changefeed_replication set, which include the rows
+// applied by the source's own logical replication streams, so that a
+// bidirectional stream doesn't replicate rows back to the cluster they came
+// from, and the rows deleted by TTL jobs of tables with
+// ttl_disable_changefeed_replication set. Other deletes, including the
+// deletion of ranges of keys, are never filtered.
+var subscriptionFiltering = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.subscription_filtering.enabled",
+	"if true, writes, deletes included, of source transactions that opted out of replication with "+
+		"disable_changefeed_replication aren't replicated; if false, they are, which must not be used "+
+		"with bidirectional streams since the rows they apply would then be replicated back; takes "+
+		"effect when a partition is next subscribed",
+	true,
+)
+
+type unknownEventPolicy int64
+
+const (
+	unknownEventError unknownEventPolicy = iota
+	unknownEventSkip
+	unknownEventLog
+)
+
+// unknownEventPolicySetting allows consumers to keep replicating when a source
+// running a newer version sends event types they don't know about.
+var unknownEventPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.unknown_event_policy",
+	"what to do with events of unknown types received from the source: error fails the stream, "+
+		"skip ignores them and log ignores them and periodically logs them",
+	"error",
+	map[int64]string{
+		int64(unknownEventError): "error",
+		int64(unknownEventSkip):  "skip",
+		int64(unknownEventLog):   "log",
+	},
+)
+
+var flushGracePeriod = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.flush_grace_period",
+	"the amount of time for which a failed flush is retried with backoff before the processor fails, "+
+		"to ride out brief unavailability of the destination; if 0, failed flushes are not retried",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var applyCPUShare = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.apply_cpu_share",
+	"the maximum share of the node's CPU capacity that the flushes of a processor may use, counting "+
+		"all the CPU the node uses while a flush runs, including executing its statements and their KV "+
+		"requests; flushes are throttled while it is exceeded; if 0, flushes are not throttled based on CPU usage",
+	0,
+	settings.FloatInRange(0, 1),
+)
+
+var maxPooledBufferMultiple = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_pooled_buffer_multiple",
+	"how many times larger than the average flush the backing array of an ingestion buffer may "+
+		"grow, if larger than kv_buffer_target_length, before it is released rather than retained "+
+		"when the buffer is returned to the buffer pool; if 0, backing arrays are always retained",
+	4,
+	settings.NonNegativeInt,
+)
+
+var minFlushBatch = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.min_flush_batch",
+	"the minimum number of KVs to accumulate before a periodic flush; smaller buffers are held "+
+		"until max_buffer_age is exceeded; if 0, periodic flushes are never held",
+	0,
+	settings.NonNegativeInt,
+)
+
+var maxBufferAge = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_buffer_age",
+	"the maximum amount of time KVs are held in the buffer waiting for min_flush_batch KVs to accumulate",
+	10*time.Second,
+	settings.NonNegativeDuration,
+)
+
 var flushBatchSize = settings.RegisterIntSetting(
 	settings.ApplicationLevel,
 	"logical_replication.consumer.batch_size",
@@ -75,6 +197,16 @@ var flushBatchSize = settings.RegisterIntSetting(
 	settings.NonNegativeInt,
 )
 
+var kvsPerFlushWorker = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.kvs_per_flush_worker",
+	"the number of buffered KVs per worker used to apply a flush, up to the size of the "+
+		"worker pool; small flushes are applied by fewer workers to avoid the overhead of "+
+		"coordinating many of them; if 0, every flush uses the whole pool",
+	256,
+	settings.NonNegativeInt,
+)
+
 var quantize = settings.RegisterDurationSettingWithExplicitUnit(
 	settings.ApplicationLevel,
 	"logical_replication.consumer.timestamp_granularity",
@@ -82,6 +214,154 @@ var quantize = settings.RegisterDurationSettingWithExplicitUnit(
 	5*time.Second,
 )
 
+var maxLag = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_lag",
+	"the maximum replication lag tolerated before the job is paused; if 0, disabled",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var maxLagWindow = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_lag_window",
+	"the amount of time the replication lag must continuously exceed max_lag before the job is paused",
+	time.Minute,
+	settings.NonNegativeDuration,
+)
+
+var singleRangeBatchesEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.single_range_batches.enabled",
+	"if enabled, batches whose rows all fall in a single destination range are "+
+		"applied using autocommitting statements that are eligible for one-phase commit",
+	false,
+)
+
+var prefetchPriorRows = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.prefetch_prior_rows.enabled",
+	"if enabled, batches applied in an explicit transaction first read the destination rows "+
+		"of all their keys in one query per table, skip the writes that would lose to them and "+
+		"apply the remaining rows as with logical_replication.consumer.batched_apply.enabled",
+	false,
+)
+
+var applyLockTimeout = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.apply_lock_timeout",
+	"the maximum amount of time the statements applying a batch wait for locks held by contending "+
+		"transactions before the batch is retried with backoff; if 0, statements wait indefinitely",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var maxBatchRetries = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_batch_retries",
+	"the number of times the transaction applying a batch may be retried, e.g. because of contention "+
+		"on a hot key or lock timeouts, before it is split down to the rows that still exhaust the "+
+		"retries, which are sent to the dead letter queue; if 0, "+
+		"batches are retried until they apply",
+	0,
+	settings.NonNegativeInt,
+)
+
+// errRetryBudgetExhausted marks the error of a batch whose transaction was
+// retried max_batch_retries times without applying it.
+var errRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudget returns the number of times the transaction applying a batch may
+// be retried, or -1 if it may be retried until it applies.
+func retryBudget(sv *settings.Values) int {
+	if n := maxBatchRetries.Get(sv); n > 0 {
+		return int(n)
+	}
+	return -1
+}
+
+var serializeSameRangeBatches = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.serialize_same_range_batches.enabled",
+	"if enabled, rows whose destination keys fall in the same range are applied by the same "+
+		"worker so that workers don't contend with one another on hot ranges",
+	false,
+)
+
+var drainCheckpointEnabled = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.drain_checkpoint.enabled",
+	"if enabled, writer processors on a draining node flush their buffered rows and "+
+		"emit a final checkpoint before shutting down so that the job resumes elsewhere with minimal rework",
+	true,
+)
+
+// holdUntilResolved, when enabled, defers applying each KV until the source's
+// resolved timestamp has advanced past it, which guarantees that the source
+// will not later retract it. This defends against source bugs at the cost of
+// adding up to a checkpoint interval of latency to every row, and of buffering
+// every unresolved KV in memory until the next checkpoint arrives, even past
+// the node-wide buffer budget.
+var holdUntilResolved = settings.RegisterBoolSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.hold_until_resolved.enabled",
+	"if enabled, rows are not applied until the source's resolved timestamp has advanced past them; "+
+		"this adds up to a checkpoint interval of apply latency and buffers all unresolved rows in memory",
+	false,
+)
+
+var flushPacingQueueDepth = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.flush_pacing_queue_depth",
+	"the KV admission queue depth above which flushes are slowed down to let the "+
+		"destination recover; if 0, flushes are never paced",
+	100,
+	settings.NonNegativeInt,
+)
+
+// checkpointFlushInterval bounds how often a processor with no buffered KVs
+// emits a checkpoint. The job coalesces the checkpoints of its processors and
+// persists its progress at most once per job_checkpoint_frequency, so lowering
+// this below that frequency only tightens the frontier reported by the
+// processors, not the replicated time recorded in the job's progress.
+var checkpointFlushInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.checkpoint_flush_interval",
+	"the minimum amount of time between flushes that only advance the frontier because no KVs are "+
+		"buffered; lower values advance the replicated time more eagerly, tightening the RPO, at the "+
+		"cost of more frequent checkpoints; if 0, such flushes are emitted as soon as a checkpoint "+
+		"advances the frontier. The job still persists its progress at most once per "+
+		"logical_replication.consumer.job_checkpoint_frequency",
+	5*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// sampleRate and sampleSeed select a deterministic sample of the stream's rows
+// to replicate, e.g. to load test a destination without the full data volume.
+// Rows outside of the sample are dropped, so the destination is left
+// incomplete; this must only be used for testing.
+var sampleRate = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.unsafe.sample_rate",
+	"the fraction of rows, selected by hashing their keys, that are replicated; "+
+		"NOTE: the destination is left incomplete if this is below 1, so this must only be used for testing",
+	1,
+	settings.FloatInRange(0, 1),
+	settings.WithUnsafe,
+)
+
+var sampleSeed = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.unsafe.sample_seed",
+	"the seed used to hash row keys when only a sample of rows is replicated",
+	0,
+	settings.WithUnsafe,
+)
+
+// errNodeDraining is returned by writer processors that shut down because their
+// node is draining.
+var errNodeDraining = errors.New("node draining")
+
 // logicalReplicationWriterProcessor started life as a copy/pasta fork of the
 // streamIngestionProcessor.
 //
@@ -96,25 +376,148 @@ type logicalReplicationWriterProcessor struct {
 	bh []BatchHandler
 
 	buffer *ingestionBuffer
+	// bufferMu is held while the processor modifies or replaces buffer so that
+	// snapshotBuffer can copy it from another goroutine. The processor itself
+	// reads buffer without holding it.
+	bufferMu syncutil.Mutex
 
 	maxFlushRateTimer timeutil.Timer
 
-	streamPartitionClient streamclient.Client
+	// streamPartitionClient is the client of the current subscription. It is
+	// replaced if the processor fails over to a fallback address, so it is
+	// guarded by streamPartitionClientMu, as is clientClosed, which is set once
+	// close has closed it.
+	streamPartitionClientMu syncutil.Mutex
+	streamPartitionClient   streamclient.Client
+	clientClosed            bool
 
 	// frontier keeps track of the progress for the spans tracked by this processor
 	// and is used forward resolved spans
 	frontier span.Frontier
+	// startTime is when the processor started, from which its warm-up is
+	// measured.
+	startTime time.Time
+	// frontierSpans is the number of spans in the frontier last added to the
+	// FrontierSpans gauge.
+	frontierSpans int64
+	// gaps detects the spans of the partition whose resolved timestamp
+	// doesn't keep up with the KVs received for them.
+	gaps *gapDetector
 	// lastFlushTime keeps track of the last time that we flushed due to a
 	// checkpoint timestamp event.
 	lastFlushTime     time.Time
 	lastFlushFrontier hlc.Timestamp
 
+	// avgFlushLen is an exponentially weighted moving average of the number of
+	// KVs in non-empty flushes. It is used to detect anomalously large buffers
+	// and is only accessed by the flushLoop.
+	avgFlushLen float64
+
+	// quantization is the granularity at which resolved timestamps are
+	// quantized, if it adapts to the frontier.
+	quantization adaptiveQuantization
+
+	// admitLatency tracks the admission latency of the events received by this
+	// processor so that its percentiles can be surfaced in the debug status.
+	// Unlike Metrics.AdmitLatency it is not aggregated across streams.
+	admitLatency metric.IHistogram
+	// flushLatency tracks the duration of this processor's flushes so that
+	// slow flushes can be detected relative to its median flush.
+	flushLatency metric.IHistogram
+
+	// drainCh is closed when the node starts draining. It is nil if drain
+	// checkpoints are disabled. drainDone must be called once the processor
+	// has shut down to let the drain proceed.
+	drainCh   <-chan struct{}
+	drainDone func()
+	// drained is set once the processor has stopped consuming events because
+	// its node is draining.
+	drained atomic.Bool
+
+	// fanout emits applied rows to the stream's fanout sink. It is nil if the
+	// stream has no fanout sink.
+	fanout *rowFanout
+	// shadow applies applied rows to the stream's shadow destination. It is
+	// nil if the stream has no shadow destination or it couldn't be dialed.
+	shadow *shadowApplier
+
+	// checkpointSink receives the checkpoints emitted by the processor. It is
+	// nil if the stream has no checkpoint sink.
+	checkpointSink CheckpointSink
+	// checkpointSinkWarning rate limits warnings about failed checkpoint sink
+	// writes.
+	checkpointSinkWarning log.EveryN
+
+	// dlqClient records rows that can't be applied.
+	dlqClient DeadLetterQueueClient
+	// quarantine tracks the tables whose rows are skipped because too many of
+	// them were sent to the dead letter queue.
+	quarantine *tableQuarantine
+
+	// schemaGate stops the processor before it applies rows written with a
+	// newer schema than the source descriptors it was planned with. It is nil
+	// unless the job replicates schema changes.
+	schemaGate sourceSchemaGate
+	// knownTables holds the IDs of the replicated source tables. KVs of other
+	// tables are handled according to unknown_table_policy. If nil, KVs aren't
+	// checked against it.
+	knownTables map[descpb.ID]struct{}
+	// groupedTables holds the IDs of the source tables whose source
+	// transactions are applied atomically if group_by_source_txn_tables is set
+	// rather than group_by_source_txn, which covers every table.
+	groupedTables map[descpb.ID]struct{}
+
+	// destIndexPrefixes are the primary index prefixes of the destination
+	// tables as of when the processor started, keyed by source table ID.
+	destIndexPrefixes map[descpb.ID]roachpb.Key
+
+	// pacer slows down the flushLoop while the KV admission queue is deep.
+	pacer flushPacer
+	// cpuLimiter slows down the flushLoop while its flushes use more than
+	// apply_cpu_share of the node's CPU.
+	cpuLimiter cpuLimiter
+	// ioPacer slows down the flushLoop while the LSMs of the node's stores are
+	// under write pressure.
+	ioPacer ioPacer
+	// bufferedBytes is the number of bytes of the KVs buffered by the
+	// processor that have not been flushed yet.
+	bufferedBytes atomic.Int64
+
+	// lagExceededSince is the time at which the replication lag of this
+	// processor first exceeded max_lag, or zero if it currently does not.
+	lagExceededSince time.Time
+	// catchUp tracks whether the frontier is catching up after falling behind.
+	catchUp catchUpTracker
+	// watchdog tracks the progress of the processor to restart it if it
+	// stalls.
+	watchdog progressWatchdog
+	// scanHandoff keeps the initial scan from resurrecting rows deleted by live
+	// changes while the two are interleaved.
+	scanHandoff initialScanHandoff
+
 	// workerGroup is a context group holding all goroutines
 	// related to this processor.
 	workerGroup ctxgroup.Group
 
 	subscription       streamclient.Subscription
+	subscriptionCtx    context.Context
 	subscriptionCancel context.CancelFunc
+	// subscriptionDone receives the outcome of each subscription if the
+	// partition has fallback addresses, so that consumeEvents can fail over to
+	// the next one if it fails. It is nil otherwise.
+	subscriptionDone chan error
+	// nextFallback is the index of the next fallback address to subscribe
+	// from.
+	nextFallback int
+	// parallelScan, if set, holds the subscriptions across which the initial
+	// scan of the partition is split.
+	parallelScan atomic.Pointer[parallelScan]
+	// eventQueue holds the events read from the subscription until they are
+	// consumed.
+	eventQueue *eventQueue
+	// eventQueueAcc is the memory account to which the events held by the
+	// event queues are charged.
+	eventQueueAcc *mon.ConcurrentBoundAccount
 
 	// stopCh stops flush loop.
 	stopCh chan struct{}
@@ -129,9 +532,13 @@ type logicalReplicationWriterProcessor struct {
 	// metrics are monitoring all running ingestion jobs.
 	metrics *Metrics
 
-	logBufferEvery log.EveryN
+	logBufferEvery       log.EveryN
+	logUnknownEventEvery log.EveryN
 
 	debug streampb.DebugLogicalConsumerStatus
+	// recentFlushes retains summaries of the processor's recent flushes for
+	// its debug status.
+	recentFlushes recentFlushes
 }
 
 var (
@@ -152,40 +559,86 @@ func newLogicalReplicationWriterProcessor(
 	if err != nil {
 		return nil, err
 	}
-	for _, resolvedSpan := range spec.Checkpoint.ResolvedSpans {
+	checkpoint, clipped := clipCheckpointToPartition(spec.Checkpoint.ResolvedSpans, spec.PartitionSpec.Spans)
+	if clipped > 0 {
+		log.Infof(ctx, "clipped %d checkpoint spans not contained in the partition's spans %v",
+			clipped, spec.PartitionSpec.Spans)
+	}
+	for _, resolvedSpan := range checkpoint {
 		if _, err := frontier.Forward(resolvedSpan.Span, resolvedSpan.Timestamp); err != nil {
 			return nil, err
 		}
 	}
+	metrics := flowCtx.Cfg.JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
+	// The applied timestamps are shared by all workers since the rows of a key
+	// may be applied by different workers in different flushes.
+	applied := newAppliedTimestamps(flowCtx.Cfg.Settings, metrics)
 	bhPool := make([]BatchHandler, maxWriterWorkers)
 	for i := range bhPool {
-		rp, err := makeSQLLastWriteWinsHandler(ctx, flowCtx.Codec(), flowCtx.Cfg.Settings, spec.TableDescriptors)
+		rp, err := makeSQLLastWriteWinsHandler(ctx, flowCtx.Codec(), flowCtx.Cfg.Settings,
+			spec.TableDescriptors, spec.Options, spec.SourceClusterID, applied, metrics)
 		if err != nil {
 			return nil, err
 		}
 		bhPool[i] = &txnBatch{
-			db: flowCtx.Cfg.DB,
-			rp: rp,
+			db:         flowCtx.Cfg.DB,
+			rp:         rp,
+			settings:   flowCtx.Cfg.Settings,
+			rangeCache: flowCtx.Cfg.RangeCache,
+			autoCommitExec: flowCtx.Cfg.DB.Executor(isql.WithSessionData(
+				writerSessionData(ctx, flowCtx.Cfg.Settings, !spec.Options.VisibleToRangefeeds))),
+			omitInRangefeeds: !spec.Options.VisibleToRangefeeds,
+			ordered:          spec.Options.ApplyOrderColumn != "",
+			hasFanoutTables:  len(spec.Options.FanoutTables) > 0,
+			compareAndSwap:   spec.Options.CompareAndSwap,
 		}
 	}
 
+	groupedTables, err := makeGroupedTables(spec.TableDescriptors, spec.Options.GroupBySourceTxnTables)
+	if err != nil {
+		return nil, err
+	}
+
 	lrw := &logicalReplicationWriterProcessor{
-		flowCtx:        flowCtx,
-		spec:           spec,
-		bh:             bhPool,
-		frontier:       frontier,
-		buffer:         getBuffer(),
-		stopCh:         make(chan struct{}),
-		flushCh:        make(chan flushableBuffer),
-		checkpointCh:   make(chan *jobspb.ResolvedSpans),
-		errCh:          make(chan error, 1),
-		logBufferEvery: log.Every(30 * time.Second),
+		flowCtx:               flowCtx,
+		spec:                  spec,
+		bh:                    bhPool,
+		frontier:              frontier,
+		scanHandoff:           makeInitialScanHandoff(spec.InitialScanTimestamp, frontier.Frontier()),
+		stopCh:                make(chan struct{}),
+		flushCh:               make(chan flushableBuffer),
+		checkpointCh:          make(chan *jobspb.ResolvedSpans),
+		errCh:                 make(chan error, 1),
+		logBufferEvery:        log.Every(30 * time.Second),
+		logUnknownEventEvery:  log.Every(time.Minute),
+		checkpointSinkWarning: log.Every(time.Minute),
+		quarantine:            newTableQuarantine(&flowCtx.Cfg.Settings.SV),
+		knownTables:           makeKnownTables(spec.TableDescriptors),
+		groupedTables:         groupedTables,
+		gaps:                  makeGapDetector(spec.PartitionSpec.Spans),
+		pacer:                 makeFlushPacer(flowCtx.Cfg.KVAdmissionQ),
+		cpuLimiter:            makeCPULimiter(),
+		ioPacer:               makeIOPacer(flowCtx),
+		admitLatency: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationAdmitLatency,
+			Duration:     base.DefaultHistogramWindowInterval(),
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
+		flushLatency: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationFlushHistNanos,
+			Duration:     base.DefaultHistogramWindowInterval(),
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
 		debug: streampb.DebugLogicalConsumerStatus{
 			StreamID:    streampb.StreamID(spec.StreamID),
 			ProcessorID: processorID,
 		},
 	}
-	if err := lrw.Init(ctx, lrw, post, logicalReplicationWriterResultType, flowCtx, processorID, nil, /* memMonitor */
+	memMonitor := execinfra.NewMonitor(ctx, flowCtx.Mon, "logical-replication-writer-mem")
+	lrw.eventQueueAcc = memMonitor.MakeConcurrentBoundAccount()
+	if err := lrw.Init(ctx, lrw, post, logicalReplicationWriterResultType, flowCtx, processorID, memMonitor,
 		execinfra.ProcStateOpts{
 			InputsToDrain: []execinfra.RowSource{},
 			TrailingMetaCallback: func() []execinfrapb.ProducerMetadata {
@@ -196,20 +649,52 @@ func newLogicalReplicationWriterProcessor(
 	); err != nil {
 		return nil, err
 	}
+	if spec.Options.ReplicateSchemaChanges {
+		lrw.schemaGate = makeSourceSchemaGate(spec.TableDescriptors)
+	}
 
 	return lrw, nil
 }
 
+// clipCheckpointToPartition returns the parts of the checkpoint's resolved
+// spans that fall within the partition's spans, along with the number of
+// resolved spans that weren't fully contained in them. The checkpoint may hold
+// spans owned by other processors, e.g. if the stream was repartitioned since
+// it was taken, and those must not advance this processor's frontier.
+func clipCheckpointToPartition(
+	checkpoint []jobspb.ResolvedSpan, partition []roachpb.Span,
+) ([]jobspb.ResolvedSpan, int) {
+	res := make([]jobspb.ResolvedSpan, 0, len(checkpoint))
+	var clipped int
+	for _, rs := range checkpoint {
+		var covered roachpb.SpanGroup
+		for _, sp := range partition {
+			if !rs.Span.Overlaps(sp) {
+				continue
+			}
+			overlap := rs.Span.Intersect(sp)
+			covered.Add(overlap)
+			res = append(res, jobspb.ResolvedSpan{Span: overlap, Timestamp: rs.Timestamp})
+		}
+		if !covered.Encloses(rs.Span) {
+			clipped++
+		}
+	}
+	return res, clipped
+}
+
 // Start launches a set of goroutines that read from the spans
 // assigned to this processor, parses each row, and generates inserts
 // or deletes to update local tables of the same name.
 //
-// A subscription's event stream is read by the consumeEvents loop.
+// A subscription's event stream is read into an eventQueue, which pauses
+// reading once it holds more than subscription_queue_high_water_mark, and
+// is consumed by the consumeEvents loop.
 //
 // The consumeEvents loop builds a buffer of KVs that it then sends to
 // the flushLoop. We currently allow 1 in-flight flush.
 //
-//	client.Subscribe -> consumeEvents -> flushLoop -> Next()
+//	client.Subscribe -> eventQueue -> consumeEvents -> flushLoop -> Next()
 //
 // All errors are reported to Next() via errCh, with the first
 // error winning.
@@ -217,65 +702,156 @@ func newLogicalReplicationWriterProcessor(
 // Start implements the RowSource interface.
 func (lrw *logicalReplicationWriterProcessor) Start(ctx context.Context) {
 	ctx = logtags.AddTag(ctx, "job", lrw.spec.JobID)
+	lrw.debug.SnapshotBuffer = lrw.snapshotBuffer
+	lrw.debug.RecentFlushes = lrw.recentFlushes.overlapping
+	lrw.debug.TableBuffers = lrw.tableBuffers
+	lrw.debug.InitialScanRanges = lrw.initialScanRanges
 	streampb.RegisterActiveLogicalConsumerStatus(&lrw.debug)
 
 	ctx = lrw.StartInternal(ctx, logicalReplicationWriterProcessorName)
+	lrw.startTime = timeutil.Now()
 
 	lrw.metrics = lrw.flowCtx.Cfg.JobRegistry.MetricsStruct().JobSpecificMetrics[jobspb.TypeLogicalReplication].(*Metrics)
+	lrw.bufferMu.Lock()
+	lrw.buffer = getBuffer(lrw.metrics)
+	lrw.bufferMu.Unlock()
+	lrw.drainCh, lrw.drainDone = makeDrainWatcher(lrw.FlowCtx)
 
 	db := lrw.FlowCtx.Cfg.DB
 
-	log.Infof(ctx, "starting logical replication writer for partitions %v", lrw.spec.PartitionSpec)
-
-	// Start the subscription for our partition.
-	partitionSpec := lrw.spec.PartitionSpec
-	token := streamclient.SubscriptionToken(partitionSpec.SubscriptionToken)
-	addr := partitionSpec.Address
-	redactedAddr, redactedErr := streamclient.RedactSourceURI(addr)
-	if redactedErr != nil {
-		log.Warning(lrw.Ctx(), "could not redact stream address")
-	}
-	streamClient, err := streamclient.NewStreamClient(ctx, streamingccl.StreamAddress(addr), db,
-		streamclient.WithStreamID(streampb.StreamID(lrw.spec.StreamID)),
-		streamclient.WithCompression(true),
-	)
+	destIndexPrefixes, _, err := resolveDestinationIndexPrefixes(
+		ctx, db, lrw.FlowCtx.Codec(), lrw.spec.TableDescriptors, nil /* prev */)
 	if err != nil {
-		lrw.MoveToDrainingAndLogError(errors.Wrapf(err, "creating client for partition spec %q from %q", token, redactedAddr))
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination tables"))
 		return
 	}
-	lrw.streamPartitionClient = streamClient
+	droppedColumns, err := resolveDestinationDroppedColumns(ctx, db, lrw.spec.TableDescriptors)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination columns"))
+		return
+	}
+	notNullColumns, err := resolveDestinationNotNullColumns(ctx, db, lrw.spec.TableDescriptors)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination columns"))
+		return
+	}
+	destOnlyColumns, err := resolveDestinationOnlyColumns(ctx, db, lrw.spec.TableDescriptors, lrw.spec.Options)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination columns"))
+		return
+	}
+	destTypes, err := resolveDestinationColumnTypes(ctx, db, lrw.spec.TableDescriptors)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination columns"))
+		return
+	}
+	fanoutTables, err := resolveFanoutTables(ctx, db, lrw.spec.TableDescriptors, lrw.spec.Options.FanoutTables)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving fan-out tables"))
+		return
+	}
+	secondaryIndexTables, err := resolveDestinationSecondaryIndexes(ctx, db, lrw.spec.TableDescriptors)
+	if err != nil {
+		lrw.MoveToDrainingAndLogError(errors.Wrap(err, "resolving destination indexes"))
+		return
+	}
+	lrw.destIndexPrefixes = destIndexPrefixes
+	for _, bh := range lrw.bh {
+		if tb, ok := bh.(*txnBatch); ok {
+			tb.destIndexPrefixes = destIndexPrefixes
+			tb.watchdog = &lrw.watchdog
+			if lww, ok := tb.rp.(*sqlLastWriteWinsRowProcessor); ok {
+				lww.destIndexPrefixes = destIndexPrefixes
+				lww.secondaryIndexTables, lww.codec = secondaryIndexTables, lrw.FlowCtx.Codec()
+				lww.notNullColumns = notNullColumns
+				lww.destinationOnlyColumns = destOnlyColumns
+				lww.destinationTypes, lww.evalCtx = destTypes, lrw.EvalCtx
+				if err := lww.dropDestinationColumns(droppedColumns); err != nil {
+					lrw.MoveToDrainingAndLogError(err)
+					return
+				}
+				if err := lww.setFanoutTables(fanoutTables); err != nil {
+					lrw.MoveToDrainingAndLogError(err)
+					return
+				}
+			}
+		}
+	}
 
-	if streamingKnobs, ok := lrw.FlowCtx.TestingKnobs().StreamingTestingKnobs.(*sql.StreamingTestingKnobs); ok {
-		if streamingKnobs != nil && streamingKnobs.BeforeClientSubscribe != nil {
-			streamingKnobs.BeforeClientSubscribe(addr, string(token), lrw.frontier)
+	if lrw.spec.Options.FanoutSinkURI != "" {
+		lrw.fanout, err = makeRowFanout(ctx, lrw.FlowCtx, lrw.spec, lrw.metrics)
+		if err != nil {
+			lrw.MoveToDrainingAndLogError(err)
+			return
+		}
+		for _, bh := range lrw.bh {
+			if tb, ok := bh.(*txnBatch); ok {
+				tb.fanout = lrw.fanout
+			}
 		}
 	}
-	sub, err := streamClient.Subscribe(ctx,
-		streampb.StreamID(lrw.spec.StreamID),
-		int32(lrw.flowCtx.NodeID.SQLInstanceID()), lrw.ProcessorID,
-		token,
-		lrw.spec.InitialScanTimestamp, lrw.frontier,
-		streamclient.WithFiltering(true),
-	)
-	if err != nil {
-		lrw.MoveToDrainingAndLogError(errors.Wrapf(err, "subscribing to partition from %s", redactedAddr))
+	if lrw.spec.Options.ShadowDestinationURI != "" {
+		// The shadow is best effort, so failing to dial it doesn't fail the
+		// stream.
+		if lrw.shadow, err = makeShadowApplier(ctx, lrw.FlowCtx, lrw.spec, lrw.metrics); err != nil {
+			lrw.metrics.ShadowApplyErrors.Inc(1)
+			log.Warningf(ctx, "not applying rows to shadow destination: %v", err)
+		} else {
+			for _, bh := range lrw.bh {
+				if tb, ok := bh.(*txnBatch); ok {
+					tb.shadow = lrw.shadow
+				}
+			}
+		}
+	}
+	var enc *artifactEncryption
+	if lrw.spec.Options.EncryptionKMSURI != "" {
+		if enc, err = makeArtifactEncryption(ctx, lrw.FlowCtx, lrw.spec.Options.EncryptionKMSURI); err != nil {
+			lrw.MoveToDrainingAndLogError(err)
+			return
+		}
+	}
+	if lrw.dlqClient, err = InitDeadLetterQueueClient(lrw.FlowCtx.Cfg.DB, lrw.spec.TableDescriptors, enc); err != nil {
+		lrw.MoveToDrainingAndLogError(err)
 		return
 	}
+	if lrw.spec.Options.CheckpointSinkURI != "" {
+		lrw.checkpointSink, err = makeCheckpointSink(ctx, lrw.FlowCtx, lrw.spec, lrw.ProcessorID, enc)
+		if err != nil {
+			lrw.MoveToDrainingAndLogError(err)
+			return
+		}
+	}
+
+	// Start the subscription for our partition. The partition spec holds
+	// unredacted source addresses, so only its ID is logged.
+	partitionSpec := lrw.spec.PartitionSpec
+	log.Infof(ctx, "starting logical replication writer for partition %s", partitionSpec.PartitionID)
+	sub, err := lrw.subscribe(ctx, partitionSpec.Address)
+	if err != nil {
+		if sub, err = lrw.subscribeWithFallback(ctx, err); err != nil {
+			lrw.MoveToDrainingAndLogError(err)
+			return
+		}
+	}
+	if len(partitionSpec.FallbackAddresses) > 0 {
+		lrw.subscriptionDone = make(chan error, 1)
+	}
 
 	// We use a different context for the subscription here so
 	// that we can explicitly cancel it.
-	var subscriptionCtx context.Context
-	subscriptionCtx, lrw.subscriptionCancel = context.WithCancel(lrw.Ctx())
+	lrw.subscriptionCtx, lrw.subscriptionCancel = context.WithCancel(lrw.Ctx())
 	lrw.workerGroup = ctxgroup.WithContext(lrw.Ctx())
-	lrw.subscription = sub
-	lrw.workerGroup.GoCtx(func(_ context.Context) error {
-		if err := sub.Subscribe(subscriptionCtx); err != nil {
-			lrw.sendError(errors.Wrap(err, "subscription"))
-		}
-		return nil
-	})
+	if split, err := lrw.maybeStartParallelScan(ctx); err != nil {
+		lrw.MoveToDrainingAndLogError(err)
+		return
+	} else if !split {
+		lrw.startSubscription(sub)
+	}
+	lrw.watchdog.init(timeutil.Now())
 	lrw.workerGroup.GoCtx(func(ctx context.Context) error {
 		defer close(lrw.flushCh)
+		defer close(lrw.watchdog.consumerDone)
 		if err := lrw.consumeEvents(ctx); err != nil {
 			lrw.sendError(errors.Wrap(err, "consume events"))
 		}
@@ -285,9 +861,27 @@ func (lrw *logicalReplicationWriterProcessor) Start(ctx context.Context) {
 		defer close(lrw.checkpointCh)
 		if err := lrw.flushLoop(ctx); err != nil {
 			lrw.sendError(errors.Wrap(err, "flush loop"))
+		} else if lrw.drained.Load() {
+			// The final checkpoint has been handed to Next() by now, so the
+			// error can't overtake it.
+			lrw.sendError(errNodeDraining)
 		}
 		return nil
 	})
+	lrw.workerGroup.GoCtx(lrw.runWatchdog)
+	lrw.workerGroup.GoCtx(lrw.runGlobalFrontierRefresh)
+	if lrw.fanout != nil {
+		lrw.workerGroup.GoCtx(func(ctx context.Context) error {
+			lrw.fanout.run(ctx, lrw.stopCh)
+			return nil
+		})
+	}
+	if lrw.shadow != nil {
+		lrw.workerGroup.GoCtx(func(ctx context.Context) error {
+			lrw.shadow.run(ctx, lrw.stopCh)
+			return nil
+		})
+	}
 }
 
 // Next is part of the RowSource interface.
@@ -329,10 +923,17 @@ func (lrw *logicalReplicationWriterProcessor) Next() (
 func (lrw *logicalReplicationWriterProcessor) MoveToDrainingAndLogError(err error) {
 	if err != nil {
 		log.Infof(lrw.Ctx(), "gracefully draining with error %s", err)
+		lrw.recordError(err)
 	}
 	lrw.MoveToDraining(err)
 }
 
+// recordError records the error in the processor's debug status, from which
+// the health of the processor is reported.
+func (lrw *logicalReplicationWriterProcessor) recordError(err error) {
+	lrw.debug.RecordError(timeutil.Now(), string(redact.Sprint(err).Redact()))
+}
+
 // MustBeStreaming implements the Processor interface.
 func (lrw *logicalReplicationWriterProcessor) MustBeStreaming() bool {
 	return true
@@ -350,11 +951,16 @@ func (lrw *logicalReplicationWriterProcessor) close() {
 		return
 	}
 
-	defer lrw.frontier.Release()
+	// The frontier is replaced when it is compacted, so it's only read once
+	// the goroutines have exited.
+	defer func() { lrw.frontier.Release() }()
 
+	lrw.streamPartitionClientMu.Lock()
 	if lrw.streamPartitionClient != nil {
 		_ = lrw.streamPartitionClient.Close(lrw.Ctx())
 	}
+	lrw.clientClosed = true
+	lrw.streamPartitionClientMu.Unlock()
 	if lrw.stopCh != nil {
 		close(lrw.stopCh)
 	}
@@ -368,8 +974,38 @@ func (lrw *logicalReplicationWriterProcessor) close() {
 	if err := lrw.workerGroup.Wait(); err != nil {
 		log.Errorf(lrw.Ctx(), "error on close(): %s", err)
 	}
+	// Buffers that were never flushed no longer count toward the node-wide
+	// total.
+	if n := lrw.bufferedBytes.Swap(0); n != 0 {
+		lrw.metrics.BufferedBytes.Dec(n)
+	}
+	lrw.metrics.FrontierSpans.Dec(lrw.frontierSpans)
+	lrw.clearGaps()
+	if lrw.eventQueue != nil {
+		lrw.eventQueue.close(lrw.Ctx())
+	}
+	lrw.eventQueueAcc.Close(lrw.Ctx())
 	lrw.maxFlushRateTimer.Stop()
+	if lrw.fanout != nil {
+		if err := lrw.fanout.close(); err != nil {
+			log.Warningf(lrw.Ctx(), "failed to close fanout sink: %v", err)
+		}
+	}
+	if lrw.shadow != nil {
+		if err := lrw.shadow.close(lrw.Ctx()); err != nil {
+			log.Warningf(lrw.Ctx(), "failed to close shadow destination connection: %v", err)
+		}
+	}
+	if lrw.checkpointSink != nil {
+		if err := lrw.checkpointSink.Close(); err != nil {
+			log.Warningf(lrw.Ctx(), "failed to close checkpoint sink: %v", err)
+		}
+	}
+	if lrw.drainDone != nil {
+		lrw.drainDone()
+	}
 
+	lrw.MemMonitor.Stop(lrw.Ctx())
 	lrw.InternalClose()
 }
 
@@ -384,71 +1020,379 @@ func (lrw *logicalReplicationWriterProcessor) sendError(err error) {
 	}
 }
 
-func (lrw *logicalReplicationWriterProcessor) flushLoop(_ context.Context) error {
+func (lrw *logicalReplicationWriterProcessor) flushLoop(ctx context.Context) error {
+	var lastFlush, lastFlushCPU time.Duration
+	var window checkpointWindow
+	cycleStart := timeutil.Now()
 	for {
-		bufferToFlush, ok := <-lrw.flushCh
+		// A held checkpoint is emitted once checkpoint_emit_interval elapses
+		// even if no flush completes in the meantime.
+		var emitDue <-chan time.Time
+		if window.pending != nil {
+			interval := checkpointEmitInterval.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+			emitDue = time.After(window.wait(timeutil.Now(), interval))
+		}
+		var bufferToFlush flushableBuffer
+		var ok bool
+		select {
+		case bufferToFlush, ok = <-lrw.flushCh:
+		case <-emitDue:
+			if !lrw.emitCheckpoint(ctx, window.take(timeutil.Now())) {
+				return nil
+			}
+			continue
+		}
 		if !ok {
-			// eventConsumer is done.
+			// eventConsumer is done, so the checkpoint of its last flush is
+			// emitted regardless of the interval.
+			if checkpoint := window.take(timeutil.Now()); checkpoint != nil {
+				lrw.emitCheckpoint(ctx, checkpoint)
+			}
 			return nil
 		}
 		lrw.flushInProgress.Store(true)
-		resolvedSpan, err := lrw.flushBuffer(bufferToFlush)
+
+		target := flushPacingQueueDepth.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+		depth, delay := lrw.pacer.pace(target, lastFlush)
+		lrw.debug.RecordFlushPacing(depth, lrw.pacer.factor)
+		cpuDelay := lrw.cpuLimiter.limit(applyCPUShare.Get(&lrw.FlowCtx.Cfg.Settings.SV),
+			lastFlushCPU, timeutil.Since(cycleStart), lastFlush)
+		lrw.debug.RecordApplyCPU(lrw.cpuLimiter.share, lrw.cpuLimiter.factor)
+		delay = max(delay, cpuDelay)
+		ioDelay := lrw.ioPacer.pace(maxIOOverloadScore.Get(&lrw.FlowCtx.Cfg.Settings.SV),
+			destinationStores(ctx, lrw.FlowCtx.Cfg.RangeCache, lrw.destIndexPrefixes, bufferToFlush.buffer.curKVBatch),
+			lastFlush)
+		lrw.debug.RecordIOPacing(lrw.ioPacer.score, lrw.ioPacer.factor)
+		delay = max(delay, ioDelay)
+		warmUp := lrw.warmUpProgress()
+		lrw.debug.RecordWarmUp(warmUp)
+		delay = max(delay, warmUpDelay(warmUp, lastFlush))
+		cycleStart = timeutil.Now()
+		if delay > 0 {
+			lrw.watchdog.recordWait(timeutil.Now())
+			select {
+			case <-time.After(delay):
+			case <-lrw.stopCh:
+				return nil
+			}
+		}
+
+		preFlush, preFlushCPU := timeutil.Now(), processCPUTime(ctx)
+		resolvedSpan, err := lrw.flushWithRetries(ctx, bufferToFlush)
 		if err != nil {
 			return err
 		}
+		lrw.watchdog.recordFlush(timeutil.Now())
+		lastFlush = timeutil.Since(preFlush)
+		lastFlushCPU = max(processCPUTime(ctx)-preFlushCPU, 0)
+		if bufferToFlush.final {
+			resolvedSpan.Complete = true
+		}
 
-		// NB: The flushLoop needs to select on stopCh here
-		// because the reader of checkpointCh is the caller of
-		// Next(). But there might never be another Next()
-		// call.
-		select {
-		case lrw.checkpointCh <- resolvedSpan:
-		case <-lrw.stopCh:
-			return nil
+		interval := checkpointEmitInterval.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+		if checkpoint := window.add(resolvedSpan, timeutil.Now(), interval); checkpoint != nil {
+			if !lrw.emitCheckpoint(ctx, checkpoint) {
+				return nil
+			}
+		} else {
+			lrw.debug.RecordCheckpointHeld(interval)
 		}
 		lrw.flushInProgress.Store(false)
 	}
 }
 
+// emitCheckpoint writes the checkpoint to the checkpoint sink, if any, and
+// hands it to Next(). It returns false if the processor stopped first.
+func (lrw *logicalReplicationWriterProcessor) emitCheckpoint(
+	ctx context.Context, checkpoint *jobspb.ResolvedSpans,
+) bool {
+	// The checkpoint sink is best effort: a checkpoint that fails to be
+	// written only leaves the sink behind the job's own progress.
+	if lrw.checkpointSink != nil {
+		if err := lrw.checkpointSink.WriteCheckpoint(ctx, checkpoint); err != nil {
+			if lrw.checkpointSinkWarning.ShouldLog() {
+				log.Warningf(ctx, "failed to write checkpoint to checkpoint sink: %v", err)
+			}
+		}
+	}
+
+	// NB: The flushLoop needs to select on stopCh here
+	// because the reader of checkpointCh is the caller of
+	// Next(). But there might never be another Next()
+	// call.
+	select {
+	case lrw.checkpointCh <- checkpoint:
+		lrw.debug.RecordCheckpointEmitted(timeutil.Now(), checkpointEmitInterval.Get(&lrw.FlowCtx.Cfg.Settings.SV))
+		return true
+	case <-lrw.stopCh:
+		return false
+	}
+}
+
+// flushWithRetries flushes the buffer, retrying the whole flush with backoff for
+// up to flush_grace_period if it fails, e.g. because the destination is briefly
+// unreachable. This is distinct from the retries of a batch's transaction.
+// Reapplying the rows of a partially applied flush is harmless since rows are
+// applied using last-write-wins. Permanent errors are not retried.
+func (lrw *logicalReplicationWriterProcessor) flushWithRetries(
+	ctx context.Context, b flushableBuffer,
+) (*jobspb.ResolvedSpans, error) {
+	gracePeriod := flushGracePeriod.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+	start := timeutil.Now()
+	opts := retry.Options{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Closer:         lrw.stopCh,
+	}
+	var resolvedSpan *jobspb.ResolvedSpans
+	var err error
+	for r := retry.StartWithCtx(ctx, opts); r.Next(); {
+		// A retry is only counted once its attempt starts, since the processor
+		// may stop during the backoff.
+		if r.CurrentAttempt() > 0 {
+			lrw.debug.RecordFlushRetry(gracePeriod)
+		}
+		resolvedSpan, err = lrw.flushBuffer(b)
+		if err == nil || jobs.IsPermanentJobError(err) || timeutil.Since(start) >= gracePeriod {
+			return resolvedSpan, err
+		}
+		// The errors of retried attempts are counted as flush retries rather
+		// than as errors of the processor, which records the error of the last
+		// attempt if the flush fails.
+		lrw.watchdog.recordWait(timeutil.Now())
+		log.Warningf(ctx, "retrying flush after %d failed attempts: %v", r.CurrentAttempt()+1, err)
+	}
+	return resolvedSpan, err
+}
+
+// maxCPUThrottleFactor bounds how much the cpuLimiter may slow down flushes.
+const maxCPUThrottleFactor = 16
+
+// cpuLimiter throttles flushes while they use more than a share of the node's
+// CPU capacity. Like the flushPacer, it forms a closed control loop: the
+// throttle factor doubles every flush cycle that used more than the share and
+// halves every cycle that didn't, and each flush is preceded by a pause of
+// factor-1 times the duration of the previous flush. A cycle spans the pause,
+// the flush and any wait for the next buffer, so the pauses lower the measured
+// share.
+//
+// Most of the CPU a flush uses isn't used by the apply workers' goroutines but
+// by those of the internal executor running their statements and of the KV
+// requests those issue, so the CPU time of a flush is that of the whole process
+// while it runs. This also counts any other work the node does meanwhile,
+// which errs on the side of throttling flushes when the node is busy.
+type cpuLimiter struct {
+	// procs returns the number of CPUs the node may use.
+	procs func() int
+	// share is the share of the node's CPU capacity used by the flushes during
+	// the last cycle. It is only accessed by the flushLoop.
+	share float64
+	// factor is the current throttle factor. It is only accessed by the
+	// flushLoop.
+	factor float64
+}
+
+func makeCPULimiter() cpuLimiter {
+	return cpuLimiter{procs: func() int { return runtime.GOMAXPROCS(0) }, factor: 1}
+}
+
+// limit updates the measured share and the throttle factor given the CPU time
+// used by the flush of the last cycle, and returns how long to wait before the
+// next flush.
+func (l *cpuLimiter) limit(maxShare float64, cpu, cycle, lastFlush time.Duration) time.Duration {
+	if cycle > 0 {
+		l.share = float64(cpu) / (float64(cycle) * float64(l.procs()))
+	}
+	if maxShare <= 0 {
+		l.factor = 1
+		return 0
+	}
+	if l.share > maxShare {
+		l.factor = min(l.factor*2, maxCPUThrottleFactor)
+	} else {
+		l.factor = max(l.factor/2, 1)
+	}
+	return time.Duration((l.factor - 1) * float64(lastFlush))
+}
+
+// processCPUTime returns the CPU time the process has used since it started, or
+// zero if it can't be read, in which case flushes are never throttled.
+func processCPUTime(ctx context.Context) time.Duration {
+	userMillis, sysMillis, err := status.GetProcCPUTime(ctx)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(userMillis+sysMillis) * time.Millisecond
+}
+
+// maxFlushPacingFactor bounds how much the flushPacer may slow down flushes.
+const maxFlushPacingFactor = 16
+
+// flushPacer paces flushes based on the depth of the KV admission queue,
+// forming a closed control loop: the pacing factor doubles every flush that
+// finds the queue deeper than the target and halves every flush that doesn't.
+// Each flush is preceded by a pause of factor-1 times the duration of the
+// previous flush, so a factor of f spends about 1/f of the time flushing.
+type flushPacer struct {
+	// queueDepth returns the current depth of the KV admission queue. It is nil
+	// if the queue can't be observed, in which case flushes are never paced.
+	queueDepth func() int64
+	// factor is the current pacing factor. It is only accessed by the
+	// flushLoop.
+	factor float64
+}
+
+func makeFlushPacer(q *admission.WorkQueue) flushPacer {
+	p := flushPacer{factor: 1}
+	if q != nil {
+		p.queueDepth = q.WaitQueueLength
+	}
+	return p
+}
+
+// pace updates the pacing factor and returns the observed queue depth and how
+// long to wait before the next flush.
+func (p *flushPacer) pace(target int64, lastFlush time.Duration) (int64, time.Duration) {
+	if p.queueDepth == nil || target <= 0 {
+		p.factor = 1
+		return 0, 0
+	}
+	depth := p.queueDepth()
+	if depth > target {
+		p.factor = min(p.factor*2, maxFlushPacingFactor)
+	} else {
+		p.factor = max(p.factor/2, 1)
+	}
+	return depth, time.Duration((p.factor - 1) * float64(lastFlush))
+}
+
 // consumeEvents handles processing events on the event queue and returns once
 // the event channel has closed.
 func (lrw *logicalReplicationWriterProcessor) consumeEvents(ctx context.Context) error {
 	minFlushInterval := minimumFlushInterval.Get(&lrw.flowCtx.Cfg.Settings.SV)
 	lrw.maxFlushRateTimer.Reset(minFlushInterval)
 	for {
+		if lrw.cutoverReached() {
+			// Everything through the cutover time has been received, so flush it
+			// and emit the final checkpoint.
+			log.Infof(ctx, "frontier reached cutover time %s; flushing buffered rows and emitting final checkpoint",
+				lrw.spec.Options.CutoverTime)
+			return lrw.flush(flushOnCutover)
+		}
 		before := timeutil.Now()
 		select {
-		case event, ok := <-lrw.subscription.Events():
+		case event, ok := <-lrw.eventQueue.events():
 			if !ok {
+				if failedOver, err := lrw.maybeFailOver(ctx); err != nil {
+					return err
+				} else if failedOver {
+					continue
+				}
 				// eventCh is closed, flush and exit.
 				if err := lrw.flush(flushOnClose); err != nil {
 					return err
 				}
 				return nil
 			}
-			lrw.debug.RecordRecv(timeutil.Since(before))
-			if err := lrw.handleEvent(event); err != nil {
+			lrw.eventQueue.dequeue(ctx, event)
+			now := timeutil.Now()
+			lrw.debug.RecordRecv(now.Sub(before))
+			lrw.watchdog.recordRecv(now)
+			if err := lrw.handleEvent(event.Event); err != nil {
 				return err
 			}
 		case <-lrw.maxFlushRateTimer.C:
 			lrw.maxFlushRateTimer.Read = true
+			if err := lrw.checkLag(); err != nil {
+				return err
+			}
+			lrw.recordAdmitLatencyPercentiles()
+			lrw.debug.RecordFrontier(lrw.frontier.Frontier())
 			minFlushInterval = minimumFlushInterval.Get(&lrw.flowCtx.Cfg.Settings.SV)
 			if timeutil.Since(lrw.lastFlushTime) >= minFlushInterval {
 				if err := lrw.maybeFlush(flushOnTime); err != nil {
 					return err
 				}
 			}
+			if err := lrw.maybeFlushTables(); err != nil {
+				return err
+			}
 			lrw.maxFlushRateTimer.Reset(minFlushInterval)
+		case <-lrw.drainCh:
+			// Flush what we have so that the checkpoint we emit covers as much as
+			// possible of what we've received, and stop consuming events.
+			log.Infof(ctx, "node draining; flushing buffered rows and emitting final checkpoint")
+			lrw.drained.Store(true)
+			return lrw.flush(flushOnDrain)
+		}
+	}
+}
+
+func makeDrainWatcher(flowCtx *execinfra.FlowCtx) (<-chan struct{}, func()) {
+	if !drainCheckpointEnabled.Get(&flowCtx.Cfg.Settings.SV) {
+		return nil, func() {}
+	}
+	if knobs, ok := flowCtx.TestingKnobs().StreamingTestingKnobs.(*sql.StreamingTestingKnobs); ok {
+		if knobs != nil && knobs.OnDrain != nil {
+			return knobs.OnDrain(), func() {}
 		}
 	}
+	return flowCtx.Cfg.JobRegistry.OnDrain()
+}
+
+// checkLag records the current replication lag of the processor's frontier and
+// returns a permanent job error, which pauses the job, once the lag has
+// exceeded max_lag for longer than max_lag_window.
+func (lrw *logicalReplicationWriterProcessor) checkLag() error {
+	sv := &lrw.FlowCtx.Cfg.Settings.SV
+	frontier := lrw.frontier.Frontier()
+	if frontier.IsEmpty() {
+		// The initial scan has not completed yet, so there is no meaningful lag.
+		return nil
+	}
+
+	threshold := maxLag.Get(sv)
+	lag := timeutil.Since(frontier.GoTime())
+	if threshold == 0 || lag <= threshold {
+		lrw.lagExceededSince = time.Time{}
+	} else if lrw.lagExceededSince.IsZero() {
+		lrw.lagExceededSince = timeutil.Now()
+	}
+	lrw.debug.RecordLag(lag, threshold, lrw.lagExceededSince)
+	catchUp := lrw.catchUp.update(timeutil.Now(), frontier, catchUpLagThreshold.Get(sv))
+	lrw.debug.RecordCatchUp(catchUp.catchingUp, catchUp.advanceRate, catchUp.eta)
+
+	if lrw.lagExceededSince.IsZero() {
+		return nil
+	}
+	if window := maxLagWindow.Get(sv); timeutil.Since(lrw.lagExceededSince) >= window {
+		return jobs.MarkAsPermanentJobError(errors.Newf(
+			"replication lag %s exceeded max_lag %s for longer than %s", lag, threshold, window))
+	}
+	return nil
+}
+
+// recordAdmitLatencyPercentiles publishes the current percentiles of this
+// processor's admission latency to its debug status.
+func (lrw *logicalReplicationWriterProcessor) recordAdmitLatencyPercentiles() {
+	snapshot := lrw.admitLatency.WindowedSnapshot()
+	lrw.debug.RecordAdmitLatencyPercentiles(
+		time.Duration(snapshot.ValueAtQuantile(50)), time.Duration(snapshot.ValueAtQuantile(99)))
 }
 
 func (lrw *logicalReplicationWriterProcessor) handleEvent(event streamingccl.Event) error {
 	sv := &lrw.FlowCtx.Cfg.Settings.SV
 
-	if event.Type() == streamingccl.KVEvent {
-		lrw.metrics.AdmitLatency.RecordValue(
-			timeutil.Since(event.GetKVs()[0].Value.Timestamp.GoTime()).Nanoseconds())
+	if event.Type() == streamingccl.KVEvent && len(event.GetKVs()) > 0 {
+		admitLatency := timeutil.Since(event.GetKVs()[0].Value.Timestamp.GoTime()).Nanoseconds()
+		lrw.metrics.AdmitLatency.RecordValue(admitLatency)
+		lrw.admitLatency.RecordValue(admitLatency)
+		if err := lrw.checkSourceClockLead(event.GetKVs()); err != nil {
+			return err
+		}
+		if gapDetectionThreshold.Get(sv) > 0 {
+			lrw.gaps.observe(event.GetKVs())
+		}
 	}
 
 	if streamingKnobs, ok := lrw.FlowCtx.TestingKnobs().StreamingTestingKnobs.(*sql.StreamingTestingKnobs); ok {
@@ -461,13 +1405,22 @@ func (lrw *logicalReplicationWriterProcessor) handleEvent(event streamingccl.Eve
 
 	switch event.Type() {
 	case streamingccl.KVEvent:
-		if err := lrw.bufferKVs(event.GetKVs()); err != nil {
+		if err := lrw.bufferKVs(event.GetKVs(), event.GetPrevValues()); err != nil {
 			return err
 		}
 	case streamingccl.CheckpointEvent:
 		if err := lrw.bufferCheckpoint(event); err != nil {
 			return err
 		}
+		lrw.maybeDetectGaps(lrw.Ctx())
+		if err := lrw.maybeConvergeScan(lrw.Ctx()); err != nil {
+			return err
+		}
+		if lrw.checkpointOnlyFlushDue(timeutil.Now()) {
+			if err := lrw.maybeFlush(flushOnTime); err != nil {
+				return err
+			}
+		}
 	case streamingccl.SSTableEvent, streamingccl.DeleteRangeEvent:
 		// TODO(ssd): Handle SSTableEvent here eventually. I'm not sure
 		// we'll ever want to truly handle DeleteRangeEvent since
@@ -477,14 +1430,32 @@ func (lrw *logicalReplicationWriterProcessor) handleEvent(event streamingccl.Eve
 	case streamingccl.SplitEvent:
 		log.Infof(lrw.Ctx(), "SplitEvent received on logical replication stream")
 	default:
-		return errors.Newf("unknown streaming event type %v", event.Type())
+		policy := unknownEventPolicy(unknownEventPolicySetting.Get(sv))
+		if policy == unknownEventError {
+			return errors.Newf("unknown streaming event type %v", event.Type())
+		}
+		lrw.metrics.UnknownEventsSkipped.Inc(1)
+		if policy == unknownEventLog && lrw.logUnknownEventEvery.ShouldLog() {
+			log.Warningf(lrw.Ctx(), "skipping unknown streaming event type %v", event.Type())
+		}
+		return nil
 	}
 
 	if lrw.logBufferEvery.ShouldLog() {
 		log.Infof(lrw.Ctx(), "current KV batch size %d (%d items)", lrw.buffer.curKVBatchSize, len(lrw.buffer.curKVBatch))
 	}
 
+	if lrw.flushHeld(sv) {
+		return nil
+	}
 	shouldFlush, mustFlush := lrw.buffer.shouldFlushOnKVSize(lrw.Ctx(), sv)
+	if !mustFlush && len(lrw.buffer.curKVBatch) > 0 && lrw.nodeBufferBudgetExceeded(sv) {
+		// The node as a whole buffers too much, so release this processor's
+		// share as soon as possible.
+		log.VInfof(lrw.Ctx(), 2, "flushing because the node-wide KV buffer budget is exhausted")
+		lrw.metrics.FlushOnNodeBudget.Inc(1)
+		shouldFlush, mustFlush = true, true
+	}
 	if mustFlush {
 		if err := lrw.flush(flushOnSize); err != nil {
 			return err
@@ -494,19 +1465,200 @@ func (lrw *logicalReplicationWriterProcessor) handleEvent(event streamingccl.Eve
 			return err
 		}
 	}
-	return nil
+	return lrw.maybeFlushTables()
 }
 
-func (lrw *logicalReplicationWriterProcessor) bufferKVs(kvs []roachpb.KeyValue) error {
+func (lrw *logicalReplicationWriterProcessor) bufferKVs(
+	kvs []roachpb.KeyValue, prevValues []roachpb.Value,
+) error {
 	if kvs == nil {
 		return errors.New("kv event expected to have kv")
 	}
-	for _, kv := range kvs {
-		lrw.buffer.addKV(kv)
+	if lrw.schemaGate != nil {
+		for _, kv := range kvs {
+			if err := lrw.schemaGate.check(kv); err != nil {
+				return err
+			}
+		}
+	}
+	// Source transactions are only identified if KVs, or those of some
+	// tables, are grouped by source transaction.
+	var txnIDs [][]byte
+	if lrw.spec.Options.GroupBySourceTxn || lrw.groupedTables != nil {
+		txnIDs = commitTimestampTxnIDs(kvs)
+	}
+	// Prior values are only kept for compare-and-swap and if the source
+	// reports one for every KV.
+	if !lrw.spec.Options.CompareAndSwap || len(prevValues) != len(kvs) {
+		prevValues = nil
+	}
+	replicated := func(i int) replicatedKV {
+		kv := replicatedKV{KeyValue: kvs[i]}
+		if txnIDs != nil && lrw.appliesBySourceTxn(kv) {
+			kv.txnID = txnIDs[i]
+		}
+		if prevValues != nil {
+			kv.prevValue = &prevValues[i]
+		}
+		return kv
+	}
+	sv := &lrw.FlowCtx.Cfg.Settings.SV
+	if rate := sampleRate.Get(sv); rate < 1 {
+		seed := sampleSeed.Get(sv)
+		var in, out int64
+		for i, kv := range kvs {
+			if lrw.afterCutover(kv) || lrw.outsideRepairWindow(kv) {
+				continue
+			}
+			if !keySampled(kv.Key, rate, seed) {
+				out++
+				continue
+			}
+			in++
+			if quarantined, err := lrw.quarantined(replicated(i)); err != nil {
+				return err
+			} else if quarantined {
+				continue
+			}
+			if unknown, err := lrw.unknownTable(replicated(i)); err != nil {
+				return err
+			} else if unknown {
+				continue
+			}
+			if lrw.scanHandoff.skip(replicated(i)) {
+				lrw.metrics.ScanHandoffSkippedKVs.Inc(1)
+				continue
+			}
+			lrw.metrics.ReplicatedValueSizeHist.RecordValue(int64(len(kv.Value.RawBytes)))
+			lrw.addToBuffer(replicated(i))
+		}
+		lrw.metrics.SampledInKVs.Inc(in)
+		lrw.metrics.SampledOutKVs.Inc(out)
+		return nil
+	}
+	for i, kv := range kvs {
+		if lrw.afterCutover(kv) || lrw.outsideRepairWindow(kv) {
+			continue
+		}
+		if quarantined, err := lrw.quarantined(replicated(i)); err != nil {
+			return err
+		} else if quarantined {
+			continue
+		}
+		if unknown, err := lrw.unknownTable(replicated(i)); err != nil {
+			return err
+		} else if unknown {
+			continue
+		}
+		if lrw.scanHandoff.skip(replicated(i)) {
+			lrw.metrics.ScanHandoffSkippedKVs.Inc(1)
+			continue
+		}
+		lrw.metrics.ReplicatedValueSizeHist.RecordValue(int64(len(kv.Value.RawBytes)))
+		lrw.addToBuffer(replicated(i))
 	}
 	return nil
 }
 
+// addToBuffer adds the KV to the buffer and accounts for its size in the
+// node-wide total of buffered bytes.
+func (lrw *logicalReplicationWriterProcessor) addToBuffer(kv replicatedKV) {
+	lrw.bufferMu.Lock()
+	lrw.buffer.addKV(kv)
+	lrw.bufferMu.Unlock()
+	lrw.trackBufferedBytes(int64(kv.Size()))
+}
+
+// snapshotBuffer returns the keys and timestamps of the KVs that are currently
+// buffered, omitting their values. It is safe to call from any goroutine and
+// only holds up the processor for as long as it takes to copy the buffer.
+func (lrw *logicalReplicationWriterProcessor) snapshotBuffer() []streampb.DebugBufferedKV {
+	lrw.bufferMu.Lock()
+	defer lrw.bufferMu.Unlock()
+	if lrw.buffer == nil {
+		return nil
+	}
+	res := make([]streampb.DebugBufferedKV, len(lrw.buffer.curKVBatch))
+	for i, kv := range lrw.buffer.curKVBatch {
+		res[i] = streampb.DebugBufferedKV{
+			Key:        kv.Key,
+			Timestamp:  kv.Value.Timestamp,
+			ValueBytes: len(kv.Value.RawBytes),
+		}
+	}
+	return res
+}
+
+// trackBufferedBytes adjusts the number of bytes buffered by the processor and
+// by all the processors on the node by delta.
+func (lrw *logicalReplicationWriterProcessor) trackBufferedBytes(delta int64) {
+	lrw.bufferedBytes.Add(delta)
+	lrw.metrics.BufferedBytes.Inc(delta)
+}
+
+// flushHeld returns true if hold_until_resolved is enabled and the frontier
+// hasn't advanced since the last flush. None of the buffered KVs can then be
+// applied until it does, so a flush, whatever triggered it, would neither apply
+// nor release any of them: the node-wide buffer budget and the size limits of
+// the buffer are only enforced once the frontier advances.
+func (lrw *logicalReplicationWriterProcessor) flushHeld(sv *settings.Values) bool {
+	return holdUntilResolved.Get(sv) && !lrw.lastFlushFrontier.Less(lrw.frontier.Frontier())
+}
+
+// nodeBufferBudgetExceeded returns true if the processors on the node buffer
+// more bytes than the node-wide budget allows.
+func (lrw *logicalReplicationWriterProcessor) nodeBufferBudgetExceeded(sv *settings.Values) bool {
+	budget := nodeBufferBudget.Get(sv)
+	return budget > 0 && lrw.metrics.BufferedBytes.Value() >= budget
+}
+
+// quarantined returns true if the KV belongs to a quarantined table, in which
+// case it is written to the dead letter queue rather than applied. The KV is
+// written before the checkpoints that follow it are handled, so the frontier
+// never moves past a row that is neither applied nor in the dead letter queue.
+func (lrw *logicalReplicationWriterProcessor) quarantined(kv replicatedKV) (bool, error) {
+	if lrw.quarantine == nil {
+		return false, nil
+	}
+	tableID, ok := sourceTableID(kv)
+	if !ok || !lrw.quarantine.isQuarantined(tableID) {
+		return false, nil
+	}
+	lrw.metrics.QuarantinedKVs.Inc(1)
+	return true, lrw.dlqClient.Log(lrw.Ctx(), jobspb.JobID(lrw.spec.JobID), kv,
+		errors.Newf("source table %d is quarantined", tableID))
+}
+
+// afterCutover returns true if the KV was written after the stream's cutover
+// time, in which case it must not be applied.
+func (lrw *logicalReplicationWriterProcessor) afterCutover(kv roachpb.KeyValue) bool {
+	cutover := lrw.spec.Options.CutoverTime
+	return !cutover.IsEmpty() && cutover.Less(kv.Value.Timestamp)
+}
+
+// cutoverReached returns true if the stream has a cutover time and the
+// frontier has reached it, i.e. all changes through the cutover time have been
+// received.
+func (lrw *logicalReplicationWriterProcessor) cutoverReached() bool {
+	cutover := lrw.spec.Options.CutoverTime
+	return !cutover.IsEmpty() && cutover.LessEq(lrw.frontier.Frontier())
+}
+
+// keySampled returns true if the row with the given key is part of the
+// deterministic sample of rows with the given rate and seed. All column
+// families of a row are sampled together.
+func keySampled(key roachpb.Key, rate float64, seed int64) bool {
+	if rowKey, err := keys.EnsureSafeSplitKey(key); err == nil {
+		key = rowKey
+	}
+	var seedBytes [8]byte
+	binary.BigEndian.PutUint64(seedBytes[:], uint64(seed))
+	h := fnv.New64a()
+	_, _ = h.Write(seedBytes[:])
+	_, _ = h.Write(key)
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
 func (lrw *logicalReplicationWriterProcessor) bufferCheckpoint(event streamingccl.Event) error {
 	if streamingKnobs, ok := lrw.FlowCtx.TestingKnobs().StreamingTestingKnobs.(*sql.StreamingTestingKnobs); ok {
 		if streamingKnobs != nil && streamingKnobs.ElideCheckpointEvent != nil {
@@ -521,7 +1673,9 @@ func (lrw *logicalReplicationWriterProcessor) bufferCheckpoint(event streamingcc
 		return errors.New("checkpoint event expected to have resolved spans")
 	}
 
-	d := quantize.Get(&lrw.EvalCtx.Settings.SV)
+	sv := &lrw.EvalCtx.Settings.SV
+	d := lrw.quantization.granularity(sv)
+	prevFrontier := lrw.frontier.Frontier()
 	for _, resolvedSpan := range resolvedSpans {
 		// If quantizing is enabled, round the timestamp down to an even multiple of
 		// the quantization amount, to maximize the number of spans that share the
@@ -537,6 +1691,15 @@ func (lrw *logicalReplicationWriterProcessor) bufferCheckpoint(event streamingcc
 			return errors.Wrap(err, "unable to forward checkpoint frontier")
 		}
 	}
+	if prevFrontier.Less(lrw.frontier.Frontier()) {
+		lrw.watchdog.recordFrontierAdvance(timeutil.Now())
+	}
+	lrw.scanHandoff.advance(lrw.frontier.Frontier())
+	if err := lrw.maybeCompactFrontier(); err != nil {
+		return err
+	}
+	lrw.quantization.adapt(sv, lrw.frontier.Len(), timeutil.Now())
+	lrw.debug.RecordQuantization(lrw.quantization.granularity(sv), lrw.frontier.Len())
 
 	lrw.metrics.CheckpointEvents.Inc(1)
 	return nil
@@ -547,48 +1710,127 @@ func (lrw *logicalReplicationWriterProcessor) maybeFlush(reason flushReason) err
 	if lrw.flushInProgress.Load() {
 		return nil
 	}
-	if len(lrw.buffer.curKVBatch) == 0 && lrw.frontier.Frontier().LessEq(lrw.lastFlushFrontier) {
+	if len(lrw.buffer.curKVBatch) == 0 {
+		if lrw.frontier.Frontier().LessEq(lrw.lastFlushFrontier) {
+			return nil
+		}
+		if reason == flushOnTime && !lrw.checkpointOnlyFlushDue(timeutil.Now()) {
+			return nil
+		}
+	}
+	if reason == flushOnTime && lrw.buffer.holdForMinBatch(&lrw.FlowCtx.Cfg.Settings.SV) {
 		return nil
 	}
 	return lrw.flush(reason)
 }
 
+// checkpointOnlyFlushDue returns true if no KVs are buffered, the frontier has
+// advanced since the last flush and that flush was at least
+// checkpoint_flush_interval ago, so that a flush only carrying the new frontier
+// is due.
+func (lrw *logicalReplicationWriterProcessor) checkpointOnlyFlushDue(now time.Time) bool {
+	if len(lrw.buffer.curKVBatch) > 0 || lrw.frontier.Frontier().LessEq(lrw.lastFlushFrontier) {
+		return false
+	}
+	return now.Sub(lrw.lastFlushTime) >= checkpointFlushInterval.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+}
+
 type flushReason int
 
 const (
 	flushOnSize flushReason = iota
 	flushOnTime
 	flushOnClose
+	flushOnDrain
+	flushOnCutover
+	flushOnTable
 )
 
 func (lrw *logicalReplicationWriterProcessor) flush(reason flushReason) error {
+	return lrw.flushTables(reason, nil /* tables */)
+}
+
+// flushTables flushes the buffered KVs of the given tables, or all of them if
+// tables is nil. The KVs of a source transaction are applied atomically, so
+// those it wrote to other tables are flushed along with them. The checkpoint
+// of the flush doesn't cover the KVs left in the buffer.
+func (lrw *logicalReplicationWriterProcessor) flushTables(
+	reason flushReason, tables map[descpb.ID]struct{},
+) error {
 	switch reason {
 	case flushOnSize:
 		lrw.metrics.FlushOnSize.Inc(1)
 	case flushOnTime:
 		lrw.metrics.FlushOnTime.Inc(1)
+	case flushOnTable:
+		lrw.metrics.FlushOnTable.Inc(1)
 	}
 
+	lrw.bufferMu.Lock()
 	bufferToFlush := lrw.buffer
-	lrw.buffer = getBuffer()
+	lrw.buffer = getBuffer(lrw.metrics)
+	if tables != nil {
+		inTables := func(kv replicatedKV) bool {
+			tableID, _ := sourceTableID(kv)
+			_, ok := tables[tableID]
+			return ok
+		}
+		flushedTxns := make(map[string]struct{})
+		for _, kv := range bufferToFlush.curKVBatch {
+			if kv.txnID != nil && inTables(kv) {
+				flushedTxns[string(kv.txnID)] = struct{}{}
+			}
+		}
+		bufferToFlush.moveKVs(func(kv replicatedKV) bool {
+			if inTables(kv) {
+				return false
+			}
+			_, flushed := flushedTxns[string(kv.txnID)]
+			return kv.txnID == nil || !flushed
+		}, lrw.buffer)
+	}
+	if holdUntilResolved.Get(&lrw.FlowCtx.Cfg.Settings.SV) {
+		bufferToFlush.moveUnresolved(lrw.frontier.Frontier(), lrw.buffer)
+	}
+	// The checkpoint must not cover the KVs left in the buffer, which are yet
+	// to be applied.
+	checkpointCap := hlc.MaxTimestamp
+	if len(lrw.buffer.curKVBatch) > 0 {
+		checkpointCap = lrw.buffer.minTimestamp.Prev()
+	}
+	lrw.bufferMu.Unlock()
 
 	checkpoint := &jobspb.ResolvedSpans{ResolvedSpans: make([]jobspb.ResolvedSpan, 0, lrw.frontier.Len())}
 	lrw.frontier.Entries(func(sp roachpb.Span, ts hlc.Timestamp) span.OpResult {
+		if checkpointCap.Less(ts) {
+			ts = checkpointCap
+		}
 		if !ts.IsEmpty() {
 			checkpoint.ResolvedSpans = append(checkpoint.ResolvedSpans, jobspb.ResolvedSpan{Span: sp, Timestamp: ts})
 		}
 		return span.ContinueMatch
 	})
+	if lrw.quarantine != nil {
+		checkpoint.QuarantinedTableIDs = lrw.quarantine.tables()
+	}
 	thisFlushFrontier := lrw.frontier.Frontier()
+	if checkpointCap.Less(thisFlushFrontier) {
+		thisFlushFrontier = checkpointCap
+	}
 
 	flushRequestStartTime := timeutil.Now()
 	select {
 	case lrw.flushCh <- flushableBuffer{
 		buffer:     bufferToFlush,
 		checkpoint: checkpoint,
+		final:      reason == flushOnCutover,
 	}:
 		lrw.lastFlushFrontier = thisFlushFrontier
-		lrw.lastFlushTime = timeutil.Now()
+		// A flush of some tables doesn't postpone the next flush of the
+		// others.
+		if tables == nil {
+			lrw.lastFlushTime = timeutil.Now()
+		}
 		lrw.metrics.FlushWaitHistNanos.RecordValue(timeutil.Since(flushRequestStartTime).Nanoseconds())
 		return nil
 	case <-lrw.stopCh:
@@ -600,6 +1842,15 @@ func (lrw *logicalReplicationWriterProcessor) flush(reason flushReason) error {
 
 const maxWriterWorkers = 32
 
+// flushWorkers returns the number of workers used to apply a flush of the
+// given number of KVs from a pool of the given size.
+func flushWorkers(numKVs, kvsPerWorker, poolSize int) int {
+	if kvsPerWorker <= 0 {
+		return poolSize
+	}
+	return max(1, min((numKVs+kvsPerWorker-1)/kvsPerWorker, poolSize))
+}
+
 // flushBuffer flushes the given flusableBufferand returns the underlying streamIngestionBuffer to the pool.
 func (lrw *logicalReplicationWriterProcessor) flushBuffer(
 	b flushableBuffer,
@@ -608,12 +1859,22 @@ func (lrw *logicalReplicationWriterProcessor) flushBuffer(
 	defer sp.Finish()
 
 	if len(b.buffer.curKVBatch) == 0 {
-		releaseBuffer(b.buffer)
+		lrw.releaseBuffer(b.buffer)
 		return b.checkpoint, nil
 	}
 
 	kvs := b.buffer.curKVBatch
 
+	// In collapse mode, only the latest version of each key is applied. The
+	// buffer only holds the retained versions from then on, so that the flush
+	// is counted as applying them and a retried flush has none to collapse.
+	if lrw.spec.Options.Collapse {
+		var collapsed int
+		kvs, collapsed = collapseKVs(kvs)
+		b.buffer.curKVBatch = kvs
+		lrw.metrics.CollapsedUpdates.Inc(int64(collapsed))
+	}
+
 	batchSize := int(flushBatchSize.Get(&lrw.EvalCtx.Settings.SV))
 
 	// Ensure the batcher is always reset, even on early error returns.
@@ -625,119 +1886,886 @@ func (lrw *logicalReplicationWriterProcessor) flushBuffer(
 	// same key in the same batch. Also, it's possible batching
 	// will make things much worse in practice.
 
-	k := func(kv roachpb.KeyValue) roachpb.Key {
-		if p, err := keys.EnsureSafeSplitKey(kv.Key); err == nil {
-			return p
+	// If every KV is tagged with its source transaction, the KVs of each
+	// source transaction are applied in the same destination transaction, so
+	// chunks and batches end between source transactions rather than rows.
+	grouped := groupedBySourceTxn(kvs)
+	sortFlushKVs(kvs, grouped)
+	phases := []flushPhase{{kvs: kvs, grouped: grouped}}
+	if !grouped && lrw.groupedTables != nil {
+		// Only the KVs of the tables whose source transactions are applied
+		// atomically are tagged with their source transaction. The other KVs
+		// are applied by row first, and then the tagged KVs by source
+		// transaction.
+		if txnKVs, rowKVs := splitBySourceTxn(kvs); len(txnKVs) > 0 {
+			sortFlushKVs(txnKVs, true)
+			phases = []flushPhase{{kvs: txnKVs, grouped: true}}
+			if len(rowKVs) > 0 {
+				phases = append([]flushPhase{{kvs: rowKVs}}, phases...)
+			}
 		}
-		return kv.Key
 	}
 
-	slices.SortFunc(kvs, func(a, b roachpb.KeyValue) int {
-		if c := k(a).Compare(k(b)); c != 0 {
-			return c
+	// Batches end at the first new row, or source transaction, after either
+	// batch_size KVs or, if the stream sets a batch size in bytes, once they
+	// reach that size.
+	batchBytes := lrw.spec.Options.BatchBytes
+	nextBatchEnd := func(kvs []replicatedKV, end func([]replicatedKV, int) int, start, chunkEnd int) int {
+		if batchBytes > 0 {
+			return end(kvs[:chunkEnd], sizedBatchEnd(kvs[:chunkEnd], start, batchBytes))
 		}
-		return a.Value.Timestamp.Compare(b.Value.Timestamp)
-	})
-
-	var flushByteSize atomic.Int64
+		return end(kvs[:chunkEnd], min(start+batchSize, chunkEnd))
+	}
 
-	chunkStart, chunkSize := 0, max((len(kvs)/len(lrw.bh))+1, batchSize)
+	// If the stream sets an apply order column, the KVs of the flush are
+	// applied in its order by a single worker. The KVs of source transactions
+	// are always applied by source transaction.
+	if len(phases) == 1 && !grouped && lrw.sortFlushByApplyOrder(ctx, kvs) {
+		phases[0].ordered = true
+	}
 
-	g := ctxgroup.WithContext(ctx)
-	for worker := range lrw.bh {
-		if chunkStart >= len(kvs) {
-			break
+	var flushByteSize atomic.Int64
+	serializeRanges := serializeSameRangeBatches.Get(&lrw.EvalCtx.Settings.SV)
+
+	// Each worker records the stats of its chunks, which are logged if the
+	// flush is slow.
+	workerStats := make([]flushWorkerStats, len(lrw.bh))
+	var workers, usedWorkers int
+	for _, phase := range phases {
+		end := rowEnd
+		if phase.grouped {
+			end = txnEnd
 		}
-		bh := lrw.bh[worker]
-		batchStart := chunkStart
-
-		// The chunk should end after the first new key after chunk size.
-		chunkEnd := min(chunkStart+chunkSize, len(kvs))
-		for chunkEnd < len(kvs) && k(kvs[chunkEnd-1]).Equal(k(kvs[chunkEnd])) {
-			chunkEnd++
+		// Small flushes are split between fewer workers, each of which applies
+		// more of the flush's KVs.
+		phaseWorkers := flushWorkers(len(phase.kvs), int(kvsPerFlushWorker.Get(&lrw.EvalCtx.Settings.SV)), len(lrw.bh))
+		// While warming up, fewer workers are used.
+		phaseWorkers = min(phaseWorkers, warmUpWorkers(len(lrw.bh), lrw.warmUpProgress()))
+		if phase.ordered {
+			phaseWorkers = 1
 		}
-		// Set the start for the next chunk to where this one ended.
-		chunkStart = chunkEnd
-
-		g.GoCtx(func(ctx context.Context) error {
-			for batchStart < chunkEnd {
-				batchEnd := min(batchStart+batchSize, chunkEnd)
-				preBatchTime := timeutil.Now()
-				batchStats, err := bh.HandleBatch(ctx, b.buffer.curKVBatch[batchStart:batchEnd])
-				if err != nil {
-					// TODO(ssd): Handle errors. We should perhaps split the batch and retry a portion of the batch.
-					// If that fails, send the failed application to the dead-letter-queue.
-					return err
-				}
-				batchStart = batchEnd
-				batchTime := timeutil.Since(preBatchTime)
+		workers = max(workers, phaseWorkers)
+		chunkStart, chunkSize := 0, max((len(phase.kvs)/phaseWorkers)+1, batchSize)
 
-				lrw.debug.RecordBatchApplied(batchTime, int64(batchEnd-batchStart))
-				lrw.metrics.BatchBytesHist.RecordValue(int64(batchStats.byteSize))
-				lrw.metrics.BatchHistNanos.RecordValue(batchTime.Nanoseconds())
-				flushByteSize.Add(int64(batchStats.byteSize))
+		chunkEnds, err := flushChunks(phase.kvs, phaseWorkers, chunkSize, end, func(worker, chunkEnd int) int {
+			if tb, ok := lrw.bh[worker].(*txnBatch); ok && serializeRanges && !phase.grouped {
+				return tb.extendToRangeEnd(ctx, phase.kvs, chunkEnd)
 			}
-			return nil
+			return chunkEnd
 		})
-	}
-
-	if chunkStart != len(kvs) {
-		panic(errors.AssertionFailedf("%d %d %d", len(lrw.bh)-1, chunkSize, len(kvs)))
-	}
+		if err != nil {
+			lrw.metrics.FlushChunkingErrors.Inc(1)
+			return b.checkpoint, err
+		}
+		usedWorkers = max(usedWorkers, len(chunkEnds))
+
+		g := ctxgroup.WithContext(ctx)
+		for worker, chunkEnd := range chunkEnds {
+			bh := lrw.bh[worker]
+			stats := &workerStats[worker]
+			batchStart := chunkStart
+			// Set the start for the next chunk to where this one ended.
+			chunkStart = chunkEnd
+
+			g.GoCtx(func(ctx context.Context) error {
+				startTime := timeutil.Now()
+				defer func() {
+					stats.elapsed += timeutil.Since(startTime)
+				}()
+				for batchStart < chunkEnd {
+					// All the KVs of a row are applied in the same transaction, so
+					// that the destination's secondary indexes, which are written
+					// along with the row, are consistent with it at every commit.
+					batchEnd := nextBatchEnd(phase.kvs, end, batchStart, chunkEnd)
+					// Batches whose rows span too many destination ranges are
+					// split at range boundaries.
+					var rangeLimited bool
+					if tb, ok := bh.(*txnBatch); ok {
+						limitedEnd := tb.rangeLimitedBatchEnd(ctx, phase.kvs, batchStart, batchEnd, end)
+						rangeLimited, batchEnd = limitedEnd < batchEnd, limitedEnd
+					}
+					preBatchTime := timeutil.Now()
+					batchStats, err := lrw.applyBatch(ctx, bh, phase.kvs[batchStart:batchEnd], end)
+					if err != nil {
+						return lrw.classifyApplyError(ctx, err)
+					}
+					batchLen := int64(batchEnd - batchStart)
+					batchStart = batchEnd
+					batchTime := timeutil.Since(preBatchTime)
+					stats.recordBatch(int(batchLen), batchStats, batchTime)
+					if batchStats.singleRange {
+						lrw.metrics.SingleRangeBatches.Inc(1)
+						lrw.metrics.SingleRangeBatchNanos.RecordValue(batchTime.Nanoseconds())
+					}
+					if rangeLimited {
+						lrw.metrics.RangeLimitedBatches.Inc(1)
+						lrw.metrics.RangeLimitedNanos.RecordValue(batchTime.Nanoseconds())
+					}
+
+					lrw.debug.RecordBatchApplied(batchTime, batchLen)
+					lrw.watchdog.recordFlush(timeutil.Now())
+					lrw.metrics.ExecutedBatchSizeHist.RecordValue(batchLen)
+					lrw.metrics.BatchRetries.Inc(int64(batchStats.retries))
+					lrw.metrics.PrefetchReads.Inc(int64(batchStats.prefetchReads))
+					lrw.metrics.PrefetchSkippedWrites.Inc(int64(batchStats.skippedWrites))
+					lrw.metrics.LockTimeoutRetries.Inc(int64(batchStats.lockTimeouts))
+					if batchStats.intentResolution > 0 {
+						lrw.metrics.IntentResolutionNanos.RecordValue(batchStats.intentResolution.Nanoseconds())
+					}
+					lrw.metrics.BatchBytesHist.RecordValue(int64(batchStats.byteSize))
+					lrw.metrics.BatchHistNanos.RecordValue(batchTime.Nanoseconds())
+					flushByteSize.Add(int64(batchStats.byteSize))
+				}
+				return nil
+			})
+		}
 
-	if err := g.Wait(); err != nil {
-		return b.checkpoint, err
+		if err := g.Wait(); err != nil {
+			return b.checkpoint, lrw.checkDestinationTables(ctx, err)
+		}
 	}
+	workerStats = workerStats[:usedWorkers]
 
 	flushTime := timeutil.Since(preFlushTime).Nanoseconds()
+	lrw.maybeLogSlowFlush(ctx, sp, time.Duration(flushTime), len(kvs), workerStats)
 	keyCount, byteCount := int64(len(b.buffer.curKVBatch)), flushByteSize.Load()
+	retained := int(recentFlushesRetained.Get(&lrw.EvalCtx.Settings.SV))
+	var summary streampb.DebugFlushSummary
+	if retained > 0 {
+		summary = summarizeFlush(kvs, preFlushTime, time.Duration(flushTime), byteCount, workerStats)
+	}
+	lrw.recentFlushes.record(summary, retained)
 	lrw.debug.RecordFlushComplete(flushTime, keyCount, byteCount)
 
 	lrw.metrics.Flushes.Inc(1)
 	lrw.metrics.FlushHistNanos.RecordValue(flushTime)
 	lrw.metrics.FlushRowCountHist.RecordValue(keyCount)
+	lrw.metrics.FlushWorkersHist.RecordValue(int64(workers))
 	lrw.metrics.FlushBytesHist.RecordValue(byteCount)
 	lrw.metrics.IngestedLogicalBytes.Inc(byteCount)
-	lrw.metrics.CommitLatency.RecordValue(timeutil.Since(b.buffer.minTimestamp.GoTime()).Nanoseconds())
+	if latency, ok := b.buffer.commitLatency(); ok {
+		lrw.metrics.CommitLatency.RecordValue(latency.Nanoseconds())
+	}
 	lrw.metrics.IngestedEvents.Inc(int64(len(b.buffer.curKVBatch)))
 
-	releaseBuffer(b.buffer)
+	lrw.releaseBuffer(b.buffer)
 
 	return b.checkpoint, nil
 }
 
+// flushLenSmoothing is the weight given to the most recent flush when
+// updating avgFlushLen.
+const flushLenSmoothing = 0.1
+
+// releaseBuffer returns the given buffer to the pool, dropping its backing
+// array if it is anomalously large compared to recent flushes.
+func (lrw *logicalReplicationWriterProcessor) releaseBuffer(b *ingestionBuffer) {
+	lrw.trackBufferedBytes(-int64(b.curKVBatchSize))
+	if n := float64(len(b.curKVBatch)); n > 0 {
+		if lrw.avgFlushLen == 0 {
+			lrw.avgFlushLen = n
+		} else {
+			lrw.avgFlushLen += flushLenSmoothing * (n - lrw.avgFlushLen)
+		}
+	}
+	sv := &lrw.EvalCtx.Settings.SV
+	maxRetainedCap := math.MaxInt
+	if multiple := maxPooledBufferMultiple.Get(sv); multiple > 0 {
+		maxRetainedCap = max(
+			int(lrw.avgFlushLen*float64(multiple)),
+			int(targetKVBufferLen.Get(sv)),
+		)
+	}
+	releaseBuffer(b, maxRetainedCap, lrw.metrics)
+}
+
+// applyBatch applies the batch using the given handler. If the batch is
+// rejected because its writes exceed the maximum size of a raft command, it is
+// split in half and each half is applied separately, recursively, down to
+// single rows. A single row that still exceeds the limit is sent to the dead
+// letter queue. Likewise, a batch with a row that violates a CHECK constraint
+// of its destination table is split down to that row, which is then handled
+// according to check_violation_policy, as is a batch with a row that has a
+// NULL for a NOT NULL column of its destination table, according to
+// not_null_violation_policy, and a batch with a row that has a value for a
+// column its destination table lacks, which dropped_column_policy rejects, or
+// a value that doesn't fit the type of its destination column, or whose
+// transaction exhausted its retry budget, is split down to the rows at fault,
+// which are sent to the dead letter queue. The batch is
+// split at the boundary found by end if possible, and otherwise between rows.
+// A batch that can't lease the descriptor of its destination table is first
+// retried for up to descriptor_lease_retry_period.
+func (lrw *logicalReplicationWriterProcessor) applyBatch(
+	ctx context.Context, bh BatchHandler, batch []replicatedKV, end func([]replicatedKV, int) int,
+) (batchStats, error) {
+	stats, err := bh.HandleBatch(ctx, batch)
+	if isDescriptorLeaseError(err) {
+		stats, err = lrw.retryDescriptorLease(ctx, bh, batch, err)
+	}
+	if err == nil {
+		return stats, nil
+	}
+	switch {
+	case isCommandTooLarge(err):
+		if len(batch) == 1 {
+			return batchStats{}, lrw.sendToDLQ(ctx, batch[0], err)
+		}
+		lrw.metrics.OversizedBatchSplits.Inc(1)
+	case isCheckViolation(err):
+		if len(batch) == 1 {
+			return batchStats{retries: stats.retries}, lrw.handleCheckViolation(ctx, batch[0], err)
+		}
+	case isNotNullViolation(err):
+		if len(batch) == 1 {
+			return batchStats{retries: stats.retries}, lrw.handleNotNullViolation(ctx, batch[0], err)
+		}
+	case errors.Is(err, errDroppedColumnValue):
+		if len(batch) == 1 {
+			lrw.metrics.DroppedColumnValues.Inc(1)
+			return batchStats{retries: stats.retries}, lrw.sendToDLQ(ctx, batch[0], err)
+		}
+	case errors.Is(err, errDestinationTypeValue):
+		if len(batch) == 1 {
+			return batchStats{retries: stats.retries}, lrw.sendToDLQ(ctx, batch[0], err)
+		}
+	case errors.Is(err, errPriorValueMismatch):
+		if len(batch) == 1 {
+			return batchStats{retries: stats.retries}, lrw.handlePriorValueMismatch(ctx, batch[0], err)
+		}
+	case isTxnDeadlineExceeded(err):
+		// The transaction took long enough to apply the batch that its commit
+		// timestamp was pushed past its deadline, so the batch is retried in
+		// smaller transactions that are quicker to commit. A single row is left
+		// for the flush to retry.
+		lrw.metrics.TxnDeadlineExceeded.Inc(1)
+		if len(batch) == 1 {
+			return stats, err
+		}
+	case errors.Is(err, errRetryBudgetExhausted):
+		// Rather than holding up the worker retrying a batch that contends on
+		// a hot key, the batch is split down to the rows that contend, which
+		// are set aside in the dead letter queue while the others are applied.
+		lrw.metrics.RetryBudgetExhausted.Inc(1)
+		if len(batch) == 1 {
+			return batchStats{retries: stats.retries, lockTimeouts: stats.lockTimeouts},
+				lrw.sendToDLQ(ctx, batch[0], err)
+		}
+	default:
+		return stats, err
+	}
+	log.VInfof(ctx, 2, "splitting batch of %d rows: %v", len(batch), err)
+	// Split the batch between source transactions or rows if possible so that
+	// their KVs are still applied together. A source transaction that is too
+	// large to apply atomically is split between rows.
+	mid := end(batch, len(batch)/2)
+	if mid == len(batch) {
+		mid = rowEnd(batch, len(batch)/2)
+	}
+	if mid == len(batch) {
+		mid = len(batch) / 2
+	}
+	left, err := lrw.applyBatch(ctx, bh, batch[:mid], end)
+	if err != nil {
+		return left, err
+	}
+	right, err := lrw.applyBatch(ctx, bh, batch[mid:], end)
+	return batchStats{
+		byteSize:      left.byteSize + right.byteSize,
+		singleRange:   left.singleRange && right.singleRange,
+		retries:       left.retries + right.retries,
+		prefetchReads: left.prefetchReads + right.prefetchReads,
+		skippedWrites: left.skippedWrites + right.skippedWrites,
+		lockTimeouts:  left.lockTimeouts + right.lockTimeouts,
+
+		intentResolution: left.intentResolution + right.intentResolution,
+	}, err
+}
+
+// sendToDLQ sends a row that can't be applied to the dead letter queue and
+// quarantines its table if too many of the table's rows were sent there.
+func (lrw *logicalReplicationWriterProcessor) sendToDLQ(
+	ctx context.Context, kv replicatedKV, reason error,
+) error {
+	lrw.metrics.DLQedRows.Inc(1)
+	if err := lrw.dlqClient.Log(ctx, jobspb.JobID(lrw.spec.JobID), kv, reason); err != nil {
+		return err
+	}
+	if lrw.quarantine == nil {
+		return nil
+	}
+	if tableID, ok := sourceTableID(kv); ok && lrw.quarantine.recordDLQ(tableID, timeutil.Now()) {
+		lrw.metrics.QuarantinedTables.Inc(1)
+		log.Warningf(ctx, "quarantining source table %d since %d of its rows were sent to the dead letter "+
+			"queue within %s; its rows are sent there without being applied until the job is resumed", tableID,
+			quarantineThreshold.Get(&lrw.FlowCtx.Cfg.Settings.SV)+1,
+			quarantineWindow.Get(&lrw.FlowCtx.Cfg.Settings.SV))
+	}
+	return nil
+}
+
+// rowKey returns the key of the row the KV belongs to, i.e. its key without
+// the column family suffix.
+func rowKey(kv replicatedKV) roachpb.Key {
+	if p, err := keys.EnsureSafeSplitKey(kv.Key); err == nil {
+		return p
+	}
+	return kv.Key
+}
+
+// sizedBatchEnd returns the index after the KV at which the total size of the
+// KVs starting at start first reaches targetBytes, or len(kvs) if they never
+// do. A batch thus always includes at least one KV.
+func sizedBatchEnd(kvs []replicatedKV, start int, targetBytes int64) int {
+	var size int64
+	for i := start; i < len(kvs); i++ {
+		size += int64(kvs[i].Size())
+		if size >= targetBytes {
+			return i + 1
+		}
+	}
+	return len(kvs)
+}
+
+// collapseKVs sorts the KVs by key and retains only the latest version of each
+// key, i.e. the one with the highest timestamp, so that a flush skips the
+// intermediate versions of keys updated more than once since the last flush.
+// It returns the retained KVs and the number of versions dropped.
+func collapseKVs(kvs []replicatedKV) ([]replicatedKV, int) {
+	slices.SortFunc(kvs, func(a, b replicatedKV) int {
+		if c := a.Key.Compare(b.Key); c != 0 {
+			return c
+		}
+		return a.Value.Timestamp.Compare(b.Value.Timestamp)
+	})
+	n := 0
+	for i := range kvs {
+		if n > 0 && kvs[n-1].Key.Equal(kvs[i].Key) {
+			kvs[n-1] = kvs[i]
+			continue
+		}
+		kvs[n] = kvs[i]
+		n++
+	}
+	return kvs[:n], len(kvs) - n
+}
+
+// flushChunks splits the sorted KVs of a flush between up to workers workers.
+// It returns the end of each worker's chunk, which starts where the previous
+// one ended. Each chunk ends at the first new row, or source transaction, as
+// decided by end, after chunkSize KVs, after which extend may extend it
+// further, e.g. to the end of its last KV's range. An error is returned if the
+// chunks don't cover the KVs, which would leave some of them unapplied.
+func flushChunks(
+	kvs []replicatedKV,
+	workers, chunkSize int,
+	end func([]replicatedKV, int) int,
+	extend func(worker, chunkEnd int) int,
+) ([]int, error) {
+	var chunkEnds []int
+	chunkStart := 0
+	for worker := 0; worker < workers && chunkStart < len(kvs); worker++ {
+		chunkEnd := end(kvs, min(chunkStart+chunkSize, len(kvs)))
+		if extend != nil {
+			chunkEnd = extend(worker, chunkEnd)
+		}
+		if chunkEnd <= chunkStart || chunkEnd > len(kvs) {
+			return nil, errors.AssertionFailedf(
+				"chunk %d of flush of %d KVs ends at %d before its start %d or past the end "+
+					"(chunk size %d, %d workers)", worker, len(kvs), chunkEnd, chunkStart, chunkSize, workers)
+		}
+		chunkEnds = append(chunkEnds, chunkEnd)
+		chunkStart = chunkEnd
+	}
+	if chunkStart != len(kvs) {
+		return nil, errors.AssertionFailedf(
+			"%d chunks of flush of %d KVs only cover %d of them (chunk size %d, %d workers)",
+			len(chunkEnds), len(kvs), chunkStart, chunkSize, workers)
+	}
+	return chunkEnds, nil
+}
+
+// rowEnd returns the index of the first KV at or after end that belongs to a
+// different row than the KV before end. The KVs must be sorted by row key.
+func rowEnd(kvs []replicatedKV, end int) int {
+	for end > 0 && end < len(kvs) && rowKey(kvs[end-1]).Equal(rowKey(kvs[end])) {
+		end++
+	}
+	return end
+}
+
+// classifyApplyError counts an error returned by a batch handler as either
+// retryable, e.g. contention that may be relieved by tuning concurrency, or
+// fatal, e.g. a data or schema error that requires fixing the configuration,
+// and annotates it with its class. Errors caused by the processor shutting
+// down are not counted.
+func (lrw *logicalReplicationWriterProcessor) classifyApplyError(
+	ctx context.Context, err error,
+) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	if isRetryableApplyError(err) {
+		lrw.metrics.RetryableApplyErrors.Inc(1)
+		return errors.Wrap(err, "retryable apply error")
+	}
+	lrw.metrics.FatalApplyErrors.Inc(1)
+	return errors.Wrap(err, "fatal apply error")
+}
+
+// isRetryableApplyError returns true if applying the batch again may succeed
+// without any change to the destination or the job.
+func isRetryableApplyError(err error) bool {
+	switch pgerror.GetPGCode(err) {
+	case pgcode.SerializationFailure, pgcode.DeadlockDetected, pgcode.LockNotAvailable,
+		pgcode.StatementCompletionUnknown:
+		return true
+	}
+	return false
+}
+
+// makeGroupedTables returns the IDs of the named replicated source tables, or
+// nil if there are none.
+func makeGroupedTables(
+	tableDescs map[string]descpb.TableDescriptor, names []string,
+) (map[descpb.ID]struct{}, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	grouped := make(map[descpb.ID]struct{}, len(names))
+	for _, name := range names {
+		desc, ok := tableDescs[name]
+		if !ok {
+			return nil, errors.Newf("table %q whose source transactions are applied atomically is not replicated", name)
+		}
+		grouped[desc.ID] = struct{}{}
+	}
+	return grouped, nil
+}
+
+// appliesBySourceTxn returns true if the KV is to be applied along with the
+// other KVs written by its source transaction.
+func (lrw *logicalReplicationWriterProcessor) appliesBySourceTxn(kv replicatedKV) bool {
+	if lrw.spec.Options.GroupBySourceTxn {
+		return true
+	}
+	tableID, ok := sourceTableID(kv)
+	if !ok {
+		return false
+	}
+	_, ok = lrw.groupedTables[tableID]
+	return ok
+}
+
+// flushPhase is a part of a flush whose KVs are applied, in order, once the
+// KVs of the previous phases have been applied.
+type flushPhase struct {
+	kvs []replicatedKV
+	// grouped is true if the KVs are sorted and applied by source transaction.
+	grouped bool
+	// ordered is true if the KVs are sorted by the stream's apply order column
+	// and must be applied in that order by a single worker.
+	ordered bool
+}
+
+// sortFlushKVs sorts the KVs of a flush by row and timestamp or, if they are
+// grouped, first by source transaction.
+func sortFlushKVs(kvs []replicatedKV, grouped bool) {
+	slices.SortFunc(kvs, func(a, b replicatedKV) int {
+		if grouped {
+			if c := bytes.Compare(a.txnID, b.txnID); c != 0 {
+				return c
+			}
+		}
+		if c := rowKey(a).Compare(rowKey(b)); c != 0 {
+			return c
+		}
+		return a.Value.Timestamp.Compare(b.Value.Timestamp)
+	})
+}
+
+// splitBySourceTxn splits the KVs into those tagged with their source
+// transaction and the rest, preserving their order.
+func splitBySourceTxn(kvs []replicatedKV) (txnKVs, rowKVs []replicatedKV) {
+	for _, kv := range kvs {
+		if kv.txnID != nil {
+			txnKVs = append(txnKVs, kv)
+		} else {
+			rowKVs = append(rowKVs, kv)
+		}
+	}
+	return txnKVs, rowKVs
+}
+
+// commitTimestampTxnIDs returns IDs standing in for the source transactions
+// that wrote the KVs, which rangefeeds don't report, derived from their MVCC
+// timestamps. All the KVs a transaction wrote share its commit timestamp, so
+// they share an ID and are still applied atomically, along with the KVs of any
+// other transaction that committed at the same timestamp. The IDs sort in
+// timestamp order.
+func commitTimestampTxnIDs(kvs []roachpb.KeyValue) [][]byte {
+	ids := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		id := make([]byte, 12)
+		binary.BigEndian.PutUint64(id, uint64(kv.Value.Timestamp.WallTime))
+		binary.BigEndian.PutUint32(id[8:], uint32(kv.Value.Timestamp.Logical))
+		ids[i] = id
+	}
+	return ids
+}
+
+// groupedBySourceTxn returns true if every KV is tagged with the source
+// transaction that wrote it.
+func groupedBySourceTxn(kvs []replicatedKV) bool {
+	for _, kv := range kvs {
+		if kv.txnID == nil {
+			return false
+		}
+	}
+	return len(kvs) > 0
+}
+
+// txnEnd returns the index of the first KV at or after end that was written by
+// a different source transaction than the KV before end. The KVs must be sorted
+// by source transaction.
+func txnEnd(kvs []replicatedKV, end int) int {
+	for end > 0 && end < len(kvs) && bytes.Equal(kvs[end-1].txnID, kvs[end].txnID) {
+		end++
+	}
+	return end
+}
+
+// isCommandTooLarge returns true if the error is the rejection of a write that
+// exceeds kv.raft.command.max_size. The error has no structured form by the
+// time it is returned through SQL, so it is identified by its message.
+func isCommandTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "command is too large")
+}
+
+// isTxnDeadlineExceeded returns true if the error is the rejection of a
+// transaction whose commit timestamp was pushed past its deadline, which is
+// bounded by the expiration of the leases on the descriptors it used.
+func isTxnDeadlineExceeded(err error) bool {
+	var retryErr *kvpb.TransactionRetryError
+	return errors.As(err, &retryErr) && retryErr.Reason == kvpb.RETRY_COMMIT_DEADLINE_EXCEEDED
+}
+
 type batchStats struct {
 	byteSize int
+	// singleRange is true if the batch was applied using autocommitting
+	// statements because all of its rows fell in a single destination range.
+	singleRange bool
+	// retries is the number of times the batch's transaction was retried.
+	retries int
+	// prefetchReads is the number of queries issued to prefetch the
+	// destination rows of the batch, and skippedWrites the number of writes
+	// skipped because a prefetched row was newer.
+	prefetchReads, skippedWrites int
+	// lockTimeouts is the number of times the batch was retried because it
+	// waited for a lock for longer than apply_lock_timeout.
+	lockTimeouts int
+	// intentResolution is the time spent resolving the intents left by the
+	// batch's transaction if intent_resolution is sync.
+	intentResolution time.Duration
 }
 
 type BatchHandler interface {
-	HandleBatch(context.Context, []roachpb.KeyValue) (batchStats, error)
+	HandleBatch(context.Context, []replicatedKV) (batchStats, error)
 }
 
 // RowProcessor knows how to process a single row from an event stream.
 type RowProcessor interface {
-	ProcessRow(context.Context, isql.Txn, roachpb.KeyValue) error
+	ProcessRow(context.Context, isql.Txn, replicatedKV) error
+}
+
+// rowPrefetcher is implemented by RowProcessors that can read the destination
+// rows of a whole batch up front, which lets ProcessRow and ApplyBatch skip the
+// writes that would lose to them rather than issuing each one.
+type rowPrefetcher interface {
+	// PrefetchRows reads the destination rows of the batch's keys in the given
+	// transaction and returns the number of queries it issued. ProcessRow and
+	// ApplyBatch use the prefetched rows until ClearPrefetched is called.
+	PrefetchRows(ctx context.Context, txn isql.Txn, batch []replicatedKV) (int, error)
+	// ClearPrefetched discards the prefetched rows and returns the number of
+	// writes skipped using them since the last PrefetchRows.
+	ClearPrefetched() int
+}
+
+// lossTracker is implemented by RowProcessors that can tell which of the rows
+// handed to ProcessRow weren't applied because they lost to a newer
+// destination row under last-write-wins, or, for deletes, found no row to
+// delete. Such rows are kept from the consumers of applied rows, which would
+// otherwise see writes the destination doesn't have.
+type lossTracker interface {
+	// ResetLosses forgets the rows that lost so far. It is called at the start
+	// of each attempt at applying a batch.
+	ResetLosses()
+	// Lost returns true if the given row lost since the last ResetLosses.
+	Lost(kv replicatedKV) bool
+}
+
+// attemptMetrics is implemented by RowProcessors that count what they do while
+// applying a batch, which is only added to their metrics once the batch is
+// applied so that the rows of a retried attempt aren't counted again.
+type attemptMetrics interface {
+	// ResetAttemptMetrics discards the counts of the current attempt. It is
+	// called at the start of each attempt at applying a batch.
+	ResetAttemptMetrics()
+	// RecordAttemptMetrics adds the counts of the attempt that applied the
+	// batch to the metrics.
+	RecordAttemptMetrics()
+}
+
+// batchApplier is implemented by RowProcessors that can apply the rows of a
+// whole batch with statements that each apply many rows, rather than calling
+// ProcessRow for each row.
+type batchApplier interface {
+	// ApplyBatch applies the rows of the batch in the given transaction.
+	ApplyBatch(ctx context.Context, txn isql.Txn, batch []replicatedKV) error
 }
 
 type txnBatch struct {
-	db descs.DB
-	rp RowProcessor
+	db         descs.DB
+	rp         RowProcessor
+	settings   *cluster.Settings
+	rangeCache *rangecache.RangeCache
+
+	// autoCommitExec executes statements outside of an explicit transaction so
+	// that each statement is committed along with its writes.
+	autoCommitExec isql.Executor
+
+	// destIndexPrefixes maps the ID of each source table to the primary index
+	// prefix of the destination table it is replicated into. It is used to
+	// find the destination range of the rows in a batch.
+	destIndexPrefixes map[descpb.ID]roachpb.Key
+
+	// shadow, if set, is handed the rows of each applied batch to apply to the
+	// stream's shadow destination.
+	shadow *shadowApplier
+	// fanout, if set, is handed the rows of each applied batch to emit to the
+	// stream's fanout sink.
+	fanout *rowFanout
+
+	// watchdog is the progress watchdog of the processor, to which retries of
+	// the batch are recorded so that a batch retried on contention isn't taken
+	// for a stall.
+	watchdog *progressWatchdog
+
+	// omitInRangefeeds, if set, excludes the applied rows from the
+	// destination's rangefeeds. It is only unset for streams whose applied rows
+	// must be visible to changefeeds on the destination.
+	omitInRangefeeds bool
+
+	// ordered is set if the stream applies the rows of each batch in the order
+	// of the batch, i.e. sets an apply_order_column, which batched statements,
+	// grouping rows by table, don't preserve.
+	ordered bool
+
+	// hasFanoutTables is set if the stream sets fanout_tables, whose rows
+	// must be written in the same transaction as the rows of their source
+	// tables.
+	hasFanoutTables bool
+
+	// compareAndSwap is set if the stream sets compare_and_swap, whose check of
+	// a row's prior value must be in the same transaction as its write.
+	compareAndSwap bool
+}
+
+// lockTimeoutRetryOptions are the options used to retry batches that failed
+// because they waited for a lock for longer than apply_lock_timeout.
+var lockTimeoutRetryOptions = retry.Options{
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	MaxRetries:     10,
 }
 
-func (t *txnBatch) HandleBatch(ctx context.Context, batch []roachpb.KeyValue) (batchStats, error) {
+func (t *txnBatch) HandleBatch(ctx context.Context, batch []replicatedKV) (batchStats, error) {
 	ctx, sp := tracing.ChildSpan(ctx, "txnBatch.HandleBatch")
 	defer sp.Finish()
 
+	stats, err := t.handleBatchWithLockTimeout(ctx, batch)
+	if counter, ok := t.rp.(attemptMetrics); ok && err == nil {
+		counter.RecordAttemptMetrics()
+	}
+	if err == nil && (t.shadow != nil || t.fanout != nil) {
+		// Applying to the shadow and emitting to the fanout sink are best
+		// effort and never hold up the batch. Rows that lost to newer
+		// destination rows are left out, since the shadow would otherwise
+		// diverge by taking them and the sink would see writes the destination
+		// doesn't have.
+		applied := t.appliedRows(batch)
+		if t.shadow != nil {
+			t.shadow.enqueue(ctx, applied)
+		}
+		if t.fanout != nil {
+			t.fanout.enqueue(ctx, applied)
+		}
+	}
+	return stats, err
+}
+
+// appliedRows returns the rows of the last batch handled that the row
+// processor didn't report as lost.
+func (t *txnBatch) appliedRows(batch []replicatedKV) []replicatedKV {
+	tracker, ok := t.rp.(lossTracker)
+	if !ok {
+		return batch
+	}
+	applied := make([]replicatedKV, 0, len(batch))
+	for _, kv := range batch {
+		if !tracker.Lost(kv) {
+			applied = append(applied, kv)
+		}
+	}
+	return applied
+}
+
+// handleBatchWithLockTimeout applies the batch, retrying it with backoff while
+// its statements time out waiting for locks if apply_lock_timeout is set.
+func (t *txnBatch) handleBatchWithLockTimeout(
+	ctx context.Context, batch []replicatedKV,
+) (batchStats, error) {
+	lockTimeout := applyLockTimeout.Get(&t.settings.SV)
+	budget := retryBudget(&t.settings.SV)
+	if lockTimeout == 0 {
+		return t.handleBatch(ctx, batch, budget, t.autoCommitExec)
+	}
+	// Rather than waiting for contending transactions, fail fast and retry the
+	// batch with backoff. Reapplying rows is harmless since they are applied
+	// using last-write-wins.
+	sd := writerSessionData(ctx, t.settings, t.omitInRangefeeds)
+	sd.LockTimeout = lockTimeout
+	exec := t.db.Executor(isql.WithSessionData(sd))
+	lockTimeouts, retries := 0, 0
+	var stats batchStats
+	var err error
+	for r := retry.StartWithCtx(ctx, lockTimeoutRetryOptions); r.Next(); {
+		// Lock timeouts count toward the retry budget of the batch along with
+		// the retries of its transaction.
+		remaining := budget
+		if budget >= 0 {
+			remaining = max(budget-lockTimeouts-retries, 0)
+		}
+		stats, err = t.handleBatch(ctx, batch, remaining, exec, isql.WithSessionData(sd))
+		retries += stats.retries
+		if err == nil || !isLockTimeout(err) {
+			break
+		}
+		lockTimeouts++
+		t.watchdog.recordWait(timeutil.Now())
+		if budget >= 0 && lockTimeouts+retries > budget {
+			err = errors.Mark(errors.Wrapf(err, "retry budget of %d exhausted", budget), errRetryBudgetExhausted)
+			break
+		}
+	}
+	stats.retries = retries
+	stats.lockTimeouts = lockTimeouts
+	return stats, err
+}
+
+// isLockTimeout returns true if the error is the rejection of a statement that
+// waited for a lock for longer than the session's lock timeout.
+func isLockTimeout(err error) bool {
+	return pgerror.GetPGCode(err) == pgcode.LockNotAvailable
+}
+
+// handleBatch applies the batch, executing statements outside of an explicit
+// transaction using exec and configuring explicit transactions using opts. An
+// explicit transaction is retried at most budget times, unless budget is
+// negative.
+func (t *txnBatch) handleBatch(
+	ctx context.Context,
+	batch []replicatedKV,
+	budget int,
+	exec isql.Executor,
+	opts ...isql.TxnOption,
+) (batchStats, error) {
 	stats := batchStats{}
+	tracker, tracksLosses := t.rp.(lossTracker)
+	if tracksLosses {
+		tracker.ResetLosses()
+	}
+	counter, countsAttempts := t.rp.(attemptMetrics)
+	if countsAttempts {
+		counter.ResetAttemptMetrics()
+	}
+	// Batched statements don't tell which of their rows lost, which the
+	// shadow and the fanout sink need to know.
+	applier, batchable := t.rp.(batchApplier)
+	batchable = batchable && !t.ordered && t.shadow == nil && t.fanout == nil
+	batched := batchable && batchedApply.Get(&t.settings.SV)
+	// The KVs of a source transaction must be applied atomically, so only
+	// untagged batches may be applied one row at a time.
+	if t.isSingleRange(ctx, batch) && batch[0].txnID == nil {
+		// A transaction spanning the whole batch needs a separate round trip to
+		// commit. Since all the rows fall in one range, we instead apply each row
+		// in its own implicit transaction, which commits in the same batch as the
+		// row's writes (1PC). Rows are applied using last-write-wins, so
+		// reapplying a prefix of the batch after a failure is harmless.
+		stats.singleRange = true
+		// Likewise, each batched statement commits in the same batch as its
+		// writes.
+		txn := autoCommitTxn{Executor: exec}
+		if batched {
+			for _, kv := range batch {
+				stats.byteSize += kv.Size()
+			}
+			return stats, applier.ApplyBatch(ctx, txn, batch)
+		}
+		for _, kv := range batch {
+			stats.byteSize += kv.Size()
+			if err := t.rp.ProcessRow(ctx, txn, kv); err != nil {
+				return stats, err
+			}
+		}
+		return stats, nil
+	}
+
+	// Once the prior rows are prefetched, the rows that don't lose to them are
+	// written with batched statements rather than one statement per row.
+	prefetcher, prefetch := t.rp.(rowPrefetcher)
+	prefetch = prefetch && prefetchPriorRows.Get(&t.settings.SV)
+	batched = batched || (prefetch && batchable)
+	attempts := 0
 	err := t.db.Txn(ctx, func(ctx context.Context, txn isql.Txn) error {
+		if budget >= 0 && attempts > budget {
+			return errors.Wrapf(errRetryBudgetExhausted, "transaction retried %d times", attempts-1)
+		}
+		if attempts > 0 {
+			t.watchdog.recordWait(timeutil.Now())
+		}
+		attempts++
+		if tracksLosses {
+			tracker.ResetLosses()
+		}
+		if countsAttempts {
+			counter.ResetAttemptMetrics()
+		}
+		stats.byteSize = 0
 		// TODO(ssd): For now, we SetOmitInRangefeeds to
 		// prevent the data from being emitted back to the source.
 		// However, I don't think we want to do this in the long run.
 		// Rather, we want to store the inbound cluster ID and store that
 		// in a way that allows us to choose to filter it out from or not.
-		// Doing it this way means that you can't choose to run CDC just from
-		// one side and not the other.
-		txn.KV().SetOmitInRangefeeds()
+		// Until then, streams with visible_to_rangefeeds set don't, so that
+		// CDC can run on their destination, at the cost of replicating the
+		// applied rows back if the destination is itself a source.
+		if t.omitInRangefeeds {
+			txn.KV().SetOmitInRangefeeds()
+		}
+		if prefetch {
+			reads, err := prefetcher.PrefetchRows(ctx, txn, batch)
+			stats.prefetchReads += reads
+			if err != nil {
+				return err
+			}
+		}
+		if batched {
+			for _, kv := range batch {
+				stats.byteSize += kv.Size()
+			}
+			return applier.ApplyBatch(ctx, txn, batch)
+		}
 		for _, kv := range batch {
 			stats.byteSize += kv.Size()
 			if err := t.rp.ProcessRow(ctx, txn, kv); err != nil {
@@ -746,13 +2774,272 @@ func (t *txnBatch) HandleBatch(ctx context.Context, batch []roachpb.KeyValue) (b
 
 		}
 		return nil
-	})
+	}, opts...)
+	if prefetch {
+		stats.skippedWrites = prefetcher.ClearPrefetched()
+	}
+	stats.retries = max(attempts-1, 0)
+	if err == nil && intentResolutionMode(intentResolution.Get(&t.settings.SV)) == intentResolutionSync {
+		start := timeutil.Now()
+		err = t.resolveIntents(ctx, batch)
+		stats.intentResolution = timeutil.Since(start)
+	}
 	return stats, err
 }
 
+// isSingleRange returns true if the cached range descriptors show that all of
+// the rows in the batch fall in a single destination range. A cache miss is
+// treated as the batch spanning multiple ranges, and so are the batches of
+// streams with fan-out tables, whose rows are also written to those tables,
+// and of compare-and-swap streams, which may check a row's prior value in a
+// separate statement from its write.
+func (t *txnBatch) isSingleRange(ctx context.Context, batch []replicatedKV) bool {
+	if len(batch) == 0 || t.rangeCache == nil || t.hasFanoutTables || t.compareAndSwap ||
+		!singleRangeBatchesEnabled.Get(&t.settings.SV) {
+		return false
+	}
+	span, ok := t.destinationSpan(batch)
+	if !ok {
+		return false
+	}
+	ranges := t.rangeCache.GetCachedOverlapping(ctx, span)
+	return len(ranges) == 1 && ranges[0].Desc.ContainsKeyRange(span.Key, span.EndKey)
+}
+
+// extendToRangeEnd returns the index of the first KV at or after end whose
+// destination key doesn't fall in the cached range holding the destination key
+// of the KV before end. It is used to assign all the rows of a destination
+// range to the same worker.
+func (t *txnBatch) extendToRangeEnd(ctx context.Context, kvs []replicatedKV, end int) int {
+	if end == 0 || end >= len(kvs) || t.rangeCache == nil {
+		return end
+	}
+	key, ok := t.destinationKey(kvs[end-1])
+	if !ok {
+		return end
+	}
+	ranges := t.rangeCache.GetCachedOverlapping(ctx, roachpb.RSpan{Key: key, EndKey: key.Next()})
+	if len(ranges) != 1 {
+		return end
+	}
+	for ; end < len(kvs); end++ {
+		next, ok := t.destinationKey(kvs[end])
+		if !ok || !ranges[0].Desc.ContainsKey(next) {
+			break
+		}
+	}
+	return end
+}
+
+// destinationKey returns the destination key written by the given row.
+func (t *txnBatch) destinationKey(kv replicatedKV) (roachpb.RKey, bool) {
+	return mapDestinationKey(t.destIndexPrefixes, kv)
+}
+
+// mapDestinationKey maps the source key of the given row to the destination
+// key it is written to by replacing the source tenant and index prefix with
+// the primary index prefix of the destination table.
+func mapDestinationKey(destIndexPrefixes map[descpb.ID]roachpb.Key, kv replicatedKV) (roachpb.RKey, bool) {
+	rest, err := keys.StripTenantPrefix(kv.Key)
+	if err != nil {
+		return nil, false
+	}
+	rest, tableID, _, err := keys.SystemSQLCodec.DecodeIndexPrefix(rest)
+	if err != nil {
+		return nil, false
+	}
+	prefix, ok := destIndexPrefixes[descpb.ID(tableID)]
+	if !ok {
+		return nil, false
+	}
+	key, err := keys.Addr(append(prefix[:len(prefix):len(prefix)], rest...))
+	if err != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+// destinationSpan returns the span of the destination keys written by the rows
+// in the batch.
+func (t *txnBatch) destinationSpan(batch []replicatedKV) (roachpb.RSpan, bool) {
+	var span roachpb.RSpan
+	for _, kv := range batch {
+		key, ok := t.destinationKey(kv)
+		if !ok {
+			return span, false
+		}
+		if span.Key == nil || key.Less(span.Key) {
+			span.Key = key
+		}
+		if span.EndKey == nil || !key.Less(span.EndKey) {
+			span.EndKey = key.Next()
+		}
+	}
+	return span, true
+}
+
+// checkDestinationTables is called when a batch fails to apply to check
+// whether a destination table was dropped and recreated under the same name
+// since the processor started. If the new table can't hold the source's rows,
+// the job is paused with an error naming the old and new descriptor IDs.
+// Otherwise, the apply error is annotated and returned so that the job retries
+// with processors that start from the new table. If the processor hasn't
+// resolved its destination tables, there is nothing to compare them against and
+// the apply error is returned as is.
+func (lrw *logicalReplicationWriterProcessor) checkDestinationTables(
+	ctx context.Context, applyErr error,
+) error {
+	if len(lrw.destIndexPrefixes) == 0 {
+		return applyErr
+	}
+	_, recreated, err := resolveDestinationIndexPrefixes(
+		ctx, lrw.FlowCtx.Cfg.DB, lrw.FlowCtx.Codec(), lrw.spec.TableDescriptors, lrw.destIndexPrefixes)
+	if err != nil {
+		if jobs.IsPermanentJobError(err) {
+			return err
+		}
+		log.Warningf(ctx, "failed to check destination tables after apply error: %v", err)
+		return applyErr
+	}
+	if len(recreated) > 0 {
+		return errors.Wrapf(applyErr, "destination tables recreated: %s", strings.Join(recreated, ", "))
+	}
+	return applyErr
+}
+
+// resolveDestinationIndexPrefixes returns the primary index prefix of each
+// destination table, keyed by the ID of the source table replicated into it.
+//
+// If prev holds previously resolved prefixes, it also returns a description of
+// each destination table whose descriptor ID changed since, i.e. which was
+// dropped and recreated. A permanent job error is returned if a recreated table
+// is incompatible with its source table.
+func resolveDestinationIndexPrefixes(
+	ctx context.Context,
+	db descs.DB,
+	codec keys.SQLCodec,
+	tableDescs map[string]descpb.TableDescriptor,
+	prev map[descpb.ID]roachpb.Key,
+) (map[descpb.ID]roachpb.Key, []string, error) {
+	prefixes := make(map[descpb.ID]roachpb.Key, len(tableDescs))
+	recreatedByName := make(map[string]string)
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		destID := dest.GetID()
+		prefixes[src.GetID()] = codec.IndexPrefix(uint32(destID), uint32(dest.GetPrimaryIndexID()))
+
+		prevPrefix, ok := prev[src.GetID()]
+		if !ok {
+			return nil
+		}
+		_, prevID, _, err := codec.DecodeIndexPrefix(prevPrefix)
+		if err != nil {
+			return err
+		}
+		if descpb.ID(prevID) == destID {
+			return nil
+		}
+		if err := checkDestinationCompatible(src, dest); err != nil {
+			return jobs.MarkAsPermanentJobError(errors.Wrapf(err,
+				"destination table %s was recreated with descriptor ID %d (previously %d) and is "+
+					"incompatible with the source table; alter it to match the source and resume the job",
+				name, destID, prevID))
+		}
+		log.Infof(ctx, "destination table %s was recreated with descriptor ID %d (previously %d)",
+			name, destID, prevID)
+		recreatedByName[name] = fmt.Sprintf("%s (ID %d, previously %d)", name, destID, prevID)
+		return nil
+	})
+	recreated := make([]string, 0, len(recreatedByName))
+	for _, desc := range recreatedByName {
+		recreated = append(recreated, desc)
+	}
+	slices.Sort(recreated)
+	return prefixes, recreated, err
+}
+
+// checkDestinationCompatible returns an error if rows of the source table can't
+// be applied to the destination table, i.e. if the destination lacks
+// crdb_internal_origin_timestamp, has a column of another type than the
+// source's or has a different primary key. Other columns written by the source
+// that the destination lacks are handled according to dropped_column_policy.
+func checkDestinationCompatible(src, dest catalog.TableDescriptor) error {
+	for _, col := range src.PublicColumns() {
+		if col.IsComputed() {
+			continue
+		}
+		destCol := catalog.FindColumnByName(dest, col.GetName())
+		if destCol == nil {
+			if col.GetName() == "crdb_internal_origin_timestamp" {
+				return errors.Newf("column %q is missing", col.GetName())
+			}
+			continue
+		}
+		if !col.GetType().Equivalent(destCol.GetType()) {
+			return errors.Newf("column %q has type %s rather than %s",
+				col.GetName(), destCol.GetType().SQLString(), col.GetType().SQLString())
+		}
+	}
+	srcKey := src.GetPrimaryIndex().IndexDesc().KeyColumnNames
+	destKey := dest.GetPrimaryIndex().IndexDesc().KeyColumnNames
+	if !slices.Equal(srcKey, destKey) {
+		return errors.Newf("primary key columns (%s) differ from the source's (%s)",
+			strings.Join(destKey, ", "), strings.Join(srcKey, ", "))
+	}
+	return nil
+}
+
+// writerSessionData returns the session data used to apply rows outside of an
+// explicit transaction. If omitInRangefeeds is set, it excludes the writes from
+// rangefeeds, like SetOmitInRangefeeds does for explicit transactions, so that
+// they are not replicated back to the source.
+//
+// Like the explicit transactions, which use the internal executor's defaults,
+// the session runs as the node user rather than as the user who created the
+// job, so that applied rows are not subject to the privileges of any
+// destination-side user. This cluster doesn't support row-level security
+// policies; if it did, the node user would need to bypass them so that
+// replicated rows are never silently filtered.
+func writerSessionData(
+	ctx context.Context, st *cluster.Settings, omitInRangefeeds bool,
+) *sessiondata.SessionData {
+	sd := sql.NewInternalSessionData(ctx, st, "logical-replication-writer")
+	sd.DisableChangefeedReplication = omitInRangefeeds
+	return sd
+}
+
+// autoCommitTxn is an isql.Txn whose statements each run in their own implicit
+// transaction.
+type autoCommitTxn struct {
+	isql.Executor
+}
+
+var _ isql.Txn = autoCommitTxn{}
+
+// KV implements the isql.Txn interface. Passing the returned nil txn to the
+// Executor runs the statement in its own implicit transaction.
+func (autoCommitTxn) KV() *kv.Txn { return nil }
+
+// SessionData implements the isql.Txn interface.
+func (autoCommitTxn) SessionData() *sessiondata.SessionData { return nil }
+
+// replicatedKV is a KV received from the source along with how it must be
+// applied.
+type replicatedKV struct {
+	roachpb.KeyValue
+	// txnID identifies the source transaction that wrote the KV by its commit
+	// timestamp, or is nil if the KV isn't applied by source transaction.
+	txnID []byte
+	// prevValue is the value the KV replaced at the source, or nil if the
+	// source didn't report it. It is only kept for compare-and-swap.
+	prevValue *roachpb.Value
+}
+
 type flushableBuffer struct {
 	buffer     *ingestionBuffer
 	checkpoint *jobspb.ResolvedSpans
+	// final is true if the buffer holds the last changes before the stream's
+	// cutover time, in which case its checkpoint is marked complete.
+	final bool
 }
 
 // streamIngestionBuffer is a local buffer for KVs.
@@ -761,11 +3048,23 @@ type flushableBuffer struct {
 
 // TOOD(ssd): We may want to sort curKVBatch based on schema topology.
 type ingestionBuffer struct {
-	curKVBatch     []roachpb.KeyValue
+	curKVBatch     []replicatedKV
 	curKVBatchSize int
 
 	// Minimum timestamp in the current batch. Used for metrics purpose.
 	minTimestamp hlc.Timestamp
+
+	// firstBuffered is when the oldest KV in the current batch was added to
+	// the buffer. Used to bound how long small batches are held.
+	firstBuffered time.Time
+
+	// tables describes the KVs of each source table in the current batch. Used
+	// to flush the KVs of a table on its own.
+	tables map[descpb.ID]*tableBuffer
+
+	// recycled is true if the buffer has previously been returned to the
+	// bufferPool. Used for metrics purpose.
+	recycled bool
 }
 
 func NewIngestionBuffer() *ingestionBuffer {
@@ -774,15 +3073,86 @@ func NewIngestionBuffer() *ingestionBuffer {
 	}
 }
 
-func (b *ingestionBuffer) addKV(kv roachpb.KeyValue) {
+func (b *ingestionBuffer) addKV(kv replicatedKV) {
+	if len(b.curKVBatch) == 0 {
+		b.firstBuffered = timeutil.Now()
+	}
 	b.curKVBatchSize += kv.Size()
 	b.curKVBatch = append(b.curKVBatch, kv)
+	b.trackTable(kv)
 	if kv.Value.Timestamp.Less(b.minTimestamp) {
 		b.minTimestamp = kv.Value.Timestamp
 	}
 }
 
+// moveUnresolved moves the KVs in the buffer with timestamps above the resolved
+// timestamp to the other buffer.
+func (b *ingestionBuffer) moveUnresolved(resolved hlc.Timestamp, other *ingestionBuffer) {
+	b.moveKVs(func(kv replicatedKV) bool {
+		return resolved.Less(kv.Value.Timestamp)
+	}, other)
+}
+
+// moveKVs moves the KVs in the buffer for which move returns true to the other
+// buffer.
+func (b *ingestionBuffer) moveKVs(move func(replicatedKV) bool, other *ingestionBuffer) {
+	kvs, firstBuffered := b.curKVBatch, b.firstBuffered
+	tablesFirstBuffered := make(map[descpb.ID]time.Time, len(b.tables))
+	for id, t := range b.tables {
+		tablesFirstBuffered[id] = t.firstBuffered
+	}
+	b.reset()
+	for _, kv := range kvs {
+		if move(kv) {
+			other.addKV(kv)
+		} else {
+			b.addKV(kv)
+		}
+	}
+	// The moved KVs have been buffered since the batch they came from was
+	// started, not since they were re-added.
+	if len(b.curKVBatch) > 0 {
+		b.firstBuffered = firstBuffered
+	}
+	if len(other.curKVBatch) > 0 && firstBuffered.Before(other.firstBuffered) {
+		other.firstBuffered = firstBuffered
+	}
+	for _, buf := range []*ingestionBuffer{b, other} {
+		for id, t := range buf.tables {
+			if first, ok := tablesFirstBuffered[id]; ok && first.Before(t.firstBuffered) {
+				t.firstBuffered = first
+			}
+		}
+	}
+}
+
+// commitLatency returns the time elapsed since the oldest KV in the buffer was
+// committed on the source. It returns false if the buffer holds no KVs, e.g.
+// when only checkpoints were received, as minTimestamp is then meaningless.
+func (b *ingestionBuffer) commitLatency() (time.Duration, bool) {
+	if len(b.curKVBatch) == 0 || b.minTimestamp == hlc.MaxTimestamp {
+		return 0, false
+	}
+	return timeutil.Since(b.minTimestamp.GoTime()), true
+}
+
+// holdForMinBatch returns true if a periodic flush of the buffer should be
+// skipped to let more KVs accumulate, trading a little latency for fewer, larger
+// transactions when traffic is sparse. Buffers holding at least min_flush_batch
+// KVs, or whose oldest KV has been buffered for longer than max_buffer_age, are
+// never held. Empty buffers aren't held either, so that checkpoints still
+// advance while no rows are being replicated.
+func (b *ingestionBuffer) holdForMinBatch(sv *settings.Values) bool {
+	minBatch := int(minFlushBatch.Get(sv))
+	if len(b.curKVBatch) == 0 || len(b.curKVBatch) >= minBatch {
+		return false
+	}
+	return timeutil.Since(b.firstBuffered) < maxBufferAge.Get(sv)
+}
+
 func (b *ingestionBuffer) reset() {
+	b.firstBuffered = time.Time{}
+	clear(b.tables)
 	b.minTimestamp = hlc.MaxTimestamp
 	b.curKVBatchSize = 0
 	b.curKVBatch = b.curKVBatch[:0]
@@ -809,12 +3179,27 @@ var bufferPool = sync.Pool{
 	New: func() interface{} { return NewIngestionBuffer() },
 }
 
-func getBuffer() *ingestionBuffer {
-	return bufferPool.Get().(*ingestionBuffer)
+func getBuffer(m *Metrics) *ingestionBuffer {
+	b := bufferPool.Get().(*ingestionBuffer)
+	if b.recycled {
+		m.BufferPoolReuses.Inc(1)
+	} else {
+		m.BufferPoolAllocations.Inc(1)
+	}
+	return b
 }
 
-func releaseBuffer(b *ingestionBuffer) {
+// releaseBuffer resets the buffer and returns it to the pool. If the capacity
+// of the buffer's backing array exceeds maxRetainedCap, the array is released
+// so that a single large flush doesn't permanently pin its memory in the pool.
+func releaseBuffer(b *ingestionBuffer, maxRetainedCap int, m *Metrics) {
+	m.BufferCapacityHist.RecordValue(int64(cap(b.curKVBatch)))
+	if cap(b.curKVBatch) > maxRetainedCap {
+		b.curKVBatch = nil
+		m.BuffersTrimmed.Inc(1)
+	}
 	b.reset()
+	b.recycled = true
 	bufferPool.Put(b)
 }
 
@@ -0,0 +1,2475 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvclient/rangecache"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvpb"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/catenumpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/mon"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/stop"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseBufferTrimsOversizedBuffers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+
+	b := NewIngestionBuffer()
+	for i := 0; i < 100; i++ {
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}})
+	}
+
+	// A buffer within the retained capacity keeps its backing array.
+	releaseBuffer(b, 1000, m)
+	require.Empty(t, b.curKVBatch)
+	require.NotZero(t, cap(b.curKVBatch))
+	require.True(t, b.recycled)
+	require.Zero(t, m.BuffersTrimmed.Count())
+
+	// An oversized buffer has its backing array dropped.
+	for i := 0; i < 100; i++ {
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}})
+	}
+	releaseBuffer(b, 10, m)
+	require.Zero(t, cap(b.curKVBatch))
+	require.Equal(t, int64(1), m.BuffersTrimmed.Count())
+}
+
+func TestDestinationSpanRewritesSourceKeys(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	srcCodec := keys.MakeSQLCodec(roachpb.MustMakeTenantID(10))
+	destCodec := keys.SystemSQLCodec
+	tb := &txnBatch{
+		destIndexPrefixes: map[descpb.ID]roachpb.Key{
+			104: destCodec.IndexPrefix(110, 1),
+		},
+	}
+	srcKey := func(pk string) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key: append(srcCodec.IndexPrefix(104, 1), pk...),
+		}}
+	}
+	destKey := func(pk string) roachpb.RKey {
+		return roachpb.RKey(append(destCodec.IndexPrefix(110, 1), pk...))
+	}
+
+	span, ok := tb.destinationSpan([]replicatedKV{srcKey("b"), srcKey("a"), srcKey("c")})
+	require.True(t, ok)
+	require.Equal(t, destKey("a"), span.Key)
+	require.Equal(t, destKey("c").Next(), span.EndKey)
+
+	// Rows from a table without a known destination can't be placed.
+	_, ok = tb.destinationSpan([]replicatedKV{{KeyValue: roachpb.KeyValue{
+		Key: srcCodec.IndexPrefix(105, 1),
+	}}})
+	require.False(t, ok)
+}
+
+func TestTraceKeyMappingRecordsSampledRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	srcCodec := keys.MakeSQLCodec(roachpb.MustMakeTenantID(10))
+	lww := &sqlLastWriteWinsRowProcessor{
+		settings: st,
+		destIndexPrefixes: map[descpb.ID]roachpb.Key{
+			104: keys.SystemSQLCodec.IndexPrefix(110, 1),
+		},
+	}
+	kv := replicatedKV{KeyValue: roachpb.KeyValue{Key: append(srcCodec.IndexPrefix(104, 1), 'a')}}
+	unknown := replicatedKV{KeyValue: roachpb.KeyValue{Key: append(srcCodec.IndexPrefix(105, 1), 'a')}}
+
+	traced := func(kvs ...replicatedKV) tracingpb.Recording {
+		tracer := tracing.NewTracer()
+		ctx, sp := tracer.StartSpanCtx(ctx, "apply", tracing.WithRecording(tracingpb.RecordingVerbose))
+		for _, kv := range kvs {
+			lww.traceKeyMapping(ctx, kv)
+		}
+		return sp.FinishAndGetRecording(tracingpb.RecordingVerbose)
+	}
+
+	// Mappings aren't traced by default.
+	_, found := traced(kv).FindLogMessage("maps to")
+	require.False(t, found)
+
+	traceKeyMappingRate.Override(ctx, &st.SV, 1)
+	rec := traced(kv, unknown)
+	_, found = rec.FindLogMessage("maps to destination key")
+	require.True(t, found)
+	_, found = rec.FindLogMessage("maps to no destination key")
+	require.True(t, found)
+}
+
+func TestDestinationRowSpansCoverAllFamilies(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	srcCodec := keys.MakeSQLCodec(roachpb.MustMakeTenantID(10))
+	destCodec := keys.SystemSQLCodec
+	tb := &txnBatch{
+		destIndexPrefixes: map[descpb.ID]roachpb.Key{
+			104: destCodec.IndexPrefix(110, 1),
+		},
+	}
+	srcKV := func(pk int64, family uint32) replicatedKV {
+		row := encoding.EncodeVarintAscending(srcCodec.IndexPrefix(104, 1), pk)
+		return replicatedKV{KeyValue: roachpb.KeyValue{Key: keys.MakeFamilyKey(row, family)}}
+	}
+	destRow := func(pk int64) roachpb.Span {
+		row := encoding.EncodeVarintAscending(destCodec.IndexPrefix(110, 1), pk)
+		return roachpb.Span{Key: row, EndKey: row.PrefixEnd()}
+	}
+
+	// The KVs of each family of a row are covered by a single span of the
+	// destination row, and KVs without a known destination are skipped.
+	spans := tb.destinationRowSpans([]replicatedKV{
+		srcKV(1, 0), srcKV(1, 1), srcKV(2, 0),
+		{KeyValue: roachpb.KeyValue{Key: keys.MakeFamilyKey(
+			encoding.EncodeVarintAscending(srcCodec.IndexPrefix(105, 1), 3), 0)}},
+	})
+	require.Equal(t, []roachpb.Span{destRow(1), destRow(2)}, spans)
+}
+
+func TestSortByApplyOrderHonorsOrderColumn(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	codec := keys.MakeSQLCodec(roachpb.MustMakeTenantID(10))
+	kvAt := func(pk int64, family uint32, wallTime int64) replicatedKV {
+		row := encoding.EncodeVarintAscending(codec.IndexPrefix(104, 1), pk)
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   keys.MakeFamilyKey(row, family),
+			Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: wallTime}},
+		}}
+	}
+	kvs := []replicatedKV{
+		kvAt(1, 0, 20), kvAt(2, 0, 10), kvAt(3, 0, 10), kvAt(3, 1, 10), kvAt(4, 0, 10),
+	}
+	values := []tree.Datum{
+		tree.NewDInt(0), tree.NewDInt(3), tree.NewDInt(1), tree.NewDInt(1), tree.DNull,
+	}
+
+	// Rows written at the same timestamp are ordered by the value of the order
+	// column, NULLs first, rather than by key, and the families of a row stay
+	// together.
+	evalCtx := &eval.Context{Settings: cluster.MakeTestingClusterSettings()}
+	sortByApplyOrder(evalCtx, kvs, values)
+	require.Equal(t, []replicatedKV{
+		kvAt(4, 0, 10), kvAt(3, 0, 10), kvAt(3, 1, 10), kvAt(2, 0, 10), kvAt(1, 0, 20),
+	}, kvs)
+}
+
+func TestCompareApplyOrderValuesIsTotalAcrossTypes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The column may have other types in other tables, whose values aren't
+	// comparable with each other, yet must still be ordered consistently.
+	evalCtx := &eval.Context{Settings: cluster.MakeTestingClusterSettings()}
+	values := []tree.Datum{
+		tree.NewDString("b"), tree.NewDInt(2), tree.DNull, tree.NewDString("a"), tree.NewDInt(1), tree.DNull,
+	}
+	for _, a := range values {
+		for _, b := range values {
+			require.Equal(t, -compareApplyOrderValues(evalCtx, b, a), compareApplyOrderValues(evalCtx, a, b))
+			for _, c := range values {
+				if compareApplyOrderValues(evalCtx, a, b) <= 0 && compareApplyOrderValues(evalCtx, b, c) <= 0 {
+					require.LessOrEqual(t, compareApplyOrderValues(evalCtx, a, c), 0, "%s, %s, %s", a, b, c)
+				}
+			}
+		}
+	}
+	slices.SortFunc(values, func(a, b tree.Datum) int { return compareApplyOrderValues(evalCtx, a, b) })
+	require.Equal(t, []tree.Datum{
+		tree.DNull, tree.DNull, tree.NewDInt(1), tree.NewDInt(2), tree.NewDString("a"), tree.NewDString("b"),
+	}, values)
+}
+
+func TestMoveUnresolvedHoldsKVsAboveResolvedTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	kvAt := func(key string, wallTime int64) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   roachpb.Key(key),
+			Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: wallTime}},
+		}}
+	}
+
+	b, held := NewIngestionBuffer(), NewIngestionBuffer()
+	for _, kv := range []replicatedKV{kvAt("a", 10), kvAt("b", 30), kvAt("c", 20), kvAt("d", 40)} {
+		b.addKV(kv)
+	}
+	b.moveUnresolved(hlc.Timestamp{WallTime: 20}, held)
+
+	bufferedKeys := func(b *ingestionBuffer) (res []string) {
+		for _, kv := range b.curKVBatch {
+			res = append(res, string(kv.Key))
+		}
+		return res
+	}
+	require.Equal(t, []string{"a", "c"}, bufferedKeys(b))
+	require.Equal(t, hlc.Timestamp{WallTime: 10}, b.minTimestamp)
+	require.Equal(t, []string{"b", "d"}, bufferedKeys(held))
+	require.Equal(t, hlc.Timestamp{WallTime: 30}, held.minTimestamp)
+	require.Equal(t, b.curKVBatchSize+held.curKVBatchSize, 4*kvAt("a", 0).Size())
+}
+
+func TestRowFanoutDropsRowsWhenBufferFull(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+	f := newRowFanout(nil /* sink */, nil /* decoder */, 3, m)
+
+	kvs := make([]replicatedKV, 5)
+	f.enqueue(context.Background(), kvs)
+	require.Len(t, f.rowCh, 3)
+	require.Equal(t, int64(2), m.FanoutDropped.Count())
+
+	// Rows are dropped rather than blocking until the buffer drains.
+	f.enqueue(context.Background(), kvs[:1])
+	require.Len(t, f.rowCh, 3)
+	require.Equal(t, int64(3), m.FanoutDropped.Count())
+	require.Zero(t, m.FanoutEmitted.Count())
+}
+
+func TestCheckpointOnlyFlushSkipsCommitLatency(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{metrics: m}
+	lrw.EvalCtx = &eval.Context{Settings: cluster.MakeTestingClusterSettings()}
+
+	// A buffer that only received checkpoints still has the sentinel
+	// minTimestamp, which must not be mistaken for a commit time.
+	b := NewIngestionBuffer()
+	_, ok := b.commitLatency()
+	require.False(t, ok)
+
+	checkpoint := &jobspb.ResolvedSpans{}
+	flushed, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: checkpoint})
+	require.NoError(t, err)
+	require.Same(t, checkpoint, flushed)
+	count, _ := m.CommitLatency.CumulativeSnapshot().Total()
+	require.Zero(t, count)
+
+	b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{
+		Key:   roachpb.Key("a"),
+		Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: 1}},
+	}})
+	latency, ok := b.commitLatency()
+	require.True(t, ok)
+	require.Positive(t, latency)
+}
+
+func TestHoldForMinBatch(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	sv := &st.SV
+	kv := func(key string) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   roachpb.Key(key),
+			Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: 1}},
+		}}
+	}
+
+	b := NewIngestionBuffer()
+	b.addKV(kv("a"))
+	// Small buffers aren't held by default.
+	require.False(t, b.holdForMinBatch(sv))
+
+	minFlushBatch.Override(ctx, sv, 3)
+	maxBufferAge.Override(ctx, sv, time.Hour)
+	require.True(t, b.holdForMinBatch(sv))
+	b.addKV(kv("b"))
+	require.True(t, b.holdForMinBatch(sv))
+	b.addKV(kv("c"))
+	require.False(t, b.holdForMinBatch(sv))
+
+	// Empty buffers are never held so that checkpoints keep advancing.
+	b.reset()
+	require.False(t, b.holdForMinBatch(sv))
+
+	// Small buffers are flushed once they have been held for max_buffer_age.
+	b.addKV(kv("a"))
+	require.True(t, b.holdForMinBatch(sv))
+	b.firstBuffered = b.firstBuffered.Add(-2 * time.Hour)
+	require.False(t, b.holdForMinBatch(sv))
+
+	// Moving unresolved KVs preserves the age of the batch.
+	other := NewIngestionBuffer()
+	b.moveUnresolved(hlc.Timestamp{}, other)
+	require.Empty(t, b.curKVBatch)
+	require.False(t, other.holdForMinBatch(sv))
+}
+
+// sizeLimitedBatchHandler is a BatchHandler that rejects batches larger than
+// its limit the way raft rejects commands that are too large.
+type sizeLimitedBatchHandler struct {
+	limit   int
+	applied []roachpb.Key
+}
+
+func (h *sizeLimitedBatchHandler) HandleBatch(
+	_ context.Context, batch []replicatedKV,
+) (batchStats, error) {
+	stats := batchStats{}
+	for _, kv := range batch {
+		stats.byteSize += kv.Size()
+	}
+	if stats.byteSize > h.limit {
+		return batchStats{}, errors.Errorf("command is too large: %d bytes (max: %d)", stats.byteSize, h.limit)
+	}
+	for _, kv := range batch {
+		h.applied = append(h.applied, kv.Key)
+	}
+	return stats, nil
+}
+
+type recordingDeadLetterQueueClient struct {
+	rows []roachpb.Key
+}
+
+func (c *recordingDeadLetterQueueClient) Log(
+	_ context.Context, _ jobspb.JobID, kv replicatedKV, _ error,
+) error {
+	c.rows = append(c.rows, kv.Key)
+	return nil
+}
+
+func TestApplyBatchSplitsOversizedBatches(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	dlq := &recordingDeadLetterQueueClient{}
+	lrw := &logicalReplicationWriterProcessor{metrics: m, dlqClient: dlq}
+
+	const limit = 1000
+	row := func(key string, size int) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   roachpb.Key(key),
+			Value: roachpb.Value{RawBytes: make([]byte, size)},
+		}}
+	}
+	// Any two small rows fit in a single command but three don't, and the
+	// large row doesn't fit on its own.
+	batch := []replicatedKV{
+		row("a", 400), row("b", 400), row("c", 1500), row("d", 400), row("e", 400),
+	}
+
+	bh := &sizeLimitedBatchHandler{limit: limit}
+	stats, err := lrw.applyBatch(ctx, bh, batch, rowEnd)
+	require.NoError(t, err)
+	require.Equal(t, []roachpb.Key{roachpb.Key("a"), roachpb.Key("b"), roachpb.Key("d"), roachpb.Key("e")}, bh.applied)
+	require.Equal(t, []roachpb.Key{roachpb.Key("c")}, dlq.rows)
+	require.Equal(t, batch[0].Size()+batch[1].Size()+batch[3].Size()+batch[4].Size(), stats.byteSize)
+	// The full batch and the half holding the large row were split.
+	require.Equal(t, int64(2), m.OversizedBatchSplits.Count())
+	require.Equal(t, int64(1), m.DLQedRows.Count())
+
+	// Other errors are returned without splitting the batch.
+	_, err = lrw.applyBatch(ctx, erroringBatchHandler{}, batch, rowEnd)
+	require.ErrorContains(t, err, "boom")
+	require.Equal(t, int64(2), m.OversizedBatchSplits.Count())
+}
+
+type erroringBatchHandler struct{}
+
+func (erroringBatchHandler) HandleBatch(context.Context, []replicatedKV) (batchStats, error) {
+	return batchStats{}, errors.New("boom")
+}
+
+// deadlineBatchHandler is a BatchHandler whose transactions exceed their
+// deadline when applying more than maxRows rows.
+type deadlineBatchHandler struct {
+	maxRows int
+	applied []roachpb.Key
+}
+
+func (h *deadlineBatchHandler) HandleBatch(
+	_ context.Context, batch []replicatedKV,
+) (batchStats, error) {
+	if len(batch) > h.maxRows {
+		return batchStats{}, errors.Wrap(
+			kvpb.NewTransactionRetryError(kvpb.RETRY_COMMIT_DEADLINE_EXCEEDED, "" /* extraMsg */),
+			"applying batch")
+	}
+	for _, kv := range batch {
+		h.applied = append(h.applied, kv.Key)
+	}
+	return batchStats{}, nil
+}
+
+func TestApplyBatchSplitsBatchesExceedingTxnDeadline(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	dlq := &recordingDeadLetterQueueClient{}
+	lrw := &logicalReplicationWriterProcessor{metrics: m, dlqClient: dlq}
+
+	var batch []replicatedKV
+	for _, key := range []string{"a", "b", "c", "d"} {
+		batch = append(batch, replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key(key)}})
+	}
+
+	// The batch is split until each transaction commits before its deadline.
+	bh := &deadlineBatchHandler{maxRows: 2}
+	_, err := lrw.applyBatch(ctx, bh, batch, rowEnd)
+	require.NoError(t, err)
+	require.Equal(t, []roachpb.Key{roachpb.Key("a"), roachpb.Key("b"), roachpb.Key("c"), roachpb.Key("d")}, bh.applied)
+	require.Equal(t, int64(1), m.TxnDeadlineExceeded.Count())
+	require.Zero(t, m.OversizedBatchSplits.Count())
+
+	// A single row exceeding the deadline is returned to be retried rather than
+	// sent to the dead letter queue.
+	bh = &deadlineBatchHandler{maxRows: 0}
+	_, err = lrw.applyBatch(ctx, bh, batch, rowEnd)
+	require.True(t, isTxnDeadlineExceeded(err))
+	require.Empty(t, bh.applied)
+	require.Empty(t, dlq.rows)
+	require.Equal(t, int64(4), m.TxnDeadlineExceeded.Count())
+
+	// Other transaction retry errors aren't mistaken for exceeded deadlines.
+	require.False(t, isTxnDeadlineExceeded(kvpb.NewTransactionRetryError(kvpb.RETRY_SERIALIZABLE, "" /* extraMsg */)))
+}
+
+// futureEvent is an event of a type introduced by a newer source version.
+type futureEvent struct {
+	streamingccl.Event
+}
+
+func (futureEvent) Type() streamingccl.EventType {
+	return streamingccl.EventType(100)
+}
+
+func TestHandleEventAppliesUnknownEventPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:              m,
+		logUnknownEventEvery: log.Every(time.Minute),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+
+	require.ErrorContains(t, lrw.handleEvent(futureEvent{}), "unknown streaming event type")
+	require.Zero(t, m.UnknownEventsSkipped.Count())
+
+	unknownEventPolicySetting.Override(ctx, &st.SV, int64(unknownEventSkip))
+	require.NoError(t, lrw.handleEvent(futureEvent{}))
+	unknownEventPolicySetting.Override(ctx, &st.SV, int64(unknownEventLog))
+	require.NoError(t, lrw.handleEvent(futureEvent{}))
+	require.Equal(t, int64(2), m.UnknownEventsSkipped.Count())
+}
+
+func TestClipCheckpointToPartition(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	// The processor owned [a, z) when the checkpoint was taken but now only
+	// owns [c, f) and [m, p) after a repartition.
+	partition := []roachpb.Span{sp("c", "f"), sp("m", "p")}
+	checkpoint := []jobspb.ResolvedSpan{
+		{Span: sp("a", "d"), Timestamp: ts(1)},
+		{Span: sp("d", "e"), Timestamp: ts(2)},
+		{Span: sp("e", "n"), Timestamp: ts(3)},
+		{Span: sp("q", "z"), Timestamp: ts(4)},
+	}
+	clippedCheckpoint, clipped := clipCheckpointToPartition(checkpoint, partition)
+	require.Equal(t, 3, clipped)
+	require.Equal(t, []jobspb.ResolvedSpan{
+		{Span: sp("c", "d"), Timestamp: ts(1)},
+		{Span: sp("d", "e"), Timestamp: ts(2)},
+		{Span: sp("e", "f"), Timestamp: ts(3)},
+		{Span: sp("m", "n"), Timestamp: ts(3)},
+	}, clippedCheckpoint)
+
+	// A frontier over the partition's spans is only advanced within them.
+	frontier, err := span.MakeFrontier(partition...)
+	require.NoError(t, err)
+	for _, rs := range clippedCheckpoint {
+		_, err := frontier.Forward(rs.Span, rs.Timestamp)
+		require.NoError(t, err)
+	}
+	require.True(t, frontier.Frontier().IsEmpty())
+	_, err = frontier.Forward(sp("n", "p"), ts(5))
+	require.NoError(t, err)
+	require.Equal(t, ts(1), frontier.Frontier())
+}
+
+// flakyBatchHandler is a BatchHandler that fails the next failures batches it
+// is handed with err.
+type flakyBatchHandler struct {
+	failures int
+	err      error
+}
+
+func (h *flakyBatchHandler) HandleBatch(context.Context, []replicatedKV) (batchStats, error) {
+	if h.failures > 0 {
+		h.failures--
+		return batchStats{}, h.err
+	}
+	return batchStats{}, nil
+}
+
+func TestCheckDestinationTablesWithoutResolvedTables(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// A processor that hasn't resolved its destination tables returns the
+	// apply error as is, without looking the tables up.
+	applyErr := errors.New("destination unavailable")
+	lrw := &logicalReplicationWriterProcessor{}
+	require.Equal(t, applyErr, lrw.checkDestinationTables(context.Background(), applyErr))
+}
+
+func TestIsDescriptorLeaseError(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	offline := catalog.NewInactiveDescriptorError(errors.New(`table "tab" is offline`))
+	require.True(t, isDescriptorLeaseError(errors.Wrap(offline, "applying batch")))
+
+	// A table that is being dropped is never retried.
+	dropped := catalog.NewInactiveDescriptorError(catalog.ErrDescriptorDropped)
+	require.False(t, isDescriptorLeaseError(errors.Wrap(dropped, "applying batch")))
+	require.False(t, isDescriptorLeaseError(catalog.ErrDescriptorDropped))
+}
+
+func TestFlushBufferClassifiesApplyErrors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	h := &flakyBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{metrics: m, bh: []BatchHandler{h}}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+	flush := func() error {
+		b := NewIngestionBuffer()
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}})
+		_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+		return err
+	}
+
+	h.failures, h.err = 1, pgerror.New(pgcode.SerializationFailure, "restart transaction")
+	require.ErrorContains(t, flush(), "retryable apply error: restart transaction")
+	require.Equal(t, int64(1), m.RetryableApplyErrors.Count())
+	require.Zero(t, m.FatalApplyErrors.Count())
+
+	h.failures, h.err = 1, pgerror.New(pgcode.NotNullViolation, "null value in column")
+	require.ErrorContains(t, flush(), "fatal apply error: null value in column")
+	require.Equal(t, int64(1), m.RetryableApplyErrors.Count())
+	require.Equal(t, int64(1), m.FatalApplyErrors.Count())
+}
+
+func TestFlushWithRetriesRetriesWithinGracePeriod(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	h := &flakyBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		bh:      []BatchHandler{h},
+		stopCh:  make(chan struct{}),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+	flush := func() error {
+		b := NewIngestionBuffer()
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}})
+		_, err := lrw.flushWithRetries(ctx, flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+		return err
+	}
+
+	// Without a grace period, a failed flush fails immediately.
+	h.failures, h.err = 1, errors.New("destination unavailable")
+	require.ErrorContains(t, flush(), "destination unavailable")
+	require.Zero(t, lrw.debug.GetStats().FlushRetries.Count)
+
+	// Within the grace period, the flush is retried until it succeeds.
+	flushGracePeriod.Override(ctx, &st.SV, time.Hour)
+	h.failures = 2
+	require.NoError(t, flush())
+	stats := lrw.debug.GetStats()
+	require.Equal(t, int64(2), stats.FlushRetries.Count)
+	require.Equal(t, time.Hour.Nanoseconds(), stats.FlushRetries.GracePeriodNanos)
+	// The errors of the retried attempts aren't also counted as errors.
+	require.Zero(t, stats.Errors.Count)
+
+	// Permanent errors are never retried.
+	h.failures, h.err = 1, jobs.MarkAsPermanentJobError(errors.New("incompatible destination"))
+	require.ErrorContains(t, flush(), "incompatible destination")
+	require.Equal(t, int64(2), lrw.debug.GetStats().FlushRetries.Count)
+}
+
+func TestFlushPacerTracksQueueDepth(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var depth int64
+	p := flushPacer{factor: 1, queueDepth: func() int64 { return depth }}
+	lastFlush := time.Second
+
+	// A shallow queue doesn't slow flushes down.
+	observed, delay := p.pace(10, lastFlush)
+	require.Zero(t, observed)
+	require.Zero(t, delay)
+
+	// The pause grows while the queue stays deep, up to the maximum factor.
+	depth = 20
+	for _, expected := range []time.Duration{1, 3, 7, 15, 15} {
+		observed, delay = p.pace(10, lastFlush)
+		require.Equal(t, depth, observed)
+		require.Equal(t, expected*time.Second, delay)
+	}
+
+	// And shrinks once the queue drains.
+	depth = 0
+	for _, expected := range []time.Duration{7, 3, 1, 0, 0} {
+		_, delay = p.pace(10, lastFlush)
+		require.Equal(t, expected*time.Second, delay)
+	}
+
+	// Pacing can be disabled.
+	depth = 20
+	_, delay = p.pace(0, lastFlush)
+	require.Zero(t, delay)
+}
+
+func TestCPULimiterTracksApplyCPUShare(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	l := cpuLimiter{factor: 1, procs: func() int { return 4 }}
+	lastFlush := time.Second
+
+	// Using one of four CPUs for the whole cycle is a quarter of the node.
+	delay := l.limit(0.5, 10*time.Second, 10*time.Second, lastFlush)
+	require.Equal(t, 0.25, l.share)
+	require.Zero(t, delay)
+
+	// The pause grows while the share is exceeded, up to the maximum factor.
+	for _, expected := range []time.Duration{1, 3, 7, 15, 15} {
+		delay = l.limit(0.5, 30*time.Second, 10*time.Second, lastFlush)
+		require.Equal(t, 0.75, l.share)
+		require.Equal(t, expected*time.Second, delay)
+	}
+
+	// And shrinks once the workers use less CPU.
+	for _, expected := range []time.Duration{7, 3, 1, 0, 0} {
+		delay = l.limit(0.5, 10*time.Second, 10*time.Second, lastFlush)
+		require.Equal(t, expected*time.Second, delay)
+	}
+
+	// Throttling can be disabled, while the share is still measured.
+	delay = l.limit(0, 30*time.Second, 10*time.Second, lastFlush)
+	require.Zero(t, delay)
+	require.Equal(t, 0.75, l.share)
+	require.Equal(t, 1.0, l.factor)
+}
+
+func TestExtendToRangeEndKeepsRangesOnOneWorker(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	stopper := stop.NewStopper()
+	defer stopper.Stop(ctx)
+
+	st := cluster.MakeTestingClusterSettings()
+	destPrefix := keys.SystemSQLCodec.IndexPrefix(110, 1)
+	destKey := func(pk string) roachpb.RKey {
+		return roachpb.RKey(append(destPrefix[:len(destPrefix):len(destPrefix)], pk...))
+	}
+	rc := rangecache.NewRangeCache(st, nil /* db */, func() int64 { return 2 << 10 }, stopper)
+	rc.Insert(ctx,
+		roachpb.RangeInfo{Desc: roachpb.RangeDescriptor{RangeID: 1, StartKey: destKey("a"), EndKey: destKey("c")}},
+		roachpb.RangeInfo{Desc: roachpb.RangeDescriptor{RangeID: 2, StartKey: destKey("c"), EndKey: destKey("z")}},
+	)
+	tb := &txnBatch{
+		rangeCache:        rc,
+		destIndexPrefixes: map[descpb.ID]roachpb.Key{104: destPrefix},
+	}
+
+	srcPrefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	var kvs []replicatedKV
+	for _, pk := range []string{"a", "b", "c", "d", "e"} {
+		kvs = append(kvs, replicatedKV{KeyValue: roachpb.KeyValue{
+			Key: append(srcPrefix[:len(srcPrefix):len(srcPrefix)], pk...),
+		}})
+	}
+
+	// A chunk ending in the middle of a range is extended to the range's end.
+	require.Equal(t, 2, tb.extendToRangeEnd(ctx, kvs, 1))
+	require.Equal(t, 5, tb.extendToRangeEnd(ctx, kvs, 3))
+	// A chunk ending on a range boundary is left alone.
+	require.Equal(t, 2, tb.extendToRangeEnd(ctx, kvs, 2))
+	require.Equal(t, 5, tb.extendToRangeEnd(ctx, kvs, 5))
+}
+
+// recordingBatchHandler is a BatchHandler that records the number of KVs in
+// each batch it is handed.
+type recordingBatchHandler struct {
+	batchLens []int
+}
+
+func (h *recordingBatchHandler) HandleBatch(
+	_ context.Context, batch []replicatedKV,
+) (batchStats, error) {
+	h.batchLens = append(h.batchLens, len(batch))
+	return batchStats{}, nil
+}
+
+func TestFlushBufferAppliesRowsInOneBatch(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	flushBatchSize.Override(ctx, &st.SV, 2)
+	h := &recordingBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		bh:      []BatchHandler{h},
+	}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+
+	// Each row has three column families, so a batch of two KVs would split
+	// every row across two transactions.
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	b := NewIngestionBuffer()
+	for i := 0; i < 3; i++ {
+		row := encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], int64(i))
+		for family := 0; family < 3; family++ {
+			b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{
+				Key: keys.MakeFamilyKey(row[:len(row):len(row)], uint32(family)),
+			}})
+		}
+	}
+	_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 3, 3}, h.batchLens)
+}
+
+func TestFlushBufferAppliesSourceTxnsInOneBatch(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	flushBatchSize.Override(ctx, &st.SV, 2)
+	h := &recordingBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		bh:      []BatchHandler{h},
+	}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+
+	// Transaction a wrote rows 0 and 2, b wrote row 1 and c wrote rows 3 to 5.
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	b := NewIngestionBuffer()
+	for i, txnID := range []string{"a", "b", "a", "c", "c", "c"} {
+		b.addKV(replicatedKV{
+			KeyValue: roachpb.KeyValue{Key: encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], int64(i))},
+			txnID:    []byte(txnID),
+		})
+	}
+	_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	// Batches end between transactions, so b and c share the second batch
+	// rather than splitting c.
+	require.Equal(t, []int{2, 4}, h.batchLens)
+
+	// Without transaction IDs, batches end between rows.
+	h.batchLens = nil
+	b = NewIngestionBuffer()
+	for i := 0; i < 6; i++ {
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{
+			Key: encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], int64(i)),
+		}})
+	}
+	_, err = lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 2, 2}, h.batchLens)
+}
+
+func TestFlushBufferAppliesSourceTxnsOfGroupedTablesOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	flushBatchSize.Override(ctx, &st.SV, 2)
+	h := &recordingBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:       MakeMetrics(time.Minute).(*Metrics),
+		bh:            []BatchHandler{h},
+		groupedTables: map[descpb.ID]struct{}{104: {}},
+	}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+
+	grouped := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	ungrouped := keys.SystemSQLCodec.IndexPrefix(105, 1)
+	kv := func(prefix roachpb.Key, pk int64) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key: encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], pk),
+		}}
+	}
+	require.True(t, lrw.appliesBySourceTxn(kv(grouped, 1)))
+	require.False(t, lrw.appliesBySourceTxn(kv(ungrouped, 1)))
+
+	// Transaction a wrote rows 0 and 2 of the grouped table and b wrote row 1,
+	// while the rows of the other table aren't tagged with their transaction.
+	b := NewIngestionBuffer()
+	for i, txnID := range []string{"a", "b", "a"} {
+		tagged := kv(grouped, int64(i))
+		tagged.txnID = []byte(txnID)
+		b.addKV(tagged)
+	}
+	for i := 0; i < 3; i++ {
+		b.addKV(kv(ungrouped, int64(i)))
+	}
+	_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	// The other table's rows are batched by row first, and then the grouped
+	// table's rows by transaction.
+	require.Equal(t, []int{2, 1, 2, 1}, h.batchLens)
+}
+
+func TestFlushBufferBatchesBySize(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	flushBatchSize.Override(ctx, &st.SV, 2)
+	h := &recordingBatchHandler{}
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		bh:      []BatchHandler{h},
+	}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+	lrw.spec.Options.BatchBytes = 1 << 10
+
+	// A wide row followed by narrow rows, then another wide row.
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	b := NewIngestionBuffer()
+	for i, width := range []int{2 << 10, 10, 10, 10, 10, 10, 2 << 10} {
+		b.addKV(replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], int64(i)),
+			Value: roachpb.MakeValueFromBytes(make([]byte, width)),
+		}})
+	}
+	_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	// Batches ignore batch_size: the first wide row fills a batch on its own,
+	// while the narrow rows accumulate until the last wide row brings them
+	// over the target size.
+	require.Equal(t, []int{1, 6}, h.batchLens)
+}
+
+func TestBufferKVsRecordsValueSizes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{metrics: m, buffer: NewIngestionBuffer()}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+
+	require.NoError(t, lrw.bufferKVs([]roachpb.KeyValue{
+		{Key: roachpb.Key("a"), Value: roachpb.Value{RawBytes: make([]byte, 10)}},
+		{Key: roachpb.Key("b"), Value: roachpb.Value{RawBytes: make([]byte, 30)}},
+	}, nil /* prevValues */))
+	count, sum := m.ReplicatedValueSizeHist.CumulativeSnapshot().Total()
+	require.Equal(t, int64(2), count)
+	require.Equal(t, float64(40), sum)
+}
+
+func TestBufferKVsKeepsPrevValuesForCompareAndSwap(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		buffer:  NewIngestionBuffer(),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+
+	kvs := []roachpb.KeyValue{{Key: roachpb.Key("a")}, {Key: roachpb.Key("b")}}
+	prevValues := []roachpb.Value{{RawBytes: []byte("x")}, {}}
+	buffered := func(prevValues []roachpb.Value) []*roachpb.Value {
+		lrw.buffer = NewIngestionBuffer()
+		require.NoError(t, lrw.bufferKVs(kvs, prevValues))
+		var res []*roachpb.Value
+		for _, kv := range lrw.buffer.curKVBatch {
+			res = append(res, kv.prevValue)
+		}
+		return res
+	}
+
+	// Prior values are dropped unless the stream uses compare-and-swap.
+	require.Equal(t, []*roachpb.Value{nil, nil}, buffered(prevValues))
+
+	lrw.spec.Options.CompareAndSwap = true
+	res := buffered(prevValues)
+	require.Equal(t, []byte("x"), res[0].RawBytes)
+	require.NotNil(t, res[1])
+	require.Nil(t, res[1].RawBytes)
+
+	// Prior values are dropped unless the source reports one for every KV.
+	require.Equal(t, []*roachpb.Value{nil, nil}, buffered(prevValues[:1]))
+}
+
+func TestBufferKVsGroupsByCommitTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: MakeMetrics(time.Minute).(*Metrics),
+		buffer:  NewIngestionBuffer(),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+	lrw.spec.Options.GroupBySourceTxn = true
+
+	at := func(key string, ts hlc.Timestamp) roachpb.KeyValue {
+		return roachpb.KeyValue{Key: roachpb.Key(key), Value: roachpb.Value{Timestamp: ts}}
+	}
+	kvs := []roachpb.KeyValue{
+		at("a", hlc.Timestamp{WallTime: 10}),
+		at("b", hlc.Timestamp{WallTime: 10, Logical: 1}),
+		at("c", hlc.Timestamp{WallTime: 10}),
+		at("d", hlc.Timestamp{WallTime: 9}),
+	}
+	require.NoError(t, lrw.bufferKVs(kvs, nil /* prevValues */))
+	ids := make([][]byte, len(lrw.buffer.curKVBatch))
+	for i, kv := range lrw.buffer.curKVBatch {
+		ids[i] = kv.txnID
+	}
+	// The KVs written at the same timestamp share a transaction, and the
+	// transactions sort in timestamp order.
+	require.Equal(t, ids[0], ids[2])
+	require.NotEqual(t, ids[0], ids[1])
+	require.Equal(t, -1, bytes.Compare(ids[3], ids[0]))
+	require.Equal(t, -1, bytes.Compare(ids[0], ids[1]))
+}
+
+func TestFrontierMilestonesAreCrossedOnce(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ts := func(wallTime int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wallTime} }
+
+	// Milestones crossed before the processor started are not crossed again,
+	// and duplicates are crossed once.
+	m := makeFrontierMilestones([]hlc.Timestamp{ts(40), ts(10), ts(20), ts(40), ts(30)}, ts(10))
+	require.Equal(t, frontierMilestones{ts(20), ts(30), ts(40)}, m)
+
+	require.Empty(t, m.cross(ts(15)))
+	require.Equal(t, []hlc.Timestamp{ts(20), ts(30)}, m.cross(ts(30)))
+	require.Empty(t, m.cross(ts(30)))
+	require.Equal(t, []hlc.Timestamp{ts(40)}, m.cross(ts(100)))
+	require.Empty(t, m)
+}
+
+func TestNodeBufferBudgetIsSharedByProcessors(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	nodeBufferBudget.Override(ctx, &st.SV, 100)
+	m := MakeMetrics(time.Minute).(*Metrics)
+	newProcessor := func() *logicalReplicationWriterProcessor {
+		lrw := &logicalReplicationWriterProcessor{metrics: m, buffer: NewIngestionBuffer()}
+		lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+		lrw.EvalCtx = &eval.Context{Settings: st}
+		return lrw
+	}
+	a, b := newProcessor(), newProcessor()
+	kv := roachpb.KeyValue{Key: roachpb.Key("a"), Value: roachpb.Value{RawBytes: make([]byte, 60)}}
+	size := int64(replicatedKV{KeyValue: kv}.Size())
+
+	require.NoError(t, a.bufferKVs([]roachpb.KeyValue{kv}, nil /* prevValues */))
+	require.Equal(t, size, m.BufferedBytes.Value())
+	require.False(t, b.nodeBufferBudgetExceeded(&st.SV))
+
+	// Buffering in another processor exhausts the budget for both.
+	require.NoError(t, b.bufferKVs([]roachpb.KeyValue{kv}, nil /* prevValues */))
+	require.Equal(t, 2*size, m.BufferedBytes.Value())
+	require.True(t, a.nodeBufferBudgetExceeded(&st.SV))
+	require.True(t, b.nodeBufferBudgetExceeded(&st.SV))
+
+	// Flushed buffers no longer count toward the budget.
+	a.releaseBuffer(a.buffer)
+	require.Equal(t, size, m.BufferedBytes.Value())
+	require.False(t, b.nodeBufferBudgetExceeded(&st.SV))
+}
+
+func TestSnapshotBufferOmitsValues(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	st := cluster.MakeTestingClusterSettings()
+	lrw := &logicalReplicationWriterProcessor{metrics: MakeMetrics(time.Minute).(*Metrics)}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+	require.Empty(t, lrw.snapshotBuffer())
+
+	lrw.buffer = NewIngestionBuffer()
+	ts := hlc.Timestamp{WallTime: 1}
+	kvs := []roachpb.KeyValue{
+		{Key: roachpb.Key("a"), Value: roachpb.Value{RawBytes: []byte("secret"), Timestamp: ts}},
+		{Key: roachpb.Key("b"), Value: roachpb.Value{RawBytes: []byte("hidden"), Timestamp: ts.Next()}},
+	}
+
+	// Snapshots may be taken concurrently with buffering.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = lrw.snapshotBuffer()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, lrw.bufferKVs(kvs, nil /* prevValues */))
+	}
+	<-done
+
+	snapshot := lrw.snapshotBuffer()
+	require.Len(t, snapshot, 200)
+	require.Equal(t, streampb.DebugBufferedKV{Key: roachpb.Key("a"), Timestamp: ts, ValueBytes: 6}, snapshot[0])
+	require.Equal(t, streampb.DebugBufferedKV{Key: roachpb.Key("b"), Timestamp: ts.Next(), ValueBytes: 6}, snapshot[1])
+}
+
+func TestKeySampledSelectsDeterministicSample(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	rowKey := func(i int) roachpb.Key {
+		return encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], int64(i))
+	}
+
+	const n = 10000
+	sampled := func(seed int64) map[int]bool {
+		res := make(map[int]bool)
+		for i := 0; i < n; i++ {
+			if keySampled(keys.MakeFamilyKey(rowKey(i), 0), 0.1, seed) {
+				res[i] = true
+			}
+		}
+		return res
+	}
+	sample := sampled(1)
+	require.InDelta(t, n/10, len(sample), n/50)
+	require.Equal(t, sample, sampled(1))
+	require.NotEqual(t, sample, sampled(2))
+
+	for i := 0; i < 100; i++ {
+		// All the column families of a row are sampled together.
+		require.Equal(t, sample[i], keySampled(keys.MakeFamilyKey(rowKey(i), 1), 0.1, 1))
+		require.True(t, keySampled(rowKey(i), 1, 1))
+		require.False(t, keySampled(rowKey(i), 0, 1))
+	}
+}
+
+func TestCatchUpTrackerEstimatesETA(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	start := time.Unix(1000, 0)
+	at := func(t time.Time) hlc.Timestamp { return hlc.Timestamp{WallTime: t.UnixNano()} }
+	var c catchUpTracker
+
+	// The frontier lags an hour behind and advances 3s every second, so the
+	// lag shrinks by 2s every second.
+	frontier := start.Add(-time.Hour)
+	status := c.update(start, at(frontier), time.Minute)
+	require.False(t, status.catchingUp)
+	for i := 1; i <= 10; i++ {
+		status = c.update(start.Add(time.Duration(i)*time.Second),
+			at(frontier.Add(time.Duration(3*i)*time.Second)), time.Minute)
+	}
+	require.True(t, status.catchingUp)
+	require.InDelta(t, 3, status.advanceRate, 0.001)
+	lag := time.Hour - 20*time.Second
+	require.InDelta(t, float64(lag/2), float64(status.eta), float64(time.Second))
+
+	// Samples taken more often than catchUpSampleInterval don't move the rate.
+	status = c.update(start.Add(10*time.Second+time.Millisecond), at(frontier.Add(time.Hour)), time.Minute)
+	require.InDelta(t, 3, status.advanceRate, 0.001)
+
+	// Once the lag is below the threshold, the stream is in steady state.
+	c = catchUpTracker{}
+	c.update(start, at(start.Add(-time.Second)), time.Minute)
+	status = c.update(start.Add(time.Second), at(start.Add(time.Second)), time.Minute)
+	require.False(t, status.catchingUp)
+	require.Zero(t, status.eta)
+}
+
+func TestInitialScanHandoffDoesNotResurrectDeletedRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:     m,
+		buffer:      NewIngestionBuffer(),
+		scanHandoff: makeInitialScanHandoff(hlc.Timestamp{WallTime: 100}, hlc.Timestamp{}),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	kvAt := func(pk uint64, family uint32, wallTime int64, deleted bool) roachpb.KeyValue {
+		row := encoding.EncodeUvarintAscending(prefix[:len(prefix):len(prefix)], pk)
+		kv := roachpb.KeyValue{Key: keys.MakeFamilyKey(row, family)}
+		if !deleted {
+			kv.Value.SetInt(1)
+		}
+		kv.Value.Timestamp = hlc.Timestamp{WallTime: wallTime}
+		return kv
+	}
+	buffered := func() []roachpb.KeyValue {
+		var res []roachpb.KeyValue
+		for _, kv := range lrw.buffer.curKVBatch {
+			res = append(res, kv.KeyValue)
+		}
+		return res
+	}
+
+	// Row 1 is deleted by a live change before the scan reaches it, and row 2
+	// after. Both families of row 1 that the scan emits must be dropped since
+	// applying them after the delete would resurrect the row.
+	liveDelete := kvAt(1, 0, 200, true)
+	liveUpdate := kvAt(2, 0, 150, false)
+	require.NoError(t, lrw.bufferKVs([]roachpb.KeyValue{liveUpdate, liveDelete}, nil /* prevValues */))
+	scanned := []roachpb.KeyValue{kvAt(1, 0, 90, false), kvAt(1, 1, 90, false), kvAt(2, 0, 90, false)}
+	require.NoError(t, lrw.bufferKVs(scanned, nil /* prevValues */))
+	require.Equal(t, []roachpb.KeyValue{liveUpdate, liveDelete, scanned[2]}, buffered())
+	require.Equal(t, int64(2), m.ScanHandoffSkippedKVs.Count())
+
+	// Row 2's delete arrives after its scanned row, which is applied first
+	// when the buffer is sorted by timestamp, so nothing is dropped.
+	lateDelete := kvAt(2, 0, 250, true)
+	require.NoError(t, lrw.bufferKVs([]roachpb.KeyValue{lateDelete}, nil /* prevValues */))
+	require.Equal(t, int64(2), m.ScanHandoffSkippedKVs.Count())
+
+	// Once the frontier reaches the initial scan timestamp the scan has
+	// completed and the deleted rows are forgotten.
+	lrw.scanHandoff.advance(hlc.Timestamp{WallTime: 99})
+	require.NotNil(t, lrw.scanHandoff.deleted)
+	lrw.scanHandoff.advance(hlc.Timestamp{WallTime: 100})
+	require.Nil(t, lrw.scanHandoff.deleted)
+	require.NoError(t, lrw.bufferKVs([]roachpb.KeyValue{kvAt(1, 0, 100, false)}, nil /* prevValues */))
+	require.Equal(t, int64(2), m.ScanHandoffSkippedKVs.Count())
+
+	// Processors resuming after the initial scan completed have no handoff.
+	require.Nil(t, makeInitialScanHandoff(hlc.Timestamp{WallTime: 100}, hlc.Timestamp{WallTime: 100}).deleted)
+	require.Nil(t, makeInitialScanHandoff(hlc.Timestamp{}, hlc.Timestamp{}).deleted)
+}
+
+func TestCheckpointOnlyFlushDue(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	sp := roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}
+	frontier, err := span.MakeFrontierAt(hlc.Timestamp{WallTime: 10}, sp)
+	require.NoError(t, err)
+	defer frontier.Release()
+	now := timeutil.Now()
+	lrw := &logicalReplicationWriterProcessor{
+		buffer:            NewIngestionBuffer(),
+		frontier:          frontier,
+		lastFlushFrontier: hlc.Timestamp{WallTime: 10},
+		lastFlushTime:     now,
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+	checkpointFlushInterval.Override(ctx, &st.SV, 10*time.Second)
+
+	// Nothing is due until the frontier advances.
+	require.False(t, lrw.checkpointOnlyFlushDue(now.Add(time.Minute)))
+	_, err = frontier.Forward(sp, hlc.Timestamp{WallTime: 20})
+	require.NoError(t, err)
+	require.False(t, lrw.checkpointOnlyFlushDue(now.Add(5*time.Second)))
+	require.True(t, lrw.checkpointOnlyFlushDue(now.Add(10*time.Second)))
+
+	// Eager flushes are due as soon as the frontier advances.
+	checkpointFlushInterval.Override(ctx, &st.SV, 0)
+	require.True(t, lrw.checkpointOnlyFlushDue(now))
+
+	// Buffered KVs are flushed on their own schedule.
+	lrw.buffer.addKV(replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}})
+	require.False(t, lrw.checkpointOnlyFlushDue(now.Add(time.Minute)))
+}
+
+func TestQuarantineSkipsTablesWithRepeatedDLQs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	quarantineThreshold.Override(ctx, &st.SV, 2)
+	quarantineWindow.Override(ctx, &st.SV, time.Minute)
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:    m,
+		buffer:     NewIngestionBuffer(),
+		dlqClient:  &recordingDeadLetterQueueClient{},
+		quarantine: newTableQuarantine(&st.SV),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+
+	kvOf := func(tableID uint32) replicatedKV {
+		key := encoding.EncodeUvarintAscending(keys.SystemSQLCodec.IndexPrefix(tableID, 1), 1)
+		return replicatedKV{KeyValue: roachpb.KeyValue{Key: keys.MakeFamilyKey(key, 0)}}
+	}
+
+	// Rows sent to the dead letter queue in different windows don't add up.
+	now := timeutil.Now()
+	require.False(t, lrw.quarantine.recordDLQ(104, now))
+	require.False(t, lrw.quarantine.recordDLQ(104, now.Add(time.Second)))
+	require.False(t, lrw.quarantine.recordDLQ(104, now.Add(time.Minute)))
+	require.Nil(t, lrw.quarantine.tables())
+
+	// Table 104 is quarantined once it exceeds the threshold within a window.
+	require.NoError(t, lrw.sendToDLQ(ctx, kvOf(104), errors.New("boom")))
+	require.NoError(t, lrw.sendToDLQ(ctx, kvOf(104), errors.New("boom")))
+	require.NoError(t, lrw.sendToDLQ(ctx, kvOf(105), errors.New("boom")))
+	require.Equal(t, []descpb.ID{104}, lrw.quarantine.tables())
+	require.Equal(t, int64(1), m.QuarantinedTables.Count())
+	require.Equal(t, int64(3), m.DLQedRows.Count())
+
+	// The rows of the quarantined table are written to the dead letter queue
+	// rather than applied while the other tables keep replicating.
+	require.NoError(t, lrw.bufferKVs([]roachpb.KeyValue{kvOf(104).KeyValue, kvOf(105).KeyValue},
+		nil /* prevValues */))
+	require.Len(t, lrw.dlqClient.(*recordingDeadLetterQueueClient).rows, 4)
+	require.Equal(t, kvOf(104).Key, lrw.dlqClient.(*recordingDeadLetterQueueClient).rows[3])
+	require.Len(t, lrw.buffer.curKVBatch, 1)
+	tableID, ok := sourceTableID(lrw.buffer.curKVBatch[0])
+	require.True(t, ok)
+	require.Equal(t, descpb.ID(105), tableID)
+	require.Equal(t, int64(1), m.QuarantinedKVs.Count())
+}
+
+func TestBufferKVsAppliesUnknownTablePolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:   m,
+		buffer:    NewIngestionBuffer(),
+		dlqClient: &recordingDeadLetterQueueClient{},
+		knownTables: makeKnownTables(map[string]descpb.TableDescriptor{
+			"a": {ID: 104},
+		}),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+
+	kvOf := func(tableID uint32) roachpb.KeyValue {
+		key := encoding.EncodeUvarintAscending(keys.SystemSQLCodec.IndexPrefix(tableID, 1), 1)
+		return roachpb.KeyValue{Key: keys.MakeFamilyKey(key, 0)}
+	}
+	kvs := []roachpb.KeyValue{kvOf(104), kvOf(105)}
+
+	// By default, the job is paused.
+	err := lrw.bufferKVs(kvs, nil /* prevValues */)
+	require.True(t, jobs.IsPermanentJobError(err))
+	require.ErrorContains(t, err, "source table 105 which is not replicated")
+
+	unknownTablePolicySetting.Override(ctx, &st.SV, int64(unknownTableSkip))
+	lrw.buffer = NewIngestionBuffer()
+	require.NoError(t, lrw.bufferKVs(kvs, nil /* prevValues */))
+	require.Len(t, lrw.buffer.curKVBatch, 1)
+	require.Equal(t, int64(1), m.UnknownTableKVsSkipped.Count())
+
+	unknownTablePolicySetting.Override(ctx, &st.SV, int64(unknownTableDLQ))
+	lrw.buffer = NewIngestionBuffer()
+	require.NoError(t, lrw.bufferKVs(kvs, nil /* prevValues */))
+	require.Len(t, lrw.buffer.curKVBatch, 1)
+	require.Equal(t, int64(1), m.DLQedRows.Count())
+}
+
+func TestFlushWorkersScaleWithFlushSize(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Small flushes are applied by a single worker.
+	require.Equal(t, 1, flushWorkers(1, 256, maxWriterWorkers))
+	require.Equal(t, 1, flushWorkers(256, 256, maxWriterWorkers))
+	// Larger flushes fan out, up to the size of the pool.
+	require.Equal(t, 2, flushWorkers(257, 256, maxWriterWorkers))
+	require.Equal(t, 16, flushWorkers(4096, 256, maxWriterWorkers))
+	require.Equal(t, maxWriterWorkers, flushWorkers(1<<20, 256, maxWriterWorkers))
+	require.Equal(t, 4, flushWorkers(1<<20, 256, 4))
+	// Without a per-worker size, every flush uses the whole pool.
+	require.Equal(t, maxWriterWorkers, flushWorkers(1, 0, maxWriterWorkers))
+}
+
+func TestSourceSchemaGateStopsRowsWithNewColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	gate := makeSourceSchemaGate(map[string]descpb.TableDescriptor{
+		"db.public.tab": {
+			ID:           104,
+			NextColumnID: 4,
+			NextFamilyID: 1,
+			Mutations: []descpb.DescriptorMutation{{
+				Descriptor_: &descpb.DescriptorMutation_Column{Column: &descpb.ColumnDescriptor{ID: 3}},
+				Direction:   descpb.DescriptorMutation_ADD,
+			}},
+		},
+	})
+	row := encoding.EncodeVarintAscending(keys.SystemSQLCodec.IndexPrefix(104, 1), 1)
+	kv := func(familyID uint32, colIDs ...descpb.ColumnID) roachpb.KeyValue {
+		var b []byte
+		var prev descpb.ColumnID
+		for _, id := range colIDs {
+			b = encoding.EncodeIntValue(b, uint32(id-prev), 1)
+			prev = id
+		}
+		var v roachpb.Value
+		v.SetTuple(b)
+		return roachpb.KeyValue{Key: keys.MakeFamilyKey(row[:len(row):len(row)], familyID), Value: v}
+	}
+
+	require.NoError(t, gate.check(kv(0, 1, 2)))
+	// Deletes don't encode any columns.
+	require.NoError(t, gate.check(roachpb.KeyValue{Key: kv(0).Key}))
+	// Columns that were being added or were added since the gate's descriptor
+	// stop the processor, as do column families added since.
+	require.True(t, errors.Is(gate.check(kv(0, 1, 3)), errSourceSchemaChanged))
+	require.True(t, errors.Is(gate.check(kv(0, 1, 4)), errSourceSchemaChanged))
+	require.True(t, errors.Is(gate.check(kv(1, 1)), errSourceSchemaChanged))
+	// Without a gate, every row is admitted.
+	require.NoError(t, sourceSchemaGate(nil).check(kv(0, 1, 4)))
+}
+
+func TestSchemaChangeStatements(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const name = "db.public.tab"
+	prev := descpb.TableDescriptor{
+		ID:      104,
+		Version: 1,
+		Columns: []descpb.ColumnDescriptor{
+			{ID: 1, Name: "pk", Type: types.Int},
+			{ID: 2, Name: "payload", Type: types.String, Nullable: true},
+			{ID: 3, Name: "obsolete", Type: types.String, Nullable: true},
+		},
+		PrimaryIndex: descpb.IndexDescriptor{ID: 1, Name: "tab_pkey", KeyColumnIDs: []descpb.ColumnID{1}},
+		Indexes: []descpb.IndexDescriptor{{
+			ID: 2, Name: "tab_obsolete_idx", KeyColumnNames: []string{"obsolete"},
+			KeyColumnDirections: []catenumpb.IndexColumn_Direction{catenumpb.IndexColumn_ASC},
+		}},
+	}
+	cur := prev
+	cur.Version = 2
+	defaultExpr := "7:::INT8"
+	cur.Columns = []descpb.ColumnDescriptor{
+		prev.Columns[0], prev.Columns[1],
+		{ID: 4, Name: "extra", Type: types.Int, DefaultExpr: &defaultExpr},
+	}
+	cur.PrimaryIndex.ID = 3
+	cur.Indexes = []descpb.IndexDescriptor{{
+		ID: 4, Name: "tab_extra_idx", KeyColumnNames: []string{"extra"},
+		KeyColumnDirections: []catenumpb.IndexColumn_Direction{catenumpb.IndexColumn_DESC},
+		StoreColumnNames:    []string{"payload"},
+	}}
+
+	stmts, err := schemaChangeStatements(name, &prev, &cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"DROP INDEX IF EXISTS db.public.tab@tab_obsolete_idx",
+		"ALTER TABLE db.public.tab DROP COLUMN IF EXISTS obsolete",
+		"ALTER TABLE db.public.tab ADD COLUMN IF NOT EXISTS extra INT8 NOT NULL DEFAULT 7:::INT8",
+		"CREATE INDEX IF NOT EXISTS tab_extra_idx ON db.public.tab (extra DESC) STORING (payload)",
+	}, stmts)
+
+	renamed := prev
+	renamed.Columns = []descpb.ColumnDescriptor{
+		prev.Columns[0], {ID: 2, Name: "body", Type: types.String, Nullable: true}, prev.Columns[2],
+	}
+	_, err = schemaChangeStatements(name, &prev, &renamed)
+	require.ErrorContains(t, err, "column payload renamed to body")
+
+	computed := "pk + 1"
+	withComputed := prev
+	withComputed.Columns = append(append([]descpb.ColumnDescriptor(nil), prev.Columns...),
+		descpb.ColumnDescriptor{ID: 4, Name: "next", Type: types.Int, ComputeExpr: &computed})
+	_, err = schemaChangeStatements(name, &prev, &withComputed)
+	require.ErrorContains(t, err, "computed column next added")
+}
+
+func TestEventQueuePausesAtHighWaterMark(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	mm := mon.NewUnlimitedMonitor(ctx, mon.Options{Name: "test", Settings: st})
+	defer mm.Stop(ctx)
+	acc := mm.MakeConcurrentBoundAccount()
+	defer acc.Close(ctx)
+	q := newEventQueue(&st.SV, m, acc)
+
+	kvs := []roachpb.KeyValue{{Key: roachpb.Key("a"), Value: roachpb.MakeValueFromString("hello")}}
+	size := eventSize(streamingccl.MakeKVEvent(kvs))
+	subscriptionQueueHighWater.Override(ctx, &st.SV, 2*size)
+
+	events := make(chan streamingccl.Event, 5)
+	for i := 0; i < 5; i++ {
+		events <- streamingccl.MakeKVEvent(kvs)
+	}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, q.run(ctx, events, nil /* deadline */, stopCh))
+	}()
+
+	waitForPauses := func(pauses int64) {
+		testutils.SucceedsSoon(t, func() error {
+			if got := m.SubscriptionQueuePauses.Count(); got != pauses {
+				return errors.Newf("expected %d pauses, got %d", pauses, got)
+			}
+			return nil
+		})
+	}
+	// Reading pauses once the queued events reach the high-water mark.
+	waitForPauses(1)
+	require.Len(t, events, 3)
+	require.Equal(t, 2*size, m.SubscriptionQueueBytes.Value())
+	require.Equal(t, 2*size, acc.Used())
+
+	// Consuming an event lets the queue read another.
+	q.dequeue(ctx, <-q.events())
+	waitForPauses(2)
+	require.Len(t, events, 2)
+	require.Equal(t, 2*size, m.SubscriptionQueueBytes.Value())
+	require.Equal(t, 2*size, acc.Used())
+
+	close(stopCh)
+	<-done
+	q.close(ctx)
+	require.Zero(t, m.SubscriptionQueueBytes.Value())
+	require.Zero(t, acc.Used())
+}
+
+func TestCompactFrontierMergesAdjacentSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	ts := func(wall int64) hlc.Timestamp { return hlc.Timestamp{WallTime: wall} }
+
+	// [a, d) is contiguous, [d, e) hasn't been resolved and [x, z) isn't
+	// adjacent to the others.
+	f, err := span.MakeFrontier(sp("a", "e"), sp("x", "z"))
+	require.NoError(t, err)
+	defer f.Release()
+	for _, r := range []jobspb.ResolvedSpan{
+		{Span: sp("a", "b"), Timestamp: ts(3)},
+		{Span: sp("b", "c"), Timestamp: ts(2)},
+		{Span: sp("c", "d"), Timestamp: ts(4)},
+		{Span: sp("x", "y"), Timestamp: ts(5)},
+		{Span: sp("y", "z"), Timestamp: ts(6)},
+	} {
+		_, err := f.Forward(r.Span, r.Timestamp)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, f.Len())
+
+	compacted, err := compactFrontier(f, 2)
+	require.NoError(t, err)
+	defer compacted.Release()
+
+	var entries []jobspb.ResolvedSpan
+	compacted.Entries(func(s roachpb.Span, t hlc.Timestamp) span.OpResult {
+		entries = append(entries, jobspb.ResolvedSpan{Span: s, Timestamp: t})
+		return span.ContinueMatch
+	})
+	require.Equal(t, []jobspb.ResolvedSpan{
+		{Span: sp("a", "d"), Timestamp: ts(2)},
+		{Span: sp("d", "e")},
+		{Span: sp("x", "z"), Timestamp: ts(5)},
+	}, entries)
+	require.Equal(t, f.Frontier(), compacted.Frontier())
+
+	// A frontier within the target is copied as is.
+	copied, err := compactFrontier(f, 10)
+	require.NoError(t, err)
+	defer copied.Release()
+	require.Equal(t, f.Len(), copied.Len())
+}
+
+func TestWarmUpRampsParallelismAndFlushRate(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Without a warm-up, processors start at full parallelism.
+	require.Equal(t, 1.0, warmUpProgress(0, 0))
+	require.Equal(t, 32, warmUpWorkers(32, warmUpProgress(0, 0)))
+	require.Zero(t, warmUpDelay(warmUpProgress(0, 0), time.Second))
+
+	// Processors start at a tenth of their parallelism and flush rate.
+	p := warmUpProgress(0, time.Minute)
+	require.InDelta(t, minWarmUpProgress, p, 1e-9)
+	require.Equal(t, 4, warmUpWorkers(32, p))
+	require.Equal(t, 1, warmUpWorkers(1, p))
+	require.InDelta(t, float64(9*time.Second), float64(warmUpDelay(p, time.Second)), float64(time.Millisecond))
+
+	// Halfway through, they use a bit more than half.
+	p = warmUpProgress(30*time.Second, time.Minute)
+	require.InDelta(t, 0.55, p, 1e-9)
+	require.Equal(t, 18, warmUpWorkers(32, p))
+
+	// Once warmed up, they run at full parallelism.
+	p = warmUpProgress(2*time.Minute, time.Minute)
+	require.Equal(t, 1.0, p)
+	require.Equal(t, 32, warmUpWorkers(32, p))
+	require.Zero(t, warmUpDelay(p, time.Second))
+}
+
+func TestFlushChunksCoverFlush(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	makeKVs := func(rows ...int64) []replicatedKV {
+		kvs := make([]replicatedKV, len(rows))
+		for i, row := range rows {
+			kvs[i] = replicatedKV{KeyValue: roachpb.KeyValue{
+				Key: encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], row),
+			}}
+		}
+		return kvs
+	}
+	chunks := func(kvs []replicatedKV, workers int) ([]int, error) {
+		// This matches the chunk size of flushBuffer with a batch size of 1.
+		return flushChunks(kvs, workers, max(len(kvs)/workers+1, 1), rowEnd, nil)
+	}
+
+	// An empty flush has no chunks.
+	ends, err := chunks(nil, 4)
+	require.NoError(t, err)
+	require.Empty(t, ends)
+
+	// Distinct rows are split evenly, with the last chunk taking the rest.
+	ends, err = chunks(makeKVs(0, 1, 2, 3, 4, 5, 6, 7, 8, 9), 3)
+	require.NoError(t, err)
+	require.Equal(t, []int{4, 8, 10}, ends)
+
+	// A run of versions of the same row longer than every chunk is applied by
+	// a single worker.
+	ends, err = chunks(makeKVs(7, 7, 7, 7, 7, 7, 7, 7), 4)
+	require.NoError(t, err)
+	require.Equal(t, []int{8}, ends)
+
+	// A run that spans several chunks leaves the remaining workers less to
+	// apply.
+	ends, err = chunks(makeKVs(1, 2, 2, 2, 2, 2, 2, 3), 4)
+	require.NoError(t, err)
+	require.Equal(t, []int{7, 8}, ends)
+
+	// More workers than KVs.
+	ends, err = chunks(makeKVs(1, 2), 8)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, ends)
+
+	// Chunks that don't cover the flush, or that extend past it, are reported
+	// as errors rather than leaving KVs unapplied.
+	kvs := makeKVs(0, 1, 2, 3, 4, 5)
+	_, err = flushChunks(kvs, 2, 1, rowEnd, nil)
+	require.ErrorContains(t, err, "only cover 2 of them")
+	_, err = flushChunks(kvs, 2, 4, rowEnd, func(_, chunkEnd int) int { return chunkEnd + 10 })
+	require.ErrorContains(t, err, "past the end")
+}
+
+func TestFlushBufferCollapsesKeys(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	prefix := keys.SystemSQLCodec.IndexPrefix(104, 1)
+	makeKV := func(row int64, wallTime int64, value string) replicatedKV {
+		kv := replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   encoding.EncodeVarintAscending(prefix[:len(prefix):len(prefix)], row),
+			Value: roachpb.MakeValueFromString(value),
+		}}
+		kv.Value.Timestamp = hlc.Timestamp{WallTime: wallTime}
+		return kv
+	}
+	valuesOf := func(kvs []replicatedKV) []string {
+		var res []string
+		for _, kv := range kvs {
+			v, err := kv.Value.GetBytes()
+			require.NoError(t, err)
+			res = append(res, string(v))
+		}
+		return res
+	}
+
+	// Versions arrive out of order; only the latest of each key is retained.
+	collapsed, n := collapseKVs([]replicatedKV{
+		makeKV(2, 3, "b3"), makeKV(1, 2, "a2"), makeKV(2, 1, "b1"), makeKV(1, 5, "a5"), makeKV(3, 4, "c4"),
+	})
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"a5", "b3", "c4"}, valuesOf(collapsed))
+
+	// Flushes in collapse mode only apply the retained versions.
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	flushBatchSize.Override(ctx, &st.SV, 100)
+	h := &recordingBatchHandler{}
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{metrics: m, bh: []BatchHandler{h}}
+	lrw.EvalCtx = &eval.Context{Settings: st}
+	lrw.spec.Options.Collapse = true
+
+	b := NewIngestionBuffer()
+	for i := int64(1); i <= 4; i++ {
+		b.addKV(makeKV(1, i, "a"))
+		b.addKV(makeKV(2, i, "b"))
+	}
+	_, err := lrw.flushBuffer(flushableBuffer{buffer: b, checkpoint: &jobspb.ResolvedSpans{}})
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, h.batchLens)
+	require.Equal(t, int64(6), m.CollapsedUpdates.Count())
+	// The flush is counted as applying the retained versions.
+	require.Equal(t, int64(2), m.IngestedEvents.Count())
+	require.Equal(t, int64(2), lrw.debug.GetStats().Flushes.KVs)
+}
+
+func TestFallbackAddressesExcludePartitionAddress(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	topology := streamclient.Topology{Partitions: []streamclient.PartitionInfo{
+		{ID: "1", SrcAddr: "postgres://n1"},
+		{ID: "2", SrcAddr: "postgres://n2"},
+		{ID: "3", SrcAddr: "postgres://n3"},
+		{ID: "4", SrcAddr: "postgres://n2"},
+	}}
+	require.Equal(t, []string{"postgres://n1", "postgres://n3"}, fallbackAddresses(topology, "postgres://n2"))
+	require.Empty(t, fallbackAddresses(streamclient.Topology{Partitions: topology.Partitions[:1]}, "postgres://n1"))
+}
+
+func TestMaybeLogSlowFlushComparesWithMedian(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	require.False(t, isSlowFlush(time.Second, 0, 10))
+	require.False(t, isSlowFlush(time.Second, time.Millisecond, 0))
+	require.False(t, isSlowFlush(10*time.Millisecond, time.Millisecond, 10))
+	require.True(t, isSlowFlush(11*time.Millisecond, time.Millisecond, 10))
+
+	ctx := context.Background()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics: m,
+		flushLatency: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationFlushHistNanos,
+			Duration:     time.Minute,
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+	workers := []flushWorkerStats{{kvs: 10, batches: 1, elapsed: time.Second, slowestBatch: time.Second}}
+
+	// Flushes aren't compared until enough have been recorded.
+	lrw.maybeLogSlowFlush(ctx, nil /* sp */, time.Second, 10, workers)
+	for i := 1; i < minFlushesForSlowFlush; i++ {
+		lrw.maybeLogSlowFlush(ctx, nil /* sp */, 10*time.Millisecond, 10, workers)
+	}
+	require.Zero(t, m.SlowFlushes.Count())
+
+	lrw.maybeLogSlowFlush(ctx, nil /* sp */, 30*time.Millisecond, 10, workers)
+	require.Zero(t, m.SlowFlushes.Count())
+	lrw.maybeLogSlowFlush(ctx, nil /* sp */, time.Second, 10, workers)
+	require.Equal(t, int64(1), m.SlowFlushes.Count())
+
+	require.Contains(t, formatFlushWorkerStats(workers), "worker 0: 10 KVs in 1 batches with 0 retries in 1s")
+}
+
+// hotKeyBatchHandler is a BatchHandler whose transactions that write the hot
+// key keep being retried until they exhaust their retry budget.
+type hotKeyBatchHandler struct {
+	hot     roachpb.Key
+	applied []roachpb.Key
+}
+
+func (h *hotKeyBatchHandler) HandleBatch(
+	_ context.Context, batch []replicatedKV,
+) (batchStats, error) {
+	for _, kv := range batch {
+		if kv.Key.Equal(h.hot) {
+			return batchStats{retries: 3}, errors.Wrapf(errRetryBudgetExhausted, "transaction retried %d times", 3)
+		}
+	}
+	for _, kv := range batch {
+		h.applied = append(h.applied, kv.Key)
+	}
+	return batchStats{}, nil
+}
+
+func TestApplyBatchSendsHotRowsToDLQOnceRetryBudgetIsExhausted(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	st := cluster.MakeTestingClusterSettings()
+	require.Equal(t, -1, retryBudget(&st.SV))
+	maxBatchRetries.Override(context.Background(), &st.SV, 3)
+	require.Equal(t, 3, retryBudget(&st.SV))
+
+	ctx := context.Background()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	dlq := &recordingDeadLetterQueueClient{}
+	lrw := &logicalReplicationWriterProcessor{metrics: m, dlqClient: dlq}
+	batch := []replicatedKV{
+		{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}},
+		{KeyValue: roachpb.KeyValue{Key: roachpb.Key("b")}},
+	}
+	// Only the row writing the hot key is sent to the dead letter queue, once
+	// the batch is split down to it.
+	bh := &hotKeyBatchHandler{hot: roachpb.Key("b")}
+	stats, err := lrw.applyBatch(ctx, bh, batch, rowEnd)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.retries)
+	require.Equal(t, []roachpb.Key{roachpb.Key("a")}, bh.applied)
+	require.Equal(t, []roachpb.Key{roachpb.Key("b")}, dlq.rows)
+	require.Equal(t, int64(2), m.RetryBudgetExhausted.Count())
+	require.Equal(t, int64(1), m.DLQedRows.Count())
+}
+
+func TestRecentFlushesRetainsLastFlushes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	srcCodec := keys.MakeSQLCodec(roachpb.MustMakeTenantID(10))
+	kv := func(table descpb.ID, pk int64, ts int64) replicatedKV {
+		key := encoding.EncodeVarintAscending(srcCodec.IndexPrefix(uint32(table), 1), pk)
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   keys.MakeFamilyKey(key, 0),
+			Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: ts}},
+		}}
+	}
+
+	// A flush is summarized as the span of keys of each table it applied KVs
+	// to, in key order.
+	workers := []flushWorkerStats{
+		{kvs: 3, batches: 2, retries: 1, slowestBatch: 3 * time.Millisecond},
+		{kvs: 1, batches: 1, retries: 2, slowestBatch: 7 * time.Millisecond},
+	}
+	s := summarizeFlush([]replicatedKV{kv(105, 3, 20), kv(104, 1, 30), kv(105, 1, 10), kv(105, 2, 40)},
+		timeutil.Unix(1, 0), time.Second, 400, workers)
+	require.Equal(t, time.Second.Nanoseconds(), s.Nanos)
+	// The flush's stats sum those of its workers.
+	require.Equal(t, int64(4), s.KVs)
+	require.Equal(t, int64(400), s.Bytes)
+	require.Equal(t, int64(3), s.Batches)
+	require.Equal(t, int64(2), s.Workers)
+	require.Equal(t, int64(3), s.Retries)
+	require.Equal(t, (7 * time.Millisecond).Nanoseconds(), s.SlowestBatchNanos)
+	require.Len(t, s.Spans, 2)
+	require.Equal(t, 1, s.Spans[0].KVs)
+	require.Equal(t, kv(104, 1, 0).Key, s.Spans[0].Span.Key)
+	require.Equal(t, 3, s.Spans[1].KVs)
+	require.Equal(t, roachpb.Span{Key: kv(105, 1, 0).Key, EndKey: kv(105, 3, 0).Key.Next()}, s.Spans[1].Span)
+	require.Equal(t, hlc.Timestamp{WallTime: 10}, s.Spans[1].MinTimestamp)
+	require.Equal(t, hlc.Timestamp{WallTime: 40}, s.Spans[1].MaxTimestamp)
+
+	// Only the last flushes are retained, newest first.
+	var r recentFlushes
+	for i := int64(1); i <= 5; i++ {
+		r.record(summarizeFlush([]replicatedKV{kv(descpb.ID(100+i), 1, i)}, timeutil.Unix(i, 0), 0, 0, nil), 3)
+	}
+	started := func(flushes []streampb.DebugFlushSummary) []int64 {
+		var res []int64
+		for _, f := range flushes {
+			res = append(res, f.StartedUnixMicros/1e6)
+		}
+		return res
+	}
+	require.Equal(t, []int64{5, 4, 3}, started(r.overlapping(roachpb.Span{})))
+
+	// Flushes can be looked up by the keys they applied KVs to.
+	row := kv(104, 1, 0).Key
+	require.Equal(t, []int64{4}, started(r.overlapping(roachpb.Span{Key: row, EndKey: row.Next()})))
+	require.Empty(t, r.overlapping(roachpb.Span{Key: kv(101, 1, 0).Key, EndKey: kv(101, 2, 0).Key}))
+
+	// Retaining fewer flushes evicts the oldest ones.
+	r.record(summarizeFlush([]replicatedKV{kv(106, 1, 6)}, timeutil.Unix(6, 0), 0, 0, nil), 2)
+	require.Equal(t, []int64{6, 5}, started(r.overlapping(roachpb.Span{})))
+	r.record(streampb.DebugFlushSummary{}, 0)
+	require.Empty(t, r.overlapping(roachpb.Span{}))
+}
+
+// hungSubscription is a subscription whose source sends the given events and
+// then hangs until its context is canceled.
+type hungSubscription struct {
+	events chan streamingccl.Event
+}
+
+func (s *hungSubscription) Subscribe(ctx context.Context) error {
+	defer close(s.events)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *hungSubscription) Events() <-chan streamingccl.Event { return s.events }
+
+func (s *hungSubscription) Err() error { return nil }
+
+func TestSubscribeHandshakeTimesOutHungSource(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{metrics: m}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+
+	// A subscription that never receives an event times out.
+	subscribeHandshakeTimeout.Override(ctx, &st.SV, time.Millisecond)
+	_, run := lrw.runSubscription(ctx, &hungSubscription{events: make(chan streamingccl.Event)})
+	err := run()
+	require.ErrorIs(t, err, errSubscribeHandshakeTimeout)
+	require.Equal(t, int64(1), m.SubscribeHandshakeTimeouts.Count())
+
+	// Once a subscription receives an event, it no longer times out, however
+	// long it then waits for the next one.
+	subscribeHandshakeTimeout.Override(ctx, &st.SV, 100*time.Millisecond)
+	subCtx, cancel := context.WithCancel(ctx)
+	deadline, run := lrw.runSubscription(subCtx, &hungSubscription{events: make(chan streamingccl.Event)})
+	deadline.received()
+	time.AfterFunc(200*time.Millisecond, cancel)
+	err = run()
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotErrorIs(t, err, errSubscribeHandshakeTimeout)
+	require.Equal(t, int64(1), m.SubscribeHandshakeTimeouts.Count())
+
+	// Without a timeout, a subscription is only bounded by its context.
+	subscribeHandshakeTimeout.Override(ctx, &st.SV, 0)
+	subCtx, cancel = context.WithCancel(ctx)
+	cancel()
+	_, run = lrw.runSubscription(subCtx, &hungSubscription{events: make(chan streamingccl.Event)})
+	require.ErrorIs(t, run(), context.Canceled)
+}
+
+func TestCheckpointWindowEmitsOneCheckpointPerInterval(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	start := timeutil.Unix(100, 0)
+	checkpoint := func(wallTime int64) *jobspb.ResolvedSpans {
+		return &jobspb.ResolvedSpans{ResolvedSpans: []jobspb.ResolvedSpan{
+			{Span: roachpb.Span{Key: roachpb.Key("a"), EndKey: roachpb.Key("b")}, Timestamp: hlc.Timestamp{WallTime: wallTime}},
+		}}
+	}
+
+	// Without an interval, every checkpoint is emitted.
+	var w checkpointWindow
+	require.Equal(t, checkpoint(1), w.add(checkpoint(1), start, 0))
+	require.Nil(t, w.pending)
+
+	// The first checkpoint is emitted and the next ones within the interval
+	// are held, each superseding the previous one.
+	w = checkpointWindow{}
+	require.Equal(t, checkpoint(1), w.add(checkpoint(1), start, time.Second))
+	require.Nil(t, w.add(checkpoint(2), start.Add(100*time.Millisecond), time.Second))
+	require.Nil(t, w.add(checkpoint(3), start.Add(200*time.Millisecond), time.Second))
+	require.Equal(t, 800*time.Millisecond, w.wait(start.Add(200*time.Millisecond), time.Second))
+	require.Equal(t, checkpoint(3), w.take(start.Add(time.Second)))
+	require.Nil(t, w.take(start.Add(time.Second)))
+
+	// A checkpoint after the interval is emitted right away, as is the final
+	// checkpoint of a stream.
+	require.Equal(t, checkpoint(4), w.add(checkpoint(4), start.Add(2*time.Second), time.Second))
+	final := checkpoint(5)
+	final.Complete = true
+	require.Equal(t, final, w.add(final, start.Add(2*time.Second), time.Second))
+}
+
+func TestFutureKVRejectsKVsTooFarAhead(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	now := timeutil.Unix(1000, 0)
+	kvAt := func(key string, d time.Duration) roachpb.KeyValue {
+		kv := roachpb.KeyValue{Key: roachpb.Key(key)}
+		kv.Value.Timestamp = hlc.Timestamp{WallTime: now.Add(d).UnixNano()}
+		return kv
+	}
+
+	// KVs in the past or within the lead are accepted.
+	_, ok := futureKV([]roachpb.KeyValue{kvAt("a", -time.Hour), kvAt("b", time.Minute)}, now, time.Hour)
+	require.False(t, ok)
+
+	// The KV furthest ahead of the lead is returned.
+	kvs := []roachpb.KeyValue{kvAt("a", time.Minute), kvAt("b", 3*time.Hour), kvAt("c", 2*time.Hour)}
+	kv, ok := futureKV(kvs, now, time.Hour)
+	require.True(t, ok)
+	require.Equal(t, roachpb.Key("b"), kv.Key)
+
+	// Without a lead, no KV is rejected.
+	_, ok = futureKV(kvs, now, 0)
+	require.False(t, ok)
+}
+
+func TestFlushTablesLeavesOtherTablesBuffered(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	kvOf := func(tableID uint32, wallTime int64) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   keys.SystemSQLCodec.TablePrefix(tableID),
+			Value: roachpb.Value{RawBytes: make([]byte, 10), Timestamp: hlc.Timestamp{WallTime: wallTime}},
+		}}
+	}
+	sp := roachpb.Span{Key: keys.SystemSQLCodec.TablePrefix(104), EndKey: keys.SystemSQLCodec.TablePrefix(106)}
+	frontier, err := span.MakeFrontierAt(hlc.Timestamp{WallTime: 100}, sp)
+	require.NoError(t, err)
+	lrw := &logicalReplicationWriterProcessor{
+		metrics:  MakeMetrics(time.Minute).(*Metrics),
+		buffer:   NewIngestionBuffer(),
+		frontier: frontier,
+		flushCh:  make(chan flushableBuffer, 1),
+		stopCh:   make(chan struct{}),
+	}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: cluster.MakeTestingClusterSettings()}}
+	for _, kv := range []replicatedKV{kvOf(104, 50), kvOf(105, 30), kvOf(104, 60)} {
+		lrw.buffer.addKV(kv)
+	}
+
+	// Only the tables over the size threshold are due.
+	due, all := lrw.buffer.dueTables(timeutil.Now(), 0 /* interval */, int64(2*kvOf(104, 0).Size()))
+	require.Equal(t, map[descpb.ID]struct{}{104: {}}, due)
+	require.False(t, all)
+	_, all = lrw.buffer.dueTables(timeutil.Now(), time.Nanosecond, 0 /* size */)
+	require.True(t, all)
+
+	// The KVs of the other tables stay buffered and the checkpoint doesn't
+	// cover them.
+	require.NoError(t, lrw.flushTables(flushOnTable, due))
+	flushed := <-lrw.flushCh
+	require.Len(t, flushed.buffer.curKVBatch, 2)
+	require.Len(t, lrw.buffer.curKVBatch, 1)
+	require.Equal(t, 1, lrw.buffer.tables[105].kvs)
+	require.Len(t, flushed.checkpoint.ResolvedSpans, 1)
+	require.Equal(t, hlc.Timestamp{WallTime: 30}.Prev(), flushed.checkpoint.ResolvedSpans[0].Timestamp)
+	require.Equal(t, int64(1), lrw.metrics.FlushOnTable.Count())
+
+	// Once nothing is left buffered, the checkpoint covers the frontier.
+	require.NoError(t, lrw.flush(flushOnTime))
+	flushed = <-lrw.flushCh
+	require.Len(t, flushed.buffer.curKVBatch, 1)
+	require.Empty(t, lrw.buffer.tables)
+	require.Equal(t, hlc.Timestamp{WallTime: 100}, flushed.checkpoint.ResolvedSpans[0].Timestamp)
+
+	// The KVs that a source transaction wrote to other tables are flushed
+	// along with those of the given tables.
+	inTxn := func(kv replicatedKV, txnID string) replicatedKV {
+		kv.txnID = []byte(txnID)
+		return kv
+	}
+	for _, kv := range []replicatedKV{
+		inTxn(kvOf(104, 110), "t1"), inTxn(kvOf(105, 110), "t1"), inTxn(kvOf(105, 120), "t2"),
+	} {
+		lrw.buffer.addKV(kv)
+	}
+	require.NoError(t, lrw.flushTables(flushOnTable, map[descpb.ID]struct{}{104: {}}))
+	flushed = <-lrw.flushCh
+	require.Len(t, flushed.buffer.curKVBatch, 2)
+	for _, kv := range flushed.buffer.curKVBatch {
+		require.Equal(t, []byte("t1"), kv.txnID)
+	}
+	require.Len(t, lrw.buffer.curKVBatch, 1)
+	require.Equal(t, []byte("t2"), lrw.buffer.curKVBatch[0].txnID)
+}
+
+func TestRangeLimitedEndSplitsAtRangeBoundaries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ranges := []roachpb.RangeDescriptor{
+		{RangeID: 1, StartKey: roachpb.RKey("a"), EndKey: roachpb.RKey("c")},
+		{RangeID: 2, StartKey: roachpb.RKey("c"), EndKey: roachpb.RKey("e")},
+		{RangeID: 3, StartKey: roachpb.RKey("e"), EndKey: roachpb.RKey("x")},
+	}
+	// The keys of a KV are separated by slashes, e.g. a primary key and the
+	// keys of its secondary index entries.
+	rangeOf := func(kv replicatedKV) ([]roachpb.RangeID, bool) {
+		var res []roachpb.RangeID
+		for _, key := range strings.Split(string(kv.Key), "/") {
+			i := slices.IndexFunc(ranges, func(desc roachpb.RangeDescriptor) bool {
+				return desc.ContainsKey(roachpb.RKey(key))
+			})
+			if i < 0 {
+				return nil, false
+			}
+			res = append(res, ranges[i].RangeID)
+		}
+		return res, true
+	}
+	kvsOf := func(ks ...string) []replicatedKV {
+		kvs := make([]replicatedKV, len(ks))
+		for i, key := range ks {
+			kvs[i] = replicatedKV{KeyValue: roachpb.KeyValue{Key: roachpb.Key(key)}}
+		}
+		return kvs
+	}
+
+	kvs := kvsOf("a", "b", "c", "d", "e", "f")
+	// The batch ends at the first KV in a range beyond the limit.
+	require.Equal(t, 4, rangeLimitedEnd(kvs, 0, len(kvs), 2, rangeOf))
+	require.Equal(t, 2, rangeLimitedEnd(kvs, 0, len(kvs), 1, rangeOf))
+	require.Equal(t, 4, rangeLimitedEnd(kvs, 3, len(kvs), 1, rangeOf))
+	// Batches within the limit, or without one, aren't cut short.
+	require.Equal(t, len(kvs), rangeLimitedEnd(kvs, 0, len(kvs), 3, rangeOf))
+	require.Equal(t, len(kvs), rangeLimitedEnd(kvs, 0, len(kvs), 0, rangeOf))
+	// A KV whose range isn't known leaves the batch as is.
+	kvs = kvsOf("a", "c", "y", "e")
+	require.Equal(t, len(kvs), rangeLimitedEnd(kvs, 0, len(kvs), 2, rangeOf))
+	// A range is only counted once, even if its KVs aren't contiguous.
+	kvs = kvsOf("a", "c", "b", "d", "e")
+	require.Equal(t, 4, rangeLimitedEnd(kvs, 0, len(kvs), 2, rangeOf))
+	// The ranges of a KV's secondary index entries count as well.
+	kvs = kvsOf("a/f", "b/c", "c")
+	require.Equal(t, 1, rangeLimitedEnd(kvs, 0, len(kvs), 2, rangeOf))
+	require.Equal(t, len(kvs), rangeLimitedEnd(kvs, 1, len(kvs), 2, rangeOf))
+	// A KV whose keys span more ranges than the limit is applied on its own.
+	require.Equal(t, 1, rangeLimitedEnd(kvs, 0, len(kvs), 1, rangeOf))
+}
+
+func TestSplitScanSpansGroupsContiguousSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	spanOf := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	spans := []roachpb.Span{
+		spanOf("e", "f"), spanOf("a", "b"), spanOf("c", "d"), spanOf("g", "h"), spanOf("i", "j"),
+	}
+
+	// The spans are sorted and split into groups of about the same size.
+	require.Equal(t, [][]roachpb.Span{
+		{spanOf("a", "b"), spanOf("c", "d")},
+		{spanOf("e", "f"), spanOf("g", "h"), spanOf("i", "j")},
+	}, splitScanSpans(spans, 2))
+	// Fewer spans than groups are split in key space.
+	groups := splitScanSpans(spans, 8)
+	require.Len(t, groups, 8)
+	var covered roachpb.SpanGroup
+	for _, g := range groups {
+		covered.Add(g...)
+	}
+	require.Equal(t, roachpb.Spans{
+		spanOf("a", "b"), spanOf("c", "d"), spanOf("e", "f"), spanOf("g", "h"), spanOf("i", "j"),
+	}, roachpb.Spans(covered.Slice()))
+	// A single span is split into as many parts as there are groups.
+	table := roachpb.Span{Key: keys.SystemSQLCodec.TablePrefix(104), EndKey: keys.SystemSQLCodec.TablePrefix(105)}
+	groups = splitScanSpans([]roachpb.Span{table}, 4)
+	require.Len(t, groups, 4)
+	require.Equal(t, table.Key, groups[0][0].Key)
+	require.Equal(t, table.EndKey, groups[3][0].EndKey)
+	for i := 1; i < len(groups); i++ {
+		require.Equal(t, groups[i-1][0].EndKey, groups[i][0].Key)
+		require.True(t, groups[i][0].Valid())
+	}
+	// Spans that can't be split into two groups aren't split.
+	require.Nil(t, splitScanSpans(spans, 1))
+	require.Nil(t, splitScanSpans([]roachpb.Span{spanOf("a", "a\x00")}, 4))
+	// The spans passed in are left as is.
+	require.Equal(t, spanOf("e", "f"), spans[0])
+}
+
+func TestGapDetectorSuspectsSpansWhoseResolvedTimestampTrailsKVs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	spanOf := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	kvAt := func(key string, wallTime int64) roachpb.KeyValue {
+		return roachpb.KeyValue{Key: roachpb.Key(key), Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: wallTime}}}
+	}
+	spans := []roachpb.Span{spanOf("m", "z"), spanOf("a", "m")}
+	frontier, err := span.MakeFrontier(spans...)
+	require.NoError(t, err)
+	defer frontier.Release()
+	g := makeGapDetector(spans)
+	const threshold = 10 * time.Nanosecond
+	start := timeutil.Unix(0, 0)
+	at := func(nanos int) time.Time { return start.Add(time.Duration(nanos)) }
+
+	// Spans whose initial scan hasn't completed aren't checked.
+	g.observe([]roachpb.KeyValue{kvAt("b", 100), kvAt("n", 100)})
+	require.Empty(t, g.check(frontier, threshold, at(0)))
+	require.Empty(t, g.check(frontier, threshold, at(100)))
+
+	// Both spans are resolved close to their KVs.
+	_, err = frontier.Forward(spanOf("a", "z"), hlc.Timestamp{WallTime: 95})
+	require.NoError(t, err)
+	require.Empty(t, g.check(frontier, threshold, at(100)))
+
+	// The source keeps writing to both spans, but only the first is resolved.
+	g.observe([]roachpb.KeyValue{kvAt("c", 200), kvAt("p", 200), kvAt("zz", 500)})
+	_, err = frontier.Forward(spanOf("a", "m"), hlc.Timestamp{WallTime: 200})
+	require.NoError(t, err)
+	require.Equal(t, []spanGap{{
+		span:         spanOf("m", "z"),
+		resolved:     hlc.Timestamp{WallTime: 95},
+		newest:       hlc.Timestamp{WallTime: 200},
+		lastAdvanced: at(100),
+		suspected:    true,
+	}}, g.check(frontier, threshold, at(101)))
+	// A span is only reported when it becomes suspected.
+	require.Empty(t, g.check(frontier, threshold, at(102)))
+
+	// Once the span's resolved timestamp catches up, it's no longer suspected.
+	_, err = frontier.Forward(spanOf("m", "z"), hlc.Timestamp{WallTime: 200})
+	require.NoError(t, err)
+	gaps := g.check(frontier, threshold, at(103))
+	require.Len(t, gaps, 1)
+	require.False(t, gaps[0].suspected)
+
+	// A span that goes silent, receiving neither KVs nor checkpoints, is
+	// suspected once its resolved timestamp hasn't advanced for longer than the
+	// threshold, while the other span is still checkpointed.
+	_, err = frontier.Forward(spanOf("a", "m"), hlc.Timestamp{WallTime: 300})
+	require.NoError(t, err)
+	require.Empty(t, g.check(frontier, threshold, at(110)))
+	_, err = frontier.Forward(spanOf("a", "m"), hlc.Timestamp{WallTime: 400})
+	require.NoError(t, err)
+	require.Equal(t, []spanGap{{
+		span:         spanOf("m", "z"),
+		resolved:     hlc.Timestamp{WallTime: 200},
+		newest:       hlc.Timestamp{WallTime: 200},
+		lastAdvanced: at(103),
+		silent:       true,
+		suspected:    true,
+	}}, g.check(frontier, threshold, at(120)))
+
+	// Once the span is checkpointed again, it's no longer suspected.
+	_, err = frontier.Forward(spanOf("m", "z"), hlc.Timestamp{WallTime: 400})
+	require.NoError(t, err)
+	gaps = g.check(frontier, threshold, at(121))
+	require.Len(t, gaps, 1)
+	require.False(t, gaps[0].suspected)
+}
+
+func TestAdaptiveQuantizationTracksFrontierSpans(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	quantize.Override(ctx, &st.SV, time.Second)
+	maxQuantize.Override(ctx, &st.SV, 4*time.Second)
+
+	// Without a target, the granularity is fixed.
+	var q adaptiveQuantization
+	now := timeutil.Now()
+	q.adapt(&st.SV, 1000, now)
+	require.Equal(t, time.Second, q.granularity(&st.SV))
+
+	quantizeTargetSpans.Override(ctx, &st.SV, 100)
+	// A fragmented frontier coarsens the granularity, at most once per its
+	// current value, up to the maximum.
+	q.adapt(&st.SV, 1000, now)
+	require.Equal(t, 2*time.Second, q.granularity(&st.SV))
+	q.adapt(&st.SV, 1000, now.Add(time.Second))
+	require.Equal(t, 2*time.Second, q.granularity(&st.SV))
+	now = now.Add(2 * time.Second)
+	q.adapt(&st.SV, 1000, now)
+	require.Equal(t, 4*time.Second, q.granularity(&st.SV))
+	now = now.Add(4 * time.Second)
+	q.adapt(&st.SV, 1000, now)
+	require.Equal(t, 4*time.Second, q.granularity(&st.SV))
+
+	// A frontier near the target keeps the granularity.
+	now = now.Add(4 * time.Second)
+	q.adapt(&st.SV, 80, now)
+	require.Equal(t, 4*time.Second, q.granularity(&st.SV))
+
+	// A compact frontier refines it down to timestamp_granularity.
+	q.adapt(&st.SV, 10, now)
+	require.Equal(t, 2*time.Second, q.granularity(&st.SV))
+	now = now.Add(2 * time.Second)
+	q.adapt(&st.SV, 10, now)
+	require.Equal(t, time.Second, q.granularity(&st.SV))
+	now = now.Add(time.Second)
+	q.adapt(&st.SV, 10, now)
+	require.Equal(t, time.Second, q.granularity(&st.SV))
+}
+
+// reversingKMS is a cloud.KMS that "encrypts" data by reversing it.
+type reversingKMS struct{}
+
+func (reversingKMS) MasterKeyID() string { return "reversing" }
+
+func (reversingKMS) Encrypt(_ context.Context, data []byte) ([]byte, error) {
+	res := make([]byte, len(data))
+	for i, b := range data {
+		res[len(data)-1-i] = b
+	}
+	return res, nil
+}
+
+func (k reversingKMS) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	return k.Encrypt(ctx, data)
+}
+
+func (reversingKMS) Close() error { return nil }
+
+func TestArtifactEncryptionRoundTrips(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	enc, err := newArtifactEncryption(ctx, reversingKMS{})
+	require.NoError(t, err)
+
+	plaintext := []byte("row with key /Table/104/1/1/0: sensitive value")
+	encrypted, err := enc.encrypt(plaintext)
+	require.NoError(t, err)
+	require.NotContains(t, string(encrypted), "sensitive value")
+	require.NotContains(t, string(encrypted), string(enc.dataKey))
+
+	decrypted, err := decryptArtifact(ctx, reversingKMS{}, encrypted, mon.NewStandaloneUnlimitedAccount())
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	_, err = decryptArtifact(ctx, reversingKMS{}, encrypted[:1], mon.NewStandaloneUnlimitedAccount())
+	require.Error(t, err)
+}
+
+func TestIOPacerTracksOverloadScore(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var score float64
+	p := ioPacer{factor: 1, overloadScore: func(storeID roachpb.StoreID) (float64, bool) {
+		// Only the first store's score is known.
+		return score, storeID == 1
+	}}
+	stores := []roachpb.StoreID{1, 2}
+	lastFlush := time.Second
+
+	// A store with few L0 files and sublevels doesn't slow flushes down.
+	score = 0.2
+	require.Zero(t, p.pace(0.8, stores, lastFlush))
+	require.Equal(t, 0.2, p.score)
+
+	// The pause grows while the score stays high, up to the maximum factor.
+	score = 1.5
+	for _, expected := range []time.Duration{1, 3, 7, 15, 15} {
+		require.Equal(t, expected*time.Second, p.pace(0.8, stores, lastFlush))
+		require.Equal(t, 1.5, p.score)
+	}
+
+	// And shrinks once compactions catch up.
+	score = 0.2
+	for _, expected := range []time.Duration{7, 3, 1, 0, 0} {
+		require.Equal(t, expected*time.Second, p.pace(0.8, stores, lastFlush))
+	}
+
+	// Pacing can be disabled.
+	score = 1.5
+	require.Zero(t, p.pace(0, stores, lastFlush))
+	require.Equal(t, 1.0, p.factor)
+
+	// Flushes aren't paced if the stores they write to are unknown.
+	require.Zero(t, p.pace(0.8, []roachpb.StoreID{2}, lastFlush))
+	require.Zero(t, p.pace(0.8, nil, lastFlush))
+
+	// Or if stores can't be observed.
+	p = ioPacer{factor: 1}
+	require.Zero(t, p.pace(0.8, stores, lastFlush))
+}
+
+func TestApplyBatchRetriesDescriptorLeaseFailures(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	lrw := &logicalReplicationWriterProcessor{metrics: m, stopCh: make(chan struct{})}
+	lrw.FlowCtx = &execinfra.FlowCtx{Cfg: &execinfra.ServerConfig{Settings: st}}
+	batch := []replicatedKV{{KeyValue: roachpb.KeyValue{Key: roachpb.Key("a")}}}
+	leaseErr := catalog.NewInactiveDescriptorError(errors.New(`relation "tab" is offline: importing`))
+
+	// The batch is retried until its destination table can be leased.
+	h := &flakyBatchHandler{failures: 2, err: leaseErr}
+	_, err := lrw.applyBatch(ctx, h, batch, rowEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), m.LeaseRetries.Count())
+
+	// Other errors aren't retried.
+	h.failures, h.err = 1, errors.New("boom")
+	_, err = lrw.applyBatch(ctx, h, batch, rowEnd)
+	require.ErrorContains(t, err, "boom")
+	require.Equal(t, int64(2), m.LeaseRetries.Count())
+
+	// Once the retry period elapses, the job is paused.
+	descriptorLeaseRetryPeriod.Override(ctx, &st.SV, 0)
+	h.failures, h.err = 1, leaseErr
+	_, err = lrw.applyBatch(ctx, h, batch, rowEnd)
+	require.True(t, jobs.IsPermanentJobError(err))
+	require.ErrorContains(t, err, "cannot acquire descriptor lease")
+	require.ErrorContains(t, err, "is offline: importing")
+}
+
+func TestLaggardPartitionsHoldBackReplicatedTime(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	sp := func(start, end string) roachpb.Span {
+		return roachpb.Span{Key: roachpb.Key(start), EndKey: roachpb.Key(end)}
+	}
+	partitions := map[string][]roachpb.Span{
+		"1": {sp("a", "c")},
+		"2": {sp("c", "e"), sp("g", "h")},
+		"3": {sp("e", "g")},
+	}
+	frontier, err := span.MakeFrontier(sp("a", "c"), sp("c", "e"), sp("e", "g"), sp("g", "h"))
+	require.NoError(t, err)
+	defer frontier.Release()
+
+	// No partition holds back the initial scan.
+	require.Empty(t, laggardPartitions(frontier, partitions))
+
+	for _, rs := range []jobspb.ResolvedSpan{
+		{Span: sp("a", "c"), Timestamp: hlc.Timestamp{WallTime: 20}},
+		{Span: sp("c", "e"), Timestamp: hlc.Timestamp{WallTime: 30}},
+		{Span: sp("e", "g"), Timestamp: hlc.Timestamp{WallTime: 20}},
+		{Span: sp("g", "h"), Timestamp: hlc.Timestamp{WallTime: 30}},
+	} {
+		_, err := frontier.Forward(rs.Span, rs.Timestamp)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"1", "3"}, laggardPartitions(frontier, partitions))
+
+	// A partition holds back the replicated time if any of its spans does,
+	// even if its frontier as a whole isn't behind the other partitions'.
+	_, err = frontier.Forward(sp("a", "c"), hlc.Timestamp{WallTime: 40})
+	require.NoError(t, err)
+	_, err = frontier.Forward(sp("e", "g"), hlc.Timestamp{WallTime: 40})
+	require.NoError(t, err)
+	_, err = frontier.Forward(sp("c", "e"), hlc.Timestamp{WallTime: 40})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, laggardPartitions(frontier, partitions))
+}
+
+func TestProgressWatchdogDetectsStalls(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var w progressWatchdog
+	start := timeutil.Now()
+	w.init(start)
+
+	// Stalls aren't detected if the timeout is 0.
+	require.NoError(t, w.check(start.Add(time.Hour), 0, true /* flushing */))
+
+	// Any progress signal defers the stall.
+	require.NoError(t, w.check(start.Add(time.Minute-time.Second), time.Minute, true /* flushing */))
+	for _, record := range []func(time.Time){w.recordFlush, w.recordFrontierAdvance, w.recordWait} {
+		now := start.Add(time.Minute)
+		record(now)
+		require.NoError(t, w.check(now.Add(time.Minute-time.Second), time.Minute, true /* flushing */))
+		start = now
+	}
+
+	// Without progress for the timeout, the processor is stalled while a
+	// flush is in progress or events keep arriving.
+	err := w.check(start.Add(time.Minute), time.Minute, true /* flushing */)
+	require.ErrorContains(t, err, "stalled, no progress")
+	w.recordRecv(start.Add(time.Minute))
+	err = w.check(start.Add(time.Minute), time.Minute, false /* flushing */)
+	require.ErrorContains(t, err, "stalled, no progress")
+
+	// A processor whose source is silent isn't stalled.
+	require.NoError(t, w.check(start.Add(2*time.Minute), time.Minute, false /* flushing */))
+}
@@ -15,21 +15,41 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
 	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/changefeedbase"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
 	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
 	"github.com/cockroachdb/cockroach/pkg/keys"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
 	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
 	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
 	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
 	"github.com/cockroachdb/cockroach/pkg/sql/parser"
 	"github.com/cockroachdb/cockroach/pkg/sql/parser/statements"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
 	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
+	"github.com/lib/pq/oid"
+)
+
+var traceKeyMappingRate = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.trace_key_mapping_sample_rate",
+	"the fraction of applied rows, selected by hashing their keys, for which the destination key "+
+		"their source key maps to is recorded in verbose traces of the apply; if 0, no mappings are recorded",
+	0,
+	settings.FloatInRange(0, 1),
 )
 
 // sqlLastWriteWinsRowProcessor is a row processor that implements partial
@@ -51,11 +71,113 @@ import (
 type sqlLastWriteWinsRowProcessor struct {
 	decoder     cdcevent.Decoder
 	queryBuffer queryBuffer
+
+	// applied, if set, verifies that the timestamps of the KVs applied to each
+	// key never go backwards.
+	applied *appliedTimestamps
+
+	// ignoreDeletes holds the IDs of the tables whose deletes are dropped
+	// rather than applied, leaving the destination intentionally divergent
+	// from the source.
+	ignoreDeletes map[catid.DescID]struct{}
+	metrics       *Metrics
+	settings      *cluster.Settings
+
+	// destIndexPrefixes maps the ID of each source table to the primary index
+	// prefix of its destination table. It is only used to trace the mapping of
+	// source keys to destination keys and may be nil.
+	destIndexPrefixes map[descpb.ID]roachpb.Key
+	// secondaryIndexTables maps the IDs of the source tables whose destination
+	// tables have secondary indexes to those destination tables, whose index
+	// keys are encoded with codec. It is only used to find the ranges a batch
+	// writes and may be nil.
+	secondaryIndexTables map[descpb.ID]catalog.TableDescriptor
+	codec                keys.SQLCodec
+
+	// prefetched maps the primary keys of the rows read by PrefetchRows to the
+	// last-write-wins timestamps of their destination rows, or to nil if the
+	// destination row doesn't exist. It is nil if no rows are prefetched.
+	prefetched map[string]*tree.DDecimal
+	// skippedWrites is the number of writes skipped using the prefetched rows.
+	skippedWrites int
+
+	// losses holds the rows handed to ProcessRow since the last ResetLosses
+	// that lost to newer destination rows under last-write-wins, and so
+	// weren't applied.
+	losses map[lwwLoss]struct{}
+
+	// droppedColumns maps the IDs of the source tables whose destination tables
+	// lack some of their columns to the names of those columns, whose values
+	// aren't applied.
+	droppedColumns map[descpb.ID]map[string]struct{}
+	// droppedValues is the number of non-NULL values of those columns the
+	// current attempt at applying a batch didn't apply.
+	droppedValues int64
+
+	// destinationTypes maps the IDs of the source tables with columns of other
+	// types than those of their destination tables to the types of those
+	// destination columns, into which their values are re-encoded using
+	// evalCtx.
+	destinationTypes map[descpb.ID]map[string]*types.T
+	evalCtx          *eval.Context
+
+	// notNullColumns maps the IDs of the source tables with nullable columns
+	// their destination tables mark NOT NULL to the names of those columns.
+	notNullColumns map[descpb.ID]map[string]struct{}
+
+	// destinationOnlyColumns maps the IDs of the source tables whose
+	// destination tables have columns they lack to the names of those columns
+	// that can be reset to their DEFAULT.
+	destinationOnlyColumns map[descpb.ID][]string
+
+	// fanoutTables maps the IDs of the source tables with fan-out tables to
+	// the statements used to apply their rows to those tables.
+	fanoutTables map[descpb.ID][]fanoutQueries
+
+	// compareAndSwap, if set, only applies rows whose destination row matches
+	// their prior value at the source.
+	compareAndSwap bool
+
+	// softDelete, if set, applies deletes by setting the soft delete column of
+	// the destination row rather than deleting it.
+	softDelete bool
 }
 
+var _ rowPrefetcher = (*sqlLastWriteWinsRowProcessor)(nil)
+var _ lossTracker = (*sqlLastWriteWinsRowProcessor)(nil)
+var _ attemptMetrics = (*sqlLastWriteWinsRowProcessor)(nil)
+
 type queryBuffer struct {
+	tableNames    map[catid.DescID]string
 	deleteQueries map[catid.DescID]statements.Statement[tree.Statement]
-	insertQueries map[catid.DescID]map[catid.FamilyID]statements.Statement[tree.Statement]
+	// softDeleteQueries are the UPDATE statements used to apply deletes if the
+	// stream sets a soft delete column.
+	softDeleteQueries map[catid.DescID]statements.Statement[tree.Statement]
+	insertQueries     map[catid.DescID]map[catid.FamilyID]statements.Statement[tree.Statement]
+	// defaultedQueries are the insert statements used to apply rows without
+	// the NULLs of columns their destination tables mark NOT NULL, keyed by
+	// table ID, family ID and the names of the omitted columns. They are
+	// generated lazily as the set of omitted columns isn't known up front.
+	defaultedQueries map[string]statements.Statement[tree.Statement]
+	// casQueries are the statements used to apply rows of compare_and_swap
+	// streams, keyed by operation, table ID, family ID and the names of the
+	// written and compared columns. They are generated lazily like
+	// defaultedQueries.
+	casQueries map[string]statements.Statement[tree.Statement]
+	// softDeleteColumn is the stream's soft delete column, if any.
+	softDeleteColumn string
+	// batchedQueries are the statements used to apply rows if batched_apply is
+	// enabled, keyed by table ID, family ID, whether they delete, the number
+	// of rows and the names of the written columns. They are generated lazily
+	// like defaultedQueries.
+	batchedQueries map[string]statements.Statement[tree.Statement]
+	// auditRules are the audit rules of the tables that have audit columns.
+	auditRules map[catid.DescID]*auditRule
+	// regionRules are the region rules of the tables that have one.
+	regionRules map[catid.DescID]*regionRule
+	// tableDescs are the descriptors of the source tables, from which the
+	// queries are generated.
+	tableDescs map[catid.DescID]catalog.TableDescriptor
 }
 
 func makeSQLLastWriteWinsHandler(
@@ -63,22 +185,59 @@ func makeSQLLastWriteWinsHandler(
 	codec keys.SQLCodec,
 	settings *cluster.Settings,
 	tableDescs map[string]descpb.TableDescriptor,
+	options jobspb.LogicalReplicationDetails_Options,
+	sourceClusterID uuid.UUID,
+	applied *appliedTimestamps,
+	metrics *Metrics,
 ) (*sqlLastWriteWinsRowProcessor, error) {
 	descs := make(map[catid.DescID]catalog.TableDescriptor)
 	qb := queryBuffer{
-		deleteQueries: make(map[catid.DescID]statements.Statement[tree.Statement], len(tableDescs)),
-		insertQueries: make(map[catid.DescID]map[catid.FamilyID]statements.Statement[tree.Statement], len(tableDescs)),
+		tableNames:        make(map[catid.DescID]string, len(tableDescs)),
+		defaultedQueries:  make(map[string]statements.Statement[tree.Statement]),
+		casQueries:        make(map[string]statements.Statement[tree.Statement]),
+		batchedQueries:    make(map[string]statements.Statement[tree.Statement]),
+		deleteQueries:     make(map[catid.DescID]statements.Statement[tree.Statement], len(tableDescs)),
+		softDeleteQueries: make(map[catid.DescID]statements.Statement[tree.Statement]),
+		insertQueries:     make(map[catid.DescID]map[catid.FamilyID]statements.Statement[tree.Statement], len(tableDescs)),
+		auditRules:        make(map[catid.DescID]*auditRule),
+		regionRules:       make(map[catid.DescID]*regionRule),
+		tableDescs:        make(map[catid.DescID]catalog.TableDescriptor, len(tableDescs)),
+		softDeleteColumn:  options.SoftDeleteColumn,
 	}
 	cdcEventTargets := changefeedbase.Targets{}
 	var err error
 	for name, desc := range tableDescs {
+		if err := checkSourceEncodingSupported(name, &desc); err != nil {
+			return nil, err
+		}
 		td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
 		descs[desc.ID] = td
+		qb.tableNames[desc.ID] = name
 		qb.deleteQueries[desc.ID], err = parser.ParseOne(makeDeleteQuery(name, td))
 		if err != nil {
 			return nil, err
 		}
-		qb.insertQueries[desc.ID], err = makeInsertQueries(name, td)
+		qb.tableDescs[desc.ID] = td
+		rule, err := makeRegionRule(td, options, name)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			qb.regionRules[desc.ID] = rule
+		}
+		audit := makeAuditRule(td, options, name, sourceClusterID)
+		if audit != nil {
+			qb.auditRules[desc.ID] = audit
+		}
+		if options.SoftDeleteColumn != "" {
+			qb.softDeleteQueries[desc.ID], err = parser.ParseOne(
+				makeSoftDeleteQuery(name, td, options.SoftDeleteColumn, audit))
+			if err != nil {
+				return nil, err
+			}
+		}
+		qb.insertQueries[desc.ID], err = makeInsertQueries(name, td, rule, audit, nil, /* dropped */
+			nil /* reset */, options.SoftDeleteColumn)
 		if err != nil {
 			return nil, err
 		}
@@ -89,35 +248,292 @@ func makeSQLLastWriteWinsHandler(
 		})
 	}
 
+	ignoreDeletes := make(map[catid.DescID]struct{}, len(options.IgnoreDeletesTables))
+	for _, name := range options.IgnoreDeletesTables {
+		desc, ok := tableDescs[name]
+		if !ok {
+			return nil, errors.Newf("table %q whose deletes are ignored is not replicated", name)
+		}
+		ignoreDeletes[desc.ID] = struct{}{}
+	}
+
 	rfCache, err := cdcevent.NewFixedRowFetcherCache(ctx, codec, settings, cdcEventTargets, descs)
 	if err != nil {
 		return nil, err
 	}
 
 	return &sqlLastWriteWinsRowProcessor{
-		queryBuffer: qb,
-		decoder:     cdcevent.NewEventDecoderWithCache(ctx, rfCache, false, false),
+		queryBuffer:   qb,
+		decoder:       cdcevent.NewEventDecoderWithCache(ctx, rfCache, false, false),
+		applied:       applied,
+		ignoreDeletes: ignoreDeletes,
+		metrics:       metrics,
+		settings:      settings,
+
+		compareAndSwap: options.CompareAndSwap,
+		softDelete:     options.SoftDeleteColumn != "",
 	}, nil
 }
 
+// checkSourceEncodingSupported returns a permanent job error if the replicated
+// rows of the source table are encoded in a version that is newer than this
+// cluster can decode. Rows are decoded using the source table's descriptor and
+// written to the destination using SQL, so rows of sources running older
+// versions are converted to the destination's encoding on the way. Building
+// the descriptor upgrades older table and index format versions.
+func checkSourceEncodingSupported(name string, desc *descpb.TableDescriptor) error {
+	if desc.FormatVersion > descpb.InterleavedFormatVersion {
+		return jobs.MarkAsPermanentJobError(errors.Newf(
+			"source table %s uses table format version %d which is newer than the latest version %d "+
+				"supported by this cluster", name, desc.FormatVersion, descpb.InterleavedFormatVersion))
+	}
+	if v := desc.PrimaryIndex.Version; v > descpb.LatestIndexDescriptorVersion {
+		return jobs.MarkAsPermanentJobError(errors.Newf(
+			"source table %s encodes its primary index with version %d which is newer than the latest "+
+				"version %d supported by this cluster", name, v, descpb.LatestIndexDescriptorVersion))
+	}
+	return nil
+}
+
 func (lww *sqlLastWriteWinsRowProcessor) ProcessRow(
-	ctx context.Context, txn isql.Txn, kv roachpb.KeyValue,
+	ctx context.Context, txn isql.Txn, kv replicatedKV,
 ) error {
-	row, err := lww.decoder.DecodeKV(ctx, kv, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if sp := tracing.SpanFromContext(ctx); sp != nil && sp.IsVerbose() {
+		lww.traceKeyMapping(ctx, kv)
+	}
+	row, err := lww.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if err != nil {
+		return err
+	}
+	if lww.ignoresDelete(row) {
+		lww.metrics.IgnoredDeletes.Inc(1)
+		return nil
+	}
+	var key string
+	var existing *tree.DDecimal
+	prefetched := false
+	if lww.prefetched != nil {
+		keyDatums, err := keyColumnDatums(row)
+		if err != nil {
+			return err
+		}
+		key = prefetchKey(row.TableID, keyDatums)
+		existing, prefetched = lww.prefetched[key]
+	}
+	ts := eval.TimestampToDecimalDatum(row.MvccTimestamp)
+	applied := true
+	switch {
+	case lww.compareAndSwap:
+		err = lww.casWriteRow(ctx, txn, kv, row)
+	case prefetched && existing != nil && newerThan(existing, ts, row.IsDeleted()):
+		// The conditional write would be a no-op, so it isn't issued.
+		lww.skippedWrites++
+		applied = false
+	case row.IsDeleted() && lww.softDelete:
+		applied, err = lww.softDeleteRow(ctx, txn, row)
+	case row.IsDeleted():
+		applied, err = lww.deleteRow(ctx, txn, row)
+	default:
+		applied, err = lww.insertRow(ctx, txn, row)
+	}
 	if err != nil {
+		if isCheckViolation(err) {
+			return annotateCheckViolation(row, err)
+		}
+		if isNotNullViolation(err) {
+			return annotateNotNullViolation(row, err)
+		}
 		return err
 	}
-	if row.IsDeleted() {
-		return lww.deleteRow(ctx, txn, row)
+	if !applied {
+		lww.recordLoss(kv)
+	}
+	if len(lww.fanoutTables[row.TableID]) > 0 {
+		if err := lww.applyToFanoutTables(ctx, txn, row); err != nil {
+			return err
+		}
+	}
+	if prefetched {
+		// Keep the prefetched row up to date for later writes to the same key
+		// in the batch. The write was applied unless the row was newer.
+		if row.IsDeleted() && lww.softDelete {
+			if existing != nil && existing.Cmp(&ts.Decimal) < 0 {
+				lww.prefetched[key] = ts
+			}
+		} else if row.IsDeleted() {
+			if existing != nil && existing.Cmp(&ts.Decimal) < 0 {
+				lww.prefetched[key] = nil
+			}
+		} else if existing == nil || existing.Cmp(&ts.Decimal) <= 0 {
+			lww.prefetched[key] = ts
+		}
+	}
+	// A row that lost to a newer destination row wasn't applied, so its older
+	// timestamp isn't a regression.
+	if !applied || lww.applied == nil {
+		return nil
+	}
+	return lww.applied.record(ctx, kv)
+}
+
+// traceKeyMapping records the destination key that the source key of the row
+// maps to in the trace, if the row is part of the sample of rows whose mapping
+// is traced. The sample is the same on every processor, so the mapping of a
+// sampled row is traced whichever processor applies it.
+func (lww *sqlLastWriteWinsRowProcessor) traceKeyMapping(ctx context.Context, kv replicatedKV) {
+	rate := traceKeyMappingRate.Get(&lww.settings.SV)
+	if rate == 0 || !keySampled(kv.Key, rate, 0 /* seed */) {
+		return
+	}
+	if destKey, ok := mapDestinationKey(lww.destIndexPrefixes, kv); ok {
+		log.Eventf(ctx, "source key %s maps to destination key %s", kv.Key, destKey)
 	} else {
-		return lww.insertRow(ctx, txn, row)
+		log.Eventf(ctx, "source key %s maps to no destination key", kv.Key)
+	}
+}
+
+// ignoresDelete returns true if the row is a delete of a table whose deletes
+// are not applied.
+func (lww *sqlLastWriteWinsRowProcessor) ignoresDelete(row cdcevent.Row) bool {
+	if !row.IsDeleted() {
+		return false
+	}
+	_, ok := lww.ignoreDeletes[row.TableID]
+	return ok
+}
+
+// newerThan returns true if a destination row with the given last-write-wins
+// timestamp wins over a write at ts. Deletes only apply to strictly older rows
+// while other writes also apply to rows written at the same timestamp.
+func newerThan(existing, ts *tree.DDecimal, isDelete bool) bool {
+	c := existing.Cmp(&ts.Decimal)
+	return c > 0 || (c == 0 && isDelete)
+}
+
+// PrefetchRows implements the rowPrefetcher interface. It reads the
+// last-write-wins timestamps of the destination rows of all the batch's keys
+// using one query per table.
+func (lww *sqlLastWriteWinsRowProcessor) PrefetchRows(
+	ctx context.Context, txn isql.Txn, batch []replicatedKV,
+) (int, error) {
+	lww.prefetched = make(map[string]*tree.DDecimal, len(batch))
+	lww.skippedWrites = 0
+	keysByTable := make(map[catid.DescID][][]interface{})
+	descs := make(map[catid.DescID]catalog.TableDescriptor)
+	for _, kv := range batch {
+		row, err := lww.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+		if err != nil {
+			return 0, err
+		}
+		keyDatums, err := keyColumnDatums(row)
+		if err != nil {
+			return 0, err
+		}
+		key := prefetchKey(row.TableID, keyDatums)
+		if _, ok := lww.prefetched[key]; ok {
+			continue
+		}
+		// The row is absent unless the query below finds it.
+		lww.prefetched[key] = nil
+		keysByTable[row.TableID] = append(keysByTable[row.TableID], keyDatums)
+		descs[row.TableID] = row.TableDescriptor()
+	}
+
+	reads := 0
+	for tableID, keys := range keysByTable {
+		keyColumns := descs[tableID].TableDesc().PrimaryIndex.KeyColumnNames
+		quoted := make([]string, len(keyColumns))
+		for i, name := range keyColumns {
+			quoted[i] = tree.NameString(name)
+		}
+		var tuples strings.Builder
+		args := make([]interface{}, 0, len(keys)*len(keyColumns))
+		for i, keyDatums := range keys {
+			if i > 0 {
+				tuples.WriteString(", ")
+			}
+			tuples.WriteString("(")
+			for j, d := range keyDatums {
+				if j > 0 {
+					tuples.WriteString(", ")
+				}
+				args = append(args, d)
+				fmt.Fprintf(&tuples, "$%d", len(args))
+			}
+			tuples.WriteString(")")
+		}
+		query := fmt.Sprintf(`SELECT %[1]s, crdb_internal_mvcc_timestamp, crdb_internal_origin_timestamp
+FROM %[2]s WHERE (%[1]s) IN (%[3]s)`,
+			strings.Join(quoted, ", "), lww.queryBuffer.tableNames[tableID], tuples.String())
+		reads++
+		rows, err := txn.QueryBufferedEx(ctx, "replicated-prefetch", txn.KV(),
+			sessiondata.NoSessionDataOverride, query, args...)
+		if err != nil {
+			return reads, err
+		}
+		for _, r := range rows {
+			keyDatums := make([]interface{}, len(keyColumns))
+			for i := range keyDatums {
+				keyDatums[i] = r[i]
+			}
+			ts := r[len(keyColumns)+1]
+			if ts == tree.DNull {
+				ts = r[len(keyColumns)]
+			}
+			d := tree.MustBeDDecimal(ts)
+			lww.prefetched[prefetchKey(tableID, keyDatums)] = &d
+		}
+	}
+	return reads, nil
+}
+
+// ResetLosses implements the lossTracker interface.
+func (lww *sqlLastWriteWinsRowProcessor) ResetLosses() {
+	lww.losses = nil
+}
+
+// Lost implements the lossTracker interface.
+func (lww *sqlLastWriteWinsRowProcessor) Lost(kv replicatedKV) bool {
+	_, ok := lww.losses[lwwLoss{key: string(kv.Key), ts: kv.Value.Timestamp}]
+	return ok
+}
+
+// lwwLoss identifies a row that lost to a newer destination row.
+type lwwLoss struct {
+	key string
+	ts  hlc.Timestamp
+}
+
+func (lww *sqlLastWriteWinsRowProcessor) recordLoss(kv replicatedKV) {
+	if lww.losses == nil {
+		lww.losses = make(map[lwwLoss]struct{})
 	}
+	lww.losses[lwwLoss{key: string(kv.Key), ts: kv.Value.Timestamp}] = struct{}{}
+}
+
+// ClearPrefetched implements the rowPrefetcher interface.
+func (lww *sqlLastWriteWinsRowProcessor) ClearPrefetched() int {
+	skipped := lww.skippedWrites
+	lww.prefetched, lww.skippedWrites = nil, 0
+	return skipped
+}
+
+// prefetchKey returns the key of the row with the given primary key datums in
+// the prefetched rows.
+func prefetchKey(tableID catid.DescID, keyDatums []interface{}) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d", tableID)
+	for _, d := range keyDatums {
+		fmt.Fprintf(&b, "/%s", d)
+	}
+	return b.String()
 }
 
 func (lww *sqlLastWriteWinsRowProcessor) insertRow(
 	ctx context.Context, txn isql.Txn, row cdcevent.Row,
-) error {
+) (bool, error) {
 	datums := make([]interface{}, 0, len(row.EncDatums()))
+	var defaulted []string
 	err := row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
 		if col.Computed {
 			return nil
@@ -130,49 +546,131 @@ func (lww *sqlLastWriteWinsRowProcessor) insertRow(
 			}
 			return nil
 		}
+		if dropped, err := lww.droppedColumn(row.TableID, col.Name, d); err != nil || dropped {
+			return err
+		}
+		if lww.defaultsNullValue(row.TableID, col.Name, d) {
+			defaulted = append(defaulted, col.Name)
+			return nil
+		}
+		d, err := lww.destinationValue(ctx, row.TableID, col.Name, d)
+		if err != nil {
+			return err
+		}
 
 		datums = append(datums, d)
 		return nil
 	})
 	if err != nil {
-		return err
+		return false, err
 	}
 	datums = append(datums, eval.TimestampToDecimalDatum(row.MvccTimestamp))
 	insertQueriesForTable, ok := lww.queryBuffer.insertQueries[row.TableID]
 	if !ok {
-		return errors.Errorf("no pre-generated insert query for table %d", row.TableID)
+		return false, errors.Errorf("no pre-generated insert query for table %d", row.TableID)
 	}
 	insertQuery, ok := insertQueriesForTable[row.FamilyID]
 	if !ok {
-		return errors.Errorf("no pre-generated insert query for table %d column family %d", row.TableID, row.FamilyID)
+		return false, errors.Errorf("no pre-generated insert query for table %d column family %d", row.TableID, row.FamilyID)
 	}
-	if _, err := txn.ExecParsed(ctx, "replicated-insert", txn.KV(), insertQuery, datums...); err != nil {
-		log.Warningf(ctx, "replicated insert failed (query: %s): %s", insertQuery.SQL, err.Error())
-		return err
+	reset := lww.resetColumns(row.TableID)
+	if len(defaulted) > 0 {
+		lww.metrics.NotNullViolations.Inc(1)
 	}
-	return nil
+	if len(defaulted) > 0 || len(reset) > 0 {
+		insertQuery, err = lww.queryBuffer.defaultedInsertQuery(
+			row.TableID, row.FamilyID, lww.droppedColumns[row.TableID], defaulted, reset)
+		if err != nil {
+			return false, err
+		}
+	}
+	// The conditional update of an existing row writes no row if the
+	// destination row is newer.
+	written, err := txn.ExecParsed(ctx, "replicated-insert", txn.KV(), insertQuery, datums...)
+	if err != nil {
+		// The error isn't logged as it may hold the row's values.
+		log.Warningf(ctx, "replicated insert failed (query: %s): %s", insertQuery.SQL, pgerror.GetPGCode(err))
+		return false, err
+	}
+	return written > 0, nil
 }
 
+// deleteRow applies a delete. It returns false if it deleted no row, either
+// because the destination row is newer or doesn't exist.
 func (lww *sqlLastWriteWinsRowProcessor) deleteRow(
 	ctx context.Context, txn isql.Txn, row cdcevent.Row,
-) error {
+) (bool, error) {
+	datums, err := keyColumnDatums(row)
+	if err != nil {
+		return false, err
+	}
+	deleteQuery := lww.queryBuffer.deleteQueries[row.TableID]
+	deleted, err := txn.ExecParsed(ctx, "replicated-delete", txn.KV(), deleteQuery, datums...)
+	if err != nil {
+		log.Warningf(ctx, "replicated delete failed (query: %s): %s", deleteQuery.SQL, pgerror.GetPGCode(err))
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+// encodedColumnIDs returns the IDs of the columns encoded in the given tuple
+// encoded value.
+func encodedColumnIDs(value roachpb.Value) (catalog.TableColSet, error) {
+	var cols catalog.TableColSet
+	b, err := value.GetTuple()
+	if err != nil {
+		return cols, err
+	}
+	var colID descpb.ColumnID
+	for len(b) > 0 {
+		_, dataOffset, colIDDelta, typ, err := encoding.DecodeValueTag(b)
+		if err != nil {
+			return cols, err
+		}
+		colID += descpb.ColumnID(colIDDelta)
+		cols.Add(colID)
+		n, err := encoding.PeekValueLengthWithOffsetsAndType(b, dataOffset, typ)
+		if err != nil {
+			return cols, err
+		}
+		b = b[n:]
+	}
+	return cols, nil
+}
+
+// keyColumnDatums returns the datums of the primary key columns of the row.
+func keyColumnDatums(row cdcevent.Row) ([]interface{}, error) {
 	datums := make([]interface{}, 0, len(row.TableDescriptor().TableDesc().PrimaryIndex.KeyColumnNames))
 	if err := row.ForEachKeyColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
 		datums = append(datums, d)
 		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
-	deleteQuery := lww.queryBuffer.deleteQueries[row.TableID]
-	if _, err := txn.ExecParsed(ctx, "replicated-delete", txn.KV(), deleteQuery, datums...); err != nil {
-		log.Warningf(ctx, "replicated delete failed (query: %s): %s", deleteQuery.SQL, err.Error())
-		return err
+	return datums, nil
+}
+
+// keyColumnPredicate returns a predicate matching the quoted primary key
+// columns of the table against placeholders starting at startIdx.
+func keyColumnPredicate(td catalog.TableDescriptor, startIdx int) string {
+	var predicate strings.Builder
+	for i, name := range td.TableDesc().PrimaryIndex.KeyColumnNames {
+		if i > 0 {
+			predicate.WriteString(" AND ")
+		}
+		fmt.Fprintf(&predicate, "%s = $%d", tree.NameString(name), startIdx+i)
 	}
-	return nil
+	return predicate.String()
 }
 
 func makeInsertQueries(
-	fqTableName string, td catalog.TableDescriptor,
+	fqTableName string,
+	td catalog.TableDescriptor,
+	rule *regionRule,
+	audit *auditRule,
+	dropped map[string]struct{},
+	reset []string,
+	softDeleteColumn string,
 ) (map[catid.FamilyID]statements.Statement[tree.Statement], error) {
 	queries := make(map[catid.FamilyID]statements.Statement[tree.Statement], td.NumFamilies())
 
@@ -182,6 +680,7 @@ func makeInsertQueries(
 		var onConflictUpdateClause strings.Builder
 		argIdx := 1
 		seenIds := make(map[catid.ColumnID]struct{})
+		placeholders := make(map[string]int)
 		addColumn := func(colName string, colID catid.ColumnID) {
 			// We will set crdb_internal_origin_timestamp ourselves from the MVCC timestamp of the incoming datum.
 			// We should never see this on the rangefeed as a non-null value as that would imply we've looped data around.
@@ -191,17 +690,23 @@ func makeInsertQueries(
 			if _, seen := seenIds[colID]; seen {
 				return
 			}
+			// Columns the destination table doesn't have aren't written.
+			if _, ok := dropped[colName]; ok {
+				return
+			}
 
+			quoted := tree.NameString(colName)
 			if argIdx == 1 {
-				columnNames.WriteString(colName)
+				columnNames.WriteString(quoted)
 				fmt.Fprintf(&valueStrings, "$%d", argIdx)
-				fmt.Fprintf(&onConflictUpdateClause, "%s = $%d", colName, argIdx)
+				fmt.Fprintf(&onConflictUpdateClause, "%s = $%d", quoted, argIdx)
 			} else {
-				fmt.Fprintf(&columnNames, ", %s", colName)
+				fmt.Fprintf(&columnNames, ", %s", quoted)
 				fmt.Fprintf(&valueStrings, ", $%d", argIdx)
-				fmt.Fprintf(&onConflictUpdateClause, ",\n%s = $%d", colName, argIdx)
+				fmt.Fprintf(&onConflictUpdateClause, ",\n%s = $%d", quoted, argIdx)
 			}
 			seenIds[colID] = struct{}{}
+			placeholders[colName] = argIdx
 			argIdx++
 		}
 
@@ -234,9 +739,32 @@ func makeInsertQueries(
 			}
 			addColumn(colName, family.ColumnIDs[i])
 		}
+		if expr, ok := rule.value(placeholders); ok {
+			fmt.Fprintf(&columnNames, ", %s", tree.NameString(rule.column))
+			fmt.Fprintf(&valueStrings, ", %s", expr)
+			fmt.Fprintf(&onConflictUpdateClause, ",\n%s = %s", tree.NameString(rule.column), expr)
+		}
 
 		var err error
 		originTSIdx := argIdx
+		auditColumns, auditValues := audit.assignments(originTSIdx)
+		for i, name := range auditColumns {
+			fmt.Fprintf(&columnNames, ", %s", name)
+			fmt.Fprintf(&valueStrings, ", %s", auditValues[i])
+			fmt.Fprintf(&onConflictUpdateClause, ",\n%s = %s", name, auditValues[i])
+		}
+		// Destination-only columns get their DEFAULT when the row is inserted
+		// since they aren't written, and are reset to it if the row exists.
+		for _, name := range reset {
+			fmt.Fprintf(&onConflictUpdateClause, ",\n%s = DEFAULT", tree.NameString(name))
+		}
+		// A written row is live, so it clears the soft delete column unless the
+		// source table has the column and writes it.
+		if col := clearedSoftDeleteColumn(td, softDeleteColumn); col != "" {
+			fmt.Fprintf(&columnNames, ", %s", col)
+			valueStrings.WriteString(", NULL")
+			fmt.Fprintf(&onConflictUpdateClause, ",\n%s = NULL", col)
+		}
 		baseQuery := `
 INSERT INTO %s (%s, crdb_internal_origin_timestamp)
 VALUES (%s, $%d)
@@ -253,7 +781,7 @@ WHERE (%[1]s.crdb_internal_mvcc_timestamp <= $%[4]d
 			columnNames.String(),
 			valueStrings.String(),
 			originTSIdx,
-			td.GetPrimaryIndex().GetName(),
+			tree.NameString(td.GetPrimaryIndex().GetName()),
 			onConflictUpdateClause.String(),
 		))
 		return err
@@ -263,14 +791,144 @@ WHERE (%[1]s.crdb_internal_mvcc_timestamp <= $%[4]d
 	return queries, nil
 }
 
+// regionRule sets the region column of the rows inserted into a REGIONAL BY ROW
+// destination table whose source table has no region column, and so whose
+// rows don't carry a region of their own.
+type regionRule struct {
+	// column is the name of the destination's region column.
+	column string
+	// typeOID is the OID of the destination's region enum type.
+	typeOID oid.Oid
+	// region, if set, is the region of all the rows.
+	region string
+	// fromColumn, if set, is the source column whose value names the region
+	// of each row.
+	fromColumn string
+}
+
+// makeRegionRule returns the region rule of the given source table, or nil if
+// the rows of the table are applied with the default region of the
+// destination's region column, or keep the region encoded by the source.
+func makeRegionRule(
+	td catalog.TableDescriptor, options jobspb.LogicalReplicationDetails_Options, name string,
+) (*regionRule, error) {
+	col, ok := options.RegionColumns[name]
+	if !ok || (options.Region == "" && options.RegionFromColumn == "") {
+		return nil, nil
+	}
+	if catalog.FindColumnByName(td, col.Name) != nil {
+		return nil, nil
+	}
+	if options.RegionFromColumn != "" && catalog.FindColumnByName(td, options.RegionFromColumn) == nil {
+		return nil, errors.Newf("source table %s has no column %q to derive regions from",
+			name, options.RegionFromColumn)
+	}
+	return &regionRule{
+		column:     col.Name,
+		typeOID:    col.TypeOID,
+		region:     options.Region,
+		fromColumn: options.RegionFromColumn,
+	}, nil
+}
+
+// value returns the expression for the region of a row given the placeholders
+// of the columns written by a query. The region of a row can't be derived by a
+// query that doesn't write the column it is derived from, e.g. because it
+// only writes another column family, in which case the region is left as is.
+func (r *regionRule) value(placeholders map[string]int) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	if r.fromColumn == "" {
+		return fmt.Sprintf("%s::@%d", lexbase.EscapeSQLString(r.region), r.typeOID), true
+	}
+	idx, ok := placeholders[r.fromColumn]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("$%d::STRING::@%d", idx, r.typeOID), true
+}
+
+// The provenance fields that the audit_columns option writes to destination
+// columns.
+const (
+	// auditSourceTimestamp is the HLC timestamp of the source write, as a
+	// DECIMAL.
+	auditSourceTimestamp = "source_timestamp"
+	// auditApplyTimestamp is the timestamp of the destination transaction
+	// that applied the write, as a TIMESTAMPTZ.
+	auditApplyTimestamp = "apply_timestamp"
+	// auditSourceClusterID is the ID of the source cluster, as a UUID.
+	auditSourceClusterID = "source_cluster_id"
+)
+
+// auditFields are the provenance fields in the order their columns are
+// written.
+var auditFields = []string{auditSourceTimestamp, auditApplyTimestamp, auditSourceClusterID}
+
+// auditRule writes the provenance of each row applied to a destination table
+// to the table's audit columns, so that it can be queried on the destination.
+type auditRule struct {
+	// columns maps the provenance fields to the destination's columns.
+	columns map[string]string
+	// sourceClusterID is the ID of the source cluster.
+	sourceClusterID uuid.UUID
+}
+
+// makeAuditRule returns the audit rule of the given source table, or nil if
+// its destination table has none of the stream's audit columns. Audit columns
+// that the source table also has are replicated from the source rather than
+// written.
+func makeAuditRule(
+	td catalog.TableDescriptor,
+	options jobspb.LogicalReplicationDetails_Options,
+	name string,
+	sourceClusterID uuid.UUID,
+) *auditRule {
+	columns := make(map[string]string)
+	for field, col := range options.TableAuditColumns[name].Columns {
+		if catalog.FindColumnByName(td, col) == nil {
+			columns[field] = col
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	return &auditRule{columns: columns, sourceClusterID: sourceClusterID}
+}
+
+// assignments returns the quoted audit columns and the expressions of their
+// values given the placeholder of the row's origin timestamp.
+func (r *auditRule) assignments(originTSIdx int) (columns, values []string) {
+	if r == nil {
+		return nil, nil
+	}
+	for _, field := range auditFields {
+		col, ok := r.columns[field]
+		if !ok {
+			continue
+		}
+		columns = append(columns, tree.NameString(col))
+		switch field {
+		case auditSourceTimestamp:
+			values = append(values, fmt.Sprintf("$%d", originTSIdx))
+		case auditApplyTimestamp:
+			values = append(values, "now()")
+		case auditSourceClusterID:
+			values = append(values, lexbase.EscapeSQLString(r.sourceClusterID.String())+"::UUID")
+		}
+	}
+	return columns, values
+}
+
 func makeDeleteQuery(fqTableName string, td catalog.TableDescriptor) string {
 	var whereClause strings.Builder
 	names := td.TableDesc().PrimaryIndex.KeyColumnNames
 	for i := 0; i < len(names); i++ {
 		if i == 0 {
-			fmt.Fprintf(&whereClause, "%s = $%d", names[i], i+1)
+			fmt.Fprintf(&whereClause, "%s = $%d", tree.NameString(names[i]), i+1)
 		} else {
-			fmt.Fprintf(&whereClause, "AND %s = $%d", names[i], i+1)
+			fmt.Fprintf(&whereClause, "AND %s = $%d", tree.NameString(names[i]), i+1)
 		}
 	}
 	originTSIdx := len(names) + 1
@@ -0,0 +1,412 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/tabledesc"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+	"github.com/cockroachdb/cockroach/pkg/util/encoding"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodedColumnIDs(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// Encode a row that only includes columns 1 and 3. Column IDs are delta
+	// encoded.
+	var b []byte
+	b = encoding.EncodeIntValue(b, 1, 42)
+	b = encoding.EncodeBytesValue(b, 2, []byte("changed"))
+	var v roachpb.Value
+	v.SetTuple(b)
+
+	cols, err := encodedColumnIDs(v)
+	require.NoError(t, err)
+	require.Equal(t, []descpb.ColumnID{1, 3}, cols.Ordered())
+}
+
+func TestAppliedTimestampsDetectsNonMonotonicApplies(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ctx := context.Background()
+	st := cluster.MakeTestingClusterSettings()
+	m := MakeMetrics(time.Minute).(*Metrics)
+	a := newAppliedTimestamps(st, m)
+	kv := func(key string, wallTime int64) replicatedKV {
+		return replicatedKV{KeyValue: roachpb.KeyValue{
+			Key:   roachpb.Key(key),
+			Value: roachpb.Value{Timestamp: hlc.Timestamp{WallTime: wallTime}},
+		}}
+	}
+
+	// Nothing is tracked while the check is off.
+	require.NoError(t, a.record(ctx, kv("a", 2)))
+	require.NoError(t, a.record(ctx, kv("a", 1)))
+	require.Zero(t, m.NonMonotonicApplies.Count())
+
+	monotonicityCheck.Override(ctx, &st.SV, int64(monotonicityCheckLog))
+	require.NoError(t, a.record(ctx, kv("a", 2)))
+	// Reapplying a KV, e.g. when a transaction is retried, is not a violation.
+	require.NoError(t, a.record(ctx, kv("a", 2)))
+	require.NoError(t, a.record(ctx, kv("b", 1)))
+	require.NoError(t, a.record(ctx, kv("a", 1)))
+	require.Equal(t, int64(1), m.NonMonotonicApplies.Count())
+
+	monotonicityCheck.Override(ctx, &st.SV, int64(monotonicityCheckError))
+	require.NoError(t, a.record(ctx, kv("a", 3)))
+	require.ErrorContains(t, a.record(ctx, kv("a", 1)), "after applying it at")
+	require.Equal(t, int64(2), m.NonMonotonicApplies.Count())
+}
+
+func TestNewerThan(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	ts := func(wallTime int64) *tree.DDecimal {
+		return eval.TimestampToDecimalDatum(hlc.Timestamp{WallTime: wallTime})
+	}
+	// A newer destination row wins over any write.
+	require.True(t, newerThan(ts(2), ts(1), false /* isDelete */))
+	require.True(t, newerThan(ts(2), ts(1), true /* isDelete */))
+	// A row written at the same time is only overwritten by non-deletes.
+	require.False(t, newerThan(ts(1), ts(1), false /* isDelete */))
+	require.True(t, newerThan(ts(1), ts(1), true /* isDelete */))
+	// An older row loses to any write.
+	require.False(t, newerThan(ts(1), ts(2), false /* isDelete */))
+	require.False(t, newerThan(ts(1), ts(2), true /* isDelete */))
+}
+
+func TestCheckSourceEncodingSupported(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	desc := func(format descpb.FormatVersion, index descpb.IndexDescriptorVersion) descpb.TableDescriptor {
+		return descpb.TableDescriptor{
+			Name:          "tab",
+			ID:            104,
+			FormatVersion: format,
+			Columns: []descpb.ColumnDescriptor{
+				{ID: 1, Name: "pk", Type: types.Int},
+				{ID: 2, Name: "payload", Type: types.String},
+			},
+			PrimaryIndex: descpb.IndexDescriptor{
+				ID: 1, Name: "tab_pkey", Version: index, KeyColumnIDs: []descpb.ColumnID{1},
+			},
+		}
+	}
+
+	// The primary index encoding changed to store non-key columns explicitly.
+	// Sources still using the older encoding are decoded using the upgraded
+	// descriptor.
+	old := desc(descpb.InterleavedFormatVersion, descpb.StrictIndexColumnIDGuaranteesVersion)
+	require.NoError(t, checkSourceEncodingSupported("tab", &old))
+	upgraded := tabledesc.NewBuilder(&old).BuildImmutableTable()
+	require.Equal(t, descpb.LatestIndexDescriptorVersion, upgraded.GetPrimaryIndex().GetVersion())
+	require.Equal(t, []descpb.ColumnID{2}, upgraded.GetPrimaryIndex().IndexDesc().StoreColumnIDs)
+
+	latest := desc(descpb.InterleavedFormatVersion, descpb.LatestIndexDescriptorVersion)
+	require.NoError(t, checkSourceEncodingSupported("tab", &latest))
+
+	// Encodings newer than this binary's can't be decoded.
+	newIndex := desc(descpb.InterleavedFormatVersion, descpb.LatestIndexDescriptorVersion+1)
+	err := checkSourceEncodingSupported("tab", &newIndex)
+	require.True(t, jobs.IsPermanentJobError(err))
+	require.ErrorContains(t, err, "primary index with version")
+
+	newFormat := desc(descpb.InterleavedFormatVersion+1, descpb.LatestIndexDescriptorVersion)
+	err = checkSourceEncodingSupported("tab", &newFormat)
+	require.True(t, jobs.IsPermanentJobError(err))
+	require.ErrorContains(t, err, "table format version")
+}
+
+func TestDestinationValueReencodesIntoDestinationTypes(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	desc := func(typs ...*types.T) catalog.TableDescriptor {
+		cols := []descpb.ColumnDescriptor{{ID: 1, Name: "pk", Type: types.Int}}
+		for i, typ := range typs {
+			cols = append(cols, descpb.ColumnDescriptor{ID: descpb.ColumnID(i + 2), Name: fmt.Sprintf("c%d", i), Type: typ})
+		}
+		return tabledesc.NewBuilder(&descpb.TableDescriptor{
+			Name:          "tab",
+			ID:            104,
+			FormatVersion: descpb.InterleavedFormatVersion,
+			Columns:       cols,
+			PrimaryIndex: descpb.IndexDescriptor{
+				ID: 1, Name: "tab_pkey", Version: descpb.LatestIndexDescriptorVersion, KeyColumnIDs: []descpb.ColumnID{1},
+			},
+		}).BuildImmutableTable()
+	}
+
+	// The destination represents the floats, integers and decimals of the
+	// source with less width or precision; its strings are the same.
+	src := desc(types.Float, types.Int, types.Decimal, types.String)
+	dest := desc(types.Float4, types.Int2, types.MakeDecimal(10, 2), types.String)
+	typs := destinationColumnTypes(src, dest)
+	require.Equal(t, map[string]*types.T{"c0": types.Float4, "c1": types.Int2, "c2": types.MakeDecimal(10, 2)}, typs)
+
+	ctx := context.Background()
+	lww := &sqlLastWriteWinsRowProcessor{
+		destinationTypes: map[descpb.ID]map[string]*types.T{104: typs},
+		evalCtx:          &eval.Context{Settings: cluster.MakeTestingClusterSettings()},
+	}
+	d, err := lww.destinationValue(ctx, 104, "c0", tree.NewDFloat(0.1))
+	require.NoError(t, err)
+	require.Equal(t, tree.NewDFloat(tree.DFloat(float32(0.1))), d)
+	d, err = lww.destinationValue(ctx, 104, "c1", tree.NewDInt(7))
+	require.NoError(t, err)
+	require.Equal(t, tree.NewDInt(7), d)
+	dec, err := tree.ParseDDecimal("1.234")
+	require.NoError(t, err)
+	d, err = lww.destinationValue(ctx, 104, "c2", dec)
+	require.NoError(t, err)
+	require.Equal(t, "1.23", d.String())
+	d, err = lww.destinationValue(ctx, 104, "c3", tree.NewDString("unchanged"))
+	require.NoError(t, err)
+	require.Equal(t, tree.NewDString("unchanged"), d)
+	d, err = lww.destinationValue(ctx, 104, "c1", tree.DNull)
+	require.NoError(t, err)
+	require.Equal(t, tree.DNull, d)
+
+	// A value that doesn't fit the destination's type is rejected.
+	_, err = lww.destinationValue(ctx, 104, "c1", tree.NewDInt(70000))
+	require.True(t, errors.Is(err, errDestinationTypeValue))
+}
+
+func TestRegionRuleSetsRegionColumn(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const name = "db.public.tab"
+	desc := descpb.TableDescriptor{
+		Name:          "tab",
+		ID:            104,
+		FormatVersion: descpb.InterleavedFormatVersion,
+		Columns: []descpb.ColumnDescriptor{
+			{ID: 1, Name: "pk", Type: types.Int},
+			{ID: 2, Name: "country", Type: types.String},
+			{ID: 3, Name: "payload", Type: types.String},
+		},
+		Families: []descpb.ColumnFamilyDescriptor{
+			{ID: 0, Name: "primary", ColumnIDs: []descpb.ColumnID{1, 2}, ColumnNames: []string{"pk", "country"}},
+			{ID: 1, Name: "extra", ColumnIDs: []descpb.ColumnID{3}, ColumnNames: []string{"payload"}},
+		},
+		PrimaryIndex: descpb.IndexDescriptor{
+			ID: 1, Name: "tab_pkey", KeyColumnIDs: []descpb.ColumnID{1}, KeyColumnNames: []string{"pk"},
+			Version: descpb.LatestIndexDescriptorVersion,
+		},
+	}
+	td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
+	options := jobspb.LogicalReplicationDetails_Options{
+		RegionColumns: map[string]jobspb.LogicalReplicationDetails_Options_RegionColumn{
+			name: {Name: "crdb_region", TypeOID: 100106},
+		},
+	}
+	insertSQL := func(options jobspb.LogicalReplicationDetails_Options) map[catid.FamilyID]string {
+		rule, err := makeRegionRule(td, options, name)
+		require.NoError(t, err)
+		queries, err := makeInsertQueries(name, td, rule, nil /* audit */, nil, /* dropped */
+			nil /* reset */, "" /* softDeleteColumn */)
+		require.NoError(t, err)
+		res := make(map[catid.FamilyID]string, len(queries))
+		for id, q := range queries {
+			res[id] = q.SQL
+		}
+		return res
+	}
+
+	// Without a rule, rows get the default region of the destination.
+	for _, q := range insertSQL(options) {
+		require.NotContains(t, q, "crdb_region")
+	}
+
+	// A fixed region applies to every column family.
+	options.Region = "us-east1"
+	for _, q := range insertSQL(options) {
+		require.Contains(t, q, "crdb_region")
+		require.Contains(t, q, "'us-east1'::@100106")
+	}
+
+	// A region derived from a column can only be set by the family holding it.
+	options.Region, options.RegionFromColumn = "", "country"
+	queries := insertSQL(options)
+	require.Contains(t, queries[0], "crdb_region = $2::STRING::@100106")
+	require.NotContains(t, queries[1], "crdb_region")
+
+	// Sources that have a region column of their own keep their regions.
+	options.RegionColumns[name] = jobspb.LogicalReplicationDetails_Options_RegionColumn{Name: "country", TypeOID: 100106}
+	rule, err := makeRegionRule(td, options, name)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+
+	options.RegionColumns[name] = jobspb.LogicalReplicationDetails_Options_RegionColumn{Name: "crdb_region", TypeOID: 100106}
+	options.RegionFromColumn = "missing"
+	_, err = makeRegionRule(td, options, name)
+	require.ErrorContains(t, err, `no column "missing"`)
+}
+
+func TestAuditRuleSetsProvenanceColumns(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	const name = "db.public.tab"
+	desc := descpb.TableDescriptor{
+		Name:          "tab",
+		ID:            104,
+		FormatVersion: descpb.InterleavedFormatVersion,
+		Columns: []descpb.ColumnDescriptor{
+			{ID: 1, Name: "pk", Type: types.Int},
+			{ID: 2, Name: "payload", Type: types.String},
+			{ID: 3, Name: "applied_at", Type: types.TimestampTZ},
+		},
+		Families: []descpb.ColumnFamilyDescriptor{
+			{ID: 0, Name: "primary", ColumnIDs: []descpb.ColumnID{1, 2, 3}, ColumnNames: []string{"pk", "payload", "applied_at"}},
+		},
+		PrimaryIndex: descpb.IndexDescriptor{
+			ID: 1, Name: "tab_pkey", KeyColumnIDs: []descpb.ColumnID{1}, KeyColumnNames: []string{"pk"},
+			Version: descpb.LatestIndexDescriptorVersion,
+		},
+	}
+	td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
+	clusterID := uuid.MakeV4()
+
+	// Tables without audit columns have no rule.
+	var options jobspb.LogicalReplicationDetails_Options
+	require.Nil(t, makeAuditRule(td, options, name, clusterID))
+
+	options.TableAuditColumns = map[string]jobspb.LogicalReplicationDetails_Options_TableAuditColumns{
+		name: {Columns: map[string]string{
+			auditSourceTimestamp: "Src_TS",
+			auditSourceClusterID: "src_cluster",
+			// The source has this column, so its value is replicated.
+			auditApplyTimestamp: "applied_at",
+		}},
+	}
+	audit := makeAuditRule(td, options, name, clusterID)
+	require.NotNil(t, audit)
+
+	queries, err := makeInsertQueries(name, td, nil /* rule */, audit, nil, /* dropped */
+		nil /* reset */, "" /* softDeleteColumn */)
+	require.NoError(t, err)
+	insertSQL := queries[0].SQL
+	require.Contains(t, insertSQL, `"Src_TS", src_cluster, crdb_internal_origin_timestamp`)
+	require.Contains(t, insertSQL, `"Src_TS" = $4`)
+	require.Contains(t, insertSQL, "src_cluster = '"+clusterID.String()+"'::UUID")
+	require.NotContains(t, insertSQL, "now()")
+}
+
+func TestBatchedQueries(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	desc := descpb.TableDescriptor{
+		Name:          "tab",
+		ID:            104,
+		FormatVersion: descpb.InterleavedFormatVersion,
+		Columns: []descpb.ColumnDescriptor{
+			{ID: 1, Name: "a", Type: types.Int},
+			{ID: 2, Name: "b", Type: types.Int},
+			{ID: 3, Name: "Payload", Type: types.String},
+		},
+		Families: []descpb.ColumnFamilyDescriptor{
+			{ID: 0, Name: "primary", ColumnIDs: []descpb.ColumnID{1, 2, 3}, ColumnNames: []string{"a", "b", "Payload"}},
+		},
+		PrimaryIndex: descpb.IndexDescriptor{
+			ID: 1, Name: "tab_pkey", KeyColumnIDs: []descpb.ColumnID{1, 2}, KeyColumnNames: []string{"a", "b"},
+			Version: descpb.LatestIndexDescriptorVersion,
+		},
+	}
+	td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
+
+	// Each deleted row has a placeholder per key column and one for its
+	// origin timestamp.
+	deleteSQL := makeBatchedDeleteQuery("db.public.tab", td, 2)
+	_, err := parser.ParseOne(deleteSQL)
+	require.NoError(t, err)
+	require.Contains(t, deleteSQL, "(a = $1 AND b = $2 AND ((db.public.tab.crdb_internal_mvcc_timestamp < $3")
+	require.Contains(t, deleteSQL, "OR (a = $4 AND b = $5 AND ((db.public.tab.crdb_internal_mvcc_timestamp < $6")
+	require.NotContains(t, deleteSQL, "$7")
+
+	// Each upserted row has a placeholder per column and one for its origin
+	// timestamp. Column names are quoted.
+	insertSQL := makeBatchedInsertQuery("db.public.tab", td, []string{"a", "b", "Payload"}, 3)
+	_, err = parser.ParseOne(insertSQL)
+	require.NoError(t, err)
+	require.Contains(t, insertSQL, "VALUES ($1, $2, $3, $4),\n       ($5, $6, $7, $8),\n       ($9, $10, $11, $12)")
+	require.Contains(t, insertSQL, `"Payload" = excluded."Payload"`)
+	require.Contains(t, insertSQL, "ON CONFLICT ON CONSTRAINT tab_pkey")
+	require.NotContains(t, insertSQL, "$13")
+}
+
+func TestQueriesQuoteIdentifiers(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	desc := descpb.TableDescriptor{
+		Name:          "tab",
+		ID:            104,
+		FormatVersion: descpb.InterleavedFormatVersion,
+		Columns: []descpb.ColumnDescriptor{
+			{ID: 1, Name: "Key", Type: types.Int},
+			{ID: 2, Name: "select", Type: types.String},
+		},
+		Families: []descpb.ColumnFamilyDescriptor{
+			{ID: 0, Name: "primary", ColumnIDs: []descpb.ColumnID{1, 2}, ColumnNames: []string{"Key", "select"}},
+		},
+		PrimaryIndex: descpb.IndexDescriptor{
+			ID: 1, Name: "Tab_pkey", KeyColumnIDs: []descpb.ColumnID{1}, KeyColumnNames: []string{"Key"},
+			Version: descpb.LatestIndexDescriptorVersion,
+		},
+	}
+	td := tabledesc.NewBuilder(&desc).BuildImmutableTable()
+	const name = "db.public.tab"
+
+	queries, err := makeInsertQueries(name, td, nil /* rule */, nil /* audit */, nil, /* dropped */
+		nil /* reset */, "" /* softDeleteColumn */)
+	require.NoError(t, err)
+	insertSQL := queries[0].SQL
+	require.Contains(t, insertSQL, `("Key", "select", crdb_internal_origin_timestamp)`)
+	require.Contains(t, insertSQL, `ON CONFLICT ON CONSTRAINT "Tab_pkey"`)
+	require.Contains(t, insertSQL, `"select" = $2`)
+
+	deleteSQL := makeDeleteQuery(name, td)
+	_, err = parser.ParseOne(deleteSQL)
+	require.NoError(t, err)
+	require.Contains(t, deleteSQL, `WHERE "Key" = $1`)
+
+	// Destination-only columns reset to their DEFAULT are quoted as well.
+	reset := []string{"Created At"}
+	queries, err = makeInsertQueries(name, td, nil /* rule */, nil /* audit */, nil, /* dropped */
+		reset, "" /* softDeleteColumn */)
+	require.NoError(t, err)
+	require.Contains(t, queries[0].SQL, `"Created At" = DEFAULT`)
+}
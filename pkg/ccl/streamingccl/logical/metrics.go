@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/metric/aggmetric"
 )
 
 var (
@@ -84,6 +85,18 @@ var (
 		Measurement: "Rows",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaReplicationExecutedBatchSize = metric.Metadata{
+		Name:        "logical_replication.executed_batch_size",
+		Help:        "Number of rows in each batch applied by a writer worker",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicatedValueSize = metric.Metadata{
+		Name:        "logical_replication.replicated_value_size",
+		Help:        "Size of the value of each replicated KV",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
 	metaReplicationFlushBytesHist = metric.Metadata{
 		Name:        "logical_replication.flush_bytes",
 		Help:        "Number of bytes in a given flush",
@@ -108,6 +121,24 @@ var (
 		Measurement: "Count",
 		Unit:        metric.Unit_COUNT,
 	}
+	metaReplicationFlushOnTable = metric.Metadata{
+		Name:        "logical_replication.flush_on_table",
+		Help:        "Number of flushes of the buffered KVs of some tables due to their own flush thresholds",
+		Measurement: "Count",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationFlushOnNodeBudget = metric.Metadata{
+		Name:        "logical_replication.flush_on_node_budget",
+		Help:        "Number of flushes caused by the writer processors on the node exhausting the node-wide buffer budget",
+		Measurement: "Count",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaBufferedBytes = metric.Metadata{
+		Name:        "logical_replication.buffered_bytes",
+		Help:        "Number of bytes of replicated KVs buffered by the writer processors on the node",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
 	metaReplicationBatchBytes = metric.Metadata{
 		Name:        "logical_replication.batch_bytes",
 		Help:        "Number of bytes in a given batch",
@@ -120,6 +151,354 @@ var (
 		Measurement: "Nanoseconds",
 		Unit:        metric.Unit_NANOSECONDS,
 	}
+	metaReplicationSingleRangeBatches = metric.Metadata{
+		Name:        "logical_replication.single_range_batches",
+		Help:        "Number of batches applied using one-phase commits because all of their rows fell in a single range",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationSingleRangeBatchNanos = metric.Metadata{
+		Name:        "logical_replication.single_range_batch_hist_nanos",
+		Help:        "Time spent flushing a batch whose rows all fell in a single range",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaReplicationRangeLimitedBatches = metric.Metadata{
+		Name:        "logical_replication.range_limited_batches",
+		Help:        "Number of batches split at a destination range boundary because their rows would have spanned more than max_ranges_per_batch ranges",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationRangeLimitedBatchNanos = metric.Metadata{
+		Name:        "logical_replication.range_limited_batch_hist_nanos",
+		Help:        "Time spent flushing a batch that was split at a destination range boundary",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaBufferPoolAllocations = metric.Metadata{
+		Name:        "logical_replication.buffer_pool_allocations",
+		Help:        "Number of ingestion buffers allocated because none were available in the buffer pool",
+		Measurement: "Buffers",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaBufferPoolReuses = metric.Metadata{
+		Name:        "logical_replication.buffer_pool_reuses",
+		Help:        "Number of ingestion buffers reused from the buffer pool",
+		Measurement: "Buffers",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaBuffersTrimmed = metric.Metadata{
+		Name:        "logical_replication.buffer_pool_trimmed",
+		Help:        "Number of ingestion buffers whose oversized backing array was released rather than retained by the buffer pool",
+		Measurement: "Buffers",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaBufferCapacity = metric.Metadata{
+		Name:        "logical_replication.buffer_capacity",
+		Help:        "Capacity, in KVs, of ingestion buffers released back to the buffer pool",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationBatchRetries = metric.Metadata{
+		Name:        "logical_replication.batch_retries",
+		Help:        "Number of times the transaction applying a batch was retried, e.g. due to contention",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSampledInKVs = metric.Metadata{
+		Name:        "logical_replication.sampled_in_kvs",
+		Help:        "Number of KVs replicated because their rows are part of the sample selected by the sample rate",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSampledOutKVs = metric.Metadata{
+		Name:        "logical_replication.sampled_out_kvs",
+		Help:        "Number of KVs dropped because their rows are not part of the sample selected by the sample rate",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFanoutEmitted = metric.Metadata{
+		Name:        "logical_replication.fanout_emitted",
+		Help:        "Number of applied rows emitted to fanout sinks",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFanoutDropped = metric.Metadata{
+		Name:        "logical_replication.fanout_dropped",
+		Help:        "Number of applied rows not emitted to fanout sinks because the fanout buffer was full or the sink failed",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaOversizedBatchSplits = metric.Metadata{
+		Name:        "logical_replication.oversized_batch_splits",
+		Help:        "Number of batches split in half because their writes exceeded the maximum raft command size",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaDLQedRows = metric.Metadata{
+		Name:        "logical_replication.events_dlqed",
+		Help:        "Number of rows that could not be applied and were sent to the dead letter queue",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaNonMonotonicApplies = metric.Metadata{
+		Name:        "logical_replication.non_monotonic_applies",
+		Help:        "Number of KVs applied with an earlier source timestamp than a KV previously applied to the same key",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaUnknownEventsSkipped = metric.Metadata{
+		Name:        "logical_replication.unknown_events_skipped",
+		Help:        "Number of events of unknown types received from the source that were skipped",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRetryableApplyErrors = metric.Metadata{
+		Name:        "logical_replication.apply_errors.retryable",
+		Help:        "Number of batches that failed to apply because of retryable errors such as contention",
+		Measurement: "Errors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFatalApplyErrors = metric.Metadata{
+		Name:        "logical_replication.apply_errors.fatal",
+		Help:        "Number of batches that failed to apply because of non-retryable errors such as data or schema errors",
+		Measurement: "Errors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaPrefetchReads = metric.Metadata{
+		Name:        "logical_replication.prefetch_reads",
+		Help:        "Number of queries issued to prefetch the destination rows of batches",
+		Measurement: "Queries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaPrefetchSkippedWrites = metric.Metadata{
+		Name:        "logical_replication.prefetch_skipped_writes",
+		Help:        "Number of row writes skipped because the prefetched destination row was newer",
+		Measurement: "Writes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaLockTimeoutRetries = metric.Metadata{
+		Name:        "logical_replication.lock_timeout_retries",
+		Help:        "Number of times batches were retried after waiting for a lock for longer than the apply lock timeout",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaIgnoredDeletes = metric.Metadata{
+		Name:        "logical_replication.ignored_deletes",
+		Help:        "Number of replicated deletes dropped because their table ignores deletes",
+		Measurement: "Deletes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSoftDeletes = metric.Metadata{
+		Name:        "logical_replication.soft_deletes",
+		Help:        "Number of replicated deletes applied by setting the soft delete column of the destination row",
+		Measurement: "Deletes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaScanHandoffSkippedKVs = metric.Metadata{
+		Name:        "logical_replication.scan_handoff_skipped_kvs",
+		Help:        "Number of KVs of the initial scan dropped because a newer delete of their row was already received",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaQuarantinedTables = metric.Metadata{
+		Name:        "logical_replication.quarantined_tables",
+		Help:        "Number of times a table was quarantined after too many of its rows were sent to the dead letter queue",
+		Measurement: "Tables",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaQuarantinedKVs = metric.Metadata{
+		Name:        "logical_replication.quarantined_kvs",
+		Help:        "Number of KVs sent to the dead letter queue without being applied because their table is quarantined",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFlushWorkers = metric.Metadata{
+		Name:        "logical_replication.flush_workers",
+		Help:        "Number of workers used to apply a given flush",
+		Measurement: "Workers",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaIntentResolutionNanos = metric.Metadata{
+		Name:        "logical_replication.intent_resolution_latency",
+		Help:        "Time spent resolving the intents left by applied batches when intent resolution is synchronous",
+		Measurement: "Nanoseconds",
+		Unit:        metric.Unit_NANOSECONDS,
+	}
+	metaTxnDeadlineExceeded = metric.Metadata{
+		Name:        "logical_replication.txn_deadline_exceeded",
+		Help:        "Number of batches whose transaction exceeded its deadline and were retried in smaller transactions",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaLeaseRetries = metric.Metadata{
+		Name:        "logical_replication.descriptor_lease_retries",
+		Help:        "Number of times a batch was retried since the descriptor of its destination table could not be leased",
+		Measurement: "Retries",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaBatchedApplyRows = metric.Metadata{
+		Name:        "logical_replication.batched_apply_rows",
+		Help:        "Number of rows applied by statements that each apply many rows of a batch",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaStalledProcessors = metric.Metadata{
+		Name:        "logical_replication.stalled_processors",
+		Help:        "Number of times a processor was restarted since it made no progress for longer than its stall timeout",
+		Measurement: "Processors",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaShadowAppliedRows = metric.Metadata{
+		Name:        "logical_replication.shadow_applied_rows",
+		Help:        "Number of applied rows also applied to the shadow destination",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaShadowApplyErrors = metric.Metadata{
+		Name:        "logical_replication.shadow_apply_errors",
+		Help:        "Number of applied rows that could not be applied to the shadow destination",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaShadowDivergences = metric.Metadata{
+		Name:        "logical_replication.shadow_divergences",
+		Help:        "Number of rows that differed between the destination and the shadow destination after being applied to both",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSubscriptionQueueBytes = metric.Metadata{
+		Name:        "logical_replication.subscription_queue_bytes",
+		Help:        "Size of the KVs read from subscriptions but not yet buffered for flushing",
+		Measurement: "Bytes",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaSubscriptionQueuePauses = metric.Metadata{
+		Name:        "logical_replication.subscription_queue_pauses",
+		Help:        "Number of times reading from a subscription paused since its queue reached the high-water mark",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaInitialScanRestarts = metric.Metadata{
+		Name:        "logical_replication.initial_scan_restarts",
+		Help:        "Number of times the flow was restarted before the initial scan completed",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaInitialScanResumedSpans = metric.Metadata{
+		Name:        "logical_replication.initial_scan_resumed_spans",
+		Help:        "Number of source spans not rescanned by restarted flows because their initial scan had already been applied",
+		Measurement: "Spans",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFrontierSpans = metric.Metadata{
+		Name:        "logical_replication.frontier_spans",
+		Help:        "Number of spans tracked by the frontiers of the writer processors",
+		Measurement: "Spans",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFrontierCompactions = metric.Metadata{
+		Name:        "logical_replication.frontier_compactions",
+		Help:        "Number of times a writer processor merged the spans of its frontier because they exceeded frontier_max_spans",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaUnknownTableKVsSkipped = metric.Metadata{
+		Name:        "logical_replication.unknown_table_kvs_skipped",
+		Help:        "Number of KVs skipped because they belong to source tables not replicated by the stream",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFlushChunkingErrors = metric.Metadata{
+		Name:        "logical_replication.flush_chunking_errors",
+		Help:        "Number of flushes that failed because their KVs couldn't be split between workers",
+		Measurement: "Flushes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaCollapsedUpdates = metric.Metadata{
+		Name:        "logical_replication.collapsed_updates",
+		Help:        "Number of intermediate versions of keys skipped by streams that only apply the latest version of each key in a flush",
+		Measurement: "KVs",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSubscriptionFailovers = metric.Metadata{
+		Name:        "logical_replication.subscription_failovers",
+		Help:        "Number of times a partition was subscribed from a fallback source address after its subscription failed",
+		Measurement: "Failovers",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSlowFlushes = metric.Metadata{
+		Name:        "logical_replication.slow_flushes",
+		Help:        "Number of flushes that took longer than slow_flush_threshold times the median flush duration of their processor",
+		Measurement: "Flushes",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRetryBudgetExhausted = metric.Metadata{
+		Name:        "logical_replication.retry_budget_exhausted",
+		Help:        "Number of batches whose rows were sent to the dead letter queue after being retried max_batch_retries times",
+		Measurement: "Batches",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaCutoverDivergentRows = metric.Metadata{
+		Name:        "logical_replication.cutover_divergent_rows",
+		Help:        "Number of rows found to differ between the source and the destination by cutover verifications",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaSubscribeHandshakeTimeouts = metric.Metadata{
+		Name:        "logical_replication.subscribe_handshake_timeouts",
+		Help:        "Number of partition subscriptions whose handshake with the source timed out",
+		Measurement: "Subscriptions",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationLagSeconds = metric.Metadata{
+		Name:        "logical_replication.replication_lag_seconds",
+		Help:        "The time elapsed since the replicated time of the most lagging logical replication stream, reported per stream by job ID",
+		Measurement: "Seconds",
+		Unit:        metric.Unit_SECONDS,
+	}
+	metaCheckViolations = metric.Metadata{
+		Name:        "logical_replication.check_violations",
+		Help:        "Number of replicated rows that violated a CHECK constraint of their destination table",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaFrontierMilestonesCrossed = metric.Metadata{
+		Name:        "logical_replication.frontier_milestones_crossed",
+		Help:        "Number of times the replicated time of a job crossed a frontier milestone",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaDroppedColumnValues = metric.Metadata{
+		Name:        "logical_replication.dropped_column_values",
+		Help:        "Number of non-NULL values of source columns not applied since the destination table lacks the column",
+		Measurement: "Values",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRejectedFutureEvents = metric.Metadata{
+		Name:        "logical_replication.rejected_future_events",
+		Help:        "Number of KV events rejected since their timestamp was too far ahead of the destination's clock",
+		Measurement: "Events",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaPriorValueMismatches = metric.Metadata{
+		Name:        "logical_replication.prior_value_mismatches",
+		Help:        "Number of rows not applied by compare-and-swap since the destination row didn't match the row's prior value at the source",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaNotNullViolations = metric.Metadata{
+		Name:        "logical_replication.not_null_violations",
+		Help:        "Number of replicated rows with a NULL for a column their destination table marks NOT NULL",
+		Measurement: "Rows",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaReplicationGaps = metric.Metadata{
+		Name:        "logical_replication.replication_gaps",
+		Help:        "Number of source spans whose resolved timestamp trails the newest KV received for them by more than gap_detection_threshold",
+		Measurement: "Spans",
+		Unit:        metric.Unit_COUNT,
+	}
 	metaDistSQLReplanCount = metric.Metadata{
 		Name:        "logical_replication.distsql_replan_count",
 		Help:        "Total number of dist sql replanning events",
@@ -142,12 +521,76 @@ type Metrics struct {
 	FlushWaitHistNanos    metric.IHistogram
 	FlushOnSize           *metric.Counter
 	FlushOnTime           *metric.Counter
+	FlushOnNodeBudget     *metric.Counter
+	FlushOnTable          *metric.Counter
+	BufferedBytes         *metric.Gauge
 	BatchBytesHist        metric.IHistogram
+	ExecutedBatchSizeHist metric.IHistogram
 	BatchHistNanos        metric.IHistogram
+	BatchRetries          *metric.Counter
+	SingleRangeBatches    *metric.Counter
+	SingleRangeBatchNanos metric.IHistogram
+	RangeLimitedBatches   *metric.Counter
+	RangeLimitedNanos     metric.IHistogram
 	CommitLatency         metric.IHistogram
 	AdmitLatency          metric.IHistogram
 	RunningCount          *metric.Gauge
 	ReplicatedTimeSeconds *metric.Gauge
+	BufferPoolAllocations *metric.Counter
+	BufferPoolReuses      *metric.Counter
+	BuffersTrimmed        *metric.Counter
+	BufferCapacityHist    metric.IHistogram
+	FanoutEmitted         *metric.Counter
+	FanoutDropped         *metric.Counter
+	SampledInKVs          *metric.Counter
+	SampledOutKVs         *metric.Counter
+	OversizedBatchSplits  *metric.Counter
+	DLQedRows             *metric.Counter
+	NonMonotonicApplies   *metric.Counter
+	UnknownEventsSkipped  *metric.Counter
+	RetryableApplyErrors  *metric.Counter
+	FatalApplyErrors      *metric.Counter
+	PrefetchReads         *metric.Counter
+	PrefetchSkippedWrites *metric.Counter
+	LockTimeoutRetries    *metric.Counter
+	IgnoredDeletes        *metric.Counter
+	SoftDeletes           *metric.Counter
+	ScanHandoffSkippedKVs *metric.Counter
+	QuarantinedTables     *metric.Counter
+	QuarantinedKVs        *metric.Counter
+	FlushWorkersHist      metric.IHistogram
+	IntentResolutionNanos metric.IHistogram
+	TxnDeadlineExceeded   *metric.Counter
+	LeaseRetries          *metric.Counter
+	BatchedApplyRows      *metric.Counter
+	StalledProcessors     *metric.Counter
+	ShadowAppliedRows     *metric.Counter
+	ShadowApplyErrors     *metric.Counter
+	ShadowDivergences     *metric.Counter
+
+	ReplicatedValueSizeHist    metric.IHistogram
+	SubscriptionQueueBytes     *metric.Gauge
+	SubscriptionQueuePauses    *metric.Counter
+	InitialScanRestarts        *metric.Counter
+	InitialScanResumedSpans    *metric.Counter
+	FrontierSpans              *metric.Gauge
+	FrontierCompactions        *metric.Counter
+	UnknownTableKVsSkipped     *metric.Counter
+	FlushChunkingErrors        *metric.Counter
+	CollapsedUpdates           *metric.Counter
+	SubscriptionFailovers      *metric.Counter
+	SlowFlushes                *metric.Counter
+	RetryBudgetExhausted       *metric.Counter
+	CutoverDivergentRows       *metric.Counter
+	SubscribeHandshakeTimeouts *metric.Counter
+	ReplicationLagSeconds      *aggmetric.AggGauge
+	CheckViolations            *metric.Counter
+	FrontierMilestonesCrossed  *metric.Counter
+	DroppedColumnValues        *metric.Counter
+	RejectedFutureEvents       *metric.Counter
+	PriorValueMismatches       *metric.Counter
+	NotNullViolations          *metric.Counter
+	ReplicationGaps            *metric.Gauge
 }
 
 // MetricStruct implements the metric.Struct interface.
@@ -198,21 +641,121 @@ func MakeMetrics(histogramWindow time.Duration) metric.Struct {
 			Duration:     histogramWindow,
 			BucketConfig: metric.BatchProcessLatencyBuckets,
 		}),
-		FlushOnSize: metric.NewCounter(metaReplicationFlushOnSize),
-		FlushOnTime: metric.NewCounter(metaReplicationFlushOnTime),
+		FlushOnSize:       metric.NewCounter(metaReplicationFlushOnSize),
+		FlushOnTime:       metric.NewCounter(metaReplicationFlushOnTime),
+		FlushOnNodeBudget: metric.NewCounter(metaReplicationFlushOnNodeBudget),
+		FlushOnTable:      metric.NewCounter(metaReplicationFlushOnTable),
+		BufferedBytes:     metric.NewGauge(metaBufferedBytes),
 		BatchBytesHist: metric.NewHistogram(metric.HistogramOptions{
 			Mode:         metric.HistogramModePrometheus,
 			Metadata:     metaReplicationBatchBytes,
 			Duration:     histogramWindow,
 			BucketConfig: metric.BatchProcessLatencyBuckets,
 		}),
+		ExecutedBatchSizeHist: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationExecutedBatchSize,
+			Duration:     histogramWindow,
+			BucketConfig: metric.Count1KBuckets,
+		}),
 		BatchHistNanos: metric.NewHistogram(metric.HistogramOptions{
 			Mode:         metric.HistogramModePrometheus,
 			Metadata:     metaReplicationBatchHistNanos,
 			Duration:     histogramWindow,
 			BucketConfig: metric.BatchProcessLatencyBuckets,
 		}),
+		BatchRetries:       metric.NewCounter(metaReplicationBatchRetries),
+		SingleRangeBatches: metric.NewCounter(metaReplicationSingleRangeBatches),
+		SingleRangeBatchNanos: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationSingleRangeBatchNanos,
+			Duration:     histogramWindow,
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
+		RangeLimitedBatches: metric.NewCounter(metaReplicationRangeLimitedBatches),
+		RangeLimitedNanos: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicationRangeLimitedBatchNanos,
+			Duration:     histogramWindow,
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
 		RunningCount:          metric.NewGauge(metaStreamsRunning),
 		ReplicatedTimeSeconds: metric.NewGauge(metaReplicatedTimeSeconds),
+		BufferPoolAllocations: metric.NewCounter(metaBufferPoolAllocations),
+		BufferPoolReuses:      metric.NewCounter(metaBufferPoolReuses),
+		BuffersTrimmed:        metric.NewCounter(metaBuffersTrimmed),
+		BufferCapacityHist: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaBufferCapacity,
+			Duration:     histogramWindow,
+			BucketConfig: metric.DataCount16MBuckets,
+		}),
+		FanoutEmitted: metric.NewCounter(metaFanoutEmitted),
+		FanoutDropped: metric.NewCounter(metaFanoutDropped),
+		SampledInKVs:  metric.NewCounter(metaSampledInKVs),
+		SampledOutKVs: metric.NewCounter(metaSampledOutKVs),
+
+		OversizedBatchSplits:  metric.NewCounter(metaOversizedBatchSplits),
+		DLQedRows:             metric.NewCounter(metaDLQedRows),
+		NonMonotonicApplies:   metric.NewCounter(metaNonMonotonicApplies),
+		UnknownEventsSkipped:  metric.NewCounter(metaUnknownEventsSkipped),
+		RetryableApplyErrors:  metric.NewCounter(metaRetryableApplyErrors),
+		FatalApplyErrors:      metric.NewCounter(metaFatalApplyErrors),
+		PrefetchReads:         metric.NewCounter(metaPrefetchReads),
+		PrefetchSkippedWrites: metric.NewCounter(metaPrefetchSkippedWrites),
+		LockTimeoutRetries:    metric.NewCounter(metaLockTimeoutRetries),
+		IgnoredDeletes:        metric.NewCounter(metaIgnoredDeletes),
+		SoftDeletes:           metric.NewCounter(metaSoftDeletes),
+		ScanHandoffSkippedKVs: metric.NewCounter(metaScanHandoffSkippedKVs),
+		QuarantinedTables:     metric.NewCounter(metaQuarantinedTables),
+		QuarantinedKVs:        metric.NewCounter(metaQuarantinedKVs),
+		FlushWorkersHist: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaFlushWorkers,
+			Duration:     histogramWindow,
+			BucketConfig: metric.Count1KBuckets,
+		}),
+		IntentResolutionNanos: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaIntentResolutionNanos,
+			Duration:     histogramWindow,
+			BucketConfig: metric.BatchProcessLatencyBuckets,
+		}),
+		TxnDeadlineExceeded: metric.NewCounter(metaTxnDeadlineExceeded),
+		LeaseRetries:        metric.NewCounter(metaLeaseRetries),
+		BatchedApplyRows:    metric.NewCounter(metaBatchedApplyRows),
+		StalledProcessors:   metric.NewCounter(metaStalledProcessors),
+		ShadowAppliedRows:   metric.NewCounter(metaShadowAppliedRows),
+		ShadowApplyErrors:   metric.NewCounter(metaShadowApplyErrors),
+		ShadowDivergences:   metric.NewCounter(metaShadowDivergences),
+
+		SubscriptionQueueBytes:     metric.NewGauge(metaSubscriptionQueueBytes),
+		SubscriptionQueuePauses:    metric.NewCounter(metaSubscriptionQueuePauses),
+		InitialScanRestarts:        metric.NewCounter(metaInitialScanRestarts),
+		InitialScanResumedSpans:    metric.NewCounter(metaInitialScanResumedSpans),
+		FrontierSpans:              metric.NewGauge(metaFrontierSpans),
+		FrontierCompactions:        metric.NewCounter(metaFrontierCompactions),
+		UnknownTableKVsSkipped:     metric.NewCounter(metaUnknownTableKVsSkipped),
+		FlushChunkingErrors:        metric.NewCounter(metaFlushChunkingErrors),
+		CollapsedUpdates:           metric.NewCounter(metaCollapsedUpdates),
+		SubscriptionFailovers:      metric.NewCounter(metaSubscriptionFailovers),
+		SlowFlushes:                metric.NewCounter(metaSlowFlushes),
+		RetryBudgetExhausted:       metric.NewCounter(metaRetryBudgetExhausted),
+		CutoverDivergentRows:       metric.NewCounter(metaCutoverDivergentRows),
+		SubscribeHandshakeTimeouts: metric.NewCounter(metaSubscribeHandshakeTimeouts),
+		ReplicationLagSeconds:      aggmetric.NewFunctionalGauge(metaReplicationLagSeconds, maxChildValue, "job_id"),
+		CheckViolations:            metric.NewCounter(metaCheckViolations),
+		FrontierMilestonesCrossed:  metric.NewCounter(metaFrontierMilestonesCrossed),
+		DroppedColumnValues:        metric.NewCounter(metaDroppedColumnValues),
+		RejectedFutureEvents:       metric.NewCounter(metaRejectedFutureEvents),
+		PriorValueMismatches:       metric.NewCounter(metaPriorValueMismatches),
+		NotNullViolations:          metric.NewCounter(metaNotNullViolations),
+		ReplicationGaps:            metric.NewGauge(metaReplicationGaps),
+		ReplicatedValueSizeHist: metric.NewHistogram(metric.HistogramOptions{
+			Mode:         metric.HistogramModePrometheus,
+			Metadata:     metaReplicatedValueSize,
+			Duration:     histogramWindow,
+			BucketConfig: metric.DataSize16MBBuckets,
+		}),
 	}
 }
@@ -0,0 +1,102 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/settings/cluster"
+	"github.com/cockroachdb/cockroach/pkg/util/cache"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/errors"
+)
+
+type monotonicityCheckMode int64
+
+const (
+	monotonicityCheckOff monotonicityCheckMode = iota
+	monotonicityCheckLog
+	monotonicityCheckError
+)
+
+var monotonicityCheck = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.monotonicity_check",
+	"if not off, the source timestamps of the KVs applied to recently seen keys are tracked and "+
+		"a KV applied with an earlier timestamp than one already applied to its key is either "+
+		"logged and counted or fails replication",
+	"off",
+	map[int64]string{
+		int64(monotonicityCheckOff):   "off",
+		int64(monotonicityCheckLog):   "log",
+		int64(monotonicityCheckError): "error",
+	},
+)
+
+// monotonicityCheckKeys bounds the number of keys whose last applied
+// timestamp is tracked by an appliedTimestamps.
+const monotonicityCheckKeys = 1 << 16
+
+// appliedTimestamps verifies that the source timestamps of the KVs applied to
+// each key never go backwards. Under last-write-wins, an earlier write applied
+// after a later one must never win, so a violation points to a reordering bug.
+// To bound its memory, only the most recently applied keys are tracked.
+type appliedTimestamps struct {
+	settings *cluster.Settings
+	metrics  *Metrics
+	mu       struct {
+		syncutil.Mutex
+		// seen maps keys to the timestamp of the last KV applied to them.
+		seen *cache.UnorderedCache
+	}
+}
+
+func newAppliedTimestamps(settings *cluster.Settings, metrics *Metrics) *appliedTimestamps {
+	a := &appliedTimestamps{settings: settings, metrics: metrics}
+	a.mu.seen = cache.NewUnorderedCache(cache.Config{
+		Policy: cache.CacheLRU,
+		ShouldEvict: func(size int, _, _ interface{}) bool {
+			return size > monotonicityCheckKeys
+		},
+	})
+	return a
+}
+
+// record records that the given KV was applied. It returns an error if the
+// KV's timestamp is earlier than that of a KV previously applied to the same
+// key and the check is configured to fail replication.
+func (a *appliedTimestamps) record(ctx context.Context, kv replicatedKV) error {
+	mode := monotonicityCheckMode(monotonicityCheck.Get(&a.settings.SV))
+	if mode == monotonicityCheckOff {
+		return nil
+	}
+	ts := kv.Value.Timestamp
+	key := string(kv.Key)
+
+	a.mu.Lock()
+	prev, ok := a.mu.seen.Get(key)
+	if !ok || prev.(hlc.Timestamp).Less(ts) {
+		a.mu.seen.Add(key, ts)
+	}
+	a.mu.Unlock()
+
+	if !ok || !ts.Less(prev.(hlc.Timestamp)) {
+		return nil
+	}
+	a.metrics.NonMonotonicApplies.Inc(1)
+	if mode == monotonicityCheckError {
+		return errors.AssertionFailedf("applied KV for key %s at %s after applying it at %s",
+			kv.Key, ts, prev.(hlc.Timestamp))
+	}
+	log.Warningf(ctx, "applied KV for key %s at %s after applying it at %s", kv.Key, ts, prev.(hlc.Timestamp))
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/parser/statements"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/catid"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+type notNullViolationPolicy int64
+
+const (
+	notNullViolationPause notNullViolationPolicy = iota
+	notNullViolationDLQ
+	notNullViolationDefault
+)
+
+// notNullViolationPolicySetting decides what happens to replicated rows with
+// a NULL for a column their destination table marks NOT NULL, e.g. because
+// the destination added a NOT NULL constraint the source table lacks.
+var notNullViolationPolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.not_null_violation_policy",
+	"what to do with replicated rows that have a NULL for a column their destination table marks "+
+		"NOT NULL: pause pauses the job until an operator intervenes, dlq sends them to the dead "+
+		"letter queue and default applies them without the NULL, so that new destination rows get "+
+		"the column's DEFAULT and existing ones keep their value",
+	"pause",
+	map[int64]string{
+		int64(notNullViolationPause):   "pause",
+		int64(notNullViolationDLQ):     "dlq",
+		int64(notNullViolationDefault): "default",
+	},
+)
+
+// isNotNullViolation returns true if the error is the rejection of a write
+// of a NULL to a NOT NULL column.
+func isNotNullViolation(err error) bool {
+	return pgerror.GetPGCode(err) == pgcode.NotNullViolation
+}
+
+// annotateNotNullViolation wraps the error of a row's write that has a NULL
+// for a NOT NULL column of its destination table, which the error names, with
+// the names of the row's NULL columns. The row's values aren't included since
+// the error ends up in the job's status and the logs.
+func annotateNotNullViolation(row cdcevent.Row, err error) error {
+	var nulls []string
+	_ = row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if !col.Computed && d == tree.DNull {
+			nulls = append(nulls, col.Name)
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "row of table %s with NULL columns %s violates a destination NOT NULL constraint",
+		row.TableName, strings.Join(nulls, ", "))
+}
+
+// handleNotNullViolation handles a row that has a NULL for a NOT NULL column
+// of its destination table according to not_null_violation_policy: it is sent
+// to the dead letter queue or else returned as a permanent job error. Rows
+// only reach here under the default policy if the column has no DEFAULT or
+// its NULL wasn't known to violate the constraint when the row was applied.
+func (lrw *logicalReplicationWriterProcessor) handleNotNullViolation(
+	ctx context.Context, kv replicatedKV, err error,
+) error {
+	lrw.metrics.NotNullViolations.Inc(1)
+	if notNullViolationPolicy(notNullViolationPolicySetting.Get(&lrw.FlowCtx.Cfg.Settings.SV)) == notNullViolationDLQ {
+		return lrw.sendToDLQ(ctx, kv, err)
+	}
+	return jobs.MarkAsPermanentJobError(errors.WithHint(err,
+		"set logical_replication.consumer.not_null_violation_policy to dlq to resume the job without "+
+			"applying such rows, or give the destination column a DEFAULT and set it to default"))
+}
+
+// destinationNotNullColumns returns the names of the columns of the source
+// table that allow NULLs but that the destination table marks NOT NULL.
+func destinationNotNullColumns(src, dest catalog.TableDescriptor) map[string]struct{} {
+	var notNull map[string]struct{}
+	for _, col := range src.PublicColumns() {
+		if col.IsComputed() || !col.IsNullable() {
+			continue
+		}
+		destCol := catalog.FindColumnByName(dest, col.GetName())
+		if destCol == nil || destCol.IsNullable() {
+			continue
+		}
+		if notNull == nil {
+			notNull = make(map[string]struct{})
+		}
+		notNull[col.GetName()] = struct{}{}
+	}
+	return notNull
+}
+
+// resolveDestinationNotNullColumns returns, for each source table with
+// nullable columns its destination table marks NOT NULL, the names of those
+// columns.
+func resolveDestinationNotNullColumns(
+	ctx context.Context, db descs.DB, tableDescs map[string]descpb.TableDescriptor,
+) (map[descpb.ID]map[string]struct{}, error) {
+	res := make(map[descpb.ID]map[string]struct{})
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		if notNull := destinationNotNullColumns(src, dest); notNull != nil {
+			names := make([]string, 0, len(notNull))
+			for col := range notNull {
+				names = append(names, col)
+			}
+			sort.Strings(names)
+			log.Infof(ctx, "destination table %s marks nullable source columns %v NOT NULL", name, names)
+			res[src.GetID()] = notNull
+		}
+		return nil
+	})
+	return res, err
+}
+
+// defaultsNullValue returns true if a NULL for the column of the row should
+// be left out of its write since its destination table marks the column NOT
+// NULL and not_null_violation_policy is default.
+func (lww *sqlLastWriteWinsRowProcessor) defaultsNullValue(
+	tableID catid.DescID, name string, d tree.Datum,
+) bool {
+	if d != tree.DNull {
+		return false
+	}
+	if _, ok := lww.notNullColumns[tableID][name]; !ok {
+		return false
+	}
+	return notNullViolationPolicy(notNullViolationPolicySetting.Get(&lww.settings.SV)) == notNullViolationDefault
+}
+
+// defaultedInsertQuery returns the insert statement used to apply a row of
+// the given column family without the given columns, whose values are NULL,
+// and that resets the given destination-only columns to their DEFAULT,
+// generating it if necessary.
+func (qb *queryBuffer) defaultedInsertQuery(
+	tableID catid.DescID,
+	familyID catid.FamilyID,
+	dropped map[string]struct{},
+	columnNames []string,
+	reset []string,
+) (statements.Statement[tree.Statement], error) {
+	cacheKey := fmt.Sprintf("%d/%d/%s/%s", tableID, familyID, strings.Join(columnNames, ","),
+		strings.Join(reset, ","))
+	if q, ok := qb.defaultedQueries[cacheKey]; ok {
+		return q, nil
+	}
+	omitted := make(map[string]struct{}, len(dropped)+len(columnNames))
+	for name := range dropped {
+		omitted[name] = struct{}{}
+	}
+	for _, name := range columnNames {
+		omitted[name] = struct{}{}
+	}
+	queries, err := makeInsertQueries(qb.tableNames[tableID], qb.tableDescs[tableID],
+		qb.regionRules[tableID], qb.auditRules[tableID], omitted, reset, qb.softDeleteColumn)
+	if err != nil {
+		return statements.Statement[tree.Statement]{}, err
+	}
+	q, ok := queries[familyID]
+	if !ok {
+		return statements.Statement[tree.Statement]{}, errors.Errorf(
+			"no pre-generated insert query for table %d column family %d", tableID, familyID)
+	}
+	qb.defaultedQueries[cacheKey] = q
+	return q, nil
+}
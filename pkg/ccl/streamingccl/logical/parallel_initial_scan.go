@@ -0,0 +1,353 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl"
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/errors"
+)
+
+// A partition is subscribed to as a whole, so its initial scan is read
+// through a single event stream however many spans it covers. If
+// initial_scan_parallelism is greater than 1 and the initial scan of the
+// partition hasn't completed, its spans are instead split into that many
+// groups of contiguous spans, each of which is subscribed to on its own from
+// the same source address. Their events are merged into the processor's
+// event queue, so the rows they scan are buffered, flushed and applied by the
+// processor's workers as usual, and their checkpoints forward the processor's
+// frontier, which thus covers all the partition's spans. Once the frontier
+// shows that every span has been scanned, the processor converges to a
+// single subscription to the whole partition, resuming from the frontier.
+//
+// The source merges the spans of adjacent ranges it plans on the same node, so
+// a partition often covers fewer spans than there are subscriptions, e.g. a
+// single span covering a whole table. Its spans are then split in key space,
+// each into about as many parts as needed. The processor doesn't know how the
+// rows of a span are distributed, so the parts may hold very different
+// numbers of rows. The scan subscriptions don't fail over to the partition's fallback addresses; if one
+// fails, the flow fails and the job resumes the scan from its checkpoint.
+
+var initialScanParallelism = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.initial_scan_parallelism",
+	"the number of subscriptions across which a writer processor splits the spans of its partition "+
+		"while their initial scan runs, before converging to a single subscription once it completes; "+
+		"if 1, the initial scan is read through the partition's single subscription",
+	1,
+	settings.PositiveInt,
+)
+
+// parallelScan holds the subscriptions across which the initial scan of the
+// processor's partition is split.
+type parallelScan struct {
+	ranges []*scanRange
+	// cancel cancels the scan subscriptions once the processor has converged
+	// to a single subscription.
+	cancel    context.CancelFunc
+	converged atomic.Bool
+}
+
+// scanRange is a group of contiguous spans of the partition whose initial
+// scan is read through a subscription of its own.
+type scanRange struct {
+	spans []roachpb.Span
+	// frontier tracks the progress of the subscription. It is only accessed
+	// by the goroutine that forwards the subscription's events.
+	frontier span.Frontier
+	kvs      atomic.Int64
+	done     atomic.Bool
+}
+
+// splitScanSpans splits the spans into at most n groups of contiguous spans,
+// each with about the same number of spans, splitting the spans in key space
+// first if there are fewer than n. It returns nil if the spans can't be split
+// into at least two groups.
+func splitScanSpans(spans []roachpb.Span, n int) [][]roachpb.Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(spans)
+	if len(sorted) < n {
+		parts := (n + len(sorted) - 1) / len(sorted)
+		split := make([]roachpb.Span, 0, len(sorted)*parts)
+		for _, sp := range sorted {
+			split = append(split, splitKeySpan(sp, parts)...)
+		}
+		sorted = split
+	}
+	n = min(n, len(sorted))
+	if n < 2 {
+		return nil
+	}
+	slices.SortFunc(sorted, func(a, b roachpb.Span) int { return a.Key.Compare(b.Key) })
+	groups := make([][]roachpb.Span, 0, n)
+	for i := 0; i < n; i++ {
+		groups = append(groups, sorted[i*len(sorted)/n:(i+1)*len(sorted)/n])
+	}
+	return groups
+}
+
+// splitKeySpan splits the span into at most n spans of about the same width in
+// key space, regardless of how its keys are distributed. The split keys extend
+// the longest common prefix of the span's bounds by eight bytes, which are
+// evenly spaced between the next eight bytes of the bounds. It returns the
+// span itself if it is too narrow to split.
+func splitKeySpan(sp roachpb.Span, n int) []roachpb.Span {
+	prefix := 0
+	for prefix < len(sp.Key) && prefix < len(sp.EndKey) && sp.Key[prefix] == sp.EndKey[prefix] {
+		prefix++
+	}
+	start, end := keyWord(sp.Key[prefix:]), keyWord(sp.EndKey[prefix:])
+	if n < 2 || end <= start || (end-start)/uint64(n) == 0 {
+		return []roachpb.Span{sp}
+	}
+	width := (end - start) / uint64(n)
+	spans := make([]roachpb.Span, 0, n)
+	key := sp.Key
+	for i := 1; i < n; i++ {
+		split := make(roachpb.Key, prefix+8)
+		copy(split, sp.Key[:prefix])
+		binary.BigEndian.PutUint64(split[prefix:], start+width*uint64(i))
+		spans = append(spans, roachpb.Span{Key: key, EndKey: split})
+		key = split
+	}
+	return append(spans, roachpb.Span{Key: key, EndKey: sp.EndKey})
+}
+
+// keyWord returns the first eight bytes of the key, padded with zeros, as a
+// big-endian integer.
+func keyWord(key []byte) uint64 {
+	var word [8]byte
+	copy(word[:], key)
+	return binary.BigEndian.Uint64(word[:])
+}
+
+// scanToken returns the subscription token of the processor's partition
+// restricted to the given spans.
+func scanToken(
+	token streamclient.SubscriptionToken, spans []roachpb.Span,
+) (streamclient.SubscriptionToken, error) {
+	var spec streampb.StreamPartitionSpec
+	if err := protoutil.Unmarshal(token, &spec); err != nil {
+		return nil, err
+	}
+	spec.Spans = spans
+	return protoutil.Marshal(&spec)
+}
+
+// subscribeToken subscribes to the spans of the given token from the
+// processor's current client, resuming from the given frontier.
+func (lrw *logicalReplicationWriterProcessor) subscribeToken(
+	ctx context.Context, token streamclient.SubscriptionToken, frontier span.Frontier,
+) (streamclient.Subscription, error) {
+	lrw.streamPartitionClientMu.Lock()
+	client := lrw.streamPartitionClient
+	lrw.streamPartitionClientMu.Unlock()
+	if client == nil {
+		return nil, errors.New("processor closed")
+	}
+	return client.Subscribe(ctx,
+		streampb.StreamID(lrw.spec.StreamID),
+		int32(lrw.flowCtx.NodeID.SQLInstanceID()), lrw.ProcessorID,
+		token,
+		lrw.spec.InitialScanTimestamp, frontier,
+		streamclient.WithFiltering(subscriptionFiltering.Get(&lrw.FlowCtx.Cfg.Settings.SV)),
+		streamclient.WithDiff(lrw.spec.Options.CompareAndSwap),
+	)
+}
+
+// maybeStartParallelScan subscribes to the spans of the partition in groups,
+// if initial_scan_parallelism is greater than 1 and their initial scan hasn't
+// completed, and reads the events of the subscriptions into a new eventQueue.
+// It returns false if the initial scan isn't split, in which case the
+// partition's single subscription should be started.
+func (lrw *logicalReplicationWriterProcessor) maybeStartParallelScan(
+	ctx context.Context,
+) (bool, error) {
+	n := int(initialScanParallelism.Get(&lrw.FlowCtx.Cfg.Settings.SV))
+	if n < 2 || lrw.spec.InitialScanTimestamp.IsEmpty() || !lrw.frontier.Frontier().IsEmpty() {
+		return false, nil
+	}
+	token := streamclient.SubscriptionToken(lrw.spec.PartitionSpec.SubscriptionToken)
+	var spec streampb.StreamPartitionSpec
+	if err := protoutil.Unmarshal(token, &spec); err != nil {
+		return false, err
+	}
+	groups := splitScanSpans(spec.Spans, n)
+	if groups == nil {
+		return false, nil
+	}
+
+	scan := &parallelScan{ranges: make([]*scanRange, len(groups))}
+	subs := make([]streamclient.Subscription, len(groups))
+	for i, spans := range groups {
+		r := &scanRange{spans: spans}
+		scan.ranges[i] = r
+		var err error
+		if r.frontier, err = span.MakeFrontier(spans...); err != nil {
+			return false, err
+		}
+		// Spans that were scanned before the processor restarted aren't
+		// scanned again.
+		lrw.frontier.Entries(func(sp roachpb.Span, ts hlc.Timestamp) span.OpResult {
+			if _, err = r.frontier.Forward(sp, ts); err != nil {
+				return span.StopMatch
+			}
+			return span.ContinueMatch
+		})
+		if err != nil {
+			return false, err
+		}
+		rangeToken, err := scanToken(token, spans)
+		if err != nil {
+			return false, err
+		}
+		if subs[i], err = lrw.subscribeToken(ctx, rangeToken, r.frontier); err != nil {
+			return false, errors.Wrap(err, "subscribing to initial scan spans")
+		}
+	}
+	log.Infof(ctx, "splitting the initial scan of partition %s across %d subscriptions",
+		lrw.spec.PartitionSpec.PartitionID, len(subs))
+
+	scanCtx, cancel := context.WithCancel(lrw.subscriptionCtx)
+	scan.cancel = cancel
+	merged := make(chan streamingccl.Event)
+	var forwarders sync.WaitGroup
+	for i, sub := range subs {
+		r := scan.ranges[i]
+		deadline, run := lrw.runSubscription(scanCtx, sub)
+		lrw.workerGroup.GoCtx(func(_ context.Context) error {
+			if err := run(); err != nil && scanCtx.Err() == nil {
+				lrw.sendError(errors.Wrap(err, "initial scan subscription"))
+			}
+			return nil
+		})
+		forwarders.Add(1)
+		lrw.workerGroup.GoCtx(func(ctx context.Context) error {
+			defer forwarders.Done()
+			defer r.frontier.Release()
+			for event := range sub.Events() {
+				deadline.received()
+				r.track(ctx, event)
+				select {
+				case merged <- event:
+				case <-scanCtx.Done():
+					return nil
+				case <-lrw.stopCh:
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	lrw.workerGroup.GoCtx(func(_ context.Context) error {
+		forwarders.Wait()
+		close(merged)
+		return nil
+	})
+
+	queue := newEventQueue(&lrw.FlowCtx.Cfg.Settings.SV, lrw.metrics, lrw.eventQueueAcc)
+	lrw.eventQueue = queue
+	lrw.workerGroup.GoCtx(func(ctx context.Context) error {
+		if err := queue.run(ctx, merged, nil /* deadline */, lrw.stopCh); err != nil {
+			lrw.sendError(errors.Wrap(err, "event queue"))
+		}
+		return nil
+	})
+	lrw.parallelScan.Store(scan)
+	return true, nil
+}
+
+// track records the progress reported by an event of the range's
+// subscription.
+func (r *scanRange) track(ctx context.Context, event streamingccl.Event) {
+	switch event.Type() {
+	case streamingccl.KVEvent:
+		r.kvs.Add(int64(len(event.GetKVs())))
+	case streamingccl.CheckpointEvent:
+		if r.done.Load() {
+			return
+		}
+		for _, resolved := range event.GetResolvedSpans() {
+			if _, err := r.frontier.Forward(resolved.Span, resolved.Timestamp); err != nil {
+				return
+			}
+		}
+		if !r.frontier.Frontier().IsEmpty() {
+			r.done.Store(true)
+			log.Infof(ctx, "initial scan of %d spans from %s completed after %d KVs",
+				len(r.spans), r.spans[0].Key, r.kvs.Load())
+		}
+	}
+}
+
+// scanning returns true if the initial scan is split across several
+// subscriptions and the processor hasn't converged to a single one yet.
+func (lrw *logicalReplicationWriterProcessor) scanning() bool {
+	scan := lrw.parallelScan.Load()
+	return scan != nil && !scan.converged.Load()
+}
+
+// maybeConvergeScan replaces the subscriptions across which the initial scan
+// is split with a single subscription to the whole partition, resuming from
+// the frontier, once the frontier shows that every span has been scanned. The
+// events of the scan subscriptions at or below the frontier have all been
+// consumed by then, so those left in their queue are dropped and received
+// again from the new subscription.
+func (lrw *logicalReplicationWriterProcessor) maybeConvergeScan(ctx context.Context) error {
+	if !lrw.scanning() || lrw.frontier.Frontier().IsEmpty() {
+		return nil
+	}
+	scan := lrw.parallelScan.Load()
+	token := streamclient.SubscriptionToken(lrw.spec.PartitionSpec.SubscriptionToken)
+	sub, err := lrw.subscribeToken(ctx, token, lrw.frontier)
+	if err != nil {
+		return errors.Wrap(err, "subscribing to partition after initial scan")
+	}
+	log.Infof(ctx, "initial scan of partition %s completed; converging to a single subscription",
+		lrw.spec.PartitionSpec.PartitionID)
+	scan.converged.Store(true)
+	scan.cancel()
+	lrw.eventQueue.close(ctx)
+	lrw.startSubscription(sub)
+	return nil
+}
+
+// initialScanRanges returns the progress of the subscriptions across which
+// the initial scan is split, or nil if it isn't.
+func (lrw *logicalReplicationWriterProcessor) initialScanRanges() []streampb.DebugInitialScanRange {
+	scan := lrw.parallelScan.Load()
+	if scan == nil {
+		return nil
+	}
+	res := make([]streampb.DebugInitialScanRange, len(scan.ranges))
+	for i, r := range scan.ranges {
+		res[i] = streampb.DebugInitialScanRange{
+			Span:  roachpb.Span{Key: r.spans[0].Key, EndKey: r.spans[len(r.spans)-1].EndKey},
+			Spans: int64(len(r.spans)),
+			KVs:   r.kvs.Load(),
+			Done:  r.done.Load(),
+		}
+	}
+	return res
+}
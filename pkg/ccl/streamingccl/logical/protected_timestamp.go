@@ -0,0 +1,157 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobsprotectedts"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/protectedts"
+	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/protectedts/ptpb"
+	"github.com/cockroachdb/cockroach/pkg/sql"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/uuid"
+	"github.com/cockroachdb/errors"
+)
+
+// gcThresholdWarningFraction is the fraction of the shortest GC TTL of the
+// destination tables the replicated time may lag behind before the job warns
+// that it is approaching the GC threshold.
+const gcThresholdWarningFraction = 0.8
+
+// resolveDestinationTables returns the IDs of the destination tables with the
+// given names and the shortest GC TTL among them.
+func resolveDestinationTables(
+	ctx context.Context, db descs.DB, tableNames []string,
+) (descpb.IDs, time.Duration, error) {
+	var ids descpb.IDs
+	var gcTTL time.Duration
+	err := db.DescsTxn(ctx, func(ctx context.Context, txn descs.Txn) error {
+		ids, gcTTL = ids[:0], 0
+		for _, name := range tableNames {
+			res, err := txn.QueryRowEx(ctx, "resolve-destination-table", txn.KV(),
+				sessiondata.NodeUserSessionDataOverride, `SELECT $1::STRING::REGCLASS::OID`, name)
+			if err != nil {
+				return err
+			}
+			id := descpb.ID(tree.MustBeDOid(res[0]).Oid)
+			zone, err := sql.GetHydratedZoneConfigForTable(ctx, txn.KV(), txn.Descriptors(), id)
+			if err != nil {
+				return err
+			}
+			if ttl := time.Duration(zone.GC.TTLSeconds) * time.Second; gcTTL == 0 || ttl < gcTTL {
+				gcTTL = ttl
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	return ids, gcTTL, err
+}
+
+// protectDestinationTables writes a protected timestamp record protecting the
+// destination tables from GC at and above the given timestamp, so that the
+// rows being applied are not collected from under the stream. The record is
+// advanced along with the job's replicated time and released when the job
+// completes, fails, is canceled or pauses after a permanent error. Its ID is
+// persisted in the job's payload. It is a no-op if the job already has a
+// record.
+func (r *logicalReplicationResumer) protectDestinationTables(
+	ctx context.Context, execCfg *sql.ExecutorConfig, tableIDs descpb.IDs, ts hlc.Timestamp,
+) error {
+	if r.job.Details().(jobspb.LogicalReplicationDetails).ProtectedTimestampRecordID != nil {
+		return nil
+	}
+	ptsID := uuid.MakeV4()
+	return execCfg.InternalDB.Txn(ctx, func(ctx context.Context, txn isql.Txn) error {
+		pts := jobsprotectedts.MakeRecord(ptsID, int64(r.job.ID()), ts,
+			nil /* deprecatedSpans */, jobsprotectedts.Jobs, ptpb.MakeSchemaObjectsTarget(tableIDs))
+		if err := execCfg.ProtectedTimestampProvider.WithTxn(txn).Protect(ctx, pts); err != nil {
+			return err
+		}
+		return r.job.WithTxn(txn).Update(ctx, func(
+			txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+		) error {
+			if err := md.CheckRunningOrReverting(); err != nil {
+				return err
+			}
+			md.Payload.GetLogicalReplication().ProtectedTimestampRecordID = &ptsID
+			ju.UpdatePayload(md.Payload)
+			return nil
+		})
+	})
+}
+
+// releaseDestinationProtectedTimestamp releases the protected timestamp record
+// of the destination tables, if the job has one, and removes its ID from the
+// job's payload so that the tables are protected again if the job resumes.
+func (r *logicalReplicationResumer) releaseDestinationProtectedTimestamp(
+	ctx context.Context, execCfg *sql.ExecutorConfig,
+) error {
+	ptsID := r.job.Details().(jobspb.LogicalReplicationDetails).ProtectedTimestampRecordID
+	if ptsID == nil {
+		return nil
+	}
+	return execCfg.InternalDB.Txn(ctx, func(ctx context.Context, txn isql.Txn) error {
+		err := execCfg.ProtectedTimestampProvider.WithTxn(txn).Release(ctx, *ptsID)
+		if errors.Is(err, protectedts.ErrNotExists) {
+			log.Warningf(ctx, "failed to release protected timestamp as it does not exist: %s", err)
+		} else if err != nil {
+			return err
+		}
+		return r.job.WithTxn(txn).Update(ctx, func(
+			txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater,
+		) error {
+			md.Payload.GetLogicalReplication().ProtectedTimestampRecordID = nil
+			ju.UpdatePayload(md.Payload)
+			return nil
+		})
+	})
+}
+
+// advanceProtectedTimestamp moves the protected timestamp record of the
+// destination tables up to the replicated time. A record that no longer exists
+// can't be maintained, so the job is paused rather than risking applying rows
+// to tables that may be collected from under it.
+func advanceProtectedTimestamp(
+	ctx context.Context, ptp protectedts.Storage, ptsID uuid.UUID, replicatedTime hlc.Timestamp,
+) (hlc.Timestamp, error) {
+	record, err := ptp.GetRecord(ctx, ptsID)
+	if err != nil {
+		if errors.Is(err, protectedts.ErrNotExists) {
+			return hlc.Timestamp{}, jobs.MarkAsPermanentJobError(errors.Wrapf(err,
+				"protected timestamp record %s of the destination tables can no longer be maintained", ptsID))
+		}
+		return hlc.Timestamp{}, err
+	}
+	if !record.Timestamp.Less(replicatedTime) {
+		return record.Timestamp, nil
+	}
+	return replicatedTime, ptp.UpdateTimestamp(ctx, ptsID, replicatedTime)
+}
+
+// approachingGCThreshold returns true if the replicated time lags far enough
+// behind now that the destination tables may soon be GC'd past it were they
+// not protected.
+func approachingGCThreshold(now, replicatedTime hlc.Timestamp, gcTTL time.Duration) bool {
+	if gcTTL == 0 || replicatedTime.IsEmpty() {
+		return false
+	}
+	lag := now.GoTime().Sub(replicatedTime.GoTime())
+	return lag > time.Duration(float64(gcTTL)*gcThresholdWarningFraction)
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+var quarantineThreshold = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.quarantine_threshold",
+	"the number of rows of a table that may be sent to the dead letter queue within "+
+		"quarantine_window before the table is quarantined: its rows are sent to the dead letter "+
+		"queue without being applied until the job is paused and resumed, while the other tables keep replicating; "+
+		"if 0, tables are never quarantined",
+	0,
+	settings.NonNegativeInt,
+)
+
+var quarantineWindow = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.quarantine_window",
+	"the window over which the rows of a table sent to the dead letter queue are counted "+
+		"towards quarantine_threshold",
+	time.Minute,
+	settings.PositiveDuration,
+)
+
+// tableQuarantine tracks the rate at which the rows of each source table are
+// sent to the dead letter queue and quarantines the tables whose rows keep
+// failing to apply, e.g. because their destination's schema doesn't match, so
+// that the rest of the stream isn't slowed down by attempting and logging each
+// of their rows. Rows of quarantined tables are written to the dead letter
+// queue without being applied, so that they can be replayed once the
+// destination is fixed, and the destination table diverges from the source
+// until they are. Quarantine lasts for the lifetime of the processor, so a
+// table is retried once the job is resumed after the destination has been
+// fixed.
+type tableQuarantine struct {
+	settings *settings.Values
+	mu       struct {
+		syncutil.Mutex
+		// windows maps tables to the start of their current window and the
+		// number of rows sent to the dead letter queue within it.
+		windows map[descpb.ID]dlqWindow
+		// quarantined holds the IDs of the quarantined tables.
+		quarantined map[descpb.ID]struct{}
+	}
+	// numQuarantined is the number of quarantined tables. It lets the KVs of
+	// streams without quarantined tables be checked without locking.
+	numQuarantined atomic.Int32
+}
+
+type dlqWindow struct {
+	start time.Time
+	count int64
+}
+
+func newTableQuarantine(sv *settings.Values) *tableQuarantine {
+	q := &tableQuarantine{settings: sv}
+	q.mu.windows = make(map[descpb.ID]dlqWindow)
+	q.mu.quarantined = make(map[descpb.ID]struct{})
+	return q
+}
+
+// recordDLQ records that a row of the given table was sent to the dead letter
+// queue at the given time. It returns true if the table was quarantined as a
+// result.
+func (q *tableQuarantine) recordDLQ(tableID descpb.ID, now time.Time) bool {
+	threshold := quarantineThreshold.Get(q.settings)
+	if threshold == 0 {
+		return false
+	}
+	window := quarantineWindow.Get(q.settings)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.mu.quarantined[tableID]; ok {
+		return false
+	}
+	w := q.mu.windows[tableID]
+	if now.Sub(w.start) >= window {
+		w = dlqWindow{start: now}
+	}
+	w.count++
+	q.mu.windows[tableID] = w
+	if w.count <= threshold {
+		return false
+	}
+	q.mu.quarantined[tableID] = struct{}{}
+	q.numQuarantined.Add(1)
+	delete(q.mu.windows, tableID)
+	return true
+}
+
+// isQuarantined returns true if the given table is quarantined.
+func (q *tableQuarantine) isQuarantined(tableID descpb.ID) bool {
+	if q.numQuarantined.Load() == 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.mu.quarantined[tableID]
+	return ok
+}
+
+// tables returns the IDs of the quarantined tables in ascending order.
+func (q *tableQuarantine) tables() []descpb.ID {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.mu.quarantined) == 0 {
+		return nil
+	}
+	ids := make([]descpb.ID, 0, len(q.mu.quarantined))
+	for id := range q.mu.quarantined {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// sourceTableID returns the ID of the source table the KV belongs to.
+func sourceTableID(kv replicatedKV) (descpb.ID, bool) {
+	rest, err := keys.StripTenantPrefix(kv.Key)
+	if err != nil {
+		return 0, false
+	}
+	_, tableID, err := keys.SystemSQLCodec.DecodeTablePrefix(rest)
+	if err != nil {
+		return 0, false
+	}
+	return descpb.ID(tableID), true
+}
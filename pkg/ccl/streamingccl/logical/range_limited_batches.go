@@ -0,0 +1,207 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descs"
+	"github.com/cockroachdb/cockroach/pkg/sql/rowenc"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// A batch whose rows span many destination ranges is applied by a distributed
+// transaction that touches all of them, which is slower to commit and more
+// likely to contend than several transactions that each touch a few ranges. If
+// max_ranges_per_batch is set, a batch is cut short at the first row whose
+// destination keys fall in a range beyond that many, according to the range
+// cache, and the rest of its rows are applied by the next batches. Rows whose
+// ranges aren't cached don't cut batches short.
+//
+// The keys of a row are its primary key and the keys of the entries it writes
+// to the secondary indexes of its destination table, which usually fall in
+// other ranges than its primary key. Finding the latter takes decoding the row
+// and encoding its index entries ahead of applying it, which is only done
+// while max_ranges_per_batch is set. The entries a delete removes aren't known
+// without the row's prior value, so only the primary key of a delete counts.
+
+var maxRangesPerBatch = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_ranges_per_batch",
+	"if non-zero, batches are split at destination range boundaries so that the rows applied "+
+		"by each transaction fall in at most this many ranges",
+	0,
+	settings.NonNegativeInt,
+)
+
+// rangeLimitedEnd returns the index of the first KV in kvs[start:end] whose
+// destination keys fall in ranges other than the first maxRanges ranges of the
+// preceding KVs, or end if there is none. rangesOf returns the ranges of the
+// destination keys of a KV, or false if they aren't known, in which case the
+// batch is not cut short. The result is always greater than start if end is,
+// even if the first KV alone writes to more than maxRanges ranges.
+func rangeLimitedEnd(
+	kvs []replicatedKV,
+	start, end, maxRanges int,
+	rangesOf func(replicatedKV) ([]roachpb.RangeID, bool),
+) int {
+	if maxRanges <= 0 {
+		return end
+	}
+	seen := make(map[roachpb.RangeID]struct{}, maxRanges)
+	for i := start; i < end; i++ {
+		rangeIDs, ok := rangesOf(kvs[i])
+		if !ok {
+			return end
+		}
+		added := 0
+		for _, rangeID := range rangeIDs {
+			if _, ok := seen[rangeID]; !ok {
+				added++
+			}
+		}
+		if added == 0 {
+			continue
+		}
+		if i > start && len(seen)+added > maxRanges {
+			return i
+		}
+		for _, rangeID := range rangeIDs {
+			seen[rangeID] = struct{}{}
+		}
+	}
+	return end
+}
+
+// rangeLimitedBatchEnd returns the index at which the batch kvs[start:end]
+// should end for its rows to fall in at most max_ranges_per_batch cached
+// destination ranges. The index is moved to the next boundary given by
+// batchEnd so that the rows, or source transactions, of the batch aren't split.
+func (t *txnBatch) rangeLimitedBatchEnd(
+	ctx context.Context,
+	kvs []replicatedKV,
+	start, end int,
+	batchEnd func([]replicatedKV, int) int,
+) int {
+	maxRanges := int(maxRangesPerBatch.Get(&t.settings.SV))
+	if maxRanges == 0 || t.rangeCache == nil {
+		return end
+	}
+	keyer, _ := t.rp.(secondaryIndexKeyer)
+	var rangeIDs []roachpb.RangeID
+	limited := rangeLimitedEnd(kvs, start, end, maxRanges, func(kv replicatedKV) ([]roachpb.RangeID, bool) {
+		key, ok := t.destinationKey(kv)
+		if !ok {
+			return nil, false
+		}
+		destKeys := []roachpb.RKey{key}
+		if keyer != nil {
+			indexKeys, err := keyer.SecondaryIndexKeys(ctx, kv)
+			if err != nil {
+				return nil, false
+			}
+			destKeys = append(destKeys, indexKeys...)
+		}
+		rangeIDs = rangeIDs[:0]
+		for _, key := range destKeys {
+			ranges := t.rangeCache.GetCachedOverlapping(ctx, roachpb.RSpan{Key: key, EndKey: key.Next()})
+			if len(ranges) != 1 || !ranges[0].Desc.ContainsKey(key) {
+				return nil, false
+			}
+			rangeIDs = append(rangeIDs, ranges[0].Desc.RangeID)
+		}
+		return rangeIDs, true
+	})
+	if limited == end {
+		return end
+	}
+	return batchEnd(kvs[:end], limited)
+}
+
+// secondaryIndexKeyer is implemented by RowProcessors that can tell which keys
+// of the secondary indexes of its destination table a row writes.
+type secondaryIndexKeyer interface {
+	// SecondaryIndexKeys returns the keys of the entries the row writes to the
+	// secondary indexes of its destination table.
+	SecondaryIndexKeys(ctx context.Context, kv replicatedKV) ([]roachpb.RKey, error)
+}
+
+// resolveDestinationSecondaryIndexes returns the destination tables of the
+// source tables whose destination tables have public secondary indexes.
+func resolveDestinationSecondaryIndexes(
+	ctx context.Context, db descs.DB, tableDescs map[string]descpb.TableDescriptor,
+) (map[descpb.ID]catalog.TableDescriptor, error) {
+	res := make(map[descpb.ID]catalog.TableDescriptor)
+	err := forEachDestinationTable(ctx, db, tableDescs, func(name string, src, dest catalog.TableDescriptor) error {
+		if len(dest.PublicNonPrimaryIndexes()) > 0 {
+			res[src.GetID()] = dest
+		}
+		return nil
+	})
+	return res, err
+}
+
+// SecondaryIndexKeys implements the secondaryIndexKeyer interface.
+func (lww *sqlLastWriteWinsRowProcessor) SecondaryIndexKeys(
+	ctx context.Context, kv replicatedKV,
+) ([]roachpb.RKey, error) {
+	tableID, ok := sourceTableID(kv)
+	if !ok {
+		return nil, nil
+	}
+	dest, ok := lww.secondaryIndexTables[tableID]
+	if !ok {
+		return nil, nil
+	}
+	row, err := lww.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if err != nil {
+		return nil, err
+	}
+	if row.IsDeleted() {
+		return nil, nil
+	}
+	cols := dest.PublicColumns()
+	values := make([]tree.Datum, len(cols))
+	var colMap catalog.TableColMap
+	for i, col := range cols {
+		values[i] = tree.DNull
+		colMap.Set(col.GetID(), i)
+	}
+	if err := row.ForAllColumns().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if destCol := catalog.FindColumnByName(dest, col.Name); destCol != nil {
+			if ord, ok := colMap.Get(destCol.GetID()); ok {
+				values[ord] = d
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var res []roachpb.RKey
+	for _, index := range dest.PublicNonPrimaryIndexes() {
+		entries, err := rowenc.EncodeSecondaryIndex(ctx, lww.codec, dest, index, colMap, values, false /* includeEmpty */)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			key, err := keys.Addr(entry.Key)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, key)
+		}
+	}
+	return res, nil
+}
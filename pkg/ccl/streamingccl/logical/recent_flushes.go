@@ -0,0 +1,155 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"slices"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+var recentFlushesRetained = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.recent_flushes_retained",
+	"the number of recent flushes of each writer processor whose key spans, timestamps and stats, "+
+		"but not values, are retained in memory for debugging, e.g. to find what was recently applied "+
+		"to the keys of a divergent row or to examine a latency spike flush by flush with "+
+		"crdb_internal.logical_replication_recent_flushes; if 0, no flushes are retained",
+	16,
+	settings.NonNegativeInt,
+)
+
+// recentFlushes is a ring buffer of the summaries of a processor's most recent
+// flushes. It is written by the processor's flushes and read by the debug
+// status, so it is safe for concurrent use.
+type recentFlushes struct {
+	mu syncutil.Mutex
+	// ring holds the retained summaries. Once it is full, next is the index of
+	// the oldest one, which the next summary replaces.
+	ring []streampb.DebugFlushSummary
+	next int
+}
+
+// record adds the summary of a flush, evicting the oldest one if more than
+// the given number of flushes would be retained. If fewer flushes are to be
+// retained than before, the oldest ones are evicted.
+func (r *recentFlushes) record(s streampb.DebugFlushSummary, retained int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if retained != cap(r.ring) {
+		kept := r.chronologicalLocked()
+		if len(kept) > retained {
+			kept = kept[len(kept)-retained:]
+		}
+		r.ring = append(make([]streampb.DebugFlushSummary, 0, retained), kept...)
+		r.next = 0
+	}
+	if retained == 0 {
+		return
+	}
+	if len(r.ring) < retained {
+		r.ring = append(r.ring, s)
+		return
+	}
+	r.ring[r.next] = s
+	r.next = (r.next + 1) % retained
+}
+
+// chronologicalLocked returns the retained summaries, oldest first.
+func (r *recentFlushes) chronologicalLocked() []streampb.DebugFlushSummary {
+	res := make([]streampb.DebugFlushSummary, 0, len(r.ring))
+	res = append(res, r.ring[r.next:]...)
+	return append(res, r.ring[:r.next]...)
+}
+
+// overlapping returns the retained summaries of the flushes that applied KVs
+// to keys in the span, or all of them if the span is empty, newest first.
+func (r *recentFlushes) overlapping(sp roachpb.Span) []streampb.DebugFlushSummary {
+	r.mu.Lock()
+	all := r.chronologicalLocked()
+	r.mu.Unlock()
+	res := all[:0]
+	for _, s := range all {
+		if s.Overlaps(sp) {
+			res = append(res, s)
+		}
+	}
+	slices.Reverse(res)
+	return res
+}
+
+// summarizeFlush summarizes the KVs applied by a flush as the span of the keys
+// of each source table it applied KVs to and their timestamps, along with the
+// stats of the workers that applied them. Keys of unknown tables are
+// summarized together.
+func summarizeFlush(
+	kvs []replicatedKV,
+	started time.Time,
+	elapsed time.Duration,
+	bytes int64,
+	workers []flushWorkerStats,
+) streampb.DebugFlushSummary {
+	byTable := make(map[descpb.ID]*streampb.DebugFlushSpan)
+	for _, kv := range kvs {
+		tableID, _ := sourceTableID(kv)
+		fs, ok := byTable[tableID]
+		if !ok {
+			fs = &streampb.DebugFlushSpan{
+				Span:         roachpb.Span{Key: kv.Key, EndKey: kv.Key},
+				MinTimestamp: kv.Value.Timestamp,
+				MaxTimestamp: kv.Value.Timestamp,
+			}
+			byTable[tableID] = fs
+		}
+		fs.KVs++
+		if kv.Key.Compare(fs.Span.Key) < 0 {
+			fs.Span.Key = kv.Key
+		}
+		if kv.Key.Compare(fs.Span.EndKey) > 0 {
+			fs.Span.EndKey = kv.Key
+		}
+		fs.MinTimestamp.Backward(kv.Value.Timestamp)
+		fs.MaxTimestamp.Forward(kv.Value.Timestamp)
+	}
+	s := streampb.DebugFlushSummary{
+		StartedUnixMicros: started.UnixMicro(),
+		Nanos:             elapsed.Nanoseconds(),
+		KVs:               int64(len(kvs)),
+		Bytes:             bytes,
+		Workers:           int64(len(workers)),
+		Spans:             make([]streampb.DebugFlushSpan, 0, len(byTable)),
+	}
+	for _, w := range workers {
+		s.Batches += int64(w.batches)
+		s.Retries += int64(w.retries)
+		s.SlowestBatchNanos = max(s.SlowestBatchNanos, w.slowestBatch.Nanoseconds())
+	}
+	for _, fs := range byTable {
+		// The keys are copied so that the summary doesn't retain the memory of
+		// the events they were received in.
+		s.Spans = append(s.Spans, streampb.DebugFlushSpan{
+			Span: roachpb.Span{
+				Key:    append(roachpb.Key(nil), fs.Span.Key...),
+				EndKey: fs.Span.EndKey.Next(),
+			},
+			KVs:          fs.KVs,
+			MinTimestamp: fs.MinTimestamp,
+			MaxTimestamp: fs.MaxTimestamp,
+		})
+	}
+	slices.SortFunc(s.Spans, func(a, b streampb.DebugFlushSpan) int {
+		return a.Span.Key.Compare(b.Span.Key)
+	})
+	return s
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/span"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// The replicated time of a stream is the frontier of all its spans, so a span
+// that silently stops being resolved holds it back without any error, and
+// looks no different from a stream that is merely slow. If
+// gap_detection_threshold is set, each processor cross-references the KVs it
+// receives for each span of its partition with the span's resolved
+// timestamp: a span whose resolved timestamp trails the newest KV received
+// for it by more than the threshold is suspected of a replication gap, since
+// the source is clearly writing to it but the span's checkpoints don't cover
+// those writes. Since a span that goes completely silent receives neither KVs
+// nor checkpoints, a span whose resolved timestamp hasn't advanced for longer
+// than the threshold while other spans of the partition are checkpointed is
+// suspected as well. Suspected spans are logged and counted by the
+// ReplicationGaps gauge until their resolved timestamp catches up. Spans whose
+// initial scan hasn't completed are not checked.
+
+var gapDetectionThreshold = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.gap_detection_threshold",
+	"if non-zero, the time by which the resolved timestamp of a source span may trail the newest KV "+
+		"received for it, or for which it may not advance, before the span is suspected of a "+
+		"replication gap, logged and counted by the logical_replication.replication_gaps gauge",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// gapDetector tracks the newest KV received for each span of a partition to
+// detect the spans whose resolved timestamp doesn't keep up with them. It is
+// only accessed by the goroutine that consumes the partition's events.
+type gapDetector struct {
+	// spans are the spans of the partition, sorted by key.
+	spans []roachpb.Span
+	// newest holds the timestamp of the newest KV received for each span.
+	newest []hlc.Timestamp
+	// resolved holds the resolved timestamp of each span as of the last check,
+	// and lastAdvanced the time of the last check at which it had advanced.
+	resolved     []hlc.Timestamp
+	lastAdvanced []time.Time
+	// suspected holds whether each span is currently suspected of a gap.
+	suspected []bool
+	// numSuspected is the number of suspected spans, as added to the
+	// ReplicationGaps gauge.
+	numSuspected int64
+}
+
+// makeGapDetector returns a gapDetector for the given spans of a partition.
+func makeGapDetector(spans []roachpb.Span) *gapDetector {
+	sorted := slices.Clone(spans)
+	slices.SortFunc(sorted, func(a, b roachpb.Span) int { return a.Key.Compare(b.Key) })
+	return &gapDetector{
+		spans:        sorted,
+		newest:       make([]hlc.Timestamp, len(sorted)),
+		resolved:     make([]hlc.Timestamp, len(sorted)),
+		lastAdvanced: make([]time.Time, len(sorted)),
+		suspected:    make([]bool, len(sorted)),
+	}
+}
+
+// observe records the timestamps of the received KVs.
+func (g *gapDetector) observe(kvs []roachpb.KeyValue) {
+	for _, kv := range kvs {
+		i := sort.Search(len(g.spans), func(i int) bool { return kv.Key.Compare(g.spans[i].EndKey) < 0 })
+		if i == len(g.spans) || !g.spans[i].ContainsKey(kv.Key) {
+			continue
+		}
+		g.newest[i].Forward(kv.Value.Timestamp)
+	}
+}
+
+// spanGap is a change in the suspicion of a span's replication gap.
+type spanGap struct {
+	span     roachpb.Span
+	resolved hlc.Timestamp
+	newest   hlc.Timestamp
+	// lastAdvanced is the time of the last check at which the span's resolved
+	// timestamp had advanced.
+	lastAdvanced time.Time
+	// silent is true if the span is suspected because its resolved timestamp
+	// hasn't advanced for longer than the threshold, rather than because it
+	// trails the span's KVs.
+	silent bool
+	// suspected is true if the span became suspected of a gap, and false if
+	// its resolved timestamp caught up.
+	suspected bool
+}
+
+// check compares the newest KV of each span with its resolved timestamp in
+// the given frontier, and the time elapsed since that resolved timestamp last
+// advanced with the threshold, and returns the spans that became suspected of
+// a gap, or stopped being suspected, since the last check.
+func (g *gapDetector) check(
+	frontier span.Frontier, threshold time.Duration, now time.Time,
+) []spanGap {
+	var changed []spanGap
+	for i, sp := range g.spans {
+		var resolved hlc.Timestamp
+		first := true
+		frontier.SpanEntries(sp, func(_ roachpb.Span, ts hlc.Timestamp) span.OpResult {
+			if first || ts.Less(resolved) {
+				resolved, first = ts, false
+			}
+			return span.ContinueMatch
+		})
+		if resolved.IsEmpty() {
+			// The span's initial scan hasn't completed.
+			continue
+		}
+		if g.lastAdvanced[i].IsZero() || g.resolved[i].Less(resolved) {
+			g.resolved[i], g.lastAdvanced[i] = resolved, now
+		}
+		trailing := !g.newest[i].IsEmpty() && g.newest[i].WallTime-resolved.WallTime > threshold.Nanoseconds()
+		silent := now.Sub(g.lastAdvanced[i]) > threshold
+		suspected := trailing || silent
+		if suspected == g.suspected[i] {
+			continue
+		}
+		g.suspected[i] = suspected
+		changed = append(changed, spanGap{
+			span:         sp,
+			resolved:     resolved,
+			newest:       g.newest[i],
+			lastAdvanced: g.lastAdvanced[i],
+			silent:       silent && !trailing,
+			suspected:    suspected,
+		})
+	}
+	return changed
+}
+
+// maybeDetectGaps checks the spans of the processor's partition for
+// replication gaps, if gap_detection_threshold is set, and logs and counts
+// the spans that became suspected or stopped being suspected.
+func (lrw *logicalReplicationWriterProcessor) maybeDetectGaps(ctx context.Context) {
+	threshold := gapDetectionThreshold.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+	if threshold == 0 {
+		lrw.clearGaps()
+		return
+	}
+	for _, gap := range lrw.gaps.check(lrw.frontier, threshold, timeutil.Now()) {
+		if gap.suspected {
+			lrw.gaps.numSuspected++
+			lrw.metrics.ReplicationGaps.Inc(1)
+			if gap.silent {
+				log.Warningf(ctx, "suspected replication gap in span %s: its resolved timestamp %s hasn't "+
+					"advanced since %s, more than %s ago", gap.span, gap.resolved, gap.lastAdvanced, threshold)
+			} else {
+				log.Warningf(ctx, "suspected replication gap in span %s: its resolved timestamp %s trails "+
+					"the newest KV received for it at %s by more than %s", gap.span, gap.resolved, gap.newest, threshold)
+			}
+		} else {
+			lrw.gaps.numSuspected--
+			lrw.metrics.ReplicationGaps.Dec(1)
+			log.Infof(ctx, "resolved timestamp %s of span %s caught up", gap.resolved, gap.span)
+		}
+	}
+}
+
+// clearGaps stops counting the spans of the processor suspected of a gap.
+func (lrw *logicalReplicationWriterProcessor) clearGaps() {
+	if lrw.gaps.numSuspected == 0 {
+		return
+	}
+	lrw.metrics.ReplicationGaps.Dec(lrw.gaps.numSuspected)
+	lrw.gaps.numSuspected = 0
+	clear(lrw.gaps.suspected)
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// The replication lag of a stream is the time elapsed since its replicated
+// time, i.e. since the frontier of its resolved spans. Each running stream
+// reports its lag as a child, labeled with its job ID, of the
+// replication_lag_seconds gauge. The lag is computed whenever the gauge is
+// read rather than when the frontier advances, so it keeps growing while the
+// stream is idle or stuck and no checkpoints arrive. The gauge itself reports
+// the lag of the most lagging stream on the node.
+
+// replicationLag tracks the replicated time of a stream for its lag. It is
+// updated by the stream's checkpoints and read by the metrics registry, so it
+// is safe for concurrent use.
+type replicationLag struct {
+	replicatedWallTime atomic.Int64
+}
+
+// update records the replicated time of the stream.
+func (l *replicationLag) update(replicatedTime hlc.Timestamp) {
+	l.replicatedWallTime.Store(replicatedTime.WallTime)
+}
+
+// seconds returns the lag of the stream at the given time in seconds. It is 0
+// until the stream has a replicated time, i.e. during its initial scan.
+func (l *replicationLag) seconds(now time.Time) int64 {
+	wallTime := l.replicatedWallTime.Load()
+	if wallTime == 0 {
+		return 0
+	}
+	return max(0, int64(now.Sub(timeutil.Unix(0, wallTime))/time.Second))
+}
+
+// trackReplicationLag reports the lag of the given job's stream as a child of
+// the ReplicationLagSeconds gauge until the returned function is called.
+func (m *Metrics) trackReplicationLag(jobID jobspb.JobID, lag *replicationLag) func() {
+	child := m.ReplicationLagSeconds.AddFunctionalChild(func() int64 {
+		return lag.seconds(timeutil.Now())
+	}, strconv.FormatInt(int64(jobID), 10))
+	return child.Unlink
+}
+
+// maxChildValue aggregates the values of the children of a gauge as their
+// maximum.
+func maxChildValue(childValues []int64) int64 {
+	var res int64
+	for _, v := range childValues {
+		res = max(res, v)
+	}
+	return res
+}
@@ -0,0 +1,453 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/streamingccl/streamclient"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/keys"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/catenumpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/redact"
+)
+
+// If the job's replicate_schema_changes option is set, columns added to or
+// dropped from the source tables and their created or dropped secondary
+// indexes are applied to the destination tables. Schema changes are applied
+// each time the job plans its processors, which happens whenever a processor
+// or the job notices that the source schema changed:
+//
+//   - Before the job persists a replicated time, it reads the source
+//     descriptors as of that time, at most once every
+//     source_schema_check_interval, and persists its progress no further
+//     than the last time it checked. If any of them changed since the
+//     processors were planned, the progress isn't persisted and the job
+//     replans, so that it never skips past a source schema change, e.g. one
+//     that moved the source rows to a new primary index.
+//   - A processor stops before applying a row written with a column or column
+//     family that its source descriptor doesn't have, so that the row is only
+//     applied once the column has been added to the destination.
+//
+// Either way, the flow fails with errSourceSchemaChanged, on which the job
+// replans right away rather than counting it as a failed attempt. When the job
+// replans, it waits for columns being added to the source tables
+// to become public, applies the DDL corresponding to the differences between
+// the source descriptors it last planned with and the current ones to the
+// destination, and only then plans processors with the current descriptors
+// and primary index spans, narrowed to the repair span of a targeted repair.
+//
+// Changes that can't be expressed by adding or dropping plain columns and
+// indexes, such as renaming columns, changing their types or changing the
+// primary key, pause the job with an error.
+
+var sourceSchemaChangeWait = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.source_schema_change_wait",
+	"how long a job that replicates schema changes waits between checks of whether the columns "+
+		"being added to its source tables have become public",
+	10*time.Second,
+	settings.PositiveDuration,
+)
+
+var sourceSchemaCheckInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.source_schema_check_interval",
+	"the minimum amount of time between the checks of the source schema of a job that replicates "+
+		"schema changes, each of which reads the source descriptors; the job's progress is only "+
+		"persisted up to the time of its last check",
+	30*time.Second,
+	settings.NonNegativeDuration,
+)
+
+// errSourceSchemaChanged is returned by processors and the job to replan the
+// job after the schema of a source table changed.
+var errSourceSchemaChanged = errors.New("source schema changed")
+
+// sourceSchemaGate stops a processor from applying rows written with a newer
+// schema than the one of the source descriptors the processor was planned
+// with. A nil gate admits every row.
+type sourceSchemaGate map[descpb.ID]sourceSchema
+
+type sourceSchema struct {
+	nextColumnID descpb.ColumnID
+	nextFamilyID descpb.FamilyID
+	// pending holds the columns that were being added to the source table.
+	pending catalog.TableColSet
+}
+
+func makeSourceSchemaGate(tableDescs map[string]descpb.TableDescriptor) sourceSchemaGate {
+	g := make(sourceSchemaGate, len(tableDescs))
+	for _, desc := range tableDescs {
+		s := sourceSchema{nextColumnID: desc.NextColumnID, nextFamilyID: desc.NextFamilyID}
+		for _, m := range desc.Mutations {
+			if col := m.GetColumn(); col != nil && m.Direction == descpb.DescriptorMutation_ADD {
+				s.pending.Add(col.ID)
+			}
+		}
+		g[desc.ID] = s
+	}
+	return g
+}
+
+// check returns an error marked with errSourceSchemaChanged if the KV was
+// written with a column or column family added to its source table after the
+// gate's descriptors.
+func (g sourceSchemaGate) check(kv roachpb.KeyValue) error {
+	if g == nil || !kv.Value.IsPresent() {
+		return nil
+	}
+	tableID, ok := sourceTableID(replicatedKV{KeyValue: kv})
+	if !ok {
+		return nil
+	}
+	s, ok := g[tableID]
+	if !ok {
+		return nil
+	}
+	familyID, err := keys.DecodeFamilyKey(kv.Key)
+	if err != nil {
+		return err
+	}
+	if descpb.FamilyID(familyID) >= s.nextFamilyID {
+		return errors.Mark(errors.Newf(
+			"row of source table %d written with column family %d, which was added after the stream was planned",
+			tableID, familyID), errSourceSchemaChanged)
+	}
+	if kv.Value.GetTag() != roachpb.ValueType_TUPLE {
+		// The value holds the only column of a known family.
+		return nil
+	}
+	cols, err := encodedColumnIDs(kv.Value)
+	if err != nil {
+		return err
+	}
+	for _, colID := range cols.Ordered() {
+		if colID >= s.nextColumnID || s.pending.Contains(colID) {
+			return errors.Mark(errors.Newf(
+				"row of source table %d written with column %d, which was added after the stream was planned",
+				tableID, colID), errSourceSchemaChanged)
+		}
+	}
+	return nil
+}
+
+// describeSourceTables returns the primary index spans and descriptors of the
+// source tables with the given names as of the given time, or the current ones
+// if it is empty.
+func describeSourceTables(
+	ctx context.Context,
+	client streamclient.LogicalReplicationClient,
+	tableDescs map[string]descpb.TableDescriptor,
+	asOf hlc.Timestamp,
+) (*streampb.ReplicationProducerSpec, error) {
+	names := make([]string, 0, len(tableDescs))
+	for name := range tableDescs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return client.DescribeTables(ctx, &streampb.ReplicationProducerRequest{TableNames: names}, asOf)
+}
+
+// checkSourceSchema returns an error marked with errSourceSchemaChanged if the
+// descriptor of any of the source tables as of the given time is newer than
+// the one the job's processors were planned with. Older descriptors are
+// expected as of times before the schema changes the job replanned for.
+func checkSourceSchema(
+	ctx context.Context,
+	client streamclient.LogicalReplicationClient,
+	tableDescs map[string]descpb.TableDescriptor,
+	asOf hlc.Timestamp,
+) error {
+	spec, err := describeSourceTables(ctx, client, tableDescs, asOf)
+	if err != nil {
+		return errors.Wrap(err, "checking the schema of the source tables")
+	}
+	for name, desc := range tableDescs {
+		if cur, ok := spec.TableDescriptors[name]; !ok || cur.Version > desc.Version {
+			return errors.Mark(errors.Newf("schema of source table %s changed before %s", name, asOf.GoTime()),
+				errSourceSchemaChanged)
+		}
+	}
+	return nil
+}
+
+// replicateSchemaChanges applies the schema changes of the source tables since
+// the descriptors recorded in the job's progress to the destination tables,
+// then records the current source descriptors and primary index spans, with
+// which the processors are planned, narrowed to the repair span of a targeted
+// repair. If columns are being added to any source table, it first waits for
+// them to become public.
+func (r *logicalReplicationResumer) replicateSchemaChanges(
+	ctx context.Context,
+	db isql.DB,
+	sv *settings.Values,
+	client streamclient.LogicalReplicationClient,
+	options jobspb.LogicalReplicationDetails_Options,
+) error {
+	progress := r.job.Progress().Details.(*jobspb.Progress_LogicalReplication).LogicalReplication
+	var spec *streampb.ReplicationProducerSpec
+	for {
+		var err error
+		spec, err = describeSourceTables(ctx, client, progress.TableDescriptors, hlc.Timestamp{})
+		if err != nil {
+			return err
+		}
+		pending := tablesAddingColumns(spec.TableDescriptors)
+		if len(pending) == 0 {
+			break
+		}
+		r.updateRunningStatus(ctx, redact.Sprintf(
+			"waiting for columns being added to source tables to become public: %s", strings.Join(pending, ", ")))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sourceSchemaChangeWait.Get(sv)):
+		}
+	}
+
+	var stmts []string
+	changed := false
+	for name, prev := range progress.TableDescriptors {
+		cur, ok := spec.TableDescriptors[name]
+		if !ok {
+			return errors.Newf("source table %s not found", name)
+		}
+		if cur.Version == prev.Version {
+			continue
+		}
+		changed = true
+		if cur.ID != prev.ID {
+			return jobs.MarkAsPermanentJobError(errors.Newf(
+				"source table %s was dropped and recreated with ID %d", name, cur.ID))
+		}
+		tableStmts, err := schemaChangeStatements(name, &prev, &cur)
+		if err != nil {
+			return jobs.MarkAsPermanentJobError(err)
+		}
+		stmts = append(stmts, tableStmts...)
+	}
+	if !changed {
+		return nil
+	}
+
+	for _, stmt := range stmts {
+		log.Infof(ctx, "replicating source schema change: %s", stmt)
+		if _, err := db.Executor().ExecEx(ctx, "logical-replication-schema-change", nil, /* txn */
+			sessiondata.NodeUserSessionDataOverride, stmt,
+		); err != nil {
+			return errors.Wrapf(err, "replicating source schema change %q", stmt)
+		}
+	}
+
+	sourceSpans := spec.TableSpans
+	if options.RepairSpan.Valid() {
+		var err error
+		if sourceSpans, err = repairSpans(sourceSpans, options.RepairSpan); err != nil {
+			return jobs.MarkAsPermanentJobError(err)
+		}
+	}
+	return r.job.NoTxn().Update(ctx, func(txn isql.Txn, md jobs.JobMetadata, ju *jobs.JobUpdater) error {
+		if err := md.CheckRunningOrReverting(); err != nil {
+			return err
+		}
+		prog := md.Progress.GetLogicalReplication()
+		prog.TableDescriptors = spec.TableDescriptors
+		prog.SourceSpans = sourceSpans
+		ju.UpdateProgress(md.Progress)
+		return nil
+	})
+}
+
+// tablesAddingColumns returns the sorted names of the tables to which columns
+// are being added.
+func tablesAddingColumns(tableDescs map[string]descpb.TableDescriptor) []string {
+	var names []string
+	for name, desc := range tableDescs {
+		for _, m := range desc.Mutations {
+			if m.GetColumn() != nil && m.Direction == descpb.DescriptorMutation_ADD {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schemaChangeStatements returns the DDL statements that apply the schema
+// changes between two versions of the descriptor of a source table to its
+// destination table. Indexes are dropped before columns, so that dropped
+// columns aren't referenced by any index, and created after columns, so that
+// they can reference added columns. Every statement is idempotent.
+func schemaChangeStatements(name string, prev, cur *descpb.TableDescriptor) ([]string, error) {
+	unsupported := func(format string, args ...interface{}) error {
+		return errors.Wrapf(errors.Newf(format, args...), "cannot replicate schema change of source table %s", name)
+	}
+	if !slices.Equal(prev.PrimaryIndex.KeyColumnIDs, cur.PrimaryIndex.KeyColumnIDs) {
+		return nil, unsupported("primary key changed")
+	}
+
+	var dropIndexes, dropColumns, addColumns, createIndexes []string
+
+	curCols := make(map[descpb.ColumnID]*descpb.ColumnDescriptor, len(cur.Columns))
+	for i := range cur.Columns {
+		curCols[cur.Columns[i].ID] = &cur.Columns[i]
+	}
+	prevCols := make(map[descpb.ColumnID]struct{}, len(prev.Columns))
+	for i := range prev.Columns {
+		col := &prev.Columns[i]
+		prevCols[col.ID] = struct{}{}
+		c, ok := curCols[col.ID]
+		if !ok {
+			dropColumns = append(dropColumns, fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s",
+				name, lexbase.EscapeSQLIdent(col.Name)))
+			continue
+		}
+		if c.Name != col.Name {
+			return nil, unsupported("column %s renamed to %s", col.Name, c.Name)
+		}
+		if !c.Type.Identical(col.Type) {
+			return nil, unsupported("type of column %s changed from %s to %s",
+				col.Name, col.Type.SQLString(), c.Type.SQLString())
+		}
+	}
+	for i := range cur.Columns {
+		col := &cur.Columns[i]
+		if _, ok := prevCols[col.ID]; ok {
+			continue
+		}
+		stmt, err := addColumnStatement(name, col)
+		if err != nil {
+			return nil, unsupported("%v", err)
+		}
+		addColumns = append(addColumns, stmt)
+	}
+
+	prevIndexes := make(map[string]struct{}, len(prev.Indexes))
+	for _, idx := range prev.Indexes {
+		prevIndexes[idx.Name] = struct{}{}
+	}
+	curIndexes := make(map[string]struct{}, len(cur.Indexes))
+	for i := range cur.Indexes {
+		idx := &cur.Indexes[i]
+		curIndexes[idx.Name] = struct{}{}
+		if _, ok := prevIndexes[idx.Name]; ok {
+			continue
+		}
+		stmt, err := createIndexStatement(name, cur, idx)
+		if err != nil {
+			return nil, unsupported("%v", err)
+		}
+		createIndexes = append(createIndexes, stmt)
+	}
+	for _, idx := range prev.Indexes {
+		if _, ok := curIndexes[idx.Name]; !ok {
+			dropIndexes = append(dropIndexes, fmt.Sprintf("DROP INDEX IF EXISTS %s@%s",
+				name, lexbase.EscapeSQLIdent(idx.Name)))
+		}
+	}
+
+	stmts := append(dropIndexes, dropColumns...)
+	stmts = append(stmts, addColumns...)
+	return append(stmts, createIndexes...), nil
+}
+
+// addColumnStatement returns the statement adding the given source column to
+// the destination table.
+func addColumnStatement(name string, col *descpb.ColumnDescriptor) (string, error) {
+	switch {
+	case col.IsComputed():
+		return "", errors.Newf("computed column %s added", col.Name)
+	case col.Type.UserDefined():
+		return "", errors.Newf("column %s of user-defined type %s added", col.Name, col.Type.SQLString())
+	case len(col.UsesSequenceIds) > 0 || len(col.UsesFunctionIds) > 0:
+		return "", errors.Newf("column %s whose default references other objects added", col.Name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+		name, lexbase.EscapeSQLIdent(col.Name), col.Type.SQLString())
+	if !col.Nullable {
+		b.WriteString(" NOT NULL")
+	}
+	if col.HasDefault() {
+		fmt.Fprintf(&b, " DEFAULT %s", *col.DefaultExpr)
+	}
+	if col.Hidden {
+		b.WriteString(" NOT VISIBLE")
+	}
+	return b.String(), nil
+}
+
+// createIndexStatement returns the statement creating the given secondary
+// index of the source table on the destination table. Columns implicitly
+// partitioning the source index are omitted, since the destination table is
+// partitioned according to its own locality.
+func createIndexStatement(
+	name string, desc *descpb.TableDescriptor, idx *descpb.IndexDescriptor,
+) (string, error) {
+	switch {
+	case idx.Type != descpb.IndexDescriptor_FORWARD:
+		return "", errors.Newf("inverted index %s created", idx.Name)
+	case idx.IsPartial():
+		return "", errors.Newf("partial index %s created", idx.Name)
+	case idx.IsSharded():
+		return "", errors.Newf("hash sharded index %s created", idx.Name)
+	}
+	inaccessible := make(map[string]struct{})
+	for _, col := range desc.Columns {
+		if col.Inaccessible {
+			inaccessible[col.Name] = struct{}{}
+		}
+	}
+	keyCols := make([]string, 0, len(idx.KeyColumnNames))
+	for i := int(idx.Partitioning.NumImplicitColumns); i < len(idx.KeyColumnNames); i++ {
+		colName := idx.KeyColumnNames[i]
+		if _, ok := inaccessible[colName]; ok {
+			return "", errors.Newf("expression index %s created", idx.Name)
+		}
+		col := lexbase.EscapeSQLIdent(colName)
+		if idx.KeyColumnDirections[i] == catenumpb.IndexColumn_DESC {
+			col += " DESC"
+		}
+		keyCols = append(keyCols, col)
+	}
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	fmt.Fprintf(&b, "INDEX IF NOT EXISTS %s ON %s (%s)",
+		lexbase.EscapeSQLIdent(idx.Name), name, strings.Join(keyCols, ", "))
+	if len(idx.StoreColumnNames) > 0 {
+		storeCols := make([]string, len(idx.StoreColumnNames))
+		for i, colName := range idx.StoreColumnNames {
+			storeCols[i] = lexbase.EscapeSQLIdent(colName)
+		}
+		fmt.Fprintf(&b, " STORING (%s)", strings.Join(storeCols, ", "))
+	}
+	return b.String(), nil
+}
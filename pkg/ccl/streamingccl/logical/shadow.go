@@ -0,0 +1,283 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfra"
+	"github.com/cockroachdb/cockroach/pkg/sql/execinfrapb"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/lexbase"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/sessiondata"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+	"github.com/jackc/pgx/v4"
+)
+
+var shadowBufferSize = settings.RegisterIntSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.shadow_buffer_size",
+	"the maximum number of applied rows buffered for application to a shadow destination; "+
+		"rows applied while the buffer is full are counted as shadow apply errors and skipped",
+	1024,
+	settings.PositiveInt,
+)
+
+// shadowApplier applies the rows applied to the destination tables to the
+// tables of the same names in a shadow destination cluster, e.g. one that is
+// being migrated to, and compares the resulting rows of both destinations so
+// that the shadow can be validated under real traffic before it is trusted.
+//
+// Like the fanout, the shadow is best effort and never holds up or fails
+// replication: rows are handed over through a bounded buffer and rows that
+// don't fit in it or fail to apply are skipped and counted in the
+// ShadowApplyErrors metric. Rows are applied to the shadow using UPSERT and
+// DELETE statements rather than last-write-wins, so the shadow tables need
+// not have a crdb_internal_origin_timestamp column.
+type shadowApplier struct {
+	conn    *pgx.Conn
+	primary isql.Executor
+	decoder cdcevent.Decoder
+	// tables maps the IDs of the source tables to the names of their
+	// destination tables, which are the names of the shadow tables.
+	tables  map[descpb.ID]string
+	metrics *Metrics
+
+	// rowCh buffers applied rows until they are applied to the shadow.
+	rowCh chan replicatedKV
+
+	errWarning        log.EveryN
+	divergenceWarning log.EveryN
+}
+
+// makeShadowApplier dials the shadow destination of the given writer spec.
+func makeShadowApplier(
+	ctx context.Context,
+	flowCtx *execinfra.FlowCtx,
+	spec execinfrapb.LogicalReplicationWriterSpec,
+	metrics *Metrics,
+) (*shadowApplier, error) {
+	decoder, tables, err := makeReplicatedRowDecoder(ctx, flowCtx, spec)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.Connect(ctx, spec.Options.ShadowDestinationURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing shadow destination")
+	}
+	return newShadowApplier(conn, flowCtx.Cfg.DB.Executor(), decoder, tables,
+		int(shadowBufferSize.Get(&flowCtx.Cfg.Settings.SV)), metrics), nil
+}
+
+func newShadowApplier(
+	conn *pgx.Conn,
+	primary isql.Executor,
+	decoder cdcevent.Decoder,
+	tables map[descpb.ID]string,
+	bufferSize int,
+	metrics *Metrics,
+) *shadowApplier {
+	return &shadowApplier{
+		conn:              conn,
+		primary:           primary,
+		decoder:           decoder,
+		tables:            tables,
+		metrics:           metrics,
+		rowCh:             make(chan replicatedKV, bufferSize),
+		errWarning:        log.Every(time.Minute),
+		divergenceWarning: log.Every(time.Minute),
+	}
+}
+
+// enqueue hands the given applied rows over to be applied to the shadow. It
+// never blocks; rows that don't fit in the buffer are skipped.
+func (s *shadowApplier) enqueue(ctx context.Context, kvs []replicatedKV) {
+	for i, kv := range kvs {
+		select {
+		case s.rowCh <- kv:
+		default:
+			skipped := len(kvs) - i
+			s.metrics.ShadowApplyErrors.Inc(int64(skipped))
+			if s.errWarning.ShouldLog() {
+				log.Warningf(ctx, "shadow buffer full; skipped %d applied rows", skipped)
+			}
+			return
+		}
+	}
+}
+
+// run applies buffered rows to the shadow until stopCh is closed.
+func (s *shadowApplier) run(ctx context.Context, stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case kv := <-s.rowCh:
+			diverged, err := s.apply(ctx, kv)
+			if err != nil {
+				s.metrics.ShadowApplyErrors.Inc(1)
+				if s.errWarning.ShouldLog() {
+					log.Warningf(ctx, "failed to apply row to shadow destination: %v", err)
+				}
+				continue
+			}
+			if diverged != "" {
+				s.metrics.ShadowDivergences.Inc(1)
+				if s.divergenceWarning.ShouldLog() {
+					log.Warningf(ctx, "shadow destination diverged from primary: %s", diverged)
+				}
+			}
+			s.metrics.ShadowAppliedRows.Inc(1)
+		}
+	}
+}
+
+// apply applies the row to the shadow and then reads it back from both
+// destinations. If they differ, e.g. because the primary kept a newer row under
+// last-write-wins or the shadow's schema differs, it returns a description of
+// the divergence. Rows are only compared once the buffer is drained, since the
+// primary may have already applied buffered rows of the same key; a row applied
+// to the primary but not yet buffered can still be reported as a divergence.
+func (s *shadowApplier) apply(ctx context.Context, kv replicatedKV) (string, error) {
+	stmts, err := s.statements(ctx, kv)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.conn.Exec(ctx, stmts.apply); err != nil {
+		return "", err
+	}
+	if len(s.rowCh) > 0 {
+		// Later rows may update the same key; compare once the buffer drains.
+		return "", nil
+	}
+	shadowRow, err := s.readShadow(ctx, stmts.read)
+	if err != nil {
+		return "", err
+	}
+	primaryRow, err := s.readPrimary(ctx, stmts.read)
+	if err != nil {
+		return "", err
+	}
+	if shadowRow != primaryRow {
+		return fmt.Sprintf("%s: primary has %s, shadow has %s", stmts.table, primaryRow, shadowRow), nil
+	}
+	return "", nil
+}
+
+// shadowStatements are the statements used to apply a row to the shadow and
+// to read it back from either destination.
+type shadowStatements struct {
+	table string
+	apply string
+	// read returns the row's columns as a single string, or no rows if the row
+	// doesn't exist.
+	read string
+}
+
+// statements returns the statements applying the given row. Values are
+// formatted as string literals so that the statements don't depend on the
+// IDs of user-defined types, which differ between the destinations.
+func (s *shadowApplier) statements(ctx context.Context, kv replicatedKV) (shadowStatements, error) {
+	row, err := s.decoder.DecodeKV(ctx, kv.KeyValue, cdcevent.CurrentRow, kv.Value.Timestamp, false)
+	if err != nil {
+		return shadowStatements{}, err
+	}
+	table, ok := s.tables[row.TableID]
+	if !ok {
+		return shadowStatements{}, errors.AssertionFailedf("no destination table for source table %d", row.TableID)
+	}
+
+	var keyCols, keyVals []string
+	if err := row.ForEachKeyColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		keyCols = append(keyCols, lexbase.EscapeSQLIdent(col.Name))
+		keyVals = append(keyVals, shadowLiteral(d))
+		return nil
+	}); err != nil {
+		return shadowStatements{}, err
+	}
+	where := make([]string, len(keyCols))
+	for i := range keyCols {
+		where[i] = fmt.Sprintf("%s = %s", keyCols[i], keyVals[i])
+	}
+	predicate := strings.Join(where, " AND ")
+
+	if row.IsDeleted() {
+		return shadowStatements{
+			table: table,
+			apply: fmt.Sprintf("DELETE FROM %s WHERE %s", table, predicate),
+			read:  fmt.Sprintf("SELECT ROW(%s)::STRING FROM %s WHERE %s", strings.Join(keyCols, ", "), table, predicate),
+		}, nil
+	}
+
+	cols, vals := keyCols, keyVals
+	if err := row.ForEachColumn().Datum(func(d tree.Datum, col cdcevent.ResultColumn) error {
+		if col.Computed || col.Name == "crdb_internal_origin_timestamp" {
+			return nil
+		}
+		cols = append(cols, lexbase.EscapeSQLIdent(col.Name))
+		vals = append(vals, shadowLiteral(d))
+		return nil
+	}); err != nil {
+		return shadowStatements{}, err
+	}
+	return shadowStatements{
+		table: table,
+		apply: fmt.Sprintf("UPSERT INTO %s (%s) VALUES (%s)", table,
+			strings.Join(cols, ", "), strings.Join(vals, ", ")),
+		read: fmt.Sprintf("SELECT ROW(%s)::STRING FROM %s WHERE %s", strings.Join(cols, ", "), table, predicate),
+	}, nil
+}
+
+// shadowLiteral formats the datum as a string literal, or NULL.
+func shadowLiteral(d tree.Datum) string {
+	if d == tree.DNull {
+		return "NULL"
+	}
+	return lexbase.EscapeSQLString(tree.AsStringWithFlags(d, tree.FmtBareStrings))
+}
+
+// readShadow runs the read statement against the shadow. It returns an empty
+// string if the row doesn't exist.
+func (s *shadowApplier) readShadow(ctx context.Context, read string) (string, error) {
+	var res string
+	if err := s.conn.QueryRow(ctx, read).Scan(&res); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return res, nil
+}
+
+// readPrimary runs the read statement against the primary destination. It
+// returns an empty string if the row doesn't exist.
+func (s *shadowApplier) readPrimary(ctx context.Context, read string) (string, error) {
+	row, err := s.primary.QueryRowEx(ctx, "logical-replication-shadow-compare", nil, /* txn */
+		sessiondata.NodeUserSessionDataOverride, read)
+	if err != nil || row == nil {
+		return "", err
+	}
+	return string(tree.MustBeDString(row[0])), nil
+}
+
+// close releases the connection to the shadow. Rows still in the buffer are
+// not applied.
+func (s *shadowApplier) close(ctx context.Context) error {
+	return s.conn.Close(ctx)
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing"
+	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
+)
+
+var slowFlushThreshold = settings.RegisterFloatSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.slow_flush_threshold",
+	"the multiple of a writer processor's median flush duration above which a flush is logged "+
+		"along with the batches applied by each of its workers and, if the flush is traced, its "+
+		"trace; if 0, slow flushes aren't logged",
+	10,
+	settings.NonNegativeFloat,
+)
+
+// minFlushesForSlowFlush is the number of flushes a processor must have
+// recorded in its flush latency histogram before a flush is compared with
+// their median.
+const minFlushesForSlowFlush = 20
+
+// flushWorkerStats summarizes the batches applied by one worker of a flush.
+type flushWorkerStats struct {
+	kvs, batches, retries int
+	// slowestBatch is the time taken to apply the worker's slowest batch.
+	slowestBatch time.Duration
+	// elapsed is the time the worker took to apply its chunk.
+	elapsed time.Duration
+}
+
+// recordBatch adds a batch applied by the worker to its stats.
+func (s *flushWorkerStats) recordBatch(kvs int, stats batchStats, elapsed time.Duration) {
+	s.kvs += kvs
+	s.batches++
+	s.retries += stats.retries
+	s.slowestBatch = max(s.slowestBatch, elapsed)
+}
+
+// isSlowFlush returns true if a flush that took the given time is slow given
+// the median of the recent flushes of the processor.
+func isSlowFlush(flushTime, median time.Duration, threshold float64) bool {
+	return threshold > 0 && median > 0 && float64(flushTime) > threshold*float64(median)
+}
+
+// formatFlushWorkerStats describes the batches applied by each worker of a
+// flush.
+func formatFlushWorkerStats(workers []flushWorkerStats) string {
+	var b strings.Builder
+	for i, w := range workers {
+		fmt.Fprintf(&b, "\n  worker %d: %d KVs in %d batches with %d retries in %s, slowest batch %s",
+			i, w.kvs, w.batches, w.retries, w.elapsed, w.slowestBatch)
+	}
+	return b.String()
+}
+
+// maybeLogSlowFlush logs the flush, along with its workers' stats and its
+// trace if it is recorded, if it took longer than slow_flush_threshold times
+// the processor's median flush duration, and then records its duration.
+func (lrw *logicalReplicationWriterProcessor) maybeLogSlowFlush(
+	ctx context.Context,
+	sp *tracing.Span,
+	flushTime time.Duration,
+	numKVs int,
+	workers []flushWorkerStats,
+) {
+	if lrw.flushLatency == nil {
+		return
+	}
+	defer lrw.flushLatency.RecordValue(flushTime.Nanoseconds())
+
+	snapshot := lrw.flushLatency.WindowedSnapshot()
+	if count, _ := snapshot.Total(); count < minFlushesForSlowFlush {
+		return
+	}
+	median := time.Duration(snapshot.ValueAtQuantile(50))
+	threshold := slowFlushThreshold.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+	if !isSlowFlush(flushTime, median, threshold) {
+		return
+	}
+	lrw.metrics.SlowFlushes.Inc(1)
+	log.Warningf(ctx, "flush of %d KVs took %s, more than %.1f times the median flush duration %s:%s",
+		numKVs, flushTime, threshold, median, formatFlushWorkerStats(workers))
+	if sp != nil && sp.RecordingType() != tracingpb.RecordingOff {
+		log.Warningf(ctx, "trace of slow flush:\n%s", sp.GetConfiguredRecording())
+	}
+}
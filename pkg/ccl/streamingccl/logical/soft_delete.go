@@ -0,0 +1,115 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/ccl/changefeedccl/cdcevent"
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog"
+	"github.com/cockroachdb/cockroach/pkg/sql/isql"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgcode"
+	"github.com/cockroachdb/cockroach/pkg/sql/pgwire/pgerror"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/eval"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/errors"
+)
+
+// If the stream sets a soft_delete_column, replicated deletes don't remove the
+// destination row but set that column to the time of the source delete, so
+// that audit or history destinations retain deleted rows. The column must be
+// a TIMESTAMPTZ column of every destination table, which is checked when the
+// job is created. Like a delete, the update only applies to destination rows
+// older than the source delete, and stamps the row with the delete's origin
+// timestamp so that older writes received later don't revive it. A delete of
+// a row the destination doesn't have is a no-op. A later write of the row at
+// the source overwrites the column with the source's value of it, if the
+// source table has it, and NULL otherwise, so that a row reinserted after its
+// delete is live again.
+
+// makeSoftDeleteQuery returns the UPDATE statement used to apply deletes to
+// the table by setting its soft delete column. Its arguments are the primary
+// key columns of the row, the time of the delete and its origin timestamp.
+func makeSoftDeleteQuery(
+	fqTableName string, td catalog.TableDescriptor, column string, audit *auditRule,
+) string {
+	keyCount := len(td.TableDesc().PrimaryIndex.KeyColumnNames)
+	originTSIdx := keyCount + 2
+	var setClause strings.Builder
+	auditColumns, auditValues := audit.assignments(originTSIdx)
+	for i, name := range auditColumns {
+		fmt.Fprintf(&setClause, "%s = %s,\n", name, auditValues[i])
+	}
+	baseQuery := `
+UPDATE %[1]s SET
+%[2]s = $%[3]d,
+%[4]scrdb_internal_origin_timestamp = $%[5]d
+WHERE %[6]s
+  AND ((%[1]s.crdb_internal_mvcc_timestamp < $%[5]d
+        AND %[1]s.crdb_internal_origin_timestamp IS NULL)
+    OR (%[1]s.crdb_internal_origin_timestamp < $%[5]d
+        AND %[1]s.crdb_internal_origin_timestamp IS NOT NULL))`
+	return fmt.Sprintf(baseQuery,
+		fqTableName,
+		tree.NameString(column),
+		keyCount+1,
+		setClause.String(),
+		originTSIdx,
+		keyColumnPredicate(td, 1 /* startIdx */),
+	)
+}
+
+// softDeleteRow applies a delete by setting the soft delete column of the
+// destination row. The job is paused if the destination table no longer has
+// the column, e.g. because it was dropped after the job was created. It
+// returns false if it updated no row, either because the destination row is
+// newer or doesn't exist.
+func (lww *sqlLastWriteWinsRowProcessor) softDeleteRow(
+	ctx context.Context, txn isql.Txn, row cdcevent.Row,
+) (bool, error) {
+	datums, err := keyColumnDatums(row)
+	if err != nil {
+		return false, err
+	}
+	deletedAt, err := tree.MakeDTimestampTZ(row.MvccTimestamp.GoTime(), time.Microsecond)
+	if err != nil {
+		return false, err
+	}
+	datums = append(datums, deletedAt, eval.TimestampToDecimalDatum(row.MvccTimestamp))
+	softDeleteQuery := lww.queryBuffer.softDeleteQueries[row.TableID]
+	updated, err := txn.ExecParsed(ctx, "replicated-soft-delete", txn.KV(), softDeleteQuery, datums...)
+	if err != nil {
+		log.Warningf(ctx, "replicated soft delete failed (query: %s): %s", softDeleteQuery.SQL, pgerror.GetPGCode(err))
+		if pgerror.GetPGCode(err) == pgcode.UndefinedColumn {
+			return false, jobs.MarkAsPermanentJobError(errors.WithHint(errors.Wrapf(err,
+				"applying a delete to table %s", lww.queryBuffer.tableNames[row.TableID]),
+				"the destination tables of a stream with a soft_delete_column must have a TIMESTAMPTZ column "+
+					"of that name; add it back to resume the job"))
+		}
+		return false, err
+	}
+	lww.metrics.SoftDeletes.Inc(1)
+	return updated > 0, nil
+}
+
+// clearedSoftDeleteColumn returns the quoted name of the soft delete column
+// that the writes of rows of the source table set to NULL, which is the
+// stream's soft delete column unless the source table has it and so writes
+// its own value of it.
+func clearedSoftDeleteColumn(src catalog.TableDescriptor, column string) string {
+	if column == "" || catalog.FindColumnByName(src, column) != nil {
+		return ""
+	}
+	return tree.NameString(column)
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+// maxSourceClockLead bounds how far ahead of the destination's clock the
+// timestamps of replicated KVs may be. Applying KVs written at timestamps far
+// in the future, e.g. because the source's clock is misconfigured, would push
+// the destination's HLC just as far ahead.
+var maxSourceClockLead = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.max_source_clock_lead",
+	"the maximum amount of time by which the timestamp of a replicated KV may be ahead of the "+
+		"destination's clock; an event with a KV further ahead, e.g. because the source's clock "+
+		"is misconfigured, is rejected and the job paused rather than applied; if 0, events are "+
+		"never rejected",
+	time.Hour,
+	settings.NonNegativeDuration,
+)
+
+// futureKV returns the KV with the latest timestamp among the given KVs if that
+// timestamp is more than the given lead ahead of now.
+func futureKV(kvs []roachpb.KeyValue, now time.Time, lead time.Duration) (roachpb.KeyValue, bool) {
+	if lead == 0 || len(kvs) == 0 {
+		return roachpb.KeyValue{}, false
+	}
+	latest := kvs[0]
+	for _, kv := range kvs[1:] {
+		if latest.Value.Timestamp.Less(kv.Value.Timestamp) {
+			latest = kv
+		}
+	}
+	if latest.Value.Timestamp.GoTime().Sub(now) <= lead {
+		return roachpb.KeyValue{}, false
+	}
+	return latest, true
+}
+
+// checkSourceClockLead returns a permanent job error if a KV of an event was
+// written more than max_source_clock_lead ahead of the destination's physical
+// clock. The physical clock is used rather than the HLC since the latter may
+// already have been pushed ahead by the source.
+func (lrw *logicalReplicationWriterProcessor) checkSourceClockLead(kvs []roachpb.KeyValue) error {
+	lead := maxSourceClockLead.Get(&lrw.FlowCtx.Cfg.Settings.SV)
+	now := timeutil.Now()
+	kv, ok := futureKV(kvs, now, lead)
+	if !ok {
+		return nil
+	}
+	lrw.metrics.RejectedFutureEvents.Inc(1)
+	ahead := kv.Value.Timestamp.GoTime().Sub(now)
+	log.Warningf(lrw.Ctx(), "rejecting event with KV at %s, %s ahead of the destination's clock",
+		kv.Value.Timestamp, ahead)
+	return jobs.MarkAsPermanentJobError(errors.WithHint(errors.Newf(
+		"replicated KV at %s is %s ahead of the destination's clock, more than max_source_clock_lead %s",
+		kv.Value.Timestamp, ahead, lead),
+		"fix the source cluster's clock or raise logical_replication.consumer.max_source_clock_lead "+
+			"to resume the job"))
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// The KVs of all tables share a buffer, which is flushed as a whole once it is
+// large enough or on the flush interval. If table_flush_interval or
+// table_flush_size is set, the KVs buffered for each table are also flushed on
+// their own once they have been buffered that long or reach that size, ahead
+// of the KVs of other tables, so that tables with little traffic are applied
+// on their own cadence. The checkpoint of such a flush doesn't cover the KVs
+// left in the buffer.
+
+var tableFlushInterval = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.table_flush_interval",
+	"if non-zero, the KVs buffered for a source table are flushed on their own, ahead of the KVs of "+
+		"other tables, once the oldest of them has been buffered this long",
+	0,
+	settings.NonNegativeDuration,
+)
+
+var tableFlushSize = settings.RegisterByteSizeSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.table_flush_size",
+	"if non-zero, the KVs buffered for a source table are flushed on their own, ahead of the KVs of "+
+		"other tables, once their size reaches this size",
+	0,
+)
+
+// tableBuffer describes the KVs of a source table in an ingestionBuffer.
+type tableBuffer struct {
+	kvs, bytes    int
+	firstBuffered time.Time
+}
+
+// trackTable accounts for the KV in the state of its table.
+func (b *ingestionBuffer) trackTable(kv replicatedKV) {
+	tableID, ok := sourceTableID(kv)
+	if !ok {
+		return
+	}
+	t, ok := b.tables[tableID]
+	if !ok {
+		if b.tables == nil {
+			b.tables = make(map[descpb.ID]*tableBuffer)
+		}
+		t = &tableBuffer{firstBuffered: timeutil.Now()}
+		b.tables[tableID] = t
+	}
+	t.kvs++
+	t.bytes += kv.Size()
+}
+
+// tableFlushDue returns true if the KVs of the table are due to be flushed on
+// their own. A zero interval or size disables the corresponding threshold.
+func (t *tableBuffer) tableFlushDue(now time.Time, interval time.Duration, size int64) bool {
+	return (interval > 0 && now.Sub(t.firstBuffered) >= interval) || (size > 0 && int64(t.bytes) >= size)
+}
+
+// dueTables returns the tables whose KVs are due to be flushed on their own,
+// or nil if there are none. all is true if every table in the buffer is due.
+func (b *ingestionBuffer) dueTables(
+	now time.Time, interval time.Duration, size int64,
+) (due map[descpb.ID]struct{}, all bool) {
+	for id, t := range b.tables {
+		if !t.tableFlushDue(now, interval, size) {
+			continue
+		}
+		if due == nil {
+			due = make(map[descpb.ID]struct{})
+		}
+		due[id] = struct{}{}
+	}
+	return due, len(due) > 0 && len(due) == len(b.tables)
+}
+
+// maybeFlushTables flushes the KVs of the tables whose KVs are due to be
+// flushed on their own, if any.
+func (lrw *logicalReplicationWriterProcessor) maybeFlushTables() error {
+	sv := &lrw.FlowCtx.Cfg.Settings.SV
+	interval, size := tableFlushInterval.Get(sv), tableFlushSize.Get(sv)
+	if (interval == 0 && size == 0) || lrw.flushInProgress.Load() {
+		return nil
+	}
+	if lrw.flushHeld(sv) {
+		return nil
+	}
+	due, all := lrw.buffer.dueTables(timeutil.Now(), interval, size)
+	if due == nil {
+		return nil
+	}
+	if all {
+		due = nil
+	}
+	return lrw.flushTables(flushOnTable, due)
+}
+
+// tableBuffers returns the state of the KVs buffered for each source table,
+// in table ID order.
+func (lrw *logicalReplicationWriterProcessor) tableBuffers() []streampb.DebugTableBuffer {
+	sv := &lrw.FlowCtx.Cfg.Settings.SV
+	interval, size := tableFlushInterval.Get(sv), tableFlushSize.Get(sv)
+	now := timeutil.Now()
+	lrw.bufferMu.Lock()
+	defer lrw.bufferMu.Unlock()
+	if lrw.buffer == nil {
+		return nil
+	}
+	res := make([]streampb.DebugTableBuffer, 0, len(lrw.buffer.tables))
+	for id, t := range lrw.buffer.tables {
+		res = append(res, streampb.DebugTableBuffer{
+			TableID:                  uint32(id),
+			KVs:                      int64(t.kvs),
+			Bytes:                    int64(t.bytes),
+			OldestBufferedUnixMicros: t.firstBuffered.UnixMicro(),
+			FlushDue:                 t.tableFlushDue(now, interval, size),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].TableID < res[j].TableID })
+	return res
+}
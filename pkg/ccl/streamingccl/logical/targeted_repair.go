@@ -0,0 +1,78 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/jobs/jobspb"
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/errors"
+)
+
+// A targeted repair restricts a stream to the changes made to a source key
+// span within a window of time, from the repair start time through the cutover
+// time, to fix a known divergence of the destination without replicating the
+// rest of the source tables again. The stream's source spans are restricted to
+// the repair span and its replicated time starts just before the window, so
+// that the stream subscribes to the changes made in the window without an
+// initial scan. The processors drop any earlier changes the source sends and,
+// as for any stream with a cutover time, the job completes once every
+// processor has applied the changes through the end of the window.
+
+// repairSpans returns the parts of the spans of the source tables within the
+// repair span.
+func repairSpans(tableSpans roachpb.Spans, repair roachpb.Span) (roachpb.Spans, error) {
+	var spans roachpb.Spans
+	for _, sp := range tableSpans {
+		if in := sp.Intersect(repair); in.Valid() {
+			spans = append(spans, in)
+		}
+	}
+	if len(spans) == 0 {
+		return nil, errors.Newf("repair span %s does not overlap the spans of the replicated tables", repair)
+	}
+	return spans, nil
+}
+
+// repairReplicatedTimeAtStart returns the replicated time at which a targeted
+// repair starts, so that the changes made at the repair start time itself are
+// applied.
+func repairReplicatedTimeAtStart(options jobspb.LogicalReplicationDetails_Options) hlc.Timestamp {
+	return options.RepairStartTime.Prev()
+}
+
+// repairCoverage returns the fraction of the window of a targeted repair
+// through which all changes have been applied, given the replicated time.
+func repairCoverage(options jobspb.LogicalReplicationDetails_Options, replicatedTime hlc.Timestamp) float64 {
+	start, end := options.RepairStartTime.WallTime, options.CutoverTime.WallTime
+	if end <= start || options.CutoverTime.LessEq(replicatedTime) {
+		return 1
+	}
+	return min(1, max(0, float64(replicatedTime.WallTime-start)/float64(end-start)))
+}
+
+// repairStatus returns the running status of a targeted repair.
+func repairStatus(options jobspb.LogicalReplicationDetails_Options, replicatedTime hlc.Timestamp) string {
+	return fmt.Sprintf("targeted repair of span %s: changes from %s through %s %.0f%% applied",
+		options.RepairSpan, options.RepairStartTime.GoTime(), options.CutoverTime.GoTime(),
+		100*repairCoverage(options, replicatedTime))
+}
+
+// outsideRepairWindow returns true if the stream is a targeted repair and the
+// KV was written before its window or outside its span, in which case it must
+// not be applied.
+func (lrw *logicalReplicationWriterProcessor) outsideRepairWindow(kv roachpb.KeyValue) bool {
+	options := &lrw.spec.Options
+	if !options.RepairSpan.Valid() {
+		return false
+	}
+	return kv.Value.Timestamp.Less(options.RepairStartTime) || !options.RepairSpan.ContainsKey(kv.Key)
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/jobs"
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/sql/catalog/descpb"
+	"github.com/cockroachdb/errors"
+)
+
+type unknownTablePolicy int64
+
+const (
+	unknownTablePause unknownTablePolicy = iota
+	unknownTableSkip
+	unknownTableDLQ
+)
+
+// unknownTablePolicySetting decides what happens to KVs of source tables that
+// the stream doesn't replicate, e.g. because the source partition covers a
+// table created after the stream was planned.
+var unknownTablePolicySetting = settings.RegisterEnumSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.unknown_table_policy",
+	"what to do with KVs received for source tables the stream doesn't replicate: pause pauses the "+
+		"job until an operator intervenes, skip ignores them and dlq sends them to the dead letter queue",
+	"pause",
+	map[int64]string{
+		int64(unknownTablePause): "pause",
+		int64(unknownTableSkip):  "skip",
+		int64(unknownTableDLQ):   "dlq",
+	},
+)
+
+// makeKnownTables returns the IDs of the given replicated source tables.
+func makeKnownTables(tableDescs map[string]descpb.TableDescriptor) map[descpb.ID]struct{} {
+	known := make(map[descpb.ID]struct{}, len(tableDescs))
+	for _, desc := range tableDescs {
+		known[desc.ID] = struct{}{}
+	}
+	return known
+}
+
+// unknownTable returns true if the KV belongs to a source table the stream
+// doesn't replicate, in which case it must not be buffered since there is no
+// destination table to apply it to. Such KVs are skipped or sent to the dead
+// letter queue according to unknown_table_policy, or else a permanent job
+// error is returned. KVs whose table can't be determined are left for the
+// apply to reject.
+func (lrw *logicalReplicationWriterProcessor) unknownTable(kv replicatedKV) (bool, error) {
+	if lrw.knownTables == nil {
+		return false, nil
+	}
+	tableID, ok := sourceTableID(kv)
+	if !ok {
+		return false, nil
+	}
+	if _, ok := lrw.knownTables[tableID]; ok {
+		return false, nil
+	}
+	switch unknownTablePolicy(unknownTablePolicySetting.Get(&lrw.FlowCtx.Cfg.Settings.SV)) {
+	case unknownTableSkip:
+		lrw.metrics.UnknownTableKVsSkipped.Inc(1)
+		return true, nil
+	case unknownTableDLQ:
+		return true, lrw.sendToDLQ(lrw.Ctx(), kv,
+			errors.Newf("source table %d is not replicated by this stream", tableID))
+	default:
+		return false, jobs.MarkAsPermanentJobError(errors.Newf(
+			"received rows of source table %d which is not replicated by this stream; set "+
+				"logical_replication.consumer.unknown_table_policy to skip or dlq to resume the job "+
+				"without applying them", tableID))
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"math"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+var warmUpDuration = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.warm_up_duration",
+	"the amount of time after a writer processor starts over which the number of workers applying "+
+		"each flush and the rate of flushes ramp up from a tenth of their maximum, so that streams "+
+		"restarting together, e.g. after a node restart, don't overwhelm a recovering destination; "+
+		"if 0, processors start at full parallelism",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// minWarmUpProgress is the progress through the warm-up at which a processor
+// starts.
+const minWarmUpProgress = 0.1
+
+// warmUpProgress returns how far through its warm-up a processor that started
+// the given amount of time ago is, from minWarmUpProgress to 1.
+func warmUpProgress(elapsed, duration time.Duration) float64 {
+	if duration <= 0 || elapsed >= duration {
+		return 1
+	}
+	return minWarmUpProgress + (1-minWarmUpProgress)*float64(elapsed)/float64(duration)
+}
+
+// warmUpWorkers returns the number of workers of the pool that may apply a
+// flush at the given progress through the warm-up.
+func warmUpWorkers(poolSize int, progress float64) int {
+	return max(1, min(poolSize, int(math.Ceil(float64(poolSize)*progress))))
+}
+
+// warmUpDelay returns how long to wait before a flush at the given progress
+// through the warm-up, given how long the last flush took, so that the
+// processor spends about progress of its time flushing.
+func warmUpDelay(progress float64, lastFlush time.Duration) time.Duration {
+	if progress >= 1 {
+		return 0
+	}
+	return time.Duration(float64(lastFlush) * (1/progress - 1))
+}
+
+// warmUpProgress returns how far through its warm-up the processor is.
+func (lrw *logicalReplicationWriterProcessor) warmUpProgress() float64 {
+	if lrw.startTime.IsZero() {
+		return 1
+	}
+	return warmUpProgress(timeutil.Since(lrw.startTime), warmUpDuration.Get(&lrw.FlowCtx.Cfg.Settings.SV))
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 The Cockroach Authors.
+//
+// Licensed as a CockroachDB Enterprise file under the Cockroach Community
+// License (the "License"); you may not use this file except in compliance with
+// the License. You may obtain a copy of the License at
+//
+//     https://github.com/cockroachdb/cockroach/blob/master/licenses/CCL.txt
+
+package logical
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+)
+
+var stallTimeout = settings.RegisterDurationSetting(
+	settings.ApplicationLevel,
+	"logical_replication.consumer.stall_timeout",
+	"the amount of time after which a processor that has events to apply but has neither applied "+
+		"or retried a batch, paced a flush nor advanced its frontier is considered stalled and restarted "+
+		"by replanning the job; if 0, stalled processors aren't detected",
+	0,
+	settings.NonNegativeDuration,
+)
+
+// watchdogInterval is how often the watchdog records the progress of the
+// processor in its debug status and checks whether it stalled.
+var watchdogInterval = time.Second
+
+// A processor that is wedged, e.g. by a deadlock or a hung RPC, neither
+// returns an error nor exits, so its job silently stops making progress. The
+// source sends checkpoints even while no rows change, so a processor whose
+// flushes keep up applies batches and advances its frontier. A processor that
+// retries a batch, e.g. on contention or while it waits for a descriptor
+// lease, or that paces its flushes, e.g. while the destination is overloaded,
+// is slow rather than wedged. A processor is only expected to make progress
+// while the source is active, i.e. it received an event within stall_timeout
+// or a flush is in progress; a silent source is left to the subscription to
+// detect. Once an active processor makes no progress for stall_timeout, the
+// watchdog fails it with a "stalled, no progress" error, which cancels the
+// context of its other goroutines and makes the job replan the flow. The
+// watchdog stops checking once the processor stops consuming events, e.g. on
+// drain or cutover.
+
+// progressWatchdog tracks the progress signals of a processor, which are
+// recorded by its goroutines and read by the watchdog.
+type progressWatchdog struct {
+	// lastRecv, lastFlush and lastFrontierAdvance are the unix nanos of when
+	// the processor last received an event, applied a batch or completed a
+	// flush, and advanced its frontier. lastWait is when it last retried a
+	// batch or waited to pace a flush.
+	lastRecv, lastFlush, lastFrontierAdvance, lastWait atomic.Int64
+	// consumerDone is closed once the processor stops consuming events.
+	consumerDone chan struct{}
+}
+
+// init resets the progress signals to the given time, from which progress is
+// expected.
+func (w *progressWatchdog) init(now time.Time) {
+	w.lastRecv.Store(now.UnixNano())
+	w.lastFlush.Store(now.UnixNano())
+	w.lastFrontierAdvance.Store(now.UnixNano())
+	w.lastWait.Store(now.UnixNano())
+	w.consumerDone = make(chan struct{})
+}
+
+func (w *progressWatchdog) recordRecv(now time.Time) {
+	w.lastRecv.Store(now.UnixNano())
+}
+
+func (w *progressWatchdog) recordFlush(now time.Time) {
+	w.lastFlush.Store(now.UnixNano())
+}
+
+func (w *progressWatchdog) recordFrontierAdvance(now time.Time) {
+	w.lastFrontierAdvance.Store(now.UnixNano())
+}
+
+// recordWait records that the processor retried a batch or paced a flush. A
+// nil watchdog, e.g. that of a batch handler built by a test, records
+// nothing.
+func (w *progressWatchdog) recordWait(now time.Time) {
+	if w != nil {
+		w.lastWait.Store(now.UnixNano())
+	}
+}
+
+// check returns an error if the processor made no progress within timeout as
+// of now while the source was active, i.e. it received an event within
+// timeout or flushing is set. It never returns an error if timeout is 0.
+func (w *progressWatchdog) check(now time.Time, timeout time.Duration, flushing bool) error {
+	if timeout == 0 {
+		return nil
+	}
+	if !flushing && now.Sub(timeutil.Unix(0, w.lastRecv.Load())) >= timeout {
+		return nil
+	}
+	last := max(w.lastFlush.Load(), w.lastFrontierAdvance.Load(), w.lastWait.Load())
+	if stalled := now.Sub(timeutil.Unix(0, last)); stalled >= timeout {
+		return errors.Newf("stalled, no progress: no batch applied or retried, flush paced or frontier "+
+			"advance for %s while receiving events, exceeding stall_timeout %s", stalled, timeout)
+	}
+	return nil
+}
+
+// runWatchdog records the progress of the processor in its debug status
+// every watchdogInterval until the processor stops consuming events. If the
+// processor stalls, it sends the stall error to Next() and returns it, which
+// cancels the context of the processor's other goroutines.
+func (lrw *logicalReplicationWriterProcessor) runWatchdog(ctx context.Context) error {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	w := &lrw.watchdog
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.consumerDone:
+			return nil
+		case <-lrw.stopCh:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+		lrw.debug.RecordProgress(timeutil.Unix(0, w.lastRecv.Load()), timeutil.Unix(0, w.lastFlush.Load()),
+			timeutil.Unix(0, w.lastFrontierAdvance.Load()))
+		if err := w.check(timeutil.Now(), stallTimeout.Get(&lrw.FlowCtx.Cfg.Settings.SV),
+			lrw.flushInProgress.Load()); err != nil {
+			log.Warningf(ctx, "restarting processor: %v", err)
+			lrw.metrics.StalledProcessors.Inc(1)
+			lrw.sendError(err)
+			return err
+		}
+	}
+}
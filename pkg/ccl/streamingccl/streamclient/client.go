@@ -10,6 +10,8 @@ package streamclient
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 
@@ -39,6 +41,13 @@ import (
 // to subscribe to a given partition.
 type SubscriptionToken []byte
 
+// Fingerprint returns a short hash of the token that identifies the partition
+// without revealing the token's contents, e.g. for use in debug output.
+func (t SubscriptionToken) Fingerprint() string {
+	h := sha256.Sum256(t)
+	return hex.EncodeToString(h[:8])
+}
+
 // CheckpointToken is an opaque identifier which can be used to represent checkpoint
 // information to start a stream processor.
 type CheckpointToken []byte
@@ -120,6 +129,10 @@ type LogicalReplicationClient interface {
 
 	PlanLogicalReplication(ctx context.Context, spans []roachpb.Span) (Topology, error)
 	CreateForTables(ctx context.Context, req *streampb.ReplicationProducerRequest) (*streampb.ReplicationProducerSpec, error)
+	// DescribeTables returns the primary index spans and descriptors of the
+	// requested tables as of the given time, or the current ones if it is
+	// empty, without starting a stream.
+	DescribeTables(ctx context.Context, req *streampb.ReplicationProducerRequest, asOf hlc.Timestamp) (*streampb.ReplicationProducerSpec, error)
 }
 
 type subscribeConfig struct {
@@ -127,6 +140,10 @@ type subscribeConfig struct {
 	// should be started with the WithFiltering option which
 	// elides rangefeed events.
 	withFiltering bool
+	// withDiff controls whether the producer-side rangefeeds should be
+	// started with the WithDiff option, which reports the prior value of
+	// each KV.
+	withDiff bool
 }
 
 type SubscribeOption func(*subscribeConfig)
@@ -140,6 +157,14 @@ func WithFiltering(filteringEnabled bool) SubscribeOption {
 	}
 }
 
+// WithDiff controls whether the producer side rangefeed is started with the
+// WithDiff option, sending the prior value of each KV along with it.
+func WithDiff(diffEnabled bool) SubscribeOption {
+	return func(cfg *subscribeConfig) {
+		cfg.withDiff = diffEnabled
+	}
+}
+
 // Topology is a configuration of stream partitions. These are particular to a
 // stream. It specifies the number and addresses of partitions of the stream.
 //
@@ -239,6 +239,7 @@ func (p *partitionedStreamClient) Subscribe(
 	sps.ConsumerProc = consumerProc
 	sps.Compressed = true
 	sps.WithFiltering = cfg.withFiltering
+	sps.WithDiff = cfg.withDiff
 
 	specBytes, err := protoutil.Marshal(&sps)
 	if err != nil {
@@ -335,6 +336,38 @@ func (p *partitionedStreamClient) CreateForTables(
 	return spec, nil
 }
 
+// DescribeTables implements the streamclient.LogicalReplicationClient
+// interface.
+func (p *partitionedStreamClient) DescribeTables(
+	ctx context.Context, req *streampb.ReplicationProducerRequest, asOf hlc.Timestamp,
+) (*streampb.ReplicationProducerSpec, error) {
+	ctx, sp := tracing.ChildSpan(ctx, "streamclient.Client.DescribeTables")
+	defer sp.Finish()
+
+	reqBytes, err := protoutil.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	query := "SELECT crdb_internal.describe_tables_for_replication($1)"
+	if !asOf.IsEmpty() {
+		query += fmt.Sprintf(" AS OF SYSTEM TIME '%s'", asOf.AsOfSystemTime())
+	}
+	r := p.mu.srcConn.QueryRow(ctx, query, reqBytes)
+	specBytes := []byte{}
+	if err := r.Scan(&specBytes); err != nil {
+		return nil, err
+	}
+
+	spec := &streampb.ReplicationProducerSpec{}
+	if err := protoutil.Unmarshal(specBytes, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
 // PriorReplicationDetails implements the streamclient.Client interface.
 func (p *partitionedStreamClient) PriorReplicationDetails(
 	ctx context.Context, tenant roachpb.TenantName,
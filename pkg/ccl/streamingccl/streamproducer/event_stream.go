@@ -133,6 +133,8 @@ func (s *eventStream) Start(ctx context.Context, txn *kv.Txn) (retErr error) {
 	// Stream channel receives datums to be sent to the consumer.
 	s.streamCh = make(chan tree.Datums)
 
+	s.seb.withDiff = s.spec.WithDiff
+
 	// Common rangefeed options.
 	opts := []rangefeed.Option{
 		rangefeed.WithPProfLabel("job", fmt.Sprintf("id=%d", s.streamID)),
@@ -148,6 +150,7 @@ func (s *eventStream) Start(ctx context.Context, txn *kv.Txn) (retErr error) {
 		rangefeed.WithFrontierQuantized(quantize.Get(&s.execCfg.Settings.SV)),
 		rangefeed.WithOnValues(s.onValues),
 		rangefeed.WithFiltering(s.spec.WithFiltering),
+		rangefeed.WithDiff(s.spec.WithDiff),
 		rangefeed.WithInvoker(func(fn func() error) error { return fn() }),
 	}
 	if emitMetadata.Get(&s.execCfg.Settings.SV) {
@@ -286,7 +289,7 @@ func (s *eventStream) onValue(ctx context.Context, value *kvpb.RangeFeedValue) {
 		s.addMu.Lock()
 		defer s.addMu.Unlock()
 	}
-	s.seb.addKV(roachpb.KeyValue{Key: value.Key, Value: value.Value})
+	s.seb.addKVWithPrevValue(roachpb.KeyValue{Key: value.Key, Value: value.Value}, value.PrevValue)
 	s.setErr(s.maybeFlushBatch(ctx))
 }
 
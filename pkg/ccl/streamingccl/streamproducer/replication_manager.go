@@ -69,23 +69,9 @@ func (r *replicationStreamManagerImpl) StartReplicationStreamForTables(
 		}
 	}
 
-	// Resolve table names to spans.
-	//
-	// TODO(ssd): Sort out the rules we want for this resolution. Right now
-	// the source sends fully qualified names and we accept them. I think we
-	// should probably do resolution
-	spans := make([]roachpb.Span, 0, len(req.TableNames))
-	tableDescs := make(map[string]descpb.TableDescriptor, len(req.TableNames))
-	for _, name := range req.TableNames {
-		parts := strings.SplitN(name, ".", 3)
-		dbName, schemaName, tblName := parts[0], parts[1], parts[2]
-		tn := tree.MakeTableNameWithSchema(tree.Name(dbName), tree.Name(schemaName), tree.Name(tblName))
-		_, td, err := resolver.ResolveMutableExistingTableObject(ctx, r.resolver, &tn, true, tree.ResolveRequireTableDesc)
-		if err != nil {
-			return streampb.ReplicationProducerSpec{}, err
-		}
-		spans = append(spans, td.PrimaryIndexSpan(r.evalCtx.Codec))
-		tableDescs[name] = td.TableDescriptor
+	spans, tableDescs, err := r.resolveTables(ctx, req.TableNames)
+	if err != nil {
+		return streampb.ReplicationProducerSpec{}, err
 	}
 
 	execConfig := r.evalCtx.Planner.ExecutorConfig().(*sql.ExecutorConfig)
@@ -126,6 +112,50 @@ func (r *replicationStreamManagerImpl) StartReplicationStreamForTables(
 	}, nil
 }
 
+// DescribeTablesForReplication implements streaming.ReplicationStreamManager
+// interface.
+func (r *replicationStreamManagerImpl) DescribeTablesForReplication(
+	ctx context.Context, req streampb.ReplicationProducerRequest,
+) (streampb.ReplicationProducerSpec, error) {
+	if err := r.checkLicense(); err != nil {
+		return streampb.ReplicationProducerSpec{}, err
+	}
+	spans, tableDescs, err := r.resolveTables(ctx, req.TableNames)
+	if err != nil {
+		return streampb.ReplicationProducerSpec{}, err
+	}
+	return streampb.ReplicationProducerSpec{
+		SourceClusterID:  r.evalCtx.ClusterID,
+		TableSpans:       spans,
+		TableDescriptors: tableDescs,
+	}, nil
+}
+
+// resolveTables returns the primary index span and the current descriptor of
+// each of the given fully qualified tables.
+//
+// TODO(ssd): Sort out the rules we want for this resolution. Right now
+// the source sends fully qualified names and we accept them. I think we
+// should probably do resolution
+func (r *replicationStreamManagerImpl) resolveTables(
+	ctx context.Context, tableNames []string,
+) ([]roachpb.Span, map[string]descpb.TableDescriptor, error) {
+	spans := make([]roachpb.Span, 0, len(tableNames))
+	tableDescs := make(map[string]descpb.TableDescriptor, len(tableNames))
+	for _, name := range tableNames {
+		parts := strings.SplitN(name, ".", 3)
+		dbName, schemaName, tblName := parts[0], parts[1], parts[2]
+		tn := tree.MakeTableNameWithSchema(tree.Name(dbName), tree.Name(schemaName), tree.Name(tblName))
+		_, td, err := resolver.ResolveMutableExistingTableObject(ctx, r.resolver, &tn, true, tree.ResolveRequireTableDesc)
+		if err != nil {
+			return nil, nil, err
+		}
+		spans = append(spans, td.PrimaryIndexSpan(r.evalCtx.Codec))
+		tableDescs[name] = td.TableDescriptor
+	}
+	return spans, tableDescs, nil
+}
+
 func (r *replicationStreamManagerImpl) PlanLogicalReplication(
 	ctx context.Context, spans []roachpb.Span,
 ) (*streampb.ReplicationStreamSpec, error) {
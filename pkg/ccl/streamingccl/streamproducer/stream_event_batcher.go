@@ -19,6 +19,8 @@ type streamEventBatcher struct {
 	batch              streampb.StreamEvent_Batch
 	size               int
 	spanConfigFrontier hlc.Timestamp
+	// withDiff is set if the batch carries the prior value of each KV.
+	withDiff bool
 }
 
 func makeStreamEventBatcher() *streamEventBatcher {
@@ -30,6 +32,7 @@ func makeStreamEventBatcher() *streamEventBatcher {
 func (seb *streamEventBatcher) reset() {
 	seb.size = 0
 	seb.batch.KeyValues = seb.batch.KeyValues[:0]
+	seb.batch.KeyValuePrevValues = seb.batch.KeyValuePrevValues[:0]
 	seb.batch.Ssts = seb.batch.Ssts[:0]
 	seb.batch.DelRanges = seb.batch.DelRanges[:0]
 	seb.batch.SpanConfigs = seb.batch.SpanConfigs[:0]
@@ -42,8 +45,19 @@ func (seb *streamEventBatcher) addSST(sst kvpb.RangeFeedSSTable) {
 }
 
 func (seb *streamEventBatcher) addKV(kv roachpb.KeyValue) {
+	seb.addKVWithPrevValue(kv, roachpb.Value{})
+}
+
+// addKVWithPrevValue adds a KV along with the value it replaced, which is
+// only kept if the batch carries prior values. A KV without a prior value,
+// e.g. one of an initial scan, gets an empty one.
+func (seb *streamEventBatcher) addKVWithPrevValue(kv roachpb.KeyValue, prevValue roachpb.Value) {
 	seb.batch.KeyValues = append(seb.batch.KeyValues, kv)
 	seb.size += kv.Size()
+	if seb.withDiff {
+		seb.batch.KeyValuePrevValues = append(seb.batch.KeyValuePrevValues, prevValue)
+		seb.size += prevValue.Size()
+	}
 }
 
 func (seb *streamEventBatcher) addDelRange(d kvpb.RangeFeedDeleteRange) {
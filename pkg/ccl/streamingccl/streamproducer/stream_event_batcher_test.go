@@ -63,6 +63,26 @@ func TestStreamEventBatcher(t *testing.T) {
 	require.Equal(t, 0, len(seb.batch.DelRanges))
 }
 
+func TestStreamEventBatcherWithDiff(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	seb := makeStreamEventBatcher()
+	seb.withDiff = true
+
+	kv := roachpb.KeyValue{Key: roachpb.Key{'1'}}
+	prev := roachpb.MakeValueFromString("prev")
+	seb.addKVWithPrevValue(kv, prev)
+	// A KV without a prior value, e.g. one of an initial scan, gets an empty
+	// one so that the prior values line up with the KVs.
+	seb.addKV(kv)
+	require.Equal(t, []roachpb.Value{prev, {}}, seb.batch.KeyValuePrevValues)
+	require.Equal(t, 2*kv.Size()+prev.Size(), seb.getSize())
+
+	seb.reset()
+	require.Equal(t, 0, len(seb.batch.KeyValuePrevValues))
+}
+
 // TestSpanConfigsInStreamEventBatcher ensures that span config events are
 // properly added to the stream event batcher.
 func TestBatchSpanConfigs(t *testing.T) {
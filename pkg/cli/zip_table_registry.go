@@ -430,6 +430,39 @@ var zipInternalTablesPerCluster = DebugZipTableRegistry{
 			"cur_kvs_todo",
 			"cur_batches",
 			"cur_slowest",
+			"lag",
+			"max_lag",
+			"lag_exceeded",
+			"admit_latency_p50",
+			"admit_latency_p99",
+			"admission_queue_depth",
+			"flush_pacing_factor",
+			"source_address",
+			"token_fingerprint",
+			"flush_retries",
+			"flush_grace_period",
+			"apply_cpu_share",
+			"apply_cpu_throttle_factor",
+			"catching_up",
+			"frontier_advance_rate",
+			"catch_up_eta",
+			"warm_up_progress",
+			"checkpoints_emitted",
+			"checkpoints_held",
+			"checkpoint_emit_interval",
+			"last_checkpoint",
+			"timestamp_granularity",
+			"frontier_spans",
+			"io_overload_score",
+			"io_pacing_factor",
+			"local_frontier",
+			"global_frontier",
+			"frontier_laggard",
+			"last_event",
+			"last_flush",
+			"last_frontier_advance",
+			"table_buffers",
+			"initial_scan_ranges",
 		},
 	},
 	"crdb_internal.default_privileges": {
@@ -14,6 +14,7 @@ import (
 	"context"
 
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/admission/admissionpb"
 	"github.com/cockroachdb/cockroach/pkg/util/errorutil"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
@@ -40,6 +41,10 @@ type Store interface {
 	// SetQueueActive disables/enables the named queue.
 	SetQueueActive(active bool, queue string) error
 
+	// IOThreshold returns the store's most recent IO threshold, which describes
+	// the state of its LSM's L0 as seen by IO admission control.
+	IOThreshold() admissionpb.IOThreshold
+
 	// GetReplicaMutexForTesting returns the mutex of the replica with the given
 	// range ID, or nil if no replica was found. This is used for testing.
 	// Returns a syncutil.RWMutex rather than ReplicaMutex to avoid import cycles.
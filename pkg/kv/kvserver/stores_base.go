@@ -17,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvserverbase"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/admission/admissionpb"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 	"github.com/cockroachdb/cockroach/pkg/util/tracing/tracingpb"
 	"github.com/cockroachdb/errors"
@@ -95,6 +96,14 @@ func (s *baseStore) SetQueueActive(active bool, queue string) error {
 	return nil
 }
 
+// IOThreshold is part of kvserverbase.Store.
+func (s *baseStore) IOThreshold() admissionpb.IOThreshold {
+	store := (*Store)(s)
+	store.ioThreshold.Lock()
+	defer store.ioThreshold.Unlock()
+	return *store.ioThreshold.t
+}
+
 // GetReplicaMutexForTesting is part of kvserverbase.Store.
 func (s *baseStore) GetReplicaMutexForTesting(rangeID roachpb.RangeID) *syncutil.RWMutex {
 	store := (*Store)(s)
@@ -14,6 +14,8 @@ import (
 	"sync/atomic"
 	time "time"
 
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
+	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
 )
 
@@ -122,13 +124,110 @@ type DebugLogicalConsumerStatus struct {
 	// Identification info.
 	StreamID    StreamID
 	ProcessorID int32
-	mu          struct {
+	// SnapshotBuffer, if set, returns the KVs currently buffered by the
+	// processor. It must be set before the status is registered and must not
+	// block the processor.
+	SnapshotBuffer func() []DebugBufferedKV
+	// RecentFlushes, if set, returns the summaries of the recent flushes
+	// retained by the processor that applied KVs to keys in the given span, or
+	// of all of them if the span is empty, newest first. It must be set before
+	// the status is registered and must not block the processor.
+	RecentFlushes func(sp roachpb.Span) []DebugFlushSummary
+	// TableBuffers, if set, returns the state of the KVs currently buffered by
+	// the processor for each source table. It must be set before the status is
+	// registered and must not block the processor.
+	TableBuffers func() []DebugTableBuffer
+	// InitialScanRanges, if set, returns the progress of the subscriptions
+	// across which the processor's initial scan is split, if it is. It must be
+	// set before the status is registered and must not block the processor.
+	InitialScanRanges func() []DebugInitialScanRange
+	mu                struct {
 		syncutil.Mutex
 		stats DebugLogicalConsumerStats
 	}
 }
 
+// DebugBufferedKV describes a KV buffered by a logical consumer. The value of
+// the KV is omitted so that dumps of the buffer only contain keys.
+type DebugBufferedKV struct {
+	Key        roachpb.Key
+	Timestamp  hlc.Timestamp
+	ValueBytes int
+}
+
+// DebugTableBuffer describes the KVs of a source table buffered by a logical
+// consumer.
+type DebugTableBuffer struct {
+	TableID    uint32
+	KVs, Bytes int64
+	// OldestBufferedUnixMicros is when the oldest of the KVs was buffered.
+	OldestBufferedUnixMicros int64
+	// FlushDue is true if the KVs are due to be flushed on their own, ahead of
+	// the KVs of other tables.
+	FlushDue bool
+}
+
+// DebugInitialScanRange describes the progress of a subscription across which
+// the initial scan of a logical consumer's partition is split.
+type DebugInitialScanRange struct {
+	// Span covers the spans scanned by the subscription.
+	Span  roachpb.Span
+	Spans int64
+	// KVs is the number of KVs received from the subscription.
+	KVs int64
+	// Done is true once all the spans have been scanned.
+	Done bool
+}
+
+// DebugFlushSummary describes a flush applied by a logical consumer. Like
+// DebugBufferedKV, it omits the values of the flush's KVs.
+type DebugFlushSummary struct {
+	StartedUnixMicros int64
+	Nanos             int64
+	KVs, Bytes        int64
+	Batches           int64
+	// Workers is the number of workers that applied the flush's batches.
+	Workers int64
+	// Retries is the number of times the transactions of the flush's batches
+	// were retried.
+	Retries           int64
+	SlowestBatchNanos int64
+	// Spans describes the KVs the flush applied to each source table, in key
+	// order.
+	Spans []DebugFlushSpan
+}
+
+// DebugFlushSpan describes the KVs a flush applied to a span of keys.
+type DebugFlushSpan struct {
+	Span                       roachpb.Span
+	KVs                        int
+	MinTimestamp, MaxTimestamp hlc.Timestamp
+}
+
+// Overlaps returns true if the flush applied KVs to keys in the span, or if
+// the span is empty.
+func (s DebugFlushSummary) Overlaps(sp roachpb.Span) bool {
+	if len(sp.Key) == 0 {
+		return true
+	}
+	for _, fs := range s.Spans {
+		if fs.Span.Overlaps(sp) {
+			return true
+		}
+	}
+	return false
+}
+
 type DebugLogicalConsumerStats struct {
+	Source struct {
+		// Address is the redacted address of the source the processor is
+		// subscribed to.
+		Address string
+		// TokenFingerprint is a hash of the subscription token of the
+		// processor's partition.
+		TokenFingerprint string
+	}
+
 	Recv struct {
 		LastWaitNanos, TotalWaitNanos int64
 	}
@@ -142,10 +241,96 @@ type DebugLogicalConsumerStats struct {
 			// TODO(dt): LastBatchErr atomic.Value
 		}
 		Last struct {
+			StartedUnixMicros, CompletedUnixMicros        int64
 			Nanos, KVs, Bytes, Batches, SlowestBatchNanos int64
 			// TODO(dt): Errors     atomic.Int64
 		}
 	}
+
+	Lag struct {
+		Nanos, MaxNanos         int64
+		ExceededSinceUnixMicros int64
+	}
+
+	AdmitLatency struct {
+		P50Nanos, P99Nanos int64
+	}
+
+	FlushPacing struct {
+		QueueDepth int64
+		Factor     float64
+	}
+
+	FlushRetries struct {
+		Count, GracePeriodNanos int64
+	}
+
+	Checkpoints struct {
+		// Emitted is the number of checkpoints emitted to the job and Held is
+		// the number of flushes whose checkpoint was held and then superseded
+		// or emitted later because it was within IntervalNanos of the last
+		// emitted one.
+		Emitted, Held, IntervalNanos int64
+		LastEmittedUnixMicros        int64
+	}
+
+	ApplyCPU struct {
+		Share, ThrottleFactor float64
+	}
+
+	IOPacing struct {
+		// OverloadScore is the highest gossiped IO overload score of the
+		// stores holding the destination ranges of the last flush, observed
+		// before it, which is 1 where admission control starts throttling
+		// writes to a store.
+		OverloadScore, Factor float64
+	}
+
+	Frontier struct {
+		// Local is the frontier of the processor's partition. Global is the
+		// replicated time of the job, i.e. the minimum of the frontiers of all
+		// partitions, as last read by the processor, which is empty unless
+		// global_frontier_refresh_interval is set. Laggard is true if the job's
+		// coordinator found that the partition holds back Global.
+		Local, Global hlc.Timestamp
+		Laggard       bool
+	}
+
+	Progress struct {
+		// LastRecvUnixMicros, LastFlushUnixMicros and
+		// LastFrontierAdvanceUnixMicros are when the processor last received an
+		// event, applied a batch or completed a flush, and advanced its
+		// frontier, from which its watchdog detects that it stalled.
+		LastRecvUnixMicros, LastFlushUnixMicros, LastFrontierAdvanceUnixMicros int64
+	}
+
+	CatchUp struct {
+		Active      bool
+		AdvanceRate float64
+		ETANanos    int64
+	}
+
+	WarmUp struct {
+		// Progress is how far through its warm-up the processor is, from 0.1
+		// when it starts to 1 once it runs at full parallelism.
+		Progress float64
+	}
+
+	Quantization struct {
+		// GranularityNanos is the granularity at which the processor quantizes
+		// resolved timestamps, which adapts to FrontierSpans if
+		// timestamp_granularity_target_spans is set.
+		GranularityNanos int64
+		FrontierSpans    int64
+	}
+
+	Errors struct {
+		Count int64
+		// Last is the redacted message of the last error encountered by the
+		// processor.
+		Last           string
+		LastUnixMicros int64
+	}
 }
 
 func (d *DebugLogicalConsumerStatus) GetStats() DebugLogicalConsumerStats {
@@ -154,6 +339,13 @@ func (d *DebugLogicalConsumerStatus) GetStats() DebugLogicalConsumerStats {
 	return d.mu.stats
 }
 
+func (d *DebugLogicalConsumerStatus) RecordSource(redactedAddr, tokenFingerprint string) {
+	d.mu.Lock()
+	d.mu.stats.Source.Address = redactedAddr
+	d.mu.stats.Source.TokenFingerprint = tokenFingerprint
+	d.mu.Unlock()
+}
+
 func (d *DebugLogicalConsumerStatus) RecordRecv(wait time.Duration) {
 	nanos := wait.Nanoseconds()
 	d.mu.Lock()
@@ -190,6 +382,8 @@ func (d *DebugLogicalConsumerStatus) RecordFlushComplete(totalNanos, keyCount, b
 	d.mu.stats.Flushes.Bytes += byteSize
 	d.mu.stats.Flushes.Batches += d.mu.stats.Flushes.Current.Batches
 
+	d.mu.stats.Flushes.Last.StartedUnixMicros = d.mu.stats.Flushes.Current.StartedUnixMicros
+	d.mu.stats.Flushes.Last.CompletedUnixMicros = d.mu.stats.Flushes.Current.StartedUnixMicros + totalNanos/int64(time.Microsecond)
 	d.mu.stats.Flushes.Last.Nanos = totalNanos
 	d.mu.stats.Flushes.Last.Batches = d.mu.stats.Flushes.Current.Batches
 	d.mu.stats.Flushes.Last.SlowestBatchNanos = d.mu.stats.Flushes.Current.SlowestBatchNanos
@@ -204,3 +398,178 @@ func (d *DebugLogicalConsumerStatus) RecordFlushComplete(totalNanos, keyCount, b
 
 	d.mu.Unlock() // nolint:deferunlockcheck
 }
+
+func (d *DebugLogicalConsumerStatus) RecordLag(lag, maxLag time.Duration, exceededSince time.Time) {
+	var exceededSinceMicros int64
+	if !exceededSince.IsZero() {
+		exceededSinceMicros = exceededSince.UnixMicro()
+	}
+	d.mu.Lock()
+	d.mu.stats.Lag.Nanos = lag.Nanoseconds()
+	d.mu.stats.Lag.MaxNanos = maxLag.Nanoseconds()
+	d.mu.stats.Lag.ExceededSinceUnixMicros = exceededSinceMicros
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordAdmitLatencyPercentiles(p50, p99 time.Duration) {
+	d.mu.Lock()
+	d.mu.stats.AdmitLatency.P50Nanos = p50.Nanoseconds()
+	d.mu.stats.AdmitLatency.P99Nanos = p99.Nanoseconds()
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordFlushRetry(gracePeriod time.Duration) {
+	d.mu.Lock()
+	d.mu.stats.FlushRetries.Count++
+	d.mu.stats.FlushRetries.GracePeriodNanos = gracePeriod.Nanoseconds()
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordCheckpointHeld(interval time.Duration) {
+	d.mu.Lock()
+	d.mu.stats.Checkpoints.Held++
+	d.mu.stats.Checkpoints.IntervalNanos = interval.Nanoseconds()
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordCheckpointEmitted(at time.Time, interval time.Duration) {
+	micros := at.UnixMicro()
+	d.mu.Lock()
+	d.mu.stats.Checkpoints.Emitted++
+	d.mu.stats.Checkpoints.IntervalNanos = interval.Nanoseconds()
+	d.mu.stats.Checkpoints.LastEmittedUnixMicros = micros
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordApplyCPU(share, throttleFactor float64) {
+	d.mu.Lock()
+	d.mu.stats.ApplyCPU.Share = share
+	d.mu.stats.ApplyCPU.ThrottleFactor = throttleFactor
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordIOPacing(overloadScore, factor float64) {
+	d.mu.Lock()
+	d.mu.stats.IOPacing.OverloadScore = overloadScore
+	d.mu.stats.IOPacing.Factor = factor
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordFrontier(local hlc.Timestamp) {
+	d.mu.Lock()
+	d.mu.stats.Frontier.Local = local
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordGlobalFrontier(global hlc.Timestamp, laggard bool) {
+	d.mu.Lock()
+	d.mu.stats.Frontier.Global = global
+	d.mu.stats.Frontier.Laggard = laggard
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordProgress(lastRecv, lastFlush, lastFrontierAdvance time.Time) {
+	d.mu.Lock()
+	d.mu.stats.Progress.LastRecvUnixMicros = lastRecv.UnixMicro()
+	d.mu.stats.Progress.LastFlushUnixMicros = lastFlush.UnixMicro()
+	d.mu.stats.Progress.LastFrontierAdvanceUnixMicros = lastFrontierAdvance.UnixMicro()
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordCatchUp(active bool, advanceRate float64, eta time.Duration) {
+	d.mu.Lock()
+	d.mu.stats.CatchUp.Active = active
+	d.mu.stats.CatchUp.AdvanceRate = advanceRate
+	d.mu.stats.CatchUp.ETANanos = eta.Nanoseconds()
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordWarmUp(progress float64) {
+	d.mu.Lock()
+	d.mu.stats.WarmUp.Progress = progress
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordQuantization(granularity time.Duration, frontierSpans int) {
+	d.mu.Lock()
+	d.mu.stats.Quantization.GranularityNanos = granularity.Nanoseconds()
+	d.mu.stats.Quantization.FrontierSpans = int64(frontierSpans)
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordFlushPacing(queueDepth int64, factor float64) {
+	d.mu.Lock()
+	d.mu.stats.FlushPacing.QueueDepth = queueDepth
+	d.mu.stats.FlushPacing.Factor = factor
+	d.mu.Unlock()
+}
+
+func (d *DebugLogicalConsumerStatus) RecordError(at time.Time, redactedErr string) {
+	micros := at.UnixMicro()
+	d.mu.Lock()
+	d.mu.stats.Errors.Count++
+	d.mu.stats.Errors.Last = redactedErr
+	d.mu.stats.Errors.LastUnixMicros = micros
+	d.mu.Unlock()
+}
+
+// DebugLogicalConsumerHealth is a compact summary of the health of a logical
+// stream consumer, suitable for external monitoring.
+type DebugLogicalConsumerHealth struct {
+	StreamID    StreamID `json:"stream_id"`
+	ProcessorID int32    `json:"processor_id"`
+	// Healthy is true if the consumer is within its lag threshold and has not
+	// encountered an error since its last successful flush.
+	Healthy     bool  `json:"healthy"`
+	LagNanos    int64 `json:"lag_nanos"`
+	LagExceeded bool  `json:"lag_exceeded"`
+	// LastFlushUnixMicros is the time at which the last successful flush
+	// completed, or zero if there was none.
+	LastFlushUnixMicros int64 `json:"last_flush_unix_micros"`
+	// FlushingSinceUnixMicros is the time at which the current flush started,
+	// or zero if the consumer isn't flushing.
+	FlushingSinceUnixMicros int64  `json:"flushing_since_unix_micros,omitempty"`
+	ErrorCount              int64  `json:"error_count"`
+	LastError               string `json:"last_error,omitempty"`
+	LastErrorUnixMicros     int64  `json:"last_error_unix_micros,omitempty"`
+}
+
+// Health summarizes the health of the consumer.
+func (d *DebugLogicalConsumerStatus) Health() DebugLogicalConsumerHealth {
+	stats := d.GetStats()
+	h := DebugLogicalConsumerHealth{
+		StreamID:                d.StreamID,
+		ProcessorID:             d.ProcessorID,
+		LagNanos:                stats.Lag.Nanos,
+		LagExceeded:             stats.Lag.ExceededSinceUnixMicros != 0,
+		LastFlushUnixMicros:     stats.Flushes.Last.CompletedUnixMicros,
+		FlushingSinceUnixMicros: stats.Flushes.Current.StartedUnixMicros,
+		ErrorCount:              stats.Errors.Count,
+		LastError:               stats.Errors.Last,
+		LastErrorUnixMicros:     stats.Errors.LastUnixMicros,
+	}
+	erroring := stats.Errors.LastUnixMicros != 0 &&
+		stats.Errors.LastUnixMicros >= stats.Flushes.Last.CompletedUnixMicros
+	h.Healthy = !h.LagExceeded && !erroring
+	return h
+}
+
+// DebugLogicalConsumersHealth summarizes the health of all active logical
+// stream consumers in the process.
+type DebugLogicalConsumersHealth struct {
+	// Healthy is true if every consumer is healthy.
+	Healthy   bool                         `json:"healthy"`
+	Consumers []DebugLogicalConsumerHealth `json:"consumers"`
+}
+
+// GetActiveLogicalConsumersHealth summarizes the health of all registered
+// logical consumer processors in the process.
+func GetActiveLogicalConsumersHealth() DebugLogicalConsumersHealth {
+	res := DebugLogicalConsumersHealth{Healthy: true, Consumers: []DebugLogicalConsumerHealth{}}
+	for _, status := range GetActiveLogicalConsumerStatuses() {
+		h := status.Health()
+		res.Healthy = res.Healthy && h.Healthy
+		res.Consumers = append(res.Consumers, h)
+	}
+	return res
+}
@@ -12,6 +12,7 @@ package debug
 
 import (
 	"context"
+	"encoding/json"
 	"expvar"
 	"fmt"
 	"io"
@@ -25,6 +26,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/closedts/sidetransport"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/kvstorage"
 	"github.com/cockroachdb/cockroach/pkg/multitenant/tenantcapabilities"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/server/debug/goroutineui"
 	"github.com/cockroachdb/cockroach/pkg/server/debug/pprofui"
@@ -120,6 +122,10 @@ func setupProcessWideRoutes(
 	// Set up the vmodule endpoint.
 	mux.HandleFunc("/debug/vmodule", authzFunc(vsrv.vmoduleHandleDebug))
 
+	// Register the logical replication health endpoint, which summarizes the
+	// health of the logical replication consumers running in the process.
+	mux.HandleFunc("/debug/logical_replication/health", authzFunc(handleLogicalReplicationHealth))
+
 	ps := pprofui.NewServer(pprofui.NewMemStorage(pprofui.ProfileConcurrency, pprofui.ProfileExpiry), profiler)
 	mux.Handle("/debug/pprof/ui/", authzFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.StripPrefix("/debug/pprof/ui", ps).ServeHTTP(w, r)
@@ -290,6 +296,21 @@ func (ds *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	handler.ServeHTTP(w, r)
 }
 
+// handleLogicalReplicationHealth writes a JSON summary of the health of the
+// logical replication consumers running in the process. The response has
+// status 503 if any of them is unhealthy, so that it can gate a load balancer
+// or a cutover.
+func handleLogicalReplicationHealth(w http.ResponseWriter, r *http.Request) {
+	health := streampb.GetActiveLogicalConsumersHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(&health); err != nil {
+		log.Warningf(r.Context(), "failed to write logical replication health: %v", err)
+	}
+}
+
 func handleLanding(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != Endpoint {
 		http.Redirect(w, r, Endpoint, http.StatusMovedPermanently)
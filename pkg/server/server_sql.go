@@ -247,6 +247,9 @@ type sqlServerOptionalKVArgs struct {
 	// The admission queue to use for SQLSQLResponseWork.
 	sqlSQLResponseAdmissionQ *admission.WorkQueue
 
+	// The admission queue for KVWork on this node, used to observe overload.
+	kvAdmissionQ *admission.WorkQueue
+
 	// Used when creating and deleting tenant records.
 	spanConfigKVAccessor spanconfig.KVAccessor
 	// kvStores is used by crdb_internal builtins to access the stores on this
@@ -859,6 +862,7 @@ func newSQLServer(ctx context.Context, cfg sqlServerArgs) (*SQLServer, error) {
 		DistSender:               cfg.distSender,
 		RangeCache:               cfg.distSender.RangeDescriptorCache(),
 		SQLSQLResponseAdmissionQ: cfg.sqlSQLResponseAdmissionQ,
+		KVAdmissionQ:             cfg.kvAdmissionQ,
 		CollectionFactory:        collectionFactory,
 		ExternalIORecorder:       cfg.costController,
 		TenantCostController:     cfg.costController,
@@ -41,6 +41,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/kv/kvserver/protectedts/ptpb"
 	"github.com/cockroachdb/cockroach/pkg/multitenant/tenantcapabilities"
 	"github.com/cockroachdb/cockroach/pkg/multitenant/tenantcapabilities/tenantcapabilitiespb"
+	"github.com/cockroachdb/cockroach/pkg/repstream/streampb"
 	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/scheduledjobs"
 	"github.com/cockroachdb/cockroach/pkg/security/username"
@@ -9218,7 +9219,40 @@ CREATE TABLE crdb_internal.logical_replication_node_processors (
 	cur_kvs_done INT,
 	cur_kvs_todo INT,
 	cur_batches INT,
-	cur_slowest INTERVAL
+	cur_slowest INTERVAL,
+	lag INTERVAL,
+	max_lag INTERVAL,
+	lag_exceeded INTERVAL,
+	admit_latency_p50 INTERVAL,
+	admit_latency_p99 INTERVAL,
+	admission_queue_depth INT,
+	flush_pacing_factor FLOAT,
+	source_address STRING,
+	token_fingerprint STRING,
+	flush_retries INT,
+	flush_grace_period INTERVAL,
+	apply_cpu_share FLOAT,
+	apply_cpu_throttle_factor FLOAT,
+	catching_up BOOL,
+	frontier_advance_rate FLOAT,
+	catch_up_eta INTERVAL,
+	warm_up_progress FLOAT,
+	checkpoints_emitted INT,
+	checkpoints_held INT,
+	checkpoint_emit_interval INTERVAL,
+	last_checkpoint INTERVAL,
+	timestamp_granularity INTERVAL,
+	frontier_spans INT,
+	io_overload_score FLOAT,
+	io_pacing_factor FLOAT,
+	local_frontier TIMESTAMPTZ,
+	global_frontier TIMESTAMPTZ,
+	frontier_laggard BOOL,
+	last_event INTERVAL,
+	last_flush INTERVAL,
+	last_frontier_advance INTERVAL,
+	table_buffers JSONB,
+	initial_scan_ranges JSONB
 );`,
 	populate: func(ctx context.Context, p *planner, _ catalog.DatabaseDescriptor, addRow func(...tree.Datum) error) error {
 		sm, err := p.EvalContext().StreamManagerFactory.GetReplicationStreamManager(ctx)
@@ -9240,8 +9274,86 @@ CREATE TABLE crdb_internal.logical_replication_node_processors (
 			return tree.NewDInterval(duration.MakeDuration(nanos, 0, 0), types.DefaultIntervalTypeMetadata)
 		}
 
+		nullIfZero := func(v int64, x tree.Datum) tree.Datum {
+			if v == 0 {
+				return tree.DNull
+			}
+			return x
+		}
+
+		nullIfEmpty := func(s string) tree.Datum {
+			if s == "" {
+				return tree.DNull
+			}
+			return tree.NewDString(s)
+		}
+
+		ts := func(t hlc.Timestamp) tree.Datum {
+			if t.IsEmpty() {
+				return tree.DNull
+			}
+			return tree.MustMakeDTimestampTZ(t.GoTime(), time.Microsecond)
+		}
+
+		tableBuffers := func(container *streampb.DebugLogicalConsumerStatus) (tree.Datum, error) {
+			if container.TableBuffers == nil {
+				return tree.DNull, nil
+			}
+			buffers := container.TableBuffers()
+			if len(buffers) == 0 {
+				return tree.DNull, nil
+			}
+			arr := make([]interface{}, len(buffers))
+			for i, b := range buffers {
+				arr[i] = map[string]interface{}{
+					"table_id":             int64(b.TableID),
+					"kvs":                  b.KVs,
+					"bytes":                b.Bytes,
+					"buffered_for_seconds": now.Sub(time.UnixMicro(b.OldestBufferedUnixMicros)).Seconds(),
+					"flush_due":            b.FlushDue,
+				}
+			}
+			j, err := json.MakeJSON(arr)
+			if err != nil {
+				return nil, err
+			}
+			return tree.NewDJSON(j), nil
+		}
+
+		initialScanRanges := func(container *streampb.DebugLogicalConsumerStatus) (tree.Datum, error) {
+			if container.InitialScanRanges == nil {
+				return tree.DNull, nil
+			}
+			ranges := container.InitialScanRanges()
+			if len(ranges) == 0 {
+				return tree.DNull, nil
+			}
+			arr := make([]interface{}, len(ranges))
+			for i, r := range ranges {
+				arr[i] = map[string]interface{}{
+					"span":  r.Span.String(),
+					"spans": r.Spans,
+					"kvs":   r.KVs,
+					"done":  r.Done,
+				}
+			}
+			j, err := json.MakeJSON(arr)
+			if err != nil {
+				return nil, err
+			}
+			return tree.NewDJSON(j), nil
+		}
+
 		for _, container := range sm.DebugGetLogicalConsumerStatuses(ctx) {
 			status := container.GetStats()
+			buffers, err := tableBuffers(container)
+			if err != nil {
+				return err
+			}
+			scanRanges, err := initialScanRanges(container)
+			if err != nil {
+				return err
+			}
 			nullCur := func(x tree.Datum) tree.Datum {
 				if status.Flushes.Current.StartedUnixMicros == 0 {
 					return tree.DNull
@@ -9267,6 +9379,40 @@ CREATE TABLE crdb_internal.logical_replication_node_processors (
 				nullCur(tree.NewDInt(tree.DInt(status.Flushes.Current.ProcessedKVs))),
 				nullCur(tree.NewDInt(tree.DInt(status.Flushes.Current.Batches))),
 				nullCur(dur(status.Flushes.Current.SlowestBatchNanos)),
+				dur(status.Lag.Nanos),
+				nullIfZero(status.Lag.MaxNanos, dur(status.Lag.MaxNanos)),
+				nullIfZero(status.Lag.ExceededSinceUnixMicros, age(time.UnixMicro(status.Lag.ExceededSinceUnixMicros))),
+				nullIfZero(status.AdmitLatency.P50Nanos, dur(status.AdmitLatency.P50Nanos)),
+				nullIfZero(status.AdmitLatency.P99Nanos, dur(status.AdmitLatency.P99Nanos)),
+				tree.NewDInt(tree.DInt(status.FlushPacing.QueueDepth)),
+				tree.NewDFloat(tree.DFloat(status.FlushPacing.Factor)),
+				nullIfEmpty(status.Source.Address),
+				nullIfEmpty(status.Source.TokenFingerprint),
+				tree.NewDInt(tree.DInt(status.FlushRetries.Count)),
+				nullIfZero(status.FlushRetries.GracePeriodNanos, dur(status.FlushRetries.GracePeriodNanos)),
+				tree.NewDFloat(tree.DFloat(status.ApplyCPU.Share)),
+				tree.NewDFloat(tree.DFloat(status.ApplyCPU.ThrottleFactor)),
+				tree.MakeDBool(tree.DBool(status.CatchUp.Active)),
+				tree.NewDFloat(tree.DFloat(status.CatchUp.AdvanceRate)),
+				nullIfZero(status.CatchUp.ETANanos, dur(status.CatchUp.ETANanos)),
+				tree.NewDFloat(tree.DFloat(status.WarmUp.Progress)),
+				tree.NewDInt(tree.DInt(status.Checkpoints.Emitted)),
+				tree.NewDInt(tree.DInt(status.Checkpoints.Held)),
+				dur(status.Checkpoints.IntervalNanos),
+				nullIfZero(status.Checkpoints.LastEmittedUnixMicros, age(time.UnixMicro(status.Checkpoints.LastEmittedUnixMicros))),
+				dur(status.Quantization.GranularityNanos),
+				tree.NewDInt(tree.DInt(status.Quantization.FrontierSpans)),
+				tree.NewDFloat(tree.DFloat(status.IOPacing.OverloadScore)),
+				tree.NewDFloat(tree.DFloat(status.IOPacing.Factor)),
+				ts(status.Frontier.Local),
+				ts(status.Frontier.Global),
+				tree.MakeDBool(tree.DBool(status.Frontier.Laggard)),
+				nullIfZero(status.Progress.LastRecvUnixMicros, age(time.UnixMicro(status.Progress.LastRecvUnixMicros))),
+				nullIfZero(status.Progress.LastFlushUnixMicros, age(time.UnixMicro(status.Progress.LastFlushUnixMicros))),
+				nullIfZero(status.Progress.LastFrontierAdvanceUnixMicros,
+					age(time.UnixMicro(status.Progress.LastFrontierAdvanceUnixMicros))),
+				buffers,
+				scanRanges,
 			); err != nil {
 				return err
 			}
@@ -1853,6 +1853,11 @@ type StreamingTestingKnobs struct {
 	SkipSpanConfigReplication bool
 
 	SpanConfigRangefeedCacheKnobs *rangefeedcache.TestingKnobs
+
+	// OnDrain, if set, returns the channel logical replication writer
+	// processors watch to detect that their node is draining, instead of the
+	// job registry's drain channel.
+	OnDrain func() <-chan struct{}
 }
 
 var _ base.ModuleTestingKnobs = &StreamingTestingKnobs{}
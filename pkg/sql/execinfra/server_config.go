@@ -183,6 +183,10 @@ type ServerConfig struct {
 	// SQLSQLResponseWork.
 	SQLSQLResponseAdmissionQ *admission.WorkQueue
 
+	// KVAdmissionQ is the admission queue for KVWork on this node. It is nil
+	// if the server does not share a process with KV.
+	KVAdmissionQ *admission.WorkQueue
+
 	// CollectionFactory is used to construct descs.Collections.
 	CollectionFactory *descs.CollectionFactory
 
@@ -363,7 +363,10 @@ func (p *DummyEvalPlanner) ExtendHistoryRetention(ctx context.Context, id jobspb
 }
 
 func (p *DummyEvalPlanner) StartLogicalReplicationJob(
-	ctx context.Context, targetConnStr string, tableNames []string,
+	ctx context.Context,
+	targetConnStr string,
+	tableNames []string,
+	options jobspb.LogicalReplicationDetails_Options,
 ) (jobspb.JobID, error) {
 	return 0, errors.WithStack(errEvalPlanner)
 }
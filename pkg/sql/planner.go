@@ -13,6 +13,7 @@ package sql
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -1002,7 +1003,10 @@ func (p *planner) ExtendHistoryRetention(ctx context.Context, jobID jobspb.JobID
 }
 
 func (p *planner) StartLogicalReplicationJob(
-	ctx context.Context, targetConnStr string, tableNames []string,
+	ctx context.Context,
+	targetConnStr string,
+	tableNames []string,
+	options jobspb.LogicalReplicationDetails_Options,
 ) (jobspb.JobID, error) {
 	if !p.ExecCfg().Settings.Version.IsActive(ctx, clusterversion.V24_1) {
 		return 0, pgerror.New(pgcode.FeatureNotSupported,
@@ -1015,6 +1019,11 @@ func (p *planner) StartLogicalReplicationJob(
 	// TODO(ssd): Name resolution needs to be thought through for
 	// the final syntax.
 	fullyQualifiedTableNames := make([]string, 0, len(tableNames))
+	fullyQualifiedByName := make(map[string]string, len(tableNames))
+	fanoutTables := make(map[string]jobspb.LogicalReplicationDetails_Options_FanoutTables, len(options.FanoutTables))
+	// applyOrderType is the type of the apply order column, which must be the
+	// same in every table so that its values are comparable.
+	var applyOrderType *types.T
 	for _, t := range tableNames {
 		un := tree.MakeUnresolvedName(t)
 		uon, err := un.ToUnresolvedObjectName(tree.NoAnnotation)
@@ -1033,6 +1042,120 @@ func (p *planner) StartLogicalReplicationJob(
 			tree.Name(td.GetName()),
 		)
 		fullyQualifiedTableNames = append(fullyQualifiedTableNames, tbNameWithSchema.FQString())
+		fullyQualifiedByName[t] = tbNameWithSchema.FQString()
+
+		if (options.Region != "" || options.RegionFromColumn != "") && td.IsLocalityRegionalByRow() {
+			colName, err := td.GetRegionalByRowTableRegionColumnName()
+			if err != nil {
+				return 0, err
+			}
+			col, err := catalog.MustFindColumnByTreeName(td, colName)
+			if err != nil {
+				return 0, err
+			}
+			if options.RegionColumns == nil {
+				options.RegionColumns = make(map[string]jobspb.LogicalReplicationDetails_Options_RegionColumn)
+			}
+			options.RegionColumns[tbNameWithSchema.FQString()] = jobspb.LogicalReplicationDetails_Options_RegionColumn{
+				Name:    string(colName),
+				TypeOID: col.GetType().Oid(),
+			}
+		}
+
+		if options.SoftDeleteColumn != "" {
+			col := catalog.FindColumnByName(td, options.SoftDeleteColumn)
+			if col == nil {
+				return 0, pgerror.Newf(pgcode.UndefinedColumn,
+					"destination table %s has no soft delete column %q", tbNameWithSchema.FQString(), options.SoftDeleteColumn)
+			}
+			if col.GetType().Family() != types.TimestampTZFamily {
+				return 0, pgerror.Newf(pgcode.DatatypeMismatch,
+					"soft delete column %q of destination table %s must be TIMESTAMPTZ, not %s",
+					options.SoftDeleteColumn, tbNameWithSchema.FQString(), col.GetType().SQLString())
+			}
+		}
+
+		if options.ApplyOrderColumn != "" {
+			col := catalog.FindColumnByName(td, options.ApplyOrderColumn)
+			if col == nil {
+				return 0, pgerror.Newf(pgcode.UndefinedColumn,
+					"destination table %s has no apply order column %q", tbNameWithSchema.FQString(), options.ApplyOrderColumn)
+			}
+			if applyOrderType == nil {
+				applyOrderType = col.GetType()
+			} else if !col.GetType().Equivalent(applyOrderType) {
+				return 0, pgerror.Newf(pgcode.DatatypeMismatch,
+					"apply order column %q of destination table %s is %s, but %s in other tables",
+					options.ApplyOrderColumn, tbNameWithSchema.FQString(), col.GetType().SQLString(),
+					applyOrderType.SQLString())
+			}
+		}
+
+		if fanout, ok := options.FanoutTables[t]; ok {
+			tables := make([]string, 0, len(fanout.Tables))
+			for _, name := range fanout.Tables {
+				fq, err := p.resolveFanoutTable(ctx, name, td)
+				if err != nil {
+					return 0, err
+				}
+				tables = append(tables, fq)
+			}
+			fanoutTables[tbNameWithSchema.FQString()] = jobspb.LogicalReplicationDetails_Options_FanoutTables{
+				Tables: tables,
+			}
+		}
+
+		if len(options.AuditColumns) > 0 {
+			columns := make(map[string]string, len(options.AuditColumns))
+			for field, colName := range options.AuditColumns {
+				if catalog.FindColumnByName(td, colName) != nil {
+					columns[field] = colName
+				}
+			}
+			if options.TableAuditColumns == nil {
+				options.TableAuditColumns = make(map[string]jobspb.LogicalReplicationDetails_Options_TableAuditColumns)
+			}
+			options.TableAuditColumns[tbNameWithSchema.FQString()] = jobspb.LogicalReplicationDetails_Options_TableAuditColumns{
+				Columns: columns,
+			}
+		}
+	}
+	if (options.Region != "" || options.RegionFromColumn != "") && len(options.RegionColumns) == 0 {
+		return 0, pgerror.New(pgcode.InvalidParameterValue,
+			"a region can only be derived for REGIONAL BY ROW destination tables")
+	}
+	for i, t := range options.IgnoreDeletesTables {
+		fq, ok := fullyQualifiedByName[t]
+		if !ok {
+			return 0, pgerror.Newf(pgcode.InvalidParameterValue,
+				"table %q whose deletes are ignored is not replicated", t)
+		}
+		options.IgnoreDeletesTables[i] = fq
+	}
+	for i, t := range options.GroupBySourceTxnTables {
+		fq, ok := fullyQualifiedByName[t]
+		if !ok {
+			return 0, pgerror.Newf(pgcode.InvalidParameterValue,
+				"table %q whose source transactions are applied atomically is not replicated", t)
+		}
+		options.GroupBySourceTxnTables[i] = fq
+	}
+	for t := range options.FanoutTables {
+		if _, ok := fullyQualifiedByName[t]; !ok {
+			return 0, pgerror.Newf(pgcode.InvalidParameterValue,
+				"table %q with fan-out tables is not replicated", t)
+		}
+	}
+	for _, fanout := range fanoutTables {
+		for _, fq := range fanout.Tables {
+			if slices.Contains(fullyQualifiedTableNames, fq) {
+				return 0, pgerror.Newf(pgcode.InvalidParameterValue,
+					"fan-out table %s is itself replicated", fq)
+			}
+		}
+	}
+	if len(fanoutTables) > 0 {
+		options.FanoutTables = fanoutTables
 	}
 	jr := jobs.Record{
 		Description: fmt.Sprintf("logical replication ingestion for %s",
@@ -1040,7 +1163,9 @@ func (p *planner) StartLogicalReplicationJob(
 		Username: evalCtx.SessionData().User(),
 		Details: jobspb.LogicalReplicationDetails{
 			TargetClusterConnStr: targetConnStr,
-			TableNames:           fullyQualifiedTableNames},
+			TableNames:           fullyQualifiedTableNames,
+			Options:              options,
+		},
 		Progress: jobspb.LogicalReplicationProgress{},
 		JobID:    registry.MakeJobID(),
 	}
@@ -1051,3 +1176,47 @@ func (p *planner) StartLogicalReplicationJob(
 	registry.NotifyToAdoptJobs()
 	return jr.JobID, nil
 }
+
+// resolveFanoutTable resolves the fan-out table of the replicated table td
+// with the given name and returns its fully qualified name, after checking
+// that the rows of td can be applied to it, i.e. that it has the primary key
+// columns of td and that its other columns are columns of td.
+func (p *planner) resolveFanoutTable(
+	ctx context.Context, name string, td catalog.TableDescriptor,
+) (string, error) {
+	un := tree.MakeUnresolvedName(name)
+	uon, err := un.ToUnresolvedObjectName(tree.NoAnnotation)
+	if err != nil {
+		return "", err
+	}
+	tn := uon.ToTableName()
+	prefix, fanout, err := resolver.ResolveMutableExistingTableObject(ctx, p, &tn, true, tree.ResolveRequireTableDesc)
+	if err != nil {
+		return "", err
+	}
+	fq := tree.MakeTableNameWithSchema(
+		tree.Name(prefix.Database.GetName()),
+		tree.Name(prefix.Schema.GetName()),
+		tree.Name(fanout.GetName()),
+	).FQString()
+	if catalog.FindColumnByName(fanout, "crdb_internal_origin_timestamp") == nil {
+		return "", pgerror.Newf(pgcode.UndefinedColumn,
+			"fan-out table %s has no crdb_internal_origin_timestamp column", fq)
+	}
+	for _, colName := range td.TableDesc().PrimaryIndex.KeyColumnNames {
+		if catalog.FindColumnByName(fanout, colName) == nil {
+			return "", pgerror.Newf(pgcode.UndefinedColumn,
+				"fan-out table %s lacks primary key column %q of table %s", fq, colName, td.GetName())
+		}
+	}
+	for _, col := range fanout.PublicColumns() {
+		if col.IsComputed() || col.GetName() == "crdb_internal_origin_timestamp" {
+			continue
+		}
+		if catalog.FindColumnByName(td, col.GetName()) == nil {
+			return "", pgerror.Newf(pgcode.UndefinedColumn,
+				"column %q of fan-out table %s is not a column of table %s", col.GetName(), fq, td.GetName())
+		}
+	}
+	return fq, nil
+}
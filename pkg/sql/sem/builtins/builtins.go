@@ -17,6 +17,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/hex"
 	gojson "encoding/json"
 	"fmt"
 	"hash"
@@ -4441,13 +4442,100 @@ value if you rely on the HLC for accuracy.`,
 					tables[i] = string(tree.MustBeDString(tableName))
 				}
 
-				jobId, err := evalCtx.Planner.StartLogicalReplicationJob(ctx, targetConnStr, tables)
+				jobId, err := evalCtx.Planner.StartLogicalReplicationJob(
+					ctx, targetConnStr, tables, jobspb.LogicalReplicationDetails_Options{})
 
 				return tree.NewDInt(tree.DInt(jobId)), err
 			},
 			Info:       "This function is used only by CockroachDB's developers for testing purposes.",
 			Volatility: volatility.Volatile,
 		},
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "conn_str", Typ: types.String},
+				{Name: "table_names", Typ: types.StringArray},
+				{Name: "options", Typ: types.Jsonb},
+			},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				if err := evalCtx.SessionAccessor.CheckPrivilege(
+					ctx, syntheticprivilege.GlobalPrivilegeObject, privilege.REPLICATION,
+				); err != nil {
+					return nil, err
+				}
+				targetConnStr := string(tree.MustBeDString(args[0]))
+				tableNameArray := tree.MustBeDArray(args[1])
+				tables := make([]string, len(tableNameArray.Array))
+				for i, tableName := range tableNameArray.Array {
+					tables[i] = string(tree.MustBeDString(tableName))
+				}
+				options, err := parseLogicalReplicationOptions(tree.MustBeDJSON(args[2]).JSON)
+				if err != nil {
+					return nil, err
+				}
+
+				jobId, err := evalCtx.Planner.StartLogicalReplicationJob(ctx, targetConnStr, tables, options)
+
+				return tree.NewDInt(tree.DInt(jobId)), err
+			},
+			Info: "This function is used only by CockroachDB's developers for testing purposes. " +
+				"Supported options are: fanout_sink, the URI of a changefeed sink to which applied rows are emitted; " +
+				"dry_run, which if true only reports the projected resource usage of the stream in the job's status; " +
+				"checkpoint_sink, the URI of an external storage location to which the stream's checkpoints are written; " +
+				"encryption_kms, the URI of a KMS key under which the checkpoints written to checkpoint_sink and the " +
+				"rows sent to the dead letter queue are encrypted, so that they aren't persisted in plaintext; " +
+				"cutover_time, the decimal HLC timestamp through which changes are replicated before the job completes; " +
+				"group_by_source_txn, which if true applies the changes of each source transaction atomically, identifying " +
+				"the transactions by their commit timestamps; " +
+				"group_by_source_txn_tables, a comma-separated list of replicated tables to whose changes group_by_source_txn " +
+				"applies, while the changes of the other tables are batched by row for throughput; " +
+				"ignore_deletes, a comma-separated list of replicated tables whose deletes are not applied, " +
+				"so that rows deleted on the source are intentionally retained on the destination; " +
+				"region, the region of the rows applied to REGIONAL BY ROW destination tables whose source tables have no region column; " +
+				"region_from_column, the source column whose value names the region of such rows instead; " +
+				"defer_secondary_indexes, which if true drops the non-unique secondary indexes of the destination tables " +
+				"while the initial scan is applied and rebuilds them once it completes, so queries can't use them in the meantime; " +
+				"replicate_schema_changes, which if true applies columns added to or dropped from the source tables and " +
+				"their created or dropped secondary indexes to the destination tables; " +
+				"shadow_destination, the URI of a second destination cluster to which applied rows are also applied, " +
+				"best effort, and whose rows are compared with those of the destination tables; " +
+				"batch_bytes, a size such as '4MiB' which if set applies rows in batches that accumulate rows until " +
+				"they reach that size rather than in batches of logical_replication.consumer.batch_size rows; " +
+				"collapse, which if true applies only the latest version of each key received in a flush, so that the " +
+				"destination lacks the intermediate history of rows updated more than once between flushes; " +
+				"audit_columns, a comma-separated list of field=column pairs naming the destination columns to which the " +
+				"provenance of each applied row is written, where the fields are source_timestamp, the HLC timestamp of " +
+				"the source write as a DECIMAL, apply_timestamp, the TIMESTAMPTZ at which it was applied, and " +
+				"source_cluster_id, the UUID of the source cluster; destination tables lacking a column are skipped; " +
+				"repair_span, the hex-encoded start and end keys, separated by a comma, of a source key span to " +
+				"which the stream is restricted for a targeted repair of a known divergence, in which only the changes " +
+				"made to the span from repair_start_time, a decimal HLC timestamp, through cutover_time, which must be " +
+				"set, are applied without an initial scan before the job completes; the source must still retain the " +
+				"history of the span since repair_start_time; " +
+				"frontier_milestones, a comma-separated list of decimal HLC timestamps the first crossing of each of " +
+				"which by the replicated time of the job is logged as a logical_replication_milestone event; " +
+				"apply_order_column, the name of a column of every destination table, of the same type in each, by " +
+				"whose value the rows written at the same source timestamp are applied within each flush, which are " +
+				"then applied in timestamp order by a single worker, for destinations sensitive to the order in which " +
+				"rows are applied; " +
+				"compare_and_swap, which if true applies each row only if the destination row matches the row's " +
+				"prior value at the source, which the source reports for every row once the option is set, rather " +
+				"than only if the destination row is older; " +
+				"soft_delete_column, the name of a TIMESTAMPTZ column of the destination tables, which every one of " +
+				"them must have, that replicated deletes set to the time of the source delete rather than deleting the row; " +
+				"fanout_tables, a semicolon-separated list of table=table[,table...] entries naming the additional " +
+				"destination tables to which the rows of each replicated table are also applied in the same " +
+				"transaction, which must have the primary key columns of the replicated table but may be keyed by any " +
+				"of their columns, e.g. to maintain denormalized copies, and whose other columns must be columns of " +
+				"the replicated table or computed columns; " +
+				"visible_to_rangefeeds, which if true leaves the applied rows visible to rangefeeds so that changefeeds " +
+				"on the destination emit them, at the risk of replicating them back if the destination is also a source; " +
+				"cutover_verification, either sample or full, which if set compares a sample of the rows, or all of them, " +
+				"of the destination tables with the source tables as of the cutover time once it is reached, and pauses " +
+				"the job rather than completing it if more rows differ than " +
+				"logical_replication.consumer.cutover_verification.max_divergent_rows.",
+			Volatility: volatility.Volatile,
+		},
 	),
 
 	"crdb_internal.datums_to_bytes": makeBuiltin(
@@ -11837,3 +11925,213 @@ func makeTimestampStatementBuiltinOverload(withOutputTZ bool, withInputTZ bool)
 		Volatility: vol,
 	}
 }
+
+// parseLogicalReplicationOptions parses the options passed to
+// crdb_internal.start_logical_replication_job.
+func parseLogicalReplicationOptions(
+	j json.JSON,
+) (jobspb.LogicalReplicationDetails_Options, error) {
+	var options jobspb.LogicalReplicationDetails_Options
+	it, err := j.ObjectIter()
+	if err != nil {
+		return options, err
+	}
+	if it == nil {
+		return options, pgerror.New(pgcode.InvalidParameterValue, "options must be a JSON object")
+	}
+	for it.Next() {
+		text, err := it.Value().AsText()
+		if err != nil {
+			return options, err
+		}
+		if text == nil {
+			return options, pgerror.Newf(pgcode.InvalidParameterValue, "option %q must not be null", it.Key())
+		}
+		switch it.Key() {
+		case "fanout_sink":
+			options.FanoutSinkURI = *text
+		case "checkpoint_sink":
+			options.CheckpointSinkURI = *text
+		case "encryption_kms":
+			options.EncryptionKMSURI = *text
+		case "cutover_time":
+			if options.CutoverTime, err = hlc.ParseHLC(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "dry_run":
+			if options.DryRun, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "group_by_source_txn":
+			if options.GroupBySourceTxn, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "group_by_source_txn_tables":
+			for _, name := range strings.Split(*text, ",") {
+				options.GroupBySourceTxnTables = append(options.GroupBySourceTxnTables, strings.TrimSpace(name))
+			}
+		case "ignore_deletes":
+			for _, name := range strings.Split(*text, ",") {
+				options.IgnoreDeletesTables = append(options.IgnoreDeletesTables, strings.TrimSpace(name))
+			}
+		case "region":
+			options.Region = *text
+		case "region_from_column":
+			options.RegionFromColumn = *text
+		case "defer_secondary_indexes":
+			if options.DeferSecondaryIndexes, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "replicate_schema_changes":
+			if options.ReplicateSchemaChanges, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "shadow_destination":
+			options.ShadowDestinationURI = *text
+		case "batch_bytes":
+			if options.BatchBytes, err = humanizeutil.ParseBytes(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+			if options.BatchBytes <= 0 {
+				return options, pgerror.Newf(pgcode.InvalidParameterValue, "option %q must be positive", it.Key())
+			}
+		case "collapse":
+			if options.Collapse, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "audit_columns":
+			options.AuditColumns = make(map[string]string)
+			for _, pair := range strings.Split(*text, ",") {
+				field, column, ok := strings.Cut(pair, "=")
+				field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+				if !ok || column == "" {
+					return options, pgerror.Newf(pgcode.InvalidParameterValue,
+						"option %q: expected field=column, got %q", it.Key(), pair)
+				}
+				switch field {
+				case "source_timestamp", "apply_timestamp", "source_cluster_id":
+				default:
+					return options, pgerror.Newf(pgcode.InvalidParameterValue,
+						"option %q: unknown field %q", it.Key(), field)
+				}
+				options.AuditColumns[field] = column
+			}
+		case "repair_span":
+			start, end, ok := strings.Cut(*text, ",")
+			if !ok {
+				return options, pgerror.Newf(pgcode.InvalidParameterValue,
+					"option %q: expected hex-encoded start and end keys separated by a comma", it.Key())
+			}
+			var span roachpb.Span
+			if span.Key, err = hex.DecodeString(strings.TrimSpace(start)); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+			if span.EndKey, err = hex.DecodeString(strings.TrimSpace(end)); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+			if len(span.EndKey) == 0 || !span.Valid() {
+				return options, pgerror.Newf(pgcode.InvalidParameterValue,
+					"option %q: start key must sort before end key", it.Key())
+			}
+			options.RepairSpan = span
+		case "repair_start_time":
+			if options.RepairStartTime, err = hlc.ParseHLC(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "frontier_milestones":
+			for _, text := range strings.Split(*text, ",") {
+				milestone, err := hlc.ParseHLC(strings.TrimSpace(text))
+				if err != nil {
+					return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+				}
+				options.FrontierMilestones = append(options.FrontierMilestones, milestone)
+			}
+		case "apply_order_column":
+			options.ApplyOrderColumn = *text
+		case "compare_and_swap":
+			if options.CompareAndSwap, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "soft_delete_column":
+			options.SoftDeleteColumn = *text
+		case "fanout_tables":
+			options.FanoutTables = make(map[string]jobspb.LogicalReplicationDetails_Options_FanoutTables)
+			for _, entry := range strings.Split(*text, ";") {
+				table, fanout, ok := strings.Cut(entry, "=")
+				table = strings.TrimSpace(table)
+				if !ok || table == "" {
+					return options, pgerror.Newf(pgcode.InvalidParameterValue,
+						"option %q: expected table=table[,table...], got %q", it.Key(), entry)
+				}
+				var tables []string
+				for _, name := range strings.Split(fanout, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						tables = append(tables, name)
+					}
+				}
+				if len(tables) == 0 {
+					return options, pgerror.Newf(pgcode.InvalidParameterValue,
+						"option %q: no fan-out tables for table %q", it.Key(), table)
+				}
+				options.FanoutTables[table] = jobspb.LogicalReplicationDetails_Options_FanoutTables{Tables: tables}
+			}
+		case "visible_to_rangefeeds":
+			if options.VisibleToRangefeeds, err = strconv.ParseBool(*text); err != nil {
+				return options, pgerror.Wrapf(err, pgcode.InvalidParameterValue, "option %q", it.Key())
+			}
+		case "cutover_verification":
+			switch *text {
+			case "sample", "full":
+				options.CutoverVerification = *text
+			default:
+				return options, pgerror.Newf(pgcode.InvalidParameterValue,
+					"option %q must be sample or full, got %q", it.Key(), *text)
+			}
+		default:
+			return options, pgerror.Newf(pgcode.InvalidParameterValue, "unknown option %q", it.Key())
+		}
+	}
+	if options.CutoverVerification != "" && options.CutoverTime.IsEmpty() {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`option "cutover_verification" requires "cutover_time"`)
+	}
+	if options.RepairSpan.Valid() != !options.RepairStartTime.IsEmpty() {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "repair_span" and "repair_start_time" must be set together`)
+	}
+	if options.RepairSpan.Valid() {
+		if !options.RepairStartTime.Less(options.CutoverTime) {
+			return options, pgerror.New(pgcode.InvalidParameterValue,
+				`option "repair_span" requires a "cutover_time" after "repair_start_time"`)
+		}
+		if options.DeferSecondaryIndexes {
+			return options, pgerror.New(pgcode.InvalidParameterValue,
+				`option "repair_span" has no initial scan for which to defer secondary indexes`)
+		}
+	}
+	if options.CompareAndSwap && options.Collapse {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "compare_and_swap" and "collapse" are mutually exclusive`)
+	}
+	if options.CompareAndSwap && options.SoftDeleteColumn != "" {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "compare_and_swap" and "soft_delete_column" are mutually exclusive`)
+	}
+	if options.CompareAndSwap && len(options.FanoutTables) > 0 {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "compare_and_swap" and "fanout_tables" are mutually exclusive`)
+	}
+	if options.CompareAndSwap && len(options.AuditColumns) > 0 {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "compare_and_swap" and "audit_columns" are mutually exclusive`)
+	}
+	if options.CompareAndSwap && (options.Region != "" || options.RegionFromColumn != "") {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`option "compare_and_swap" is mutually exclusive with "region" and "region_from_column"`)
+	}
+	if options.Region != "" && options.RegionFromColumn != "" {
+		return options, pgerror.New(pgcode.InvalidParameterValue,
+			`options "region" and "region_from_column" are mutually exclusive`)
+	}
+	return options, nil
+}
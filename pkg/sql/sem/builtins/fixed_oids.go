@@ -2584,6 +2584,12 @@ var builtinOidsArray = []string{
 	2616: `crdb_internal.start_logical_replication_job(conn_str: string, table_names: string[]) -> int`,
 	2617: `crdb_internal.plan_logical_replication(spans: bytes[]) -> bytes`,
 	2618: `crdb_internal.start_replication_stream_for_tables(req: bytes) -> bytes`,
+	2619: `crdb_internal.start_logical_replication_job(conn_str: string, table_names: string[], options: jsonb) -> int`,
+	2620: `crdb_internal.dump_logical_replication_buffers(stream_id: int) -> int`,
+	2622: `crdb_internal.describe_tables_for_replication(req: bytes) -> bytes`,
+	2623: `crdb_internal.logical_replication_recent_flushes(stream_id: int) -> jsonb`,
+	2624: `crdb_internal.logical_replication_recent_flushes(stream_id: int, start_key: bytes, end_key: bytes) -> jsonb`,
+	2626: `crdb_internal.decrypt_logical_replication_artifact(kms_uri: string, artifact: bytes) -> bytes`,
 }
 
 var builtinOidsBySignature map[string]oid.Oid
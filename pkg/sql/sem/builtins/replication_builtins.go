@@ -12,6 +12,8 @@ package builtins
 
 import (
 	"context"
+	gojson "encoding/json"
+	"time"
 
 	"github.com/cockroachdb/cockroach/pkg/kv"
 	"github.com/cockroachdb/cockroach/pkg/kv/kvpb"
@@ -27,8 +29,10 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/sql/syntheticprivilege"
 	"github.com/cockroachdb/cockroach/pkg/sql/types"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/redact"
 )
 
 func init() {
@@ -512,6 +516,131 @@ var replicationBuiltins = map[string]builtinDefinition{
 		},
 	),
 
+	"crdb_internal.dump_logical_replication_buffers": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         builtinconstants.CategoryClusterReplication,
+			Undocumented:     true,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "stream_id", Typ: types.Int},
+			},
+			ReturnType: tree.FixedReturnType(types.Int),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				mgr, err := evalCtx.StreamManagerFactory.GetReplicationStreamManager(ctx)
+				if err != nil {
+					return nil, err
+				}
+				streamID := streampb.StreamID(tree.MustBeDInt(args[0]))
+				var dumped int
+				for _, status := range mgr.DebugGetLogicalConsumerStatuses(ctx) {
+					if status.StreamID != streamID || status.SnapshotBuffer == nil {
+						continue
+					}
+					kvs := status.SnapshotBuffer()
+					var buf redact.StringBuilder
+					for _, kv := range kvs {
+						buf.Printf("\n%s %s (%d value bytes)", kv.Key, kv.Timestamp, kv.ValueBytes)
+					}
+					log.Infof(ctx, "logical replication stream %d processor %d buffers %d KVs:%s",
+						streamID, status.ProcessorID, len(kvs), buf.RedactableString())
+					dumped += len(kvs)
+				}
+				return tree.NewDInt(tree.DInt(dumped)), nil
+			},
+			Info: "Writes the keys and timestamps of the KVs buffered by the logical replication " +
+				"processors of the given stream on this node to the log and the session trace, " +
+				"and returns the number of KVs written. Values are not written.",
+			Volatility: volatility.Volatile,
+		},
+	),
+
+	"crdb_internal.logical_replication_recent_flushes": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         builtinconstants.CategoryClusterReplication,
+			Undocumented:     true,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "stream_id", Typ: types.Int},
+			},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				streamID := streampb.StreamID(tree.MustBeDInt(args[0]))
+				return logicalReplicationRecentFlushes(ctx, evalCtx, streamID, roachpb.Span{})
+			},
+			Info: "Returns a JSON array of the recent flushes retained by the logical replication " +
+				"processors of the given stream on this node, newest first: the size, latency, workers " +
+				"and retries of each flush, and the span of keys of each table it applied KVs to, and " +
+				"their timestamps. Values are not retained.",
+			Volatility: volatility.Volatile,
+		},
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "stream_id", Typ: types.Int},
+				{Name: "start_key", Typ: types.Bytes},
+				{Name: "end_key", Typ: types.Bytes},
+			},
+			ReturnType: tree.FixedReturnType(types.Jsonb),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				streamID := streampb.StreamID(tree.MustBeDInt(args[0]))
+				sp := roachpb.Span{
+					Key:    roachpb.Key(tree.MustBeDBytes(args[1])),
+					EndKey: roachpb.Key(tree.MustBeDBytes(args[2])),
+				}
+				if !sp.Valid() {
+					return nil, pgerror.Newf(pgcode.InvalidParameterValue, "invalid span %s", sp)
+				}
+				return logicalReplicationRecentFlushes(ctx, evalCtx, streamID, sp)
+			},
+			Info: "Returns a JSON array of the recent flushes retained by the logical replication " +
+				"processors of the given stream on this node that applied KVs to source keys in " +
+				"[start_key, end_key), newest first.",
+			Volatility: volatility.Volatile,
+		},
+	),
+
+	"crdb_internal.describe_tables_for_replication": makeBuiltin(
+		tree.FunctionProperties{
+			Category:         builtinconstants.CategoryClusterReplication,
+			Undocumented:     true,
+			DistsqlBlocklist: true,
+		},
+		tree.Overload{
+			Types: tree.ParamTypes{
+				{Name: "req", Typ: types.Bytes},
+			},
+			ReturnType: tree.FixedReturnType(types.Bytes),
+			Fn: func(ctx context.Context, evalCtx *eval.Context, args tree.Datums) (tree.Datum, error) {
+				mgr, err := evalCtx.StreamManagerFactory.GetReplicationStreamManager(ctx)
+				if err != nil {
+					return nil, err
+				}
+				reqBytes := []byte(tree.MustBeDBytes(args[0]))
+				req := streampb.ReplicationProducerRequest{}
+				if err := protoutil.Unmarshal(reqBytes, &req); err != nil {
+					return nil, err
+				}
+
+				spec, err := mgr.DescribeTablesForReplication(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+
+				rawSpec, err := protoutil.Marshal(&spec)
+				if err != nil {
+					return nil, err
+				}
+				return tree.NewDBytes(tree.DBytes(rawSpec)), err
+			},
+			Info: "Returns the current primary index spans and descriptors of the requested " +
+				"tables, which are used by logical replication consumers to replicate schema changes.",
+			Volatility: volatility.Volatile,
+		},
+	),
+
 	"crdb_internal.start_replication_stream_for_tables": makeBuiltin(
 		tree.FunctionProperties{
 			Category:         builtinconstants.CategoryClusterReplication,
@@ -550,3 +679,69 @@ var replicationBuiltins = map[string]builtinDefinition{
 		},
 	),
 }
+
+// logicalReplicationRecentFlushes returns the recent flushes retained by the
+// logical replication processors of the given stream on this node that
+// applied KVs to keys in the span, or all of them if it is empty.
+func logicalReplicationRecentFlushes(
+	ctx context.Context, evalCtx *eval.Context, streamID streampb.StreamID, sp roachpb.Span,
+) (tree.Datum, error) {
+	mgr, err := evalCtx.StreamManagerFactory.GetReplicationStreamManager(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type flushSpan struct {
+		StartKey     string `json:"start_key"`
+		EndKey       string `json:"end_key"`
+		KVs          int    `json:"kvs"`
+		MinTimestamp string `json:"min_timestamp"`
+		MaxTimestamp string `json:"max_timestamp"`
+	}
+	type flush struct {
+		ProcessorID  int32       `json:"processor_id"`
+		Started      time.Time   `json:"started"`
+		Duration     string      `json:"duration"`
+		KVs          int64       `json:"kvs"`
+		Bytes        int64       `json:"bytes"`
+		Batches      int64       `json:"batches"`
+		Workers      int64       `json:"workers"`
+		Retries      int64       `json:"retries"`
+		SlowestBatch string      `json:"slowest_batch"`
+		Spans        []flushSpan `json:"spans"`
+	}
+	flushes := []flush{}
+	for _, status := range mgr.DebugGetLogicalConsumerStatuses(ctx) {
+		if status.StreamID != streamID || status.RecentFlushes == nil {
+			continue
+		}
+		for _, s := range status.RecentFlushes(sp) {
+			f := flush{
+				ProcessorID:  status.ProcessorID,
+				Started:      time.UnixMicro(s.StartedUnixMicros).UTC(),
+				Duration:     time.Duration(s.Nanos).String(),
+				KVs:          s.KVs,
+				Bytes:        s.Bytes,
+				Batches:      s.Batches,
+				Workers:      s.Workers,
+				Retries:      s.Retries,
+				SlowestBatch: time.Duration(s.SlowestBatchNanos).String(),
+				Spans:        make([]flushSpan, len(s.Spans)),
+			}
+			for i, fs := range s.Spans {
+				f.Spans[i] = flushSpan{
+					StartKey:     fs.Span.Key.String(),
+					EndKey:       fs.Span.EndKey.String(),
+					KVs:          fs.KVs,
+					MinTimestamp: fs.MinTimestamp.AsOfSystemTime(),
+					MaxTimestamp: fs.MaxTimestamp.AsOfSystemTime(),
+				}
+			}
+			flushes = append(flushes, f)
+		}
+	}
+	jsonStr, err := gojson.Marshal(flushes)
+	if err != nil {
+		return nil, err
+	}
+	return tree.ParseDJSON(string(jsonStr))
+}
@@ -947,6 +947,13 @@ type ReplicationStreamManager interface {
 		ctx context.Context,
 		req streampb.ReplicationProducerRequest,
 	) (streampb.ReplicationProducerSpec, error)
+
+	// DescribeTablesForReplication returns the current primary index spans and
+	// descriptors of the requested tables without starting a stream.
+	DescribeTablesForReplication(
+		ctx context.Context,
+		req streampb.ReplicationProducerRequest,
+	) (streampb.ReplicationProducerSpec, error)
 }
 
 // StreamIngestManager represents a collection of APIs that streaming replication supports
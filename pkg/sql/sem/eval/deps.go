@@ -452,7 +452,12 @@ type Planner interface {
 		tempSchemaName string, databaseID descpb.ID, schemaID descpb.ID,
 	)
 
-	StartLogicalReplicationJob(ctx context.Context, targetConnStr string, tableNames []string) (jobspb.JobID, error)
+	StartLogicalReplicationJob(
+		ctx context.Context,
+		targetConnStr string,
+		tableNames []string,
+		options jobspb.LogicalReplicationDetails_Options,
+	) (jobspb.JobID, error)
 }
 
 // InternalRows is an iterator interface that's exposed by the internal
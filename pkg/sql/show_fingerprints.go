@@ -290,71 +290,30 @@ func (n *showFingerprintsNode) Next(params runParams) (bool, error) {
 	}
 	index := n.indexes[n.run.rowIdx]
 
-	cols := make([]string, 0, len(n.tableDesc.PublicColumns()))
-	var numBytesCols int
-	addColumn := func(col catalog.Column) {
-		var colNameOrExpr string
-		if col.IsExpressionIndexColumn() {
-			colNameOrExpr = fmt.Sprintf("(%s)", col.GetComputeExpr())
-		} else {
-			name := col.GetName()
-			colNameOrExpr = tree.NameStringP(&name)
-		}
-		// TODO(dan): This is known to be a flawed way to fingerprint. Any datum
-		// with the same string representation is fingerprinted the same, even
-		// if they're different types.
-		switch col.GetType().Family() {
-		case types.BytesFamily:
-			cols = append(cols, fmt.Sprintf("%s:::bytes", colNameOrExpr))
-			numBytesCols++
-		case types.StringFamily:
-			cols = append(cols, fmt.Sprintf("%s:::string", colNameOrExpr))
-		default:
-			cols = append(cols, fmt.Sprintf("%s::string", colNameOrExpr))
-		}
-	}
-
+	var cols []catalog.Column
 	if index.Primary() {
-		for _, col := range n.tableDesc.PublicColumns() {
-			addColumn(col)
-		}
+		cols = n.tableDesc.PublicColumns()
 	} else {
 		for i := 0; i < index.NumKeyColumns(); i++ {
 			col, err := catalog.MustFindColumnByID(n.tableDesc, index.GetKeyColumnID(i))
 			if err != nil {
 				return false, err
 			}
-			addColumn(col)
+			cols = append(cols, col)
 		}
 		for i := 0; i < index.NumKeySuffixColumns(); i++ {
 			col, err := catalog.MustFindColumnByID(n.tableDesc, index.GetKeySuffixColumnID(i))
 			if err != nil {
 				return false, err
 			}
-			addColumn(col)
+			cols = append(cols, col)
 		}
 		for i := 0; i < index.NumSecondaryStoredColumns(); i++ {
 			col, err := catalog.MustFindColumnByID(n.tableDesc, index.GetStoredColumnID(i))
 			if err != nil {
 				return false, err
 			}
-			addColumn(col)
-		}
-	}
-
-	if len(cols) != numBytesCols && numBytesCols != 0 {
-		// Currently, cols has a mix of BYTES and STRING types, but fnv64
-		// requires all arguments to be of the same type. We'll cast less
-		// frequent type to the other.
-		from, to := "::bytes", "::string"
-		if numBytesCols > len(cols)/2 {
-			// BYTES is more frequent.
-			from, to = "::string", "::bytes"
-		}
-		for i := range cols {
-			if strings.HasSuffix(cols[i], from) {
-				cols[i] = cols[i] + to
-			}
+			cols = append(cols, col)
 		}
 	}
 
@@ -371,9 +330,9 @@ func (n *showFingerprintsNode) Next(params runParams) (bool, error) {
 	// TODO(dan): If/when this ever loses its EXPERIMENTAL prefix and gets
 	// exposed to users, consider adding a version to the fingerprint output.
 	sql := fmt.Sprintf(`SELECT
-	  xor_agg(fnv64(%s))::string AS fingerprint
+	  xor_agg(%s)::string AS fingerprint
 	  FROM [%d AS t]@{FORCE_INDEX=[%d]}
-	`, strings.Join(cols, `,`), n.tableDesc.GetID(), index.GetID())
+	`, FingerprintRowHash(cols), n.tableDesc.GetID(), index.GetID())
 	if index.IsPartial() {
 		sql = fmt.Sprintf("%s WHERE %s", sql, index.GetPredicate())
 	}
@@ -408,5 +367,51 @@ func (n *showFingerprintsNode) Next(params runParams) (bool, error) {
 	return true, nil
 }
 
+// FingerprintRowHash returns the expression hashing the given columns of a row
+// of a table, whose xor_agg over the rows of an index is the fingerprint of the
+// index reported by SHOW EXPERIMENTAL_FINGERPRINTS.
+func FingerprintRowHash(cols []catalog.Column) string {
+	exprs := make([]string, 0, len(cols))
+	var numBytesCols int
+	for _, col := range cols {
+		var colNameOrExpr string
+		if col.IsExpressionIndexColumn() {
+			colNameOrExpr = fmt.Sprintf("(%s)", col.GetComputeExpr())
+		} else {
+			name := col.GetName()
+			colNameOrExpr = tree.NameStringP(&name)
+		}
+		// TODO(dan): This is known to be a flawed way to fingerprint. Any datum
+		// with the same string representation is fingerprinted the same, even
+		// if they're different types.
+		switch col.GetType().Family() {
+		case types.BytesFamily:
+			exprs = append(exprs, fmt.Sprintf("%s:::bytes", colNameOrExpr))
+			numBytesCols++
+		case types.StringFamily:
+			exprs = append(exprs, fmt.Sprintf("%s:::string", colNameOrExpr))
+		default:
+			exprs = append(exprs, fmt.Sprintf("%s::string", colNameOrExpr))
+		}
+	}
+
+	if len(exprs) != numBytesCols && numBytesCols != 0 {
+		// Currently, exprs has a mix of BYTES and STRING types, but fnv64
+		// requires all arguments to be of the same type. We'll cast less
+		// frequent type to the other.
+		from, to := "::bytes", "::string"
+		if numBytesCols > len(exprs)/2 {
+			// BYTES is more frequent.
+			from, to = "::string", "::bytes"
+		}
+		for i := range exprs {
+			if strings.HasSuffix(exprs[i], from) {
+				exprs[i] = exprs[i] + to
+			}
+		}
+	}
+	return fmt.Sprintf("fnv64(%s)", strings.Join(exprs, ","))
+}
+
 func (n *showFingerprintsNode) Values() tree.Datums     { return n.run.values }
 func (n *showFingerprintsNode) Close(_ context.Context) {}
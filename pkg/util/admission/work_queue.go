@@ -870,6 +870,13 @@ func (q *WorkQueue) AdmittedWorkDone(tenantID roachpb.TenantID, cpuTime time.Dur
 	q.granter.returnGrant(1)
 }
 
+// WaitQueueLength returns the number of requests waiting for admission. Since
+// WorkQueues of the same kind share their metrics, this is aggregated across
+// all of them.
+func (q *WorkQueue) WaitQueueLength() int64 {
+	return q.metrics.total.WaitQueueLength.Value()
+}
+
 func (q *WorkQueue) hasWaitingRequests() bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()